@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/procfs"
+)
+
+// Streams the agent feeds. Detection runs on a stream's rps, so each host
+// metric is fed as the rps of a stream of its own, with the host CPU
+// alongside as its cpu.
+const (
+	agentRequestsStream = "host.requests"
+	agentCPUStream      = "host.cpu"
+	agentMemoryStream   = "host.memory"
+)
+
+// hostAgent samples the host the service runs on every interval, for
+// single-node deployments that want anomaly alerts on their own box
+// without an external producer (-agent): CPU busy percentage and memory
+// in use from /proc, and the rate of requests the service itself served.
+// Rates need two readings, so the first interval feeds nothing.
+type hostAgent struct {
+	fs       procfs.FS
+	interval time.Duration
+	// requests counts requests served, by countRequests.
+	requests atomic.Int64
+
+	prev    agentReading
+	hasPrev bool
+}
+
+// agentReading is one set of the cumulative counters rates come from.
+type agentReading struct {
+	at       time.Time
+	busy     float64
+	total    float64
+	requests int64
+}
+
+// newHostAgent returns an agent reading procfs mounted at procRoot. It
+// fails at once on a host without a readable /proc, rather than at every
+// interval.
+func newHostAgent(procRoot string, interval time.Duration) (*hostAgent, error) {
+	fs, err := procfs.NewFS(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fs.Stat(); err != nil {
+		return nil, err
+	}
+	if _, err := fs.Meminfo(); err != nil {
+		return nil, err
+	}
+	return &hostAgent{fs: fs, interval: interval}, nil
+}
+
+// countRequests counts the requests next serves.
+func (a *hostAgent) countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.requests.Add(1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sample reads the host at now and returns the Metric of each agent
+// stream, or nil on the first reading.
+func (a *hostAgent) sample(now time.Time) (map[string]Metric, error) {
+	stat, err := a.fs.Stat()
+	if err != nil {
+		return nil, err
+	}
+	mem, err := a.fs.Meminfo()
+	if err != nil {
+		return nil, err
+	}
+	if mem.MemTotal == nil || mem.MemAvailable == nil || *mem.MemTotal == 0 {
+		return nil, errors.New("meminfo lacks MemTotal or MemAvailable")
+	}
+
+	// Guest time is already counted in user time.
+	c := stat.CPUTotal
+	idle := c.Idle + c.Iowait
+	total := idle + c.User + c.Nice + c.System + c.IRQ + c.SoftIRQ + c.Steal
+	cur := agentReading{at: now, busy: total - idle, total: total, requests: a.requests.Load()}
+	prev, hasPrev := a.prev, a.hasPrev
+	a.prev, a.hasPrev = cur, true
+	if !hasPrev || !now.After(prev.at) || cur.total <= prev.total {
+		return nil, nil
+	}
+
+	ts := now.UTC()
+	cpu := 100 * (cur.busy - prev.busy) / (cur.total - prev.total)
+	memory := 100 * float64(*mem.MemTotal-*mem.MemAvailable) / float64(*mem.MemTotal)
+	rate := float64(cur.requests-prev.requests) / now.Sub(prev.at).Seconds()
+	return map[string]Metric{
+		agentRequestsStream: {Timestamp: ts, CPU: cpu, RPS: rate},
+		agentCPUStream:      {Timestamp: ts, CPU: cpu, RPS: cpu},
+		agentMemoryStream:   {Timestamp: ts, CPU: cpu, RPS: memory},
+	}, nil
+}
+
+// run samples the host every interval until ctx is cancelled, feeding the
+// samples through the pipeline.
+func (a *hostAgent) run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.feed(ctx, now)
+		}
+	}
+}
+
+// feed takes one sample at now and processes it.
+func (a *hostAgent) feed(ctx context.Context, now time.Time) {
+	samples, err := a.sample(now)
+	if err != nil {
+		logSampled(slog.Default(), "Agent sampling failed", "error", err)
+		return
+	}
+	for stream, m := range samples {
+		logger := slog.Default().With("transport", "agent", "stream", stream)
+		if err := recordIntake(ctx, logger, stream, m); err != nil {
+			continue
+		}
+		processMetric(ctx, logger, stream, m)
+	}
+}
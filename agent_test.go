@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeProc writes the /proc/stat and /proc/meminfo files the agent reads.
+func writeProc(t *testing.T, dir string, user, idle, memAvailableKB uint64) {
+	t.Helper()
+	stat := fmt.Sprintf("cpu  %d 0 0 %d 0 0 0 0 0 0\ncpu0 %d 0 0 %d 0 0 0 0 0 0\nbtime 1700000000\n", user, idle, user, idle)
+	meminfo := fmt.Sprintf("MemTotal:       1000 kB\nMemFree:         100 kB\nMemAvailable:    %d kB\n", memAvailableKB)
+	for name, content := range map[string]string{"stat": stat, "meminfo": meminfo} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHostAgentSample(t *testing.T) {
+	dir := t.TempDir()
+	writeProc(t, dir, 100, 900, 600)
+	a, err := newHostAgent(dir, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got, err := a.sample(start); err != nil || got != nil {
+		t.Fatalf("first sample = %v, %v, want nothing", got, err)
+	}
+
+	// 250 of 1000 ticks busy, 8 requests in 2s, 700 of 1000 kB in use.
+	writeProc(t, dir, 350, 1650, 300)
+	handler := a.countRequests(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	for range 8 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	got, err := a.sample(start.Add(2 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Metric{
+		agentRequestsStream: {CPU: 25, RPS: 4},
+		agentCPUStream:      {CPU: 25, RPS: 25},
+		agentMemoryStream:   {CPU: 25, RPS: 70},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d streams, want %d: %v", len(got), len(want), got)
+	}
+	for stream, w := range want {
+		g := got[stream]
+		if math.Abs(g.CPU-w.CPU) > 1e-9 || math.Abs(g.RPS-w.RPS) > 1e-9 || !g.Timestamp.Equal(start.Add(2*time.Second)) {
+			t.Errorf("%s = %+v, want %+v", stream, g, w)
+		}
+	}
+}
+
+func TestHostAgentFeed(t *testing.T) {
+	newTestAppState(t)
+	dir := t.TempDir()
+	writeProc(t, dir, 100, 900, 600)
+	a, err := newHostAgent(dir, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	a.feed(context.Background(), now)
+	writeProc(t, dir, 200, 1800, 500)
+	a.feed(context.Background(), now.Add(time.Second))
+
+	for stream, want := range map[string][]float64{agentCPUStream: {10}, agentMemoryStream: {50}, agentRequestsStream: {0}} {
+		if got := rpsOf(appState.buffer.window(stream)); !equalFloats(got, want) {
+			t.Errorf("%s rps = %v, want %v", stream, got, want)
+		}
+	}
+}
+
+func TestNewHostAgentWithoutProc(t *testing.T) {
+	if _, err := newHostAgent(t.TempDir(), time.Second); err == nil {
+		t.Error("newHostAgent succeeded without /proc files")
+	}
+}
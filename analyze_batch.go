@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/redis/go-redis/v9"
+	"go-stream-processing/ingestpb"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultMaxBatchSamples = 1000
+
+	// maxBatchSampleBytes is the body size allowed per sample of a batch,
+	// so MAX_BATCH_SAMPLES also bounds how much of a body is read before
+	// the samples are counted.
+	maxBatchSampleBytes = 1 << 10
+)
+
+// SampleBatchResult is the response of POST /analyze/batch. Result is the
+// verdict for the newest accepted sample; Skipped maps the index of every
+// rejected sample to the reason.
+type SampleBatchResult struct {
+	Accepted int            `json:"accepted"`
+	Skipped  map[int]string `json:"skipped,omitempty"`
+	Result   AnalysisResult `json:"result"`
+}
+
+// processSamples stores samples on stream, oldest first, and runs
+// detection once, for the newest one against the resulting window. The
+// push and the window read go out in a single MULTI/EXEC pipeline. Earlier
+// samples are stored and feed the window but get no verdict of their own.
+//
+// Like processMetric, samples are buffered in memory while the Redis
+// circuit breaker is open or write-behind is on. With SAMPLE_RATE below 1
+// writes are per-sample decisions, so each sample goes through
+// processMetric instead.
+func processSamples(ctx context.Context, logger *slog.Logger, stream string, samples []Metric) (AnalysisResult, error) {
+	newest := samples[len(samples)-1]
+	if appState.sampleRate < 1 {
+		var result AnalysisResult
+		for _, m := range samples {
+			res, err := processMetric(ctx, logger, stream, m)
+			if err != nil {
+				return AnalysisResult{}, err
+			}
+			result = res
+		}
+		return result, nil
+	}
+	if appState.writeBehind != nil {
+		for _, m := range samples {
+			appState.writeBehind.add(stream, m)
+		}
+		return analyzeWindow(ctx, logger, stream, newest, appState.buffer.window(stream)), nil
+	}
+
+	values := make([]interface{}, len(samples))
+	for i, m := range samples {
+		data, err := encodeMetric(m, appState.codec)
+		if err != nil {
+			return AnalysisResult{}, err
+		}
+		values[i] = data
+	}
+	var window func() ([]string, error)
+	err := withRedisRetry(ctx, logger, func() error {
+		_, err := appState.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			appState.store.Append(ctx, pipe, stream, values...)
+			window = appState.store.Recent(ctx, pipe, stream, appState.maxWindow())
+			return nil
+		})
+		return err
+	})
+	if isBreakerRejection(err) {
+		logger.Warn("Redis circuit open, processing batch in memory", "samples", len(samples))
+		for _, m := range samples {
+			appState.buffer.addPending(stream, m)
+		}
+		return analyzeWindow(ctx, logger, stream, newest, appState.buffer.window(stream)), nil
+	}
+	if err != nil {
+		logger.Error("Redis batch pipeline error", "error", err)
+		for _, m := range samples {
+			deadLetter(ctx, logger, stream, m, err)
+		}
+		return AnalysisResult{}, err
+	}
+	items, _ := window()
+	decoded := decodeWindow(items)
+	appState.buffer.sync(stream, decoded)
+	return analyzeWindow(ctx, logger, stream, newest, decoded), nil
+}
+
+// handleAnalyzeBatch accepts an array of metrics for one stream, named by
+// ?stream= as on /analyze, for clients that report many samples at a
+// time. Samples rejected by the skew or unit checks are skipped and
+// listed; the rest are processed as one unit by processSamples. It is
+// always synchronous. A request with more than MAX_BATCH_SAMPLES samples
+// is rejected.
+func handleAnalyzeBatch(w http.ResponseWriter, r *http.Request) {
+	stream, err := streamFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	metrics, ok := decodeSampleBatch(w, r)
+	if !ok {
+		return
+	}
+
+	logger := loggerFrom(r.Context()).With("stream", stream)
+	samples := make([]Metric, 0, len(metrics))
+	skipped := make(map[int]string)
+	for i, m := range metrics {
+		var err error
+		if m, err = stampMetric(logger, m); err != nil {
+			skipped[i] = err.Error()
+			continue
+		}
+		samples = append(samples, m)
+	}
+	if len(samples) == 0 {
+		writeBody(w, r, http.StatusUnprocessableEntity, SampleBatchResult{Skipped: skipped})
+		return
+	}
+
+	n, err := appState.redisClient.IncrBy(context.Background(), appState.key("request_count"), int64(len(samples))).Result()
+	switch {
+	case isBreakerRejection(err):
+		logger.Warn("Redis circuit open, requests not counted")
+	case err != nil:
+		logger.Error("Redis INCRBY error", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Error incrementing counter")
+		return
+	default:
+		logSampled(logger, "Redis counter incremented", "count", n)
+	}
+	appState.requestCounter.Add(float64(len(samples)))
+	newest := samples[len(samples)-1]
+	appState.cpuGauge.WithLabelValues(stream).Set(newest.CPU)
+	appState.rpsGauge.WithLabelValues(stream).Set(newest.RPS)
+	appState.markSeen(stream)
+
+	result, err := processSamples(r.Context(), logger, stream, samples)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "unavailable", "Error processing metrics")
+		return
+	}
+	writeBody(w, r, http.StatusOK, SampleBatchResult{Accepted: len(samples), Skipped: skipped, Result: result})
+}
+
+// decodeSampleBatch reads the metrics of a /analyze/batch request, a JSON
+// array, a MessagePack one for isMsgpack requests or, for isProtobuf
+// requests, an ingestpb.MetricBatch. It answers the request itself and
+// returns false when the body is unusable. Bodies larger than
+// maxBatchSampleBytes per allowed sample are answered with 413.
+func decodeSampleBatch(w http.ResponseWriter, r *http.Request) ([]Metric, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(appState.maxBatchSamples)*maxBatchSampleBytes)
+	var metrics []Metric
+	var tooLarge *http.MaxBytesError
+	if isProtobuf(r) {
+		data, err := io.ReadAll(r.Body)
+		var batch ingestpb.MetricBatch
+		if err == nil {
+			err = proto.Unmarshal(data, &batch)
+		}
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "too_large", "Request body too large")
+			return nil, false
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_protobuf", "Invalid protobuf, want a MetricBatch")
+			return nil, false
+		}
+		if !checkBatchSize(w, len(batch.GetMetrics())) {
+			return nil, false
+		}
+		for _, pm := range batch.GetMetrics() {
+			metrics = append(metrics, metricFromProto(pm))
+		}
+		return metrics, true
+	}
+	if isMsgpack(r) {
+		var payload []map[string]interface{}
+		err := decodeMsgpack(r.Body, &payload)
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "too_large", "Request body too large")
+			return nil, false
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_msgpack", "Invalid MessagePack, want an array of metrics")
+			return nil, false
+		}
+		if !checkBatchSize(w, len(payload)) {
+			return nil, false
+		}
+		for i, obj := range payload {
+			m, err := decodeIngestedMsgpack(obj, appState.fieldMapping)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_msgpack", fmt.Sprintf("Invalid MessagePack for metric %d", i))
+				return nil, false
+			}
+			metrics = append(metrics, m)
+		}
+		return metrics, true
+	}
+
+	var payload []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "too_large", "Request body too large")
+			return nil, false
+		}
+		writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON, want an array of metrics")
+		return nil, false
+	}
+	if !checkBatchSize(w, len(payload)) {
+		return nil, false
+	}
+	for i, raw := range payload {
+		m, err := decodeIngested(raw, appState.fieldMapping)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_json", fmt.Sprintf("Invalid JSON for metric %d", i))
+			return nil, false
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, true
+}
+
+// checkBatchSize answers the request and returns false unless a batch of n
+// metrics is within MAX_BATCH_SAMPLES and not empty.
+func checkBatchSize(w http.ResponseWriter, n int) bool {
+	if n == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request", "batch must hold at least one metric")
+		return false
+	}
+	if n > appState.maxBatchSamples {
+		writeError(w, http.StatusRequestEntityTooLarge, "too_large", fmt.Sprintf("batch holds %d metrics, at most %d are allowed", n, appState.maxBatchSamples))
+		return false
+	}
+	return true
+}
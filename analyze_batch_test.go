@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyzeBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		body        string
+		wantCode    int
+		wantStored  int
+		wantSkipped []int
+		wantSamples int
+	}{
+		{name: "batch", target: "/analyze/batch?stream=web", body: `[{"rps": 1}, {"rps": 2}, {"rps": 3}]`, wantCode: http.StatusOK, wantStored: 3, wantSamples: 3},
+		{
+			name:     "unit rejected",
+			target:   "/analyze/batch?stream=web",
+			body:     `[{"rps": 1}, {"rps": 2, "rps_unit": "furlongs"}, {"rps": 3}]`,
+			wantCode: http.StatusOK, wantStored: 2, wantSkipped: []int{1}, wantSamples: 2,
+		},
+		{name: "all rejected", target: "/analyze/batch?stream=web", body: `[{"rps": 1, "rps_unit": "furlongs"}]`, wantCode: http.StatusUnprocessableEntity, wantSkipped: []int{0}},
+		{name: "empty", target: "/analyze/batch?stream=web", body: `[]`, wantCode: http.StatusBadRequest},
+		{name: "not an array", target: "/analyze/batch?stream=web", body: `{"rps": 1}`, wantCode: http.StatusBadRequest},
+		{name: "bad metric", target: "/analyze/batch?stream=web", body: `[{"rps": "high"}]`, wantCode: http.StatusBadRequest},
+		{name: "too many", target: "/analyze/batch?stream=web", body: "[" + strings.Repeat(`{"rps": 1},`, 10) + `{"rps": 1}]`, wantCode: http.StatusRequestEntityTooLarge},
+		{name: "body too large", target: "/analyze/batch?stream=web", body: `[{"rps": 1, "note": "` + strings.Repeat("x", 10*maxBatchSampleBytes) + `"}]`, wantCode: http.StatusRequestEntityTooLarge},
+		{name: "invalid stream", target: "/analyze/batch?stream=bad%20name", body: `[{"rps": 1}]`, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			stored, _ := mr.List("metrics:web")
+			if len(stored) != tt.wantStored {
+				t.Errorf("stored %d samples, want %d", len(stored), tt.wantStored)
+			}
+			if count, _ := mr.Get("request_count"); tt.wantStored > 0 && count != fmt.Sprint(tt.wantStored) {
+				t.Errorf("request_count = %q, want %d", count, tt.wantStored)
+			}
+			if w.Code >= 400 && w.Code != http.StatusUnprocessableEntity {
+				return
+			}
+
+			var got SampleBatchResult
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Accepted != tt.wantStored || len(got.Skipped) != len(tt.wantSkipped) {
+				t.Errorf("accepted %d, skipped %v; want %d and %v", got.Accepted, got.Skipped, tt.wantStored, tt.wantSkipped)
+			}
+			for _, i := range tt.wantSkipped {
+				if got.Skipped[i] == "" {
+					t.Errorf("metric %d not reported as skipped: %v", i, got.Skipped)
+				}
+			}
+			// One window recompute, for the newest sample, over the whole batch.
+			if got.Result.Samples != tt.wantSamples {
+				t.Errorf("result over %d samples, want %d", got.Result.Samples, tt.wantSamples)
+			}
+		})
+	}
+}
+
+func TestAnalyzeBatchInMemory(t *testing.T) {
+	newTestAppState(t)
+	appState.writeBehind = newWriteBehind(time.Hour, 5)
+	w := httptest.NewRecorder()
+	newMux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze/batch?stream=web", strings.NewReader(`[{"rps": 1}, {"rps": 2}]`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", w.Code, w.Body.String())
+	}
+	if n := appState.buffer.pendingCount(); n != 2 {
+		t.Errorf("pending = %d, want both samples held for write-behind", n)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultAnomalyLimit = 100
+	maxAnomalyLimit     = 1000
+)
+
+// AnomalyRecord is one detected anomaly as stored in the anomalies sorted
+// set, scored by the Unix time of the sample that triggered it.
+type AnomalyRecord struct {
+	ID        string    `json:"id"`
+	Stream    string    `json:"stream"`
+	Timestamp time.Time `json:"timestamp"`
+	RPS       float64   `json:"rps"`
+	Score     float64   `json:"score"`
+	Detector  string    `json:"detector"`
+	// Severity is empty on records stored before severities existed.
+	Severity string `json:"severity,omitempty"`
+	// Direction is "up" for a spike and "down" for a dip; empty on records
+	// stored before directions existed.
+	Direction Direction `json:"direction,omitempty"`
+}
+
+// recordAnomaly stores rec and trims the set to the newest anomalyRetention
+// records. Failures are logged and otherwise ignored: losing a history
+// entry must not fail detection.
+func recordAnomaly(ctx context.Context, logger *slog.Logger, rec AnomalyRecord) {
+	data, err := marshalJSON(rec)
+	if err != nil {
+		logger.Error("Failed to encode anomaly record", "error", err)
+		return
+	}
+	key := appState.key("anomalies")
+	pipe := appState.redisClient.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: unixSeconds(rec.Timestamp), Member: data})
+	pipe.ZRemRangeByRank(ctx, key, 0, -int64(appState.anomalyRetention)-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error("Redis anomaly record error", "error", err)
+	}
+}
+
+// updateRecentAnomalies sets go_service_anomalies_recent from the anomalies
+// set. Records are scored by sample time, so backfilled samples only count
+// if their timestamps fall inside the window.
+func updateRecentAnomalies(ctx context.Context) error {
+	from := appState.now().Add(-appState.recentAnomalyWindow)
+	n, err := appState.redisClient.ZCount(ctx, appState.key("anomalies"), strconv.FormatFloat(unixSeconds(from), 'f', -1, 64), "+inf").Result()
+	if err != nil {
+		return err
+	}
+	appState.recentAnomaliesGauge.Set(float64(n))
+	return nil
+}
+
+// monitorRecentAnomalies refreshes go_service_anomalies_recent every
+// interval, for dashboards that want a per-window count without PromQL.
+func monitorRecentAnomalies(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := updateRecentAnomalies(ctx); err != nil {
+				log.Printf("Redis recent anomaly count error: %v", err)
+			}
+		}
+	}
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// parseTimeParam accepts RFC3339 or Unix epoch seconds (fractions allowed).
+func parseTimeParam(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, nil
+	}
+	secs, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return time.Time{}, fmt.Errorf("invalid time %q: want RFC3339 or Unix seconds", v)
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC(), nil
+}
+
+// scoreBound turns an optional time parameter into a ZRANGEBYSCORE bound.
+func scoreBound(r *http.Request, name, open string) (string, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return open, nil
+	}
+	t, err := parseTimeParam(v)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return strconv.FormatFloat(unixSeconds(t), 'f', -1, 64), nil
+}
+
+// handleAnomalies returns recorded anomalies between from and to (inclusive,
+// both optional), oldest first, paginated with limit and offset.
+func handleAnomalies(w http.ResponseWriter, r *http.Request) {
+	from, err := scoreBound(r, "from", "-inf")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	to, err := scoreBound(r, "to", "+inf")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	limit, err := limitFromRequest(r, defaultAnomalyLimit)
+	if err != nil || limit > maxAnomalyLimit {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("limit must be between 1 and %d", maxAnomalyLimit))
+		return
+	}
+	offset := 0
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request", "offset must be a non-negative integer")
+			return
+		}
+	}
+
+	ctx := r.Context()
+	key := appState.key("anomalies")
+	pipe := appState.redisClient.Pipeline()
+	countCmd := pipe.ZCount(ctx, key, from, to)
+	rangeCmd := pipe.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: from, Max: to, Offset: int64(offset), Count: int64(limit),
+	})
+	if _, err := pipe.Exec(ctx); err != nil {
+		loggerFrom(ctx).Error("Redis anomaly query error", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Error reading anomalies")
+		return
+	}
+
+	anomalies := []AnomalyRecord{}
+	for _, item := range rangeCmd.Val() {
+		var rec AnomalyRecord
+		if json.Unmarshal([]byte(item), &rec) == nil {
+			rec.Score = appState.round(rec.Score)
+			anomalies = append(anomalies, rec)
+		}
+	}
+
+	writeBody(w, r, http.StatusOK, map[string]interface{}{
+		"total":     countCmd.Val(),
+		"limit":     limit,
+		"offset":    offset,
+		"anomalies": anomalies,
+	})
+}
+
+// AnomalyContext is what the data looked like when an anomaly fired: the
+// window the detector saw and the stats computed from it. The newest
+// ANOMALY_CONTEXT_SIZE contexts are kept in memory only.
+type AnomalyContext struct {
+	Anomaly    AnomalyRecord `json:"anomaly"`
+	Window     []Metric      `json:"window"`
+	Samples    int           `json:"samples"`
+	RollingAvg float64       `json:"rolling_avg"`
+	StdDev     float64       `json:"stddev"`
+}
+
+// handleAnomalyContext returns the context kept for one anomaly. Contexts
+// are not persisted, so anomalies from before a restart or evicted from the
+// cache are 404 even though /anomalies still lists them.
+func handleAnomalyContext(w http.ResponseWriter, r *http.Request) {
+	ac, ok := appState.anomalyContexts.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "no context kept for this anomaly")
+		return
+	}
+	writeBody(w, r, http.StatusOK, ac)
+}
+
+const (
+	defaultAnomalyWait = 30 * time.Second
+	maxAnomalyWait     = 2 * time.Minute
+)
+
+// handleAnomalyWait long-polls for the next anomaly on any stream, for
+// clients that cannot follow an event stream. It answers with the record as
+// soon as one is detected, or 204 once timeout (default 30s, at most 2m)
+// passes without one. Only anomalies detected while the request waits
+// count; earlier ones are on /anomalies. Waiters beyond
+// MAX_ANOMALY_WAITERS are turned away with 429 rather than queued. HEAD is
+// answered with 204 at once.
+func handleAnomalyWait(w http.ResponseWriter, r *http.Request) {
+	timeout := defaultAnomalyWait
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxAnomalyWait {
+			writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("timeout must be a duration up to %s", maxAnomalyWait))
+			return
+		}
+		timeout = d
+	}
+	// HEAD would otherwise wait out the timeout for a body it never gets,
+	// holding a waiter slot.
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	select {
+	case appState.anomalyWaiters <- struct{}{}:
+		defer func() { <-appState.anomalyWaiters }()
+	default:
+		writeError(w, http.StatusTooManyRequests, "too_many_waiters", "too many waiters")
+		return
+	}
+
+	anomalies, unsubscribe := appState.anomalyHub.subscribe(1)
+	defer unsubscribe()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case rec := <-anomalies:
+		rec.Score = appState.round(rec.Score)
+		writeBody(w, r, http.StatusOK, rec)
+	case <-timer.C:
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	}
+}
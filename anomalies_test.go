@@ -0,0 +1,270 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTimeParam(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "2024-01-01T10:00:00Z", want: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{in: "2024-01-01T12:00:00+02:00", want: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{in: "1704103200", want: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{in: "1704103200.5", want: time.Date(2024, 1, 1, 10, 0, 0, 5e8, time.UTC)},
+		{in: "yesterday", wantErr: true},
+		{in: "NaN", wantErr: true},
+		{in: "2024-01-01", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeParam(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimeParam(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !got.Equal(tt.want) {
+			t.Errorf("parseTimeParam(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHandleAnomalies(t *testing.T) {
+	newTestAppState(t)
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		recordAnomaly(context.Background(), slog.Default(), AnomalyRecord{
+			ID:        newRequestID(),
+			Stream:    defaultStream,
+			Timestamp: base.Add(time.Duration(i) * 5 * time.Minute),
+			RPS:       float64(i),
+		})
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantTotal int
+		wantRPS   []float64
+	}{
+		{name: "everything", wantCode: http.StatusOK, wantTotal: 5, wantRPS: []float64{0, 1, 2, 3, 4}},
+		{name: "RFC3339 range", query: "from=2024-01-01T10:00:00Z&to=2024-01-01T10:15:00Z", wantCode: http.StatusOK, wantTotal: 4, wantRPS: []float64{0, 1, 2, 3}},
+		{name: "epoch range", query: "from=1704103500&to=1704103800", wantCode: http.StatusOK, wantTotal: 2, wantRPS: []float64{1, 2}},
+		{name: "paginated", query: "limit=2&offset=3", wantCode: http.StatusOK, wantTotal: 5, wantRPS: []float64{3, 4}},
+		{name: "bad from", query: "from=soon", wantCode: http.StatusBadRequest},
+		{name: "bad limit", query: "limit=5000", wantCode: http.StatusBadRequest},
+		{name: "bad offset", query: "offset=-1", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleAnomalies(w, httptest.NewRequest(http.MethodGet, "/anomalies?"+tt.query, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Total     int             `json:"total"`
+				Anomalies []AnomalyRecord `json:"anomalies"`
+			}
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp.Total != tt.wantTotal || len(resp.Anomalies) != len(tt.wantRPS) {
+				t.Fatalf("got total %d and %d records, want %d and %d", resp.Total, len(resp.Anomalies), tt.wantTotal, len(tt.wantRPS))
+			}
+			for i, rec := range resp.Anomalies {
+				if rec.RPS != tt.wantRPS[i] || rec.ID == "" {
+					t.Errorf("record %d = %+v, want rps %v with an id", i, rec, tt.wantRPS[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRecordAnomalyRetention(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.anomalyRetention = 3
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		recordAnomaly(context.Background(), slog.Default(), AnomalyRecord{ID: newRequestID(), Timestamp: base.Add(time.Duration(i) * time.Second)})
+	}
+	members, _ := mr.ZMembers("anomalies")
+	if len(members) != 3 {
+		t.Errorf("anomalies set size = %d, want 3", len(members))
+	}
+}
+
+func TestHandleAnomalyContext(t *testing.T) {
+	newTestAppState(t)
+	appState.detector = &ZScoreDetector{Threshold: 1.5}
+	ctx := context.Background()
+	var result AnalysisResult
+	for _, rps := range []float64{10, 11, 10, 11, 500} {
+		var err error
+		if result, err = processMetric(ctx, slog.Default(), defaultStream, Metric{RPS: rps}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if result.Status != statusAnomaly {
+		t.Fatalf("status = %s, want anomaly", result.Status)
+	}
+	ids, _ := appState.redisClient.ZRange(ctx, appState.key("anomalies"), 0, -1).Result()
+	if len(ids) != 1 {
+		t.Fatalf("recorded %d anomalies, want 1", len(ids))
+	}
+	var rec AnomalyRecord
+	json.Unmarshal([]byte(ids[0]), &rec)
+
+	tests := []struct {
+		name     string
+		id       string
+		wantCode int
+	}{
+		{name: "recent", id: rec.ID, wantCode: http.StatusOK},
+		{name: "unknown", id: "nope", wantCode: http.StatusNotFound},
+	}
+	mux := newMux()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/anomalies/"+tt.id+"/context", nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var got AnomalyContext
+			json.NewDecoder(w.Body).Decode(&got)
+			if got.Anomaly.ID != rec.ID || got.Samples != 5 || len(got.Window) != 5 || got.Window[4].RPS != 500 {
+				t.Errorf("context = %+v, want the 5-sample window ending at the spike", got)
+			}
+			if got.RollingAvg != 108.4 || got.StdDev == 0 {
+				t.Errorf("stats = avg %v stddev %v, want avg 108.4 and a non-zero stddev", got.RollingAvg, got.StdDev)
+			}
+		})
+	}
+}
+
+func TestUpdateRecentAnomalies(t *testing.T) {
+	newTestAppState(t)
+	now := appState.now()
+	for _, age := range []time.Duration{time.Hour, 6 * time.Minute, 4 * time.Minute, time.Minute, 0} {
+		recordAnomaly(context.Background(), slog.Default(), AnomalyRecord{ID: newRequestID(), Timestamp: now.Add(-age)})
+	}
+
+	tests := []struct {
+		window time.Duration
+		want   float64
+	}{
+		{window: 5 * time.Minute, want: 3},
+		{window: 30 * time.Second, want: 1},
+		{window: 24 * time.Hour, want: 5},
+	}
+	for _, tt := range tests {
+		appState.recentAnomalyWindow = tt.window
+		if err := updateRecentAnomalies(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := gaugeValue(appState.recentAnomaliesGauge); got != tt.want {
+			t.Errorf("window %v: anomalies_recent = %v, want %v", tt.window, got, tt.want)
+		}
+	}
+}
+
+// waitForSubscribers blocks until h has n subscribers.
+func waitForSubscribers[T any](t *testing.T, h *hub[T], n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		h.mu.Lock()
+		got := len(h.subs)
+		h.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("hub never reached %d subscribers", n)
+}
+
+func TestHandleAnomalyWait(t *testing.T) {
+	rec := AnomalyRecord{ID: "a1", Stream: "web", RPS: 500, Score: 4.123456, Detector: "zscore", Severity: severityCritical}
+	tests := []struct {
+		name     string
+		method   string
+		query    string
+		publish  bool
+		waiters  int // slots taken before the request
+		wantCode int
+	}{
+		{name: "anomaly arrives", query: "?timeout=5s", publish: true, wantCode: http.StatusOK},
+		{name: "timeout", query: "?timeout=20ms", wantCode: http.StatusNoContent},
+		{name: "bad timeout", query: "?timeout=soon", wantCode: http.StatusBadRequest},
+		{name: "timeout too long", query: "?timeout=1h", wantCode: http.StatusBadRequest},
+		{name: "too many waiters", query: "?timeout=20ms", waiters: 2, wantCode: http.StatusTooManyRequests},
+		// HEAD answers at once and takes no slot, even with none left.
+		{name: "head", method: http.MethodHead, query: "?timeout=2m", waiters: 2, wantCode: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			for i := 0; i < tt.waiters; i++ {
+				appState.anomalyWaiters <- struct{}{}
+			}
+			w := httptest.NewRecorder()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				newMux().ServeHTTP(w, httptest.NewRequest(cmp.Or(tt.method, http.MethodGet), "/anomalies/wait"+tt.query, nil))
+			}()
+			if tt.publish {
+				waitForSubscribers(t, appState.anomalyHub, 1)
+				appState.anomalyHub.publish(rec)
+			}
+			<-done
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.publish {
+				var got AnomalyRecord
+				if err := json.NewDecoder(w.Body).Decode(&got); err != nil || got.ID != rec.ID || got.Score != 4.1235 {
+					t.Errorf("body = %+v, %v; want %s with rounded score", got, err, rec.ID)
+				}
+			}
+			if len(appState.anomalyWaiters) != tt.waiters {
+				t.Errorf("%d waiter slots held after return, want %d", len(appState.anomalyWaiters), tt.waiters)
+			}
+		})
+	}
+}
+
+func TestHandleAnomalyWaitCancelled(t *testing.T) {
+	newTestAppState(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodGet, "/anomalies/wait", nil).WithContext(ctx)
+		handleAnomalyWait(httptest.NewRecorder(), req)
+	}()
+	waitForSubscribers(t, appState.anomalyHub, 1)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("wait did not return after the client went away")
+	}
+	waitForSubscribers(t, appState.anomalyHub, 0)
+}
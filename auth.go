@@ -0,0 +1,25 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin guards endpoints that generate load or change state with
+// the ADMIN_TOKEN bearer token. With no token configured they are off.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if appState.adminToken == "" {
+			writeError(w, http.StatusForbidden, "admin_disabled", "admin endpoints are disabled (ADMIN_TOKEN is not set)")
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(appState.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="go-service"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid bearer token")
+			return
+		}
+		next(w, r)
+	}
+}
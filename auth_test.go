@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		header   string
+		wantCode int
+	}{
+		{name: "disabled", header: "Bearer anything", wantCode: http.StatusForbidden},
+		{name: "missing", token: "s3cret", wantCode: http.StatusUnauthorized},
+		{name: "wrong", token: "s3cret", header: "Bearer nope", wantCode: http.StatusUnauthorized},
+		{name: "not bearer", token: "s3cret", header: "Basic s3cret", wantCode: http.StatusUnauthorized},
+		{name: "valid", token: "s3cret", header: "Bearer s3cret", wantCode: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			appState.adminToken = tt.token
+			handler := requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultMaxBatchStreams = 100
+
+// BatchResult is the response of POST /batch/analyze: a verdict for every
+// stream that was processed and an error for every stream that was not.
+type BatchResult struct {
+	Results map[string]AnalysisResult `json:"results"`
+	Errors  map[string]string         `json:"errors,omitempty"`
+}
+
+// processBatch stores one metric per stream and runs detection on each.
+// All pushes, trims and window reads go out in a single MULTI/EXEC
+// pipeline, so the batch is one round-trip and is applied atomically. With
+// SAMPLE_RATE below 1 writes are per-sample decisions, so each stream goes
+// through processMetric instead.
+func processBatch(ctx context.Context, logger *slog.Logger, batch map[string]Metric) BatchResult {
+	result := BatchResult{Results: make(map[string]AnalysisResult, len(batch)), Errors: make(map[string]string)}
+	streams := make([]string, 0, len(batch))
+	for stream := range batch {
+		streams = append(streams, stream)
+	}
+	slices.Sort(streams)
+
+	if appState.sampleRate < 1 {
+		for _, stream := range streams {
+			res, err := processMetric(ctx, logger.With("stream", stream), stream, batch[stream])
+			if err != nil {
+				result.Errors[stream] = "Error processing metric"
+				continue
+			}
+			result.Results[stream] = res
+		}
+		return result
+	}
+
+	windows := make(map[string]func() ([]string, error), len(streams))
+	err := withRedisRetry(ctx, logger, func() error {
+		_, err := appState.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, stream := range streams {
+				data, err := encodeMetric(batch[stream], appState.codec)
+				if err != nil {
+					return err
+				}
+				appState.store.Append(ctx, pipe, stream, data)
+				windows[stream] = appState.store.Recent(ctx, pipe, stream, appState.maxWindow())
+			}
+			return nil
+		})
+		return err
+	})
+
+	for _, stream := range streams {
+		m, streamLogger := batch[stream], logger.With("stream", stream)
+		switch {
+		case isBreakerRejection(err):
+			streamLogger.Warn("Redis circuit open, processing metric in memory")
+			appState.buffer.addPending(stream, m)
+			result.Results[stream] = analyzeWindow(ctx, streamLogger, stream, m, appState.buffer.window(stream))
+		case err != nil:
+			streamLogger.Error("Redis batch pipeline error", "error", err)
+			deadLetter(ctx, streamLogger, stream, m, err)
+			result.Errors[stream] = "Error processing metric"
+		default:
+			items, _ := windows[stream]()
+			window := decodeWindow(items)
+			appState.buffer.sync(stream, window)
+			result.Results[stream] = analyzeWindow(ctx, streamLogger, stream, m, window)
+		}
+	}
+	return result
+}
+
+// handleBatchAnalyze accepts a JSON or MessagePack object mapping stream
+// names to one metric each, for collectors that report many streams at
+// once, and returns the verdict per stream. Unlike /analyze it is always
+// synchronous. A request naming more than MAX_BATCH_STREAMS streams is
+// rejected.
+func handleBatchAnalyze(w http.ResponseWriter, r *http.Request) {
+	payload, err := decodeBatchPayload(r)
+	if err != nil && isMsgpack(r) {
+		writeError(w, http.StatusBadRequest, "invalid_msgpack", "Invalid MessagePack")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+	if len(payload) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request", "batch must name at least one stream")
+		return
+	}
+	if len(payload) > appState.maxBatchStreams {
+		writeError(w, http.StatusRequestEntityTooLarge, "too_large", fmt.Sprintf("batch names %d streams, at most %d are allowed", len(payload), appState.maxBatchStreams))
+		return
+	}
+
+	logger := loggerFrom(r.Context())
+	batch := make(map[string]Metric, len(payload))
+	skipped := make(map[string]string)
+	for stream, decode := range payload {
+		if !streamNamePattern.MatchString(stream) {
+			writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid stream name %q", stream))
+			return
+		}
+		m, err := decode()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid metric for stream %s", stream))
+			return
+		}
+		if m, err = stampMetric(logger.With("stream", stream), m); err != nil {
+			skipped[stream] = err.Error()
+			continue
+		}
+		batch[stream] = m
+	}
+
+	n, err := appState.redisClient.IncrBy(context.Background(), appState.key("request_count"), int64(len(batch))).Result()
+	switch {
+	case isBreakerRejection(err):
+		logger.Warn("Redis circuit open, requests not counted")
+	case err != nil:
+		logger.Error("Redis INCRBY error", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Error incrementing counter")
+		return
+	default:
+		logSampled(logger, "Redis counter incremented", "count", n)
+	}
+	appState.requestCounter.Add(float64(len(batch)))
+	for stream, m := range batch {
+		appState.cpuGauge.WithLabelValues(stream).Set(m.CPU)
+		appState.rpsGauge.WithLabelValues(stream).Set(m.RPS)
+		appState.markSeen(stream)
+	}
+
+	result := processBatch(r.Context(), logger, batch)
+	for stream, reason := range skipped {
+		result.Errors[stream] = reason
+	}
+	writeBody(w, r, http.StatusOK, result)
+}
+
+// decodeBatchPayload reads a /batch/analyze body, returning a decoder for
+// the metric of each stream it names.
+func decodeBatchPayload(r *http.Request) (map[string]func() (Metric, error), error) {
+	if isMsgpack(r) {
+		var objs map[string]map[string]interface{}
+		if err := decodeMsgpack(r.Body, &objs); err != nil {
+			return nil, err
+		}
+		payload := make(map[string]func() (Metric, error), len(objs))
+		for stream, obj := range objs {
+			payload[stream] = func() (Metric, error) { return decodeIngestedMsgpack(obj, appState.fieldMapping) }
+		}
+		return payload, nil
+	}
+	var raws map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raws); err != nil {
+		return nil, err
+	}
+	payload := make(map[string]func() (Metric, error), len(raws))
+	for stream, raw := range raws {
+		payload[stream] = func() (Metric, error) { return decodeIngested(raw, appState.fieldMapping) }
+	}
+	return payload, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleBatchAnalyze(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantCode    int
+		wantResults []string
+		wantErrors  []string
+	}{
+		{name: "two streams", body: `{"web": {"rps": 1}, "api": {"rps": 2}}`, wantCode: http.StatusOK, wantResults: []string{"web", "api"}},
+		{name: "skewed stream reported", body: `{"web": {"rps": 1}, "old": {"rps": 2, "timestamp": "2000-01-01T00:00:00Z"}}`,
+			wantCode: http.StatusOK, wantResults: []string{"web"}, wantErrors: []string{"old"}},
+		{name: "empty", body: `{}`, wantCode: http.StatusBadRequest},
+		{name: "invalid json", body: `[`, wantCode: http.StatusBadRequest},
+		{name: "invalid stream name", body: `{"bad name": {"rps": 1}}`, wantCode: http.StatusBadRequest},
+		{name: "invalid metric", body: `{"web": {"rps": "x"}}`, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			appState.skewTolerance = time.Hour
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/batch/analyze", strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var result BatchResult
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Results) != len(tt.wantResults) || len(result.Errors) != len(tt.wantErrors) {
+				t.Fatalf("result = %+v, want results for %v and errors for %v", result, tt.wantResults, tt.wantErrors)
+			}
+			for _, stream := range tt.wantResults {
+				if _, ok := result.Results[stream]; !ok {
+					t.Errorf("no result for %s", stream)
+				}
+				if stored, _ := mr.List("metrics:" + stream); len(stored) != 1 {
+					t.Errorf("%s has %d stored samples, want 1", stream, len(stored))
+				}
+			}
+			for _, stream := range tt.wantErrors {
+				if result.Errors[stream] == "" {
+					t.Errorf("no error for %s", stream)
+				}
+			}
+			if count, _ := mr.Get("request_count"); count != fmt.Sprint(len(tt.wantResults)) {
+				t.Errorf("request_count = %q, want %d", count, len(tt.wantResults))
+			}
+		})
+	}
+}
+
+func TestBatchAnalyzeLimit(t *testing.T) {
+	newTestAppState(t)
+	appState.maxBatchStreams = 2
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"a": {"rps": 1}, "b": {"rps": 1}, "c": {"rps": 1}}`)
+	newMux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/batch/analyze", body))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", w.Code)
+	}
+}
+
+// Detection runs per stream against each stream's own window.
+func TestBatchAnalyzeDetectsPerStream(t *testing.T) {
+	newTestAppState(t)
+	appState.detector = &ZScoreDetector{Threshold: 1.5}
+	mux := newMux()
+	var result BatchResult
+	for i, spike := range []float64{10, 11, 10, 11, 500} {
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"steady": {"rps": %v}, "spiky": {"rps": %v}}`, 10+i%2, spike)
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/batch/analyze", strings.NewReader(body)))
+		result = BatchResult{}
+		json.NewDecoder(w.Body).Decode(&result)
+	}
+	if got := result.Results["spiky"].Status; got != statusAnomaly {
+		t.Errorf("spiky status = %q, want anomaly", got)
+	}
+	if got := result.Results["steady"].Status; got != statusNormal {
+		t.Errorf("steady status = %q, want normal", got)
+	}
+	if got := result.Results["spiky"].Samples; got != 5 {
+		t.Errorf("spiky samples = %d, want 5", got)
+	}
+}
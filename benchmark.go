@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultBenchmarkPoints = 10000
+	maxBenchmarkPoints     = 1000000
+)
+
+// DetectorBenchmark is the cost of running one detector over a synthetic
+// series, one Detect call per point.
+type DetectorBenchmark struct {
+	Detector       string  `json:"detector"`
+	Points         int     `json:"points"`
+	Window         int     `json:"window"`
+	ElapsedMS      float64 `json:"elapsed_ms"`
+	PointsPerSec   float64 `json:"points_per_sec"`
+	NsPerPoint     float64 `json:"ns_per_point"`
+	AllocsPerPoint float64 `json:"allocs_per_point"`
+	BytesPerPoint  float64 `json:"bytes_per_point"`
+}
+
+// registerBenchmark adds POST /benchmark-internal. It is only called when
+// ENABLE_BENCHMARK=true and additionally needs the admin token: a run
+// burns a full core for as long as it takes, competing with real traffic.
+func registerBenchmark(mux *http.ServeMux) {
+	mux.HandleFunc("POST /benchmark-internal", requireAdmin(handleBenchmark))
+}
+
+// benchmarkDetectors returns the configured detectors: the primary one,
+// each ensemble member on its own, the trend detector and, if enabled, the
+// divergence detector. The ensemble is copied without its vote counter so
+// a benchmark run does not show up in go_service_detector_votes_total.
+func benchmarkDetectors() []AnomalyDetector {
+	primary := appState.currentDetector()
+	var detectors []AnomalyDetector
+	if e, ok := primary.(*EnsembleDetector); ok {
+		detectors = append(detectors, &EnsembleDetector{Members: e.Members, MinVotes: e.MinVotes})
+		detectors = append(detectors, e.Members...)
+	} else {
+		detectors = append(detectors, primary)
+	}
+	detectors = append(detectors, appState.trend)
+	if appState.divergence != nil {
+		detectors = append(detectors, appState.divergence)
+	}
+	return detectors
+}
+
+// benchmarkDetector runs d over values with a sliding window of size
+// window and measures time and allocations. Allocations are process-wide,
+// so concurrent requests inflate them; run it on an idle pod.
+func benchmarkDetector(d AnomalyDetector, values []float64, window int) DetectorBenchmark {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := range values {
+		d.Detect(values[max(0, i+1-window):i+1], values[i])
+	}
+	// Never zero, so the rates below stay finite for tiny runs.
+	elapsed := max(time.Since(start), time.Nanosecond)
+	runtime.ReadMemStats(&after)
+
+	n := float64(len(values))
+	return DetectorBenchmark{
+		Detector:       d.Name(),
+		Points:         len(values),
+		Window:         window,
+		ElapsedMS:      float64(elapsed.Microseconds()) / 1000,
+		PointsPerSec:   n / elapsed.Seconds(),
+		NsPerPoint:     float64(elapsed.Nanoseconds()) / n,
+		AllocsPerPoint: float64(after.Mallocs-before.Mallocs) / n,
+		BytesPerPoint:  float64(after.TotalAlloc-before.TotalAlloc) / n,
+	}
+}
+
+// handleBenchmark runs every configured detector over ?points= synthetic
+// RPS values (default 10000) and reports throughput and allocations, for
+// sizing pods before putting a detector config on a busy stream. It reads
+// the detector configuration but never touches stored data or metrics.
+func handleBenchmark(w http.ResponseWriter, r *http.Request) {
+	points := defaultBenchmarkPoints
+	if v := r.URL.Query().Get("points"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxBenchmarkPoints {
+			writeError(w, http.StatusBadRequest, "invalid_request", "points must be an integer between 1 and "+strconv.Itoa(maxBenchmarkPoints))
+			return
+		}
+		points = n
+	}
+
+	// A fixed seed keeps runs comparable across pods and configs.
+	rng := rand.New(rand.NewPCG(1, 2))
+	values := make([]float64, points)
+	for i := range values {
+		values[i] = 100 + rng.NormFloat64()*10
+	}
+
+	window := appState.windowFor("rps")
+	var results []DetectorBenchmark
+	for _, d := range benchmarkDetectors() {
+		size := window
+		if div, ok := d.(*DivergenceDetector); ok {
+			size = max(size, div.Long)
+		}
+		results = append(results, benchmarkDetector(d, values, size))
+	}
+	loggerFrom(r.Context()).Info("Detector benchmark finished", "points", points, "detectors", len(results))
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, map[string]interface{}{
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"results":    results,
+	})
+}
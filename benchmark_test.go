@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHandleBenchmark(t *testing.T) {
+	tests := []struct {
+		name          string
+		enabled       bool
+		token         string
+		target        string
+		wantCode      int
+		wantDetectors []string
+	}{
+		{name: "disabled", token: "secret", target: "/benchmark-internal", wantCode: http.StatusNotFound},
+		{name: "no token", enabled: true, target: "/benchmark-internal", wantCode: http.StatusUnauthorized},
+		{name: "bad points", enabled: true, token: "secret", target: "/benchmark-internal?points=0", wantCode: http.StatusBadRequest},
+		{name: "too many points", enabled: true, token: "secret", target: "/benchmark-internal?points=2000000", wantCode: http.StatusBadRequest},
+		{name: "single point", enabled: true, token: "secret", target: "/benchmark-internal?points=1", wantCode: http.StatusOK,
+			wantDetectors: []string{"zscore", "trend"}},
+		{name: "default", enabled: true, token: "secret", target: "/benchmark-internal", wantCode: http.StatusOK,
+			wantDetectors: []string{"zscore", "trend"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			appState.adminToken = "secret"
+			mux := newMux()
+			if tt.enabled {
+				registerBenchmark(mux)
+			}
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Results []DetectorBenchmark `json:"results"`
+			}
+			json.NewDecoder(w.Body).Decode(&resp)
+			if len(resp.Results) != len(tt.wantDetectors) {
+				t.Fatalf("got %d results, want %v", len(resp.Results), tt.wantDetectors)
+			}
+			for i, res := range resp.Results {
+				if res.Detector != tt.wantDetectors[i] || res.Points == 0 || res.PointsPerSec <= 0 {
+					t.Errorf("result %d = %+v, want a %s run", i, res, tt.wantDetectors[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBenchmarkDetectorsLeavesVotesAlone(t *testing.T) {
+	newTestAppState(t)
+	votes := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "votes"}, []string{"detector"})
+	appState.detector = &EnsembleDetector{
+		Members:  []AnomalyDetector{&ZScoreDetector{Threshold: 0.1}, &MADDetector{Threshold: 0.1}},
+		MinVotes: 1,
+		Votes:    votes,
+	}
+	appState.divergence = &DivergenceDetector{Short: 2, Long: 8, Threshold: 1}
+
+	var names []string
+	for _, d := range benchmarkDetectors() {
+		res := benchmarkDetector(d, []float64{1, 5, 1, 9, 1, 20}, 5)
+		names = append(names, res.Detector)
+	}
+	want := []string{"ensemble", "zscore", "mad", "trend", "divergence"}
+	if len(names) != len(want) {
+		t.Fatalf("benchmarked %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("benchmarked %v, want %v", names, want)
+			break
+		}
+	}
+	for _, member := range []string{"zscore", "mad"} {
+		if got := counterValue(votes.WithLabelValues(member)); got != 0 {
+			t.Errorf("%s votes = %v after a benchmark, want 0", member, got)
+		}
+	}
+}
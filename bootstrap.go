@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Detectors are stateless: every sample is scored against its window as
+// read back from Redis, so EWMA mean and variance and the warmup count are
+// rebuilt on the first sample after a restart. What a restart does lose is
+// the in-memory copy of each window, which quick verdicts and detection
+// while the Redis breaker is open run against, and when each stream was
+// last seen. bootstrapState restores both from Redis.
+
+// knownStreams lists the streams with raw samples in Redis.
+func knownStreams(ctx context.Context) ([]string, error) {
+	var streams []string
+	defaultKey := appState.metricsKey(defaultStream)
+	prefix := appState.key("metrics:")
+	for _, pattern := range []string{defaultKey, prefix + "*"} {
+		iter := appState.redisClient.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			key := iter.Val()
+			if key == defaultKey {
+				streams = append(streams, defaultStream)
+				continue
+			}
+			if stream := strings.TrimPrefix(key, prefix); streamNamePattern.MatchString(stream) {
+				streams = append(streams, stream)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return streams, err
+		}
+	}
+	return streams, nil
+}
+
+// bootstrapState loads the newest samples of every known stream into the
+// in-memory buffer and marks each stream as last seen at its newest
+// sample, then reports the service ready. It stops early when ctx is done;
+// streams not restored by then simply warm up from live traffic, as they
+// did before bootstrapping existed.
+func bootstrapState(ctx context.Context) {
+	defer appState.ready.Store(true)
+
+	streams, err := knownStreams(ctx)
+	if err != nil {
+		log.Printf("Bootstrap: listing streams failed, restored what was found: %v", err)
+	}
+	restored := 0
+	for _, stream := range streams {
+		items, err := recentSamples(ctx, stream, appState.buffer.size)
+		if err != nil {
+			log.Printf("Bootstrap: reading stream %s failed: %v", stream, err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		window := decodeWindow(items)
+		if len(window) == 0 {
+			continue
+		}
+		appState.buffer.sync(stream, window)
+		appState.restoreSeen(stream, window[len(window)-1].Timestamp)
+		log.Printf("Bootstrap: restored %d samples for stream %s", len(window), stream)
+		restored++
+	}
+	log.Printf("Bootstrap: restored %d of %d streams", restored, len(streams))
+}
+
+// handleReady is the readiness probe: 503 until bootstrapState has run, so
+// no traffic is routed to a pod whose windows are still empty.
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	if !appState.ready.Load() {
+		writeError(w, http.StatusServiceUnavailable, "not_ready", "bootstrapping")
+		return
+	}
+	w.Write([]byte("ready\n"))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBootstrapState(t *testing.T) {
+	mr := newTestAppState(t)
+	pushSamples(t, mr, appState.metricsKey(defaultStream), 1, 2, 3, 4, 5, 6, 7)
+	pushSamples(t, mr, appState.metricsKey("web"), 10, 20)
+	// Keys that look like streams but are not must be left alone.
+	pushSamples(t, mr, appState.compactedKey("web"), 99)
+	mr.RPush(appState.key("deadletter"), "x")
+
+	mux := newMux()
+	probe := func() int {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		return w.Code
+	}
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("ready before bootstrap = %d, want 503", code)
+	}
+
+	bootstrapState(context.Background())
+
+	if code := probe(); code != http.StatusOK {
+		t.Errorf("ready after bootstrap = %d, want 200", code)
+	}
+	tests := []struct {
+		stream   string
+		wantRPS  []float64
+		wantSeen time.Time
+	}{
+		// The buffer holds 5 samples per stream.
+		{stream: defaultStream, wantRPS: []float64{3, 4, 5, 6, 7}, wantSeen: time.Date(2024, 1, 1, 0, 0, 6, 0, time.UTC)},
+		{stream: "web", wantRPS: []float64{10, 20}, wantSeen: time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := rpsOf(appState.buffer.window(tt.stream)); !equalFloats(got, tt.wantRPS) {
+			t.Errorf("%s: buffer = %v, want %v", tt.stream, got, tt.wantRPS)
+		}
+		if got := appState.lastSeen[tt.stream]; !got.Equal(tt.wantSeen) {
+			t.Errorf("%s: last seen %v, want %v", tt.stream, got, tt.wantSeen)
+		}
+	}
+	var streams []string
+	for stream := range appState.buffer.snapshot() {
+		streams = append(streams, stream)
+	}
+	slices.Sort(streams)
+	if !slices.Equal(streams, []string{defaultStream, "web"}) {
+		t.Errorf("restored streams = %v", streams)
+	}
+}
+
+func TestBootstrapStateTimeout(t *testing.T) {
+	mr := newTestAppState(t)
+	pushSamples(t, mr, appState.metricsKey(defaultStream), 1, 2, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	bootstrapState(ctx)
+
+	if !appState.ready.Load() {
+		t.Error("not ready after a bootstrap that ran out of time")
+	}
+	if got := appState.buffer.window(defaultStream); len(got) != 0 {
+		t.Errorf("buffer = %v, want nothing restored", got)
+	}
+}
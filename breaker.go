@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+// redisBreakerHook runs every Redis command through a circuit breaker, so
+// that once Redis is known to be failing callers get gobreaker.ErrOpenState
+// immediately instead of each waiting out its own timeout.
+type redisBreakerHook struct {
+	cb *gobreaker.CircuitBreaker
+}
+
+// inBreakerKey marks a context that is already inside a breaker call.
+// go-redis runs connection setup commands (HELLO, SELECT) through the same
+// hooks with the caller's context; counting those separately would make a
+// half-open probe reject its own handshake.
+type inBreakerKey struct{}
+
+func (h redisBreakerHook) execute(ctx context.Context, run func(context.Context) error) error {
+	if ctx.Value(inBreakerKey{}) != nil {
+		return run(ctx)
+	}
+	ctx = context.WithValue(ctx, inBreakerKey{}, true)
+	_, err := h.cb.Execute(func() (interface{}, error) {
+		return nil, run(ctx)
+	})
+	return err
+}
+
+func (h redisBreakerHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h redisBreakerHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := h.execute(ctx, func(ctx context.Context) error {
+			return next(ctx, cmd)
+		})
+		if isBreakerRejection(err) {
+			cmd.SetErr(err)
+		}
+		return err
+	}
+}
+
+func (h redisBreakerHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := h.execute(ctx, func(ctx context.Context) error {
+			return next(ctx, cmds)
+		})
+		if isBreakerRejection(err) {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+		}
+		return err
+	}
+}
+
+// errRedisDown is returned without calling Redis while the health monitor
+// finds it unreachable. It counts as a breaker rejection.
+var errRedisDown = fmt.Errorf("redis health check failing: %w", gobreaker.ErrOpenState)
+
+// isBreakerRejection reports whether err came from the breaker itself
+// rather than from Redis.
+func isBreakerRejection(err error) bool {
+	return errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests)
+}
+
+// breakerSuccess decides what counts against the breaker. Any reply from the
+// server, including redis.Nil and script errors, proves Redis is reachable;
+// only transport failures and timeouts trip it. Callers cancelling their own
+// context say nothing about Redis either.
+func breakerSuccess(err error) bool {
+	var redisErr redis.Error
+	return err == nil || errors.As(err, &redisErr) || errors.Is(err, context.Canceled)
+}
+
+// newRedisBreaker opens after failures consecutive failed commands and lets
+// a probe through after timeout. The go_service_redis_breaker_state gauge
+// tracks its state; when it closes again, onClose is called, to write back
+// the samples buffered in memory while it was open. onClose runs inside
+// the Redis command that closed the breaker, so it must not block.
+func newRedisBreaker(failures uint32, timeout time.Duration, onClose func()) *gobreaker.CircuitBreaker {
+	return gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:    "redis",
+		Timeout: timeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= failures
+		},
+		IsSuccessful: breakerSuccess,
+		OnStateChange: func(_ string, from, to gobreaker.State) {
+			log.Printf("Redis circuit breaker %s -> %s", from, to)
+			appState.breakerStateGauge.Set(float64(to))
+			if to == gobreaker.StateClosed {
+				onClose()
+			}
+		},
+	})
+}
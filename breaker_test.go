@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+func TestBreakerSuccess(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: true},
+		{name: "redis nil", err: redis.Nil, want: true},
+		{name: "caller cancelled", err: context.Canceled, want: true},
+		{name: "timeout", err: context.DeadlineExceeded, want: false},
+		{name: "connection closed", err: io.EOF, want: false},
+		{name: "breaker open", err: gobreaker.ErrOpenState, want: false},
+	}
+	for _, tt := range tests {
+		if got := breakerSuccess(tt.err); got != tt.want {
+			t.Errorf("%s: breakerSuccess(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+
+	// Server error replies mean Redis answered.
+	mr := newTestAppState(t)
+	mr.Set("str", "x")
+	err := appState.redisClient.LPush(context.Background(), "str", "y").Err()
+	if err == nil || !breakerSuccess(err) {
+		t.Errorf("WRONGTYPE reply %v should count as success", err)
+	}
+}
+
+func TestBreakerDegradedPathAndRecovery(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.redisClient.AddHook(redisBreakerHook{cb: newRedisBreaker(2, 50*time.Millisecond, appState.recovery.trigger)})
+	ctx := context.Background()
+
+	mr.Close()
+	// The failed push and the failed dead-letter write trip the breaker.
+	if _, err := processMetric(ctx, slog.Default(), defaultStream, Metric{RPS: 1}); err == nil {
+		t.Fatal("processMetric succeeded with Redis down and breaker closed")
+	}
+	if got := gaugeValue(appState.breakerStateGauge); got != float64(gobreaker.StateOpen) {
+		t.Fatalf("breaker state gauge = %v, want open", got)
+	}
+
+	start := time.Now()
+	for _, rps := range []float64{2, 3} {
+		if _, err := processMetric(ctx, slog.Default(), defaultStream, Metric{RPS: rps}); err != nil {
+			t.Fatalf("processMetric with open breaker = %v, want in-memory success", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("open breaker took %v, want an immediate short-circuit", elapsed)
+	}
+	if got := len(appState.buffer.window(defaultStream)); got != 2 {
+		t.Errorf("in-memory window = %d samples, want 2", got)
+	}
+
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	// Once the open timeout passes a probe gets through, closes the breaker
+	// and flushes the buffer.
+	for i := 0; i < 50 && gaugeValue(appState.breakerStateGauge) != float64(gobreaker.StateClosed); i++ {
+		time.Sleep(60 * time.Millisecond)
+		appState.redisClient.Ping(ctx)
+	}
+	if got := gaugeValue(appState.breakerStateGauge); got != float64(gobreaker.StateClosed) {
+		t.Fatalf("breaker state gauge = %v, want closed", got)
+	}
+	appState.recovery.wait()
+	if stored, _ := mr.List("metrics"); len(stored) != 2 {
+		t.Errorf("flushed %d buffered samples, want 2", len(stored))
+	}
+}
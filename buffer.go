@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// sampleBuffer keeps the most recent samples of each stream in memory. It
+// backs detection while the Redis circuit breaker is open, and holds the
+// samples accepted during that time until they can be written to Redis.
+// Both views are bounded by size per stream; the oldest entries go first.
+type sampleBuffer struct {
+	mu      sync.Mutex
+	size    int
+	recent  map[string][]Metric
+	pending map[string][]Metric
+	// rpsStats follows the RPS window of recent as samples come and go.
+	// It is built on first use, and again whenever the window size
+	// changes.
+	rpsStats map[string]*slidingStats
+	// flushMu serializes flushPendingSamples, so batches taken one after
+	// the other reach Redis in that order.
+	flushMu sync.Mutex
+}
+
+func newSampleBuffer(size int) *sampleBuffer {
+	return &sampleBuffer{
+		size:     size,
+		recent:   make(map[string][]Metric),
+		pending:  make(map[string][]Metric),
+		rpsStats: make(map[string]*slidingStats),
+	}
+}
+
+func (b *sampleBuffer) appendBounded(samples []Metric, m ...Metric) []Metric {
+	samples = append(samples, m...)
+	if over := len(samples) - b.size; over > 0 {
+		samples = samples[over:]
+	}
+	return samples
+}
+
+func (b *sampleBuffer) pushRecent(stream string, m Metric) {
+	samples := append(b.recent[stream], m)
+	if s := b.rpsStats[stream]; s != nil {
+		s.push(samples)
+	}
+	b.recent[stream] = b.appendBounded(samples)
+}
+
+// addRecent records a sample that will not be written to Redis from here,
+// either because it already was or because it was not sampled.
+func (b *sampleBuffer) addRecent(stream string, m Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pushRecent(stream, m)
+}
+
+// addPending records a sample that still has to be written to Redis.
+func (b *sampleBuffer) addPending(stream string, m Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pushRecent(stream, m)
+	b.pending[stream] = b.appendBounded(b.pending[stream], m)
+}
+
+// window returns a copy of the buffered samples for stream, oldest first.
+func (b *sampleBuffer) window(stream string) []Metric {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Metric(nil), b.recent[stream]...)
+}
+
+// snapshot returns a copy of the buffered samples of every stream.
+func (b *sampleBuffer) snapshot() map[string][]Metric {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	windows := make(map[string][]Metric, len(b.recent))
+	for stream, samples := range b.recent {
+		windows[stream] = append([]Metric(nil), samples...)
+	}
+	return windows
+}
+
+// rpsWindow is the RPS window of a stream with its mean, variance and
+// bounds. lo and hi are only set while the window holds values.
+type rpsWindow struct {
+	stats  runningStats
+	lo, hi float64
+}
+
+// rpsWindows returns the statistics of the last window RPS values of every
+// stream. They are kept up to date as samples are added, rather than
+// recomputed here.
+func (b *sampleBuffer) rpsWindows(window int) map[string]rpsWindow {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	windows := make(map[string]rpsWindow, len(b.recent))
+	for stream := range b.recent {
+		s := b.slidingStats(stream, window)
+		w := rpsWindow{stats: s.stats}
+		if s.stats.n > 0 {
+			w.lo, w.hi = s.bounds()
+		}
+		windows[stream] = w
+	}
+	return windows
+}
+
+// windowStats returns the running stats of the last window RPS values of
+// stream, provided the buffer still holds exactly samples for it, as it
+// does right after handing them out or being synced to them.
+func (b *sampleBuffer) windowStats(stream string, samples []Metric, window int) (runningStats, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	recent := b.recent[stream]
+	if len(samples) == 0 || len(recent) != len(samples) || !sameSample(recent[len(recent)-1], samples[len(samples)-1]) {
+		return runningStats{}, false
+	}
+	return b.slidingStats(stream, window).stats, true
+}
+
+// slidingStats returns the running RPS stats of stream, building them if
+// there are none yet or they cover another window size. b.mu must be held.
+func (b *sampleBuffer) slidingStats(stream string, window int) *slidingStats {
+	window = min(window, b.size)
+	s := b.rpsStats[stream]
+	if s == nil || s.window != window {
+		s = newSlidingStats(b.recent[stream], window)
+		b.rpsStats[stream] = s
+	}
+	return s
+}
+
+// sync replaces the recent samples for stream with window, as just read
+// back from Redis, followed by any samples still pending for it. This keeps
+// the buffer consistent with Redis instead of drifting from it. The running
+// RPS stats of stream take in the samples appended since the last sync;
+// they are rebuilt on next use if the two cannot be lined up.
+func (b *sampleBuffer) sync(stream string, window []Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	old := b.recent[stream]
+	recent := append([]Metric(nil), window...)
+	recent = b.appendBounded(recent, b.pending[stream]...)
+	b.recent[stream] = recent
+	s := b.rpsStats[stream]
+	if s == nil {
+		return
+	}
+	added, ok := appendedSince(old, recent)
+	if !ok {
+		delete(b.rpsStats, stream)
+		return
+	}
+	// old is no longer referenced, so it can grow in place.
+	for _, m := range added {
+		old = append(old, m)
+		s.push(old)
+	}
+}
+
+// appendedSince returns the samples recent holds after those of old, when
+// recent continues old: the raw list is only appended to and trimmed from
+// the front, so old's last sample is found near the end of recent. The
+// first sample the two still share is compared as well, which rules out a
+// repeated sample being taken for old's last.
+func appendedSince(old, recent []Metric) ([]Metric, bool) {
+	if len(old) == 0 {
+		return nil, false
+	}
+	last := old[len(old)-1]
+	for i := len(recent) - 1; i >= 0; i-- {
+		if !sameSample(recent[i], last) {
+			continue
+		}
+		shared := min(i+1, len(old))
+		if sameSample(recent[i+1-shared], old[len(old)-shared]) {
+			return recent[i+1:], true
+		}
+	}
+	return nil, false
+}
+
+// sameSample reports whether a and b are the same sample, which may have
+// been decoded from Redis since.
+func sameSample(a, b Metric) bool {
+	return a.Timestamp.Equal(b.Timestamp) && a.RPS == b.RPS && a.CPU == b.CPU
+}
+
+// pendingCount returns the number of samples waiting to be written.
+func (b *sampleBuffer) pendingCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, samples := range b.pending {
+		n += len(samples)
+	}
+	return n
+}
+
+// takePending removes and returns every pending sample, keyed by stream.
+func (b *sampleBuffer) takePending() map[string][]Metric {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	taken := b.pending
+	b.pending = make(map[string][]Metric)
+	return taken
+}
+
+// requeue puts samples that could not be flushed back in front of any
+// pending samples added since.
+func (b *sampleBuffer) requeue(stream string, samples []Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending[stream] = b.appendBounded(samples, b.pending[stream]...)
+}
+
+// flushPendingSamples writes samples buffered while Redis was unavailable,
+// or held back for write-behind, to their streams, in arrival order, and
+// reports how many were written. Samples already present in the raw list
+// are skipped, so a flush that is retried after a partial failure or a
+// restart does not duplicate them. Streams that fail are requeued for the
+// next attempt and the last error is returned.
+func flushPendingSamples(ctx context.Context) (int, error) {
+	appState.buffer.flushMu.Lock()
+	defer appState.buffer.flushMu.Unlock()
+	flushed := 0
+	var lastErr error
+	for stream, samples := range appState.buffer.takePending() {
+		n, err := flushStream(ctx, stream, samples)
+		if err != nil {
+			log.Printf("Failed to flush %d buffered samples for stream %s: %v", len(samples), stream, err)
+			appState.buffer.requeue(stream, samples)
+			appState.flushCounter.WithLabelValues("error").Inc()
+			lastErr = err
+			continue
+		}
+		logSampled(slog.Default(), "Flushed buffered samples", "stream", stream, "written", n, "already_stored", len(samples)-n)
+		appState.flushCounter.WithLabelValues("ok").Inc()
+		appState.flushedSamplesCounter.Add(float64(n))
+		flushed += n
+	}
+	return flushed, lastErr
+}
+
+// recoveryFlusher runs the flushes started when the Redis circuit breaker
+// closes. They run in the background, as the breaker calls back from
+// inside a Redis command, but are tracked so that shutdown and tests can
+// wait for them. Once stopped, for the shutdown flush, it starts no more.
+type recoveryFlusher struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+func newRecoveryFlusher() *recoveryFlusher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &recoveryFlusher{ctx: ctx, cancel: cancel}
+}
+
+// trigger starts a flush of the pending samples in the background.
+func (f *recoveryFlusher) trigger() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopped {
+		return
+	}
+	f.wg.Go(func() { flushPendingSamples(f.ctx) })
+}
+
+// wait returns once every flush started so far is done.
+func (f *recoveryFlusher) wait() {
+	f.wg.Wait()
+}
+
+// stop cancels a running flush, whose unwritten samples are requeued, and
+// waits for it, so nothing flushes behind the caller's back afterwards.
+func (f *recoveryFlusher) stop() {
+	f.mu.Lock()
+	f.stopped = true
+	f.mu.Unlock()
+	f.cancel()
+	f.wg.Wait()
+}
+
+// sampleID identifies a sample independently of its encoding and of the
+// timestamp's zone.
+type sampleID struct {
+	ts       int64
+	cpu, rps float64
+}
+
+func idOf(m Metric) sampleID {
+	return sampleID{ts: m.Timestamp.UnixNano(), cpu: m.CPU, rps: m.RPS}
+}
+
+func flushStream(ctx context.Context, stream string, samples []Metric) (int, error) {
+	stored, err := recentSamples(ctx, stream, appState.rawRetention)
+	if err != nil {
+		return 0, err
+	}
+	// Compare decoded samples rather than stored bytes, since the stored
+	// copy may have been written with another codec.
+	seen := make(map[sampleID]bool, len(stored))
+	for _, m := range decodeWindow(stored) {
+		seen[idOf(m)] = true
+	}
+
+	values := make([]interface{}, 0, len(samples))
+	for _, m := range samples {
+		if seen[idOf(m)] {
+			continue
+		}
+		data, err := encodeMetric(m, appState.codec)
+		if err != nil {
+			return 0, err
+		}
+		values = append(values, data)
+	}
+	if len(values) == 0 {
+		return 0, nil
+	}
+	pipe := appState.redisClient.TxPipeline()
+	appState.store.Append(ctx, pipe, stream, values...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return len(values), nil
+}
+
+// flushOnShutdown makes a last attempt, bounded by timeout, to write samples
+// buffered during a Redis outage before the process exits. A flush started
+// by the circuit breaker closing is stopped first, so the two never run
+// together. Whatever cannot be written is lost, and logged as such.
+func flushOnShutdown(timeout time.Duration) {
+	appState.recovery.stop()
+	if appState.buffer.pendingCount() == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := flushPendingSamples(ctx); err != nil {
+		log.Printf("Shutdown flush incomplete, %d buffered samples lost: %v", appState.buffer.pendingCount(), err)
+	}
+}
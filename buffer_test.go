@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func rpsOf(samples []Metric) []float64 {
+	var out []float64
+	for _, m := range samples {
+		out = append(out, m.RPS)
+	}
+	return out
+}
+
+func equalFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSampleBuffer(t *testing.T) {
+	b := newSampleBuffer(3)
+	for i := 0; i < 5; i++ {
+		b.addPending("s", Metric{RPS: float64(i)})
+	}
+	if got := rpsOf(b.window("s")); !equalFloats(got, []float64{2, 3, 4}) {
+		t.Errorf("window = %v, want [2 3 4]", got)
+	}
+	if n := b.pendingCount(); n != 3 {
+		t.Errorf("pendingCount = %d, want 3", n)
+	}
+
+	pending := b.takePending()["s"]
+	if got := rpsOf(pending); !equalFloats(got, []float64{2, 3, 4}) {
+		t.Fatalf("pending = %v, want [2 3 4]", got)
+	}
+	if b.pendingCount() != 0 {
+		t.Error("takePending left samples behind")
+	}
+
+	b.addPending("s", Metric{RPS: 9})
+	b.requeue("s", pending)
+	if got := rpsOf(b.takePending()["s"]); !equalFloats(got, []float64{3, 4, 9}) {
+		t.Errorf("after requeue = %v, want [3 4 9]", got)
+	}
+}
+
+func TestSampleBufferSync(t *testing.T) {
+	b := newSampleBuffer(4)
+	b.addPending("s", Metric{RPS: 9})
+	b.sync("s", []Metric{{RPS: 1}, {RPS: 2}, {RPS: 3}, {RPS: 4}})
+	// Redis' window first, then what Redis does not have yet.
+	if got := rpsOf(b.window("s")); !equalFloats(got, []float64{2, 3, 4, 9}) {
+		t.Errorf("window = %v, want [2 3 4 9]", got)
+	}
+	b.takePending()
+	b.sync("s", []Metric{{RPS: 5}})
+	if got := rpsOf(b.window("s")); !equalFloats(got, []float64{5}) {
+		t.Errorf("window = %v, want [5]", got)
+	}
+}
+
+func TestFlushOnShutdownWritesPendingSamples(t *testing.T) {
+	mr := newTestAppState(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := []Metric{
+		{Timestamp: base, RPS: 1},
+		{Timestamp: base.Add(time.Second), RPS: 2},
+		{Timestamp: base.Add(2 * time.Second), RPS: 3},
+	}
+	for _, m := range samples {
+		appState.buffer.addPending(defaultStream, m)
+	}
+	appState.buffer.addPending("web", Metric{Timestamp: base, RPS: 7})
+	// The first sample made it to Redis before the outage was noticed.
+	first, _ := json.Marshal(samples[0])
+	mr.RPush("metrics", string(first))
+
+	flushOnShutdown(time.Second)
+
+	stored, _ := mr.List("metrics")
+	var got []Metric
+	for _, item := range stored {
+		var m Metric
+		json.Unmarshal([]byte(item), &m)
+		got = append(got, m)
+	}
+	if rps := rpsOf(got); !equalFloats(rps, []float64{1, 2, 3}) {
+		t.Errorf("metrics = %v, want [1 2 3] without duplicates", rps)
+	}
+	if web, _ := mr.List("metrics:web"); len(web) != 1 {
+		t.Errorf("metrics:web has %d samples, want 1", len(web))
+	}
+	if n := appState.buffer.pendingCount(); n != 0 {
+		t.Errorf("%d samples still pending after flush", n)
+	}
+}
+
+func TestFlushOnShutdownWhileBreakerCloses(t *testing.T) {
+	mr := newTestAppState(t)
+	for rps := range 5 {
+		appState.buffer.addPending(defaultStream, Metric{Timestamp: time.Unix(int64(rps), 0).UTC(), RPS: float64(rps)})
+	}
+	// The breaker closes just as shutdown begins.
+	var closing sync.WaitGroup
+	closing.Go(appState.recovery.trigger)
+	flushOnShutdown(time.Second)
+	closing.Wait()
+	appState.recovery.trigger()
+
+	if stored, _ := mr.List("metrics"); len(stored) != 5 {
+		t.Errorf("stored %d samples, want each of the 5 once", len(stored))
+	}
+	if n := appState.buffer.pendingCount(); n != 0 {
+		t.Errorf("%d samples still pending after flush", n)
+	}
+}
+
+func TestFlushOnShutdownKeepsSamplesWhenRedisIsDown(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.buffer.addPending(defaultStream, Metric{RPS: 1})
+	mr.Close()
+
+	start := time.Now()
+	flushOnShutdown(200 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("flush took %v, want it bounded by the timeout", elapsed)
+	}
+	if n := appState.buffer.pendingCount(); n != 1 {
+		t.Errorf("pendingCount = %d, want the sample requeued", n)
+	}
+
+	// A later retry, once Redis is back, must not lose it either.
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := flushPendingSamples(context.Background()); err != nil || n != 1 {
+		t.Errorf("flushPendingSamples = (%d, %v), want (1, nil)", n, err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+const defaultTargetRate = 0.01
+
+// suggestThreshold scores every value in window by its absolute z-score
+// against the window and returns the smallest threshold that flags no more
+// than targetRate of them, with the number it flags. The threshold is the
+// score of the k-th highest sample, k = floor(targetRate·n), since only
+// scores strictly above the threshold are flagged; ties can make the count
+// lower than k.
+func suggestThreshold(window []float64, targetRate float64, method StdDevMethod) (threshold float64, flagged int) {
+	mean := calculateAverage(window)
+	stdDev := calculateStandardDeviation(window, mean, method)
+	scores := make([]float64, len(window))
+	if stdDev > 0 {
+		for i, v := range window {
+			scores[i] = math.Abs(v-mean) / stdDev
+		}
+	}
+	slices.Sort(scores)
+	slices.Reverse(scores)
+
+	k := int(targetRate * float64(len(scores)))
+	threshold = scores[min(k, len(scores)-1)]
+	for _, s := range scores {
+		if s > threshold {
+			flagged++
+		}
+	}
+	return threshold, flagged
+}
+
+// handleCalibrate suggests a z-score threshold that would flag about
+// target_rate of the samples in a stream's current RPS window. It is
+// read-only; the live detector keeps its configured threshold.
+func handleCalibrate(w http.ResponseWriter, r *http.Request) {
+	stream, err := streamFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	targetRate := defaultTargetRate
+	if v := r.URL.Query().Get("target_rate"); v != "" {
+		targetRate, err = strconv.ParseFloat(v, 64)
+		if err != nil || targetRate <= 0 || targetRate >= 1 {
+			writeError(w, http.StatusBadRequest, "invalid_request", "target_rate must be a number between 0 and 1")
+			return
+		}
+	}
+
+	ctx := r.Context()
+	items, err := recentSamples(ctx, stream, appState.windowFor("rps"))
+	if err != nil {
+		loggerFrom(ctx).Error("Redis LRANGE error", "stream", stream, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Error reading window")
+		return
+	}
+	var window []float64
+	for _, m := range decodeWindow(items) {
+		window = append(window, m.RPS)
+	}
+	if len(window) < max(appState.minSamples, 2) {
+		writeError(w, http.StatusConflict, "insufficient_samples", fmt.Sprintf("stream has %d samples, calibration needs at least %d", len(window), max(appState.minSamples, 2)))
+		return
+	}
+
+	threshold, flagged := suggestThreshold(window, targetRate, appState.stdDevMethod)
+	writeBody(w, r, http.StatusOK, map[string]interface{}{
+		"stream":              stream,
+		"target_rate":         targetRate,
+		"samples":             len(window),
+		"suggested_threshold": appState.round(threshold),
+		"implied_anomalies":   flagged,
+		"implied_rate":        appState.round(float64(flagged) / float64(len(window))),
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSuggestThreshold(t *testing.T) {
+	tests := []struct {
+		name          string
+		window        []float64
+		targetRate    float64
+		wantThreshold float64
+		wantFlagged   int
+	}{
+		{name: "one spike at 20%", window: []float64{10, 10, 10, 10, 50}, targetRate: 0.2, wantThreshold: 0.4472, wantFlagged: 1},
+		{name: "rate below one sample", window: []float64{10, 10, 10, 10, 50}, targetRate: 0.1, wantThreshold: 1.7889, wantFlagged: 0},
+		{name: "flat window", window: []float64{3, 3, 3, 3}, targetRate: 0.5, wantThreshold: 0, wantFlagged: 0},
+		{name: "ties flag fewer", window: []float64{0, 0, 10, 10}, targetRate: 0.25, wantThreshold: 0.866, wantFlagged: 0},
+	}
+	for _, tt := range tests {
+		threshold, flagged := suggestThreshold(tt.window, tt.targetRate, StdDevSample)
+		if math.Abs(threshold-tt.wantThreshold) > 1e-4 || flagged != tt.wantFlagged {
+			t.Errorf("%s: suggestThreshold = %v, %d; want %v, %d", tt.name, threshold, flagged, tt.wantThreshold, tt.wantFlagged)
+		}
+	}
+}
+
+func TestHandleCalibrate(t *testing.T) {
+	mr := newTestAppState(t)
+	pushSamples(t, mr, "metrics:web", 10, 10, 10, 10, 50)
+	pushSamples(t, mr, "metrics:cold", 10)
+	tests := []struct {
+		target        string
+		wantCode      int
+		wantThreshold float64
+		wantFlagged   int
+	}{
+		{target: "/calibrate/web?target_rate=0.2", wantCode: http.StatusOK, wantThreshold: 0.4472, wantFlagged: 1},
+		{target: "/calibrate?stream=web", wantCode: http.StatusOK, wantThreshold: 1.7889, wantFlagged: 0},
+		{target: "/calibrate/cold", wantCode: http.StatusConflict},
+		{target: "/calibrate/web?target_rate=1", wantCode: http.StatusBadRequest},
+		{target: "/calibrate/web?target_rate=x", wantCode: http.StatusBadRequest},
+	}
+	mux := newMux()
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var body struct {
+				Threshold float64 `json:"suggested_threshold"`
+				Flagged   int     `json:"implied_anomalies"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Threshold != tt.wantThreshold || body.Flagged != tt.wantFlagged {
+				t.Errorf("got threshold %v, %d flagged; want %v, %d", body.Threshold, body.Flagged, tt.wantThreshold, tt.wantFlagged)
+			}
+		})
+	}
+}
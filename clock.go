@@ -0,0 +1,22 @@
+package main
+
+import "time"
+
+// Clock is the source of the current time. Time-dependent paths go through
+// AppState.now rather than time.Now so tests can drive them with a fake.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// now returns the current time from the configured clock, falling back to
+// the wall clock for states built without one.
+func (s *AppState) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
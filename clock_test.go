@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when told to.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock(t time.Time) *fakeClock { return &fakeClock{t: t} }
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestFakeClockDrivesTimestamps(t *testing.T) {
+	mr := newTestAppState(t)
+	clock := appState.clock.(*fakeClock)
+	clock.Advance(90 * time.Minute)
+	want := time.Date(2024, 1, 1, 1, 30, 0, 0, time.UTC)
+
+	deadLetter(context.Background(), slog.Default(), defaultStream, Metric{}, errors.New("boom"))
+	stored, _ := mr.List("deadletter")
+	var entry DeadLetter
+	if len(stored) != 1 || json.Unmarshal([]byte(stored[0]), &entry) != nil || !entry.FailedAt.Equal(want) {
+		t.Fatalf("dead letter = %v, want failed_at %v", stored, want)
+	}
+
+	w := httptest.NewRecorder()
+	handleAnalyze(w, httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader(`{"rps": 5, "cpu": 1}`)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	var raw []string
+	for i := 0; i < 100 && len(raw) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		raw, _ = mr.List("metrics")
+	}
+	var m Metric
+	if len(raw) != 1 || json.Unmarshal([]byte(raw[0]), &m) != nil || !m.Timestamp.Equal(want) {
+		t.Errorf("stored metric = %v, want timestamp %v", raw, want)
+	}
+}
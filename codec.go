@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// StorageCodec is how samples are serialized on the raw Redis lists
+// (STORAGE_CODEC). Only writes use it: every entry is decoded by sniffing
+// its first byte, so lists written under different codecs, for example
+// during a rollout, stay readable.
+//
+// Measured on a typical sample, {"timestamp":"2024-01-01T12:34:56.789Z",
+// "cpu":42.5,"rps":1234.5}, the encoded entry is 64 bytes as json, 85 as
+// json-gzip and 47 as msgpack (see TestCodecSizes). gzip's fixed header
+// and checksum cost more than deflate saves on a single small sample, so
+// json-gzip only pays off once samples carry more fields; msgpack saves
+// about a quarter of the raw list memory.
+type StorageCodec string
+
+const (
+	// CodecJSON stores plain JSON. It is the default.
+	CodecJSON StorageCodec = "json"
+	// CodecJSONGzip stores gzip-compressed JSON.
+	CodecJSONGzip StorageCodec = "json-gzip"
+	// CodecMsgpack stores MessagePack, keyed by the JSON field names.
+	CodecMsgpack StorageCodec = "msgpack"
+)
+
+func parseStorageCodec(v string) (StorageCodec, error) {
+	switch StorageCodec(v) {
+	case "", CodecJSON:
+		return CodecJSON, nil
+	case CodecJSONGzip, CodecMsgpack:
+		return StorageCodec(v), nil
+	default:
+		return "", fmt.Errorf("unknown storage codec %q (want json, json-gzip or msgpack)", v)
+	}
+}
+
+// encodeMetric serializes m with codec. The zero codec is CodecJSON.
+func encodeMetric(m Metric, codec StorageCodec) ([]byte, error) {
+	switch codec {
+	case CodecJSONGzip:
+		data, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		zw.Write(data)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CodecMsgpack:
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		if err := enc.Encode(m); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return json.Marshal(m)
+	}
+}
+
+// decodeMetric parses a stored sample written by any codec. gzip data
+// starts with its magic bytes 1f 8b, and a MessagePack map with a
+// fixmap/map16/map32 marker, none of which can start JSON.
+func decodeMetric(data []byte) (Metric, error) {
+	var m Metric
+	switch {
+	case len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return m, err
+		}
+		raw, err := io.ReadAll(zr)
+		if err != nil {
+			return m, err
+		}
+		err = json.Unmarshal(raw, &m)
+		return m, err
+	case len(data) > 0 && (data[0]&0xf0 == 0x80 || data[0] == 0xde || data[0] == 0xdf):
+		dec := msgpack.NewDecoder(bytes.NewReader(data))
+		dec.SetCustomStructTag("json")
+		err := dec.Decode(&m)
+		m.Timestamp = m.Timestamp.UTC()
+		return m, err
+	default:
+		err := json.Unmarshal(data, &m)
+		return m, err
+	}
+}
+
+// decodeWindow decodes stored samples in order, skipping entries that do
+// not parse.
+func decodeWindow(items []string) []Metric {
+	window := make([]Metric, 0, len(items))
+	for _, item := range items {
+		if m, err := decodeMetric([]byte(item)); err == nil {
+			window = append(window, m)
+		}
+	}
+	return window
+}
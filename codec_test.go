@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseStorageCodec(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    StorageCodec
+		wantErr bool
+	}{
+		{in: "", want: CodecJSON},
+		{in: "json", want: CodecJSON},
+		{in: "json-gzip", want: CodecJSONGzip},
+		{in: "msgpack", want: CodecMsgpack},
+		{in: "protobuf", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseStorageCodec(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseStorageCodec(%q) = %q, %v; want %q, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	m := Metric{Timestamp: time.Date(2024, 1, 1, 12, 34, 56, 789000000, time.UTC), CPU: 42.5, RPS: 1234.5}
+	for _, codec := range []StorageCodec{"", CodecJSON, CodecJSONGzip, CodecMsgpack} {
+		data, err := encodeMetric(m, codec)
+		if err != nil {
+			t.Fatalf("%q: encode: %v", codec, err)
+		}
+		got, err := decodeMetric(data)
+		if err != nil {
+			t.Fatalf("%q: decode: %v", codec, err)
+		}
+		if got != m {
+			t.Errorf("%q: round trip = %+v, want %+v", codec, got, m)
+		}
+	}
+	if _, err := decodeMetric([]byte("not json")); err == nil {
+		t.Error("decodeMetric accepted garbage")
+	}
+}
+
+// TestCodecSizes pins the per-sample sizes quoted on StorageCodec.
+func TestCodecSizes(t *testing.T) {
+	m := Metric{Timestamp: time.Date(2024, 1, 1, 12, 34, 56, 789000000, time.UTC), CPU: 42.5, RPS: 1234.5}
+	tests := []struct {
+		codec StorageCodec
+		want  int
+	}{
+		{CodecJSON, 64},
+		{CodecJSONGzip, 85},
+		{CodecMsgpack, 47},
+	}
+	for _, tt := range tests {
+		data, _ := encodeMetric(m, tt.codec)
+		if len(data) != tt.want {
+			t.Errorf("%s: %d bytes, want %d", tt.codec, len(data), tt.want)
+		}
+	}
+}
+
+// A stream written under each codec in turn, as during a rollout, must
+// read back as one window everywhere the raw list is read.
+func TestMixedCodecWindow(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.windowSize = 6
+	ctx := context.Background()
+	codecs := []StorageCodec{CodecJSON, CodecJSONGzip, CodecMsgpack, CodecJSON, CodecMsgpack, CodecJSONGzip}
+	var last AnalysisResult
+	for i, codec := range codecs {
+		appState.codec = codec
+		m := Metric{Timestamp: appState.now().Add(time.Duration(i) * time.Second), RPS: float64(i + 1)}
+		var err error
+		if last, err = processMetric(ctx, slog.Default(), "mixed", m); err != nil {
+			t.Fatalf("processMetric with %s: %v", codec, err)
+		}
+	}
+	if last.Samples != len(codecs) || last.RollingAvg != 3.5 {
+		t.Errorf("result = %+v, want %d samples averaging 3.5", last, len(codecs))
+	}
+
+	w := httptest.NewRecorder()
+	newMux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/history/mixed", nil))
+	var body struct {
+		Samples []Metric `json:"samples"`
+	}
+	json.NewDecoder(w.Body).Decode(&body)
+	if got := rpsOf(body.Samples); !equalFloats(got, []float64{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("history rps = %v, want 1..6", got)
+	}
+
+	// Compaction decodes every codec instead of dropping entries.
+	appState.windowSize = 2
+	compacted, buckets, skipped, err := compactStream(ctx, "mixed", 2)
+	if err != nil || compacted != 4 || buckets != 2 || skipped != 0 {
+		t.Errorf("compactStream = %d, %d, %d, %v; want 4, 2, 0, nil", compacted, buckets, skipped, err)
+	}
+	if raw, _ := mr.List("metrics:mixed"); len(raw) != 2 {
+		t.Errorf("raw list length = %d, want 2", len(raw))
+	}
+}
+
+func TestCompactCommitConflict(t *testing.T) {
+	mr := newCompactTestState(t)
+	pushSamples(t, mr, "metrics", 10, 20, 1, 2, 3)
+	keys := []string{"metrics", "metrics_compacted"}
+	ok, err := compactCommitScript.Run(context.Background(), appState.redisClient, keys, 2, "stale", "stale", 100, "{}").Int()
+	if err != nil || ok != 0 {
+		t.Fatalf("commit with stale head = %d, %v; want 0", ok, err)
+	}
+	if raw, _ := mr.List("metrics"); len(raw) != 5 {
+		t.Errorf("raw list length = %d, want untouched 5", len(raw))
+	}
+	if mr.Exists("metrics_compacted") {
+		t.Error("conflicting commit wrote summaries")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// CompactedPoint is the JSON shape of one entry in a stream's compacted
+// list: a summary of a bucket of consecutive raw samples.
+type CompactedPoint struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Count  int       `json:"count"`
+	RPSAvg float64   `json:"rps_avg"`
+	RPSMin float64   `json:"rps_min"`
+	RPSMax float64   `json:"rps_max"`
+	CPUAvg float64   `json:"cpu_avg"`
+	CPUMin float64   `json:"cpu_min"`
+	CPUMax float64   `json:"cpu_max"`
+}
+
+// compactCommitScript commits one compaction pass: it pushes the bucket
+// summaries and trims the compacted samples off the raw list. Samples are
+// read and aggregated in Go, because the raw list may hold entries in any
+// STORAGE_CODEC and Lua cannot decompress gzip. The script first checks
+// that the first and last compacted entries are still at the head of the
+// raw list; if a concurrent trim shifted it, nothing is written and the
+// caller retries, so raw samples are never dropped without their
+// summaries.
+//
+// KEYS[1] raw list, KEYS[2] compacted list.
+// ARGV[1] number of raw entries compacted, ARGV[2] first entry, ARGV[3]
+// last entry, ARGV[4] compacted retention, ARGV[5..] summaries.
+// Returns 1 when committed, 0 on conflict.
+var compactCommitScript = redis.NewScript(`
+local n = tonumber(ARGV[1])
+if redis.call('LINDEX', KEYS[1], 0) ~= ARGV[2] or redis.call('LINDEX', KEYS[1], n - 1) ~= ARGV[3] then
+	return 0
+end
+-- Push in chunks to stay well below Lua's unpack limit.
+for i = 5, #ARGV, 1000 do
+	redis.call('RPUSH', KEYS[2], unpack(ARGV, i, math.min(i + 999, #ARGV)))
+end
+if #ARGV > 4 then
+	redis.call('LTRIM', KEYS[2], -tonumber(ARGV[4]), -1)
+end
+redis.call('LTRIM', KEYS[1], n, -1)
+return 1
+`)
+
+// compactAttempts bounds retries when concurrent trims keep shifting the
+// raw list under a compaction pass.
+const compactAttempts = 3
+
+var errCompactConflict = errors.New("raw list changed during compaction")
+
+// bucketSamples summarises window into consecutive buckets of size samples.
+func bucketSamples(window []Metric, size int) []CompactedPoint {
+	var points []CompactedPoint
+	for len(window) > 0 {
+		bucket := window[:min(size, len(window))]
+		window = window[len(bucket):]
+		p := CompactedPoint{
+			Start:  bucket[0].Timestamp,
+			End:    bucket[len(bucket)-1].Timestamp,
+			Count:  len(bucket),
+			RPSMin: bucket[0].RPS, RPSMax: bucket[0].RPS,
+			CPUMin: bucket[0].CPU, CPUMax: bucket[0].CPU,
+		}
+		for _, m := range bucket {
+			p.RPSAvg += m.RPS
+			p.CPUAvg += m.CPU
+			p.RPSMin, p.RPSMax = min(p.RPSMin, m.RPS), max(p.RPSMax, m.RPS)
+			p.CPUMin, p.CPUMax = min(p.CPUMin, m.CPU), max(p.CPUMax, m.CPU)
+		}
+		p.RPSAvg /= float64(p.Count)
+		p.CPUAvg /= float64(p.Count)
+		points = append(points, p)
+	}
+	return points
+}
+
+// compactStream replaces every raw sample of stream older than the live
+// window with bucket summaries in the compacted list. It returns how many
+// samples were compacted, how many buckets were written and how many
+// malformed entries were dropped.
+func compactStream(ctx context.Context, stream string, bucketSize int) (compacted, buckets, skipped int, err error) {
+	return appState.store.Compact(ctx, stream, bucketSize)
+}
+
+// compactList is compactStream for the list backend.
+func compactList(ctx context.Context, stream string, bucketSize int) (compacted, buckets, skipped int, err error) {
+	keys := []string{appState.metricsKey(stream), appState.compactedKey(stream)}
+	for attempt := 0; attempt < compactAttempts; attempt++ {
+		items, err := appState.redisClient.LRange(ctx, keys[0], 0, -int64(appState.maxWindow())-1).Result()
+		if err != nil || len(items) == 0 {
+			return 0, 0, 0, err
+		}
+		window := decodeWindow(items)
+		points := bucketSamples(window, bucketSize)
+
+		args := []interface{}{len(items), items[0], items[len(items)-1], appState.compactedRetention}
+		for _, p := range points {
+			data, _ := json.Marshal(p)
+			args = append(args, data)
+		}
+		ok, err := compactCommitScript.Run(ctx, appState.redisClient, keys, args...).Int()
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if ok == 1 {
+			return len(window), len(points), len(items) - len(window), nil
+		}
+	}
+	return 0, 0, 0, errCompactConflict
+}
+
+func handleCompact(w http.ResponseWriter, r *http.Request) {
+	stream, err := streamFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	bucketSize := appState.compactBucketSize
+	if v := r.URL.Query().Get("bucket"); v != "" {
+		bucketSize, err = strconv.Atoi(v)
+		if err != nil || bucketSize <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request", "bucket must be a positive integer")
+			return
+		}
+	}
+
+	ctx := context.Background()
+	logger := loggerFrom(r.Context()).With("stream", stream)
+	compacted, buckets, skipped, err := compactStream(ctx, stream, bucketSize)
+	if err != nil {
+		logger.Error("Redis compaction error", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Error compacting samples")
+		return
+	}
+	if skipped > 0 {
+		logger.Warn("Compaction dropped malformed samples", "dropped", skipped)
+	}
+	logger.Info("Compacted samples", "compacted", compacted, "buckets", buckets)
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, map[string]interface{}{
+		"stream":    stream,
+		"compacted": compacted,
+		"buckets":   buckets,
+		"dropped":   skipped,
+	})
+}
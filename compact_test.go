@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newCompactTestState(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	appState = &AppState{
+		redisClient:        redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		windowSize:         3,
+		rawRetention:       20,
+		compactBucketSize:  2,
+		compactedRetention: 100,
+		store:              listStore{},
+	}
+	return mr
+}
+
+func pushSamples(t *testing.T, mr *miniredis.Miniredis, key string, rps ...float64) {
+	t.Helper()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, v := range rps {
+		data, _ := json.Marshal(Metric{Timestamp: base.Add(time.Duration(i) * time.Second), CPU: v / 10, RPS: v})
+		mr.RPush(key, string(data))
+	}
+}
+
+func TestHandleCompact(t *testing.T) {
+	tests := []struct {
+		name        string
+		rps         []float64
+		query       string
+		wantCode    int
+		wantCount   int
+		wantRaw     int
+		wantBuckets []CompactedPoint
+	}{
+		{
+			name:      "nothing older than window",
+			rps:       []float64{1, 2, 3},
+			wantCode:  http.StatusOK,
+			query:     "stream=s1",
+			wantCount: 0,
+			wantRaw:   3,
+		},
+		{
+			name:      "buckets old samples",
+			rps:       []float64{10, 20, 30, 40, 50, 1, 2, 3},
+			query:     "stream=s1",
+			wantCode:  http.StatusOK,
+			wantCount: 5,
+			wantRaw:   3,
+			wantBuckets: []CompactedPoint{
+				{Count: 2, RPSAvg: 15, RPSMin: 10, RPSMax: 20, CPUAvg: 1.5, CPUMin: 1, CPUMax: 2},
+				{Count: 2, RPSAvg: 35, RPSMin: 30, RPSMax: 40, CPUAvg: 3.5, CPUMin: 3, CPUMax: 4},
+				{Count: 1, RPSAvg: 50, RPSMin: 50, RPSMax: 50, CPUAvg: 5, CPUMin: 5, CPUMax: 5},
+			},
+		},
+		{
+			name:      "bucket query overrides default",
+			rps:       []float64{10, 20, 30, 1, 2, 3},
+			query:     "stream=s1&bucket=3",
+			wantCode:  http.StatusOK,
+			wantCount: 3,
+			wantRaw:   3,
+			wantBuckets: []CompactedPoint{
+				{Count: 3, RPSAvg: 20, RPSMin: 10, RPSMax: 30, CPUAvg: 2, CPUMin: 1, CPUMax: 3},
+			},
+		},
+		{
+			name:     "invalid bucket",
+			query:    "stream=s1&bucket=0",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "invalid stream",
+			query:    "stream=bad%20name",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newCompactTestState(t)
+			pushSamples(t, mr, "metrics:s1", tt.rps...)
+
+			w := httptest.NewRecorder()
+			handleCompact(w, httptest.NewRequest(http.MethodPost, "/compact?"+tt.query, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Compacted int `json:"compacted"`
+				Buckets   int `json:"buckets"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Compacted != tt.wantCount || resp.Buckets != len(tt.wantBuckets) {
+				t.Errorf("response = %+v, want %d compacted in %d buckets", resp, tt.wantCount, len(tt.wantBuckets))
+			}
+
+			raw, _ := mr.List("metrics:s1")
+			if len(raw) != tt.wantRaw {
+				t.Errorf("raw list length = %d, want %d", len(raw), tt.wantRaw)
+			}
+
+			stored, _ := mr.List("metrics_compacted:s1")
+			if len(stored) != len(tt.wantBuckets) {
+				t.Fatalf("compacted list length = %d, want %d", len(stored), len(tt.wantBuckets))
+			}
+			for i, item := range stored {
+				var got CompactedPoint
+				if err := json.Unmarshal([]byte(item), &got); err != nil {
+					t.Fatalf("bucket %d: %v", i, err)
+				}
+				want := tt.wantBuckets[i]
+				want.Start, want.End = got.Start, got.End
+				if got != want {
+					t.Errorf("bucket %d = %+v, want %+v", i, got, want)
+				}
+				if !got.End.After(got.Start) && got.Count > 1 {
+					t.Errorf("bucket %d spans %v..%v", i, got.Start, got.End)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleCompactDropsMalformedSamples(t *testing.T) {
+	mr := newCompactTestState(t)
+	mr.RPush("metrics", "not json")
+	pushSamples(t, mr, "metrics", 10, 20, 1, 2, 3)
+
+	w := httptest.NewRecorder()
+	handleCompact(w, httptest.NewRequest(http.MethodPost, "/compact", nil))
+
+	var resp map[string]int
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp["compacted"] != 2 || resp["dropped"] != 1 || resp["buckets"] != 1 {
+		t.Errorf("response = %v, want 2 compacted, 1 dropped, 1 bucket", resp)
+	}
+	if raw, _ := mr.List("metrics"); len(raw) != 3 {
+		t.Errorf("raw list length = %d, want 3", len(raw))
+	}
+}
+
+func TestCompactRespectsRetention(t *testing.T) {
+	mr := newCompactTestState(t)
+	appState.compactedRetention = 2
+	for i := 0; i < 3; i++ {
+		pushSamples(t, mr, "metrics", 10, 20, 1, 2, 3)
+		w := httptest.NewRecorder()
+		handleCompact(w, httptest.NewRequest(http.MethodPost, "/compact", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("round %d: status %d", i, w.Code)
+		}
+	}
+	stored, _ := mr.List("metrics_compacted")
+	if len(stored) != 2 {
+		t.Errorf("compacted list length = %d, want %d", len(stored), 2)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// reloadableSettings are the settings POST /config/reload applies to the
+// running process. Everything else is read once at startup.
+var reloadableSettings = []string{
+	"LOG_SAMPLE_RATE",
+	"MIN_STDDEV",
+	"SEVERITY_CRITICAL_SCORE",
+	"SEVERITY_WARNING_SCORE",
+	"WINDOW_SIZES",
+}
+
+// SettingChange is one setting whose value differs from the last load.
+// An empty From or To means unset.
+type SettingChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ReloadResult is the response of POST /config/reload. Changed lists what
+// was applied; Ignored lists settings that differ from what the process
+// runs with but only take effect on restart.
+type ReloadResult struct {
+	Changed map[string]SettingChange `json:"changed"`
+	Ignored []string                 `json:"ignored"`
+	Config  RuntimeConfig            `json:"config"`
+}
+
+// environ returns the process environment as a map.
+func environ() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// parseConfigFile reads KEY=VALUE lines, as in a Docker env file or a
+// ConfigMap rendered to one. Blank lines and lines starting with # are
+// skipped; values are taken verbatim, without quote handling.
+func parseConfigFile(r io.Reader) (map[string]string, error) {
+	settings := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: want KEY=VALUE, got %q", n, line)
+		}
+		settings[key] = strings.TrimSpace(value)
+	}
+	return settings, scanner.Err()
+}
+
+// loadSettings returns the environment overlaid with CONFIG_FILE, if set.
+// The environment of a running process never changes, so without a file a
+// reload only picks up what PATCH /config or a previous reload changed.
+func loadSettings() (map[string]string, error) {
+	settings := environ()
+	path := settings["CONFIG_FILE"]
+	if path == "" {
+		return settings, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fromFile, err := parseConfigFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	maps.Copy(settings, fromFile)
+	return settings, nil
+}
+
+// reloadConfig applies the reloadable settings in next that differ from
+// the last load, all or nothing, and reports the other differences as
+// ignored. WINDOW_SIZES replaces every window size, including one set with
+// PATCH /config.
+func (s *AppState) reloadConfig(next map[string]string) (ReloadResult, error) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+
+	result := ReloadResult{Changed: make(map[string]SettingChange), Ignored: []string{}}
+	for _, key := range slices.Sorted(maps.Keys(mergeKeys(s.settings, next))) {
+		if s.settings[key] == next[key] {
+			continue
+		}
+		if slices.Contains(reloadableSettings, key) {
+			result.Changed[key] = SettingChange{From: s.settings[key], To: next[key]}
+		} else {
+			result.Ignored = append(result.Ignored, key)
+		}
+	}
+
+	changed := func(key string) bool { _, ok := result.Changed[key]; return ok }
+	float := func(key string, def float64) (float64, error) {
+		if next[key] == "" {
+			return def, nil
+		}
+		v, err := strconv.ParseFloat(next[key], 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", key, err)
+		}
+		return v, nil
+	}
+
+	windowSizes := s.windowSizes
+	if changed("WINDOW_SIZES") {
+		sizes, err := parseWindowSizes(next["WINDOW_SIZES"], s.windowSize)
+		if err != nil {
+			return ReloadResult{}, fmt.Errorf("WINDOW_SIZES: %w", err)
+		}
+		for series, size := range sizes {
+			// Redis retention and the in-memory buffer are sized at startup.
+			if size < max(s.minSamples, 2) || size > s.buffer.size {
+				return ReloadResult{}, fmt.Errorf("WINDOW_SIZES: %s must be between %d and %d, got %d", series, max(s.minSamples, 2), s.buffer.size, size)
+			}
+		}
+		windowSizes = sizes
+	}
+
+	minStdDev := s.minStdDev
+	if changed("MIN_STDDEV") {
+		v, err := float("MIN_STDDEV", 0)
+		if err != nil {
+			return ReloadResult{}, err
+		}
+		if v < 0 {
+			return ReloadResult{}, fmt.Errorf("MIN_STDDEV must not be negative, got %v", v)
+		}
+		minStdDev = v
+	}
+
+	severity := s.severity
+	if changed("SEVERITY_WARNING_SCORE") || changed("SEVERITY_CRITICAL_SCORE") {
+		warning, err := float("SEVERITY_WARNING_SCORE", defaultSeverityWarning)
+		if err != nil {
+			return ReloadResult{}, err
+		}
+		critical, err := float("SEVERITY_CRITICAL_SCORE", defaultSeverityCritical)
+		if err != nil {
+			return ReloadResult{}, err
+		}
+		if severity, err = newSeverityCutoffs(warning, critical); err != nil {
+			return ReloadResult{}, err
+		}
+	}
+
+	sampler := s.logSampler
+	if changed("LOG_SAMPLE_RATE") {
+		rate := 1
+		if v := next["LOG_SAMPLE_RATE"]; v != "" {
+			var err error
+			if rate, err = strconv.Atoi(v); err != nil || rate <= 0 {
+				return ReloadResult{}, fmt.Errorf("LOG_SAMPLE_RATE must be a positive integer, got %q", v)
+			}
+		}
+		sampler = newLogSampler(rate)
+	}
+
+	s.windowSizes = windowSizes
+	s.minStdDev = minStdDev
+	s.detector = withMinStdDev(s.detector, minStdDev)
+	s.quickDetector = withMinStdDev(s.quickDetector, minStdDev)
+	s.severity = severity
+	s.logSampler = sampler
+	for key, change := range result.Changed {
+		if change.To == "" {
+			delete(s.settings, key)
+		} else {
+			s.settings[key] = change.To
+		}
+	}
+	result.Config = s.runtimeConfig()
+	return result, nil
+}
+
+func mergeKeys(a, b map[string]string) map[string]struct{} {
+	keys := make(map[string]struct{}, len(a)+len(b))
+	for k := range a {
+		keys[k] = struct{}{}
+	}
+	for k := range b {
+		keys[k] = struct{}{}
+	}
+	return keys
+}
+
+// withMinStdDev returns d with its z-score floor set to floor. Detectors
+// are shared with in-flight requests, so they are copied, not changed.
+func withMinStdDev(d AnomalyDetector, floor float64) AnomalyDetector {
+	switch d := d.(type) {
+	case *ZScoreDetector:
+		if d.MinStdDev == floor {
+			return d
+		}
+		c := *d
+		c.MinStdDev = floor
+		return &c
+	case *EnsembleDetector:
+		c := *d
+		c.Members = make([]AnomalyDetector, len(d.Members))
+		for i, m := range d.Members {
+			c.Members[i] = withMinStdDev(m, floor)
+		}
+		return &c
+	}
+	return d
+}
+
+// handleConfigReload re-reads the configuration (see loadSettings) and
+// applies what can change at runtime.
+func handleConfigReload(w http.ResponseWriter, r *http.Request) {
+	settings, err := loadSettings()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Error loading configuration: "+err.Error())
+		return
+	}
+	result, err := appState.reloadConfig(settings)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	loggerFrom(r.Context()).Info("Configuration reloaded", "changed", slices.Sorted(maps.Keys(result.Changed)), "ignored", result.Ignored)
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, result)
+}
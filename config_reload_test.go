@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", in: "", want: map[string]string{}},
+		{name: "pairs and comments", in: "# tuning\nMIN_STDDEV=1\n\n  WINDOW_SIZES = rps=4 \n",
+			want: map[string]string{"MIN_STDDEV": "1", "WINDOW_SIZES": "rps=4"}},
+		{name: "empty value", in: "LOG_SAMPLE_RATE=", want: map[string]string{"LOG_SAMPLE_RATE": ""}},
+		{name: "no equals", in: "MIN_STDDEV 1", wantErr: true},
+		{name: "no key", in: "=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseConfigFile(strings.NewReader(tt.in))
+		if (err != nil) != tt.wantErr || (err == nil && !maps.Equal(got, tt.want)) {
+			t.Errorf("%s: parseConfigFile = %v, %v; want %v, err %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHandleConfigReload(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		wantCode    int
+		wantChanged []string
+		wantIgnored []string
+		check       func(t *testing.T)
+	}{
+		{name: "nothing changed", file: "", wantCode: http.StatusOK},
+		{name: "window sizes", file: "WINDOW_SIZES=rps=3,cpu=4", wantCode: http.StatusOK,
+			wantChanged: []string{"WINDOW_SIZES"},
+			check: func(t *testing.T) {
+				if rps, cpu := appState.windowFor("rps"), appState.windowFor("cpu"); rps != 3 || cpu != 4 {
+					t.Errorf("windows rps %d cpu %d, want 3 and 4", rps, cpu)
+				}
+			}},
+		{name: "min stddev and severity", file: "MIN_STDDEV=2\nSEVERITY_CRITICAL_SCORE=6", wantCode: http.StatusOK,
+			wantChanged: []string{"MIN_STDDEV", "SEVERITY_CRITICAL_SCORE"},
+			check: func(t *testing.T) {
+				if d := appState.currentDetector().(*ZScoreDetector); d.MinStdDev != 2 {
+					t.Errorf("detector floor = %v, want 2", d.MinStdDev)
+				}
+				if c := appState.currentSeverity(); c.Critical != 6 || c.Warning != defaultSeverityWarning {
+					t.Errorf("severity = %+v, want critical 6", c)
+				}
+			}},
+		{name: "log sample rate", file: "LOG_SAMPLE_RATE=10", wantCode: http.StatusOK,
+			wantChanged: []string{"LOG_SAMPLE_RATE"},
+			check: func(t *testing.T) {
+				if appState.logSampler.rate != 10 {
+					t.Errorf("log sample rate = %d, want 10", appState.logSampler.rate)
+				}
+			}},
+		{name: "restart-only settings ignored", file: "PORT=9090\nMIN_STDDEV=1", wantCode: http.StatusOK,
+			wantChanged: []string{"MIN_STDDEV"}, wantIgnored: []string{"PORT"}},
+		{name: "window above buffer", file: "WINDOW_SIZES=rps=6", wantCode: http.StatusBadRequest},
+		{name: "bad severity order", file: "SEVERITY_WARNING_SCORE=5", wantCode: http.StatusBadRequest},
+		{name: "invalid setting changes nothing", file: "MIN_STDDEV=3\nLOG_SAMPLE_RATE=0", wantCode: http.StatusBadRequest},
+		{name: "malformed file", file: "MIN_STDDEV", wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			appState.adminToken = "secret"
+			path := filepath.Join(t.TempDir(), "service.env")
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("CONFIG_FILE", path)
+			appState.settings = environ()
+
+			req := httptest.NewRequest(http.MethodPost, "/config/reload", nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				if d := appState.currentDetector().(*ZScoreDetector); d.MinStdDev != 0 || appState.logSampler != nil {
+					t.Errorf("rejected reload changed the config")
+				}
+				return
+			}
+			var got ReloadResult
+			json.NewDecoder(w.Body).Decode(&got)
+			if changed := slices.Sorted(maps.Keys(got.Changed)); !slices.Equal(changed, tt.wantChanged) {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if !slices.Equal(got.Ignored, tt.wantIgnored) {
+				t.Errorf("ignored = %v, want %v", got.Ignored, tt.wantIgnored)
+			}
+			if tt.check != nil {
+				tt.check(t)
+			}
+		})
+	}
+}
+
+// A second reload with the same file changes nothing, and keeps reporting
+// what still needs a restart.
+func TestConfigReloadIsIdempotent(t *testing.T) {
+	newTestAppState(t)
+	next := map[string]string{"MIN_STDDEV": "1", "PORT": "9090"}
+	for i, wantChanged := range []int{1, 0} {
+		got, err := appState.reloadConfig(next)
+		if err != nil || len(got.Changed) != wantChanged || !slices.Equal(got.Ignored, []string{"PORT"}) {
+			t.Errorf("reload %d = %+v, %v; want %d changed and PORT ignored", i+1, got, err, wantChanged)
+		}
+	}
+}
+
+func TestConfigReloadRequiresToken(t *testing.T) {
+	newTestAppState(t)
+	appState.adminToken = "secret"
+	w := httptest.NewRecorder()
+	newMux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/config/reload", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// corsPolicy says which browser origins may read responses from the GET
+// endpoints. Other methods never get CORS headers, so a page on another
+// origin cannot submit metrics or trigger maintenance endpoints.
+type corsPolicy struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	credentials bool
+}
+
+// newCORSPolicy parses CORS_ALLOWED_ORIGINS, a comma separated list of
+// origins or "*". It returns nil when spec is empty, meaning CORS is off.
+// "*" cannot be combined with credentials: that would let any site make
+// authenticated reads.
+func newCORSPolicy(spec string, credentials bool) (*corsPolicy, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	p := &corsPolicy{origins: make(map[string]bool), credentials: credentials}
+	for _, origin := range strings.Split(spec, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		switch {
+		case origin == "":
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.HasPrefix(origin, "http://"), strings.HasPrefix(origin, "https://"):
+			p.origins[origin] = true
+		default:
+			return nil, errors.New("origin " + origin + " must start with http:// or https://")
+		}
+	}
+	if p.anyOrigin && credentials {
+		return nil, errors.New(`"*" cannot be used with CORS_ALLOW_CREDENTIALS`)
+	}
+	return p, nil
+}
+
+func (p *corsPolicy) allowed(origin string) bool {
+	return p.anyOrigin || p.origins[origin]
+}
+
+// withCORS adds CORS headers for allowed origins on GET and HEAD requests
+// and answers their preflights. With a nil policy it returns next as is.
+func withCORS(p *corsPolicy, next http.Handler) http.Handler {
+	if p == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !p.anyOrigin {
+			w.Header().Add("Vary", "Origin")
+		}
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			if p.allowed(origin) && isReadMethod(r.Header.Get("Access-Control-Request-Method")) {
+				p.setHeaders(w.Header(), origin)
+				w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+requestIDHeader)
+				w.Header().Set("Access-Control-Max-Age", "600")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if p.allowed(origin) && isReadMethod(r.Method) {
+			p.setHeaders(w.Header(), origin)
+			w.Header().Set("Access-Control-Expose-Headers", requestIDHeader)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *corsPolicy) setHeaders(h http.Header, origin string) {
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewCORSPolicy(t *testing.T) {
+	tests := []struct {
+		spec        string
+		credentials bool
+		wantNil     bool
+		wantErr     bool
+	}{
+		{spec: "", wantNil: true},
+		{spec: "  ", credentials: true, wantNil: true},
+		{spec: "*"},
+		{spec: "https://a.example, http://b.example:3000/"},
+		{spec: "https://a.example", credentials: true},
+		{spec: "*", credentials: true, wantErr: true},
+		{spec: "https://a.example,*", credentials: true, wantErr: true},
+		{spec: "a.example", wantErr: true},
+	}
+	for _, tt := range tests {
+		p, err := newCORSPolicy(tt.spec, tt.credentials)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newCORSPolicy(%q, %v) error = %v, wantErr %v", tt.spec, tt.credentials, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (p == nil) != tt.wantNil {
+			t.Errorf("newCORSPolicy(%q) = %v, want nil %v", tt.spec, p, tt.wantNil)
+		}
+	}
+}
+
+func TestWithCORS(t *testing.T) {
+	listed, _ := newCORSPolicy("https://dash.example,http://localhost:3000", true)
+	anyOrigin, _ := newCORSPolicy("*", false)
+	tests := []struct {
+		name        string
+		policy      *corsPolicy
+		method      string
+		origin      string
+		preflight   string
+		wantCode    int
+		wantOrigin  string
+		wantCreds   string
+		wantMethods string
+	}{
+		{name: "disabled", method: http.MethodGet, origin: "https://dash.example", wantCode: http.StatusOK},
+		{name: "listed origin", policy: listed, method: http.MethodGet, origin: "https://dash.example",
+			wantCode: http.StatusOK, wantOrigin: "https://dash.example", wantCreds: "true"},
+		{name: "unlisted origin", policy: listed, method: http.MethodGet, origin: "https://evil.example", wantCode: http.StatusOK},
+		{name: "no origin", policy: listed, method: http.MethodGet, wantCode: http.StatusOK},
+		{name: "post not allowed", policy: listed, method: http.MethodPost, origin: "https://dash.example", wantCode: http.StatusOK},
+		{name: "wildcard", policy: anyOrigin, method: http.MethodGet, origin: "https://any.example",
+			wantCode: http.StatusOK, wantOrigin: "*"},
+		{name: "preflight", policy: listed, method: http.MethodOptions, origin: "http://localhost:3000", preflight: "GET",
+			wantCode: http.StatusNoContent, wantOrigin: "http://localhost:3000", wantCreds: "true", wantMethods: "GET, HEAD"},
+		{name: "preflight for post", policy: listed, method: http.MethodOptions, origin: "https://dash.example", preflight: "POST",
+			wantCode: http.StatusNoContent},
+		{name: "preflight unlisted", policy: listed, method: http.MethodOptions, origin: "https://evil.example", preflight: "GET",
+			wantCode: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := withCORS(tt.policy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(tt.method, "/count", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.preflight)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			h := w.Header()
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials"); got != tt.wantCreds {
+				t.Errorf("Allow-Credentials = %q, want %q", got, tt.wantCreds)
+			}
+			if got := h.Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("Allow-Methods = %q, want %q", got, tt.wantMethods)
+			}
+		})
+	}
+}
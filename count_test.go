@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func getCount(t *testing.T, mux http.Handler) (int, int) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/count", nil))
+	var body struct {
+		Count int `json:"count"`
+	}
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Errorf("decoding /count: %v", err)
+		}
+	}
+	return w.Code, body.Count
+}
+
+func TestCountHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		stored    string
+		wantCode  int
+		wantCount int
+		wantKey   bool
+	}{
+		{name: "missing key", wantCode: http.StatusOK, wantCount: 0},
+		{name: "stored", stored: "42", wantCode: http.StatusOK, wantCount: 42, wantKey: true},
+		{name: "corrupt", stored: "nope", wantCode: http.StatusInternalServerError, wantKey: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			if tt.stored != "" {
+				mr.Set("request_count", tt.stored)
+			}
+			code, count := getCount(t, newMux())
+			if code != tt.wantCode || count != tt.wantCount {
+				t.Errorf("/count = %d %d, want %d %d", code, count, tt.wantCode, tt.wantCount)
+			}
+			if got := mr.Exists("request_count"); got != tt.wantKey {
+				t.Errorf("request_count exists = %v, want %v; /count must not create it", got, tt.wantKey)
+			}
+		})
+	}
+}
+
+// Concurrent /count calls must never reset increments made by /analyze.
+func TestCountNeverGoesBackward(t *testing.T) {
+	newTestAppState(t)
+	mux := newMux()
+	const analyzes = 50
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < analyzes; i++ {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze?stream=count", strings.NewReader(`{"rps": 1}`)))
+		}
+	}()
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			last := 0
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				code, count := getCount(t, mux)
+				if code != http.StatusOK {
+					t.Errorf("/count status = %d", code)
+					return
+				}
+				if count < last {
+					t.Errorf("count went backward: %d after %d", count, last)
+					return
+				}
+				last = count
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	readers.Wait()
+
+	if _, count := getCount(t, mux); count != analyzes {
+		t.Errorf("final count = %d, want %d", count, analyzes)
+	}
+}
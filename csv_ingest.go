@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxCSVRows bounds a /ingest/csv upload. Rows are held in memory to be
+// sorted, so this also bounds the memory one upload takes.
+const maxCSVRows = 1_000_000
+
+// maxCSVBodyBytes bounds the size of a /ingest/csv upload, as csv.Reader
+// does not bound a row or field on its own.
+const maxCSVBodyBytes = 128 << 20
+
+var errTooManyCSVRows = fmt.Errorf("CSV holds more than %d rows", maxCSVRows)
+
+// csvColumns are the columns of a /ingest/csv upload, in the order used
+// when the file has no header row.
+var csvColumns = []string{"timestamp", "cpu", "rps"}
+
+// CSVIngestResult is the response of POST /ingest/csv.
+type CSVIngestResult struct {
+	Rows      int `json:"rows"`
+	Processed int `json:"processed"`
+	Anomalies int `json:"anomalies"`
+}
+
+// handleCSVIngest backfills a stream from a CSV file of timestamp, cpu and
+// rps columns, to warm baselines after a redeploy. An optional header row
+// names the columns in any order; extra columns are ignored. Timestamps are
+// RFC3339 or epoch seconds or milliseconds, as in JSON metrics. The whole
+// file is parsed first, and any bad row rejects it with its line number, so
+// nothing is stored from a file that would only be half read. The rows are
+// then sorted by timestamp and run through processMetric one by one, so
+// anomalies are detected and recorded as if the samples had arrived live.
+//
+// Backfilled samples are appended after any the stream already holds, so a
+// backfill belongs before live traffic resumes. CLOCK_SKEW_TOLERANCE does
+// not apply: the point is to send old samples.
+func handleCSVIngest(w http.ResponseWriter, r *http.Request) {
+	stream, err := streamFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	rows, err := readMetricsCSV(http.MaxBytesReader(w, r.Body, maxCSVBodyBytes))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge), errors.Is(err, errTooManyCSVRows):
+		writeError(w, http.StatusRequestEntityTooLarge, "too_large", err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "invalid_csv", err.Error())
+		return
+	case len(rows) == 0:
+		writeError(w, http.StatusBadRequest, "invalid_csv", "CSV holds no rows")
+		return
+	}
+	slices.SortStableFunc(rows, func(a, b Metric) int { return a.Timestamp.Compare(b.Timestamp) })
+
+	logger := loggerFrom(r.Context()).With("stream", stream)
+	logger.Info("Backfilling stream from CSV", "rows", len(rows), "from", rows[0].Timestamp, "to", rows[len(rows)-1].Timestamp)
+	res := CSVIngestResult{Rows: len(rows)}
+	for _, m := range rows {
+		if r.Context().Err() != nil {
+			logger.Warn("CSV backfill cancelled by client", "processed", res.Processed)
+			return
+		}
+		if err := recordIntake(r.Context(), logger, stream, m); err != nil {
+			writeError(w, http.StatusServiceUnavailable, "unavailable", fmt.Sprintf("Error counting row, %d of %d rows processed", res.Processed, res.Rows))
+			return
+		}
+		result, err := processMetric(r.Context(), logger, stream, m)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, "unavailable", fmt.Sprintf("Error processing row, %d of %d rows processed", res.Processed, res.Rows))
+			return
+		}
+		res.Processed++
+		if result.Status == statusAnomaly {
+			res.Anomalies++
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, res)
+}
+
+// readMetricsCSV parses a /ingest/csv body into metrics in file order.
+func readMetricsCSV(body io.Reader) ([]Metric, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.ReuseRecord = true
+
+	index := map[string]int{"timestamp": 0, "cpu": 1, "rps": 2}
+	var rows []Metric
+	for first := true; ; first = false {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if first {
+			if header, ok := csvHeader(record); ok {
+				index = header
+				continue
+			}
+		}
+		if len(rows) == maxCSVRows {
+			return nil, errTooManyCSVRows
+		}
+		m, err := parseCSVRow(record, index)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rows = append(rows, m)
+	}
+}
+
+// csvHeader reports whether record is a header row, and if so where each
+// of csvColumns is. A header must name every one of them.
+func csvHeader(record []string) (map[string]int, bool) {
+	index := make(map[string]int)
+	for i, name := range record {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if slices.Contains(csvColumns, name) {
+			index[name] = i
+		}
+	}
+	return index, len(index) == len(csvColumns)
+}
+
+func parseCSVRow(record []string, index map[string]int) (Metric, error) {
+	field := func(name string) (string, error) {
+		i := index[name]
+		if i >= len(record) || strings.TrimSpace(record[i]) == "" {
+			return "", fmt.Errorf("missing %s", name)
+		}
+		return strings.TrimSpace(record[i]), nil
+	}
+
+	var m Metric
+	ts, err := field("timestamp")
+	if err != nil {
+		return m, err
+	}
+	// Epoch numbers go to parseTimestampJSON as they are, anything else as
+	// a JSON string.
+	if _, err := strconv.ParseFloat(ts, 64); err != nil {
+		ts = strconv.Quote(ts)
+	}
+	if m.Timestamp, err = parseTimestampJSON([]byte(ts)); err != nil {
+		return m, err
+	}
+	for _, f := range []struct {
+		name string
+		dst  *float64
+	}{{"cpu", &m.CPU}, {"rps", &m.RPS}} {
+		v, err := field(f.name)
+		if err != nil {
+			return m, err
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return m, fmt.Errorf("%s %q is not a finite number", f.name, v)
+		}
+		*f.dst = n
+	}
+	return m, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSVIngest(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		wantCode   int
+		wantErr    string
+		wantStored []float64
+	}{
+		{
+			name:       "sorted by timestamp",
+			target:     "/ingest/csv/web",
+			body:       "timestamp,cpu,rps\n2023-12-25T00:00:02Z,50,30\n2023-12-25T00:00:00Z,50,10\n2023-12-25T00:00:01Z,50,20\n",
+			wantCode:   http.StatusOK,
+			wantStored: []float64{10, 20, 30},
+		},
+		{
+			name:       "no header, epoch seconds",
+			target:     "/ingest/csv?stream=web",
+			body:       "1703462401,1,20\n1703462400,1,10\n",
+			wantCode:   http.StatusOK,
+			wantStored: []float64{10, 20},
+		},
+		{
+			name:       "reordered header, extra column",
+			target:     "/ingest/csv/web",
+			body:       "host,rps,timestamp,cpu\na,10,1703462400000,1\n",
+			wantCode:   http.StatusOK,
+			wantStored: []float64{10},
+		},
+		{name: "bad number", target: "/ingest/csv/web", body: "timestamp,cpu,rps\n1703462400,1,10\n1703462401,1,high\n", wantCode: http.StatusBadRequest, wantErr: "line 3"},
+		{name: "missing column", target: "/ingest/csv/web", body: "1703462400,1\n", wantCode: http.StatusBadRequest, wantErr: "missing rps"},
+		{name: "bad timestamp", target: "/ingest/csv/web", body: "yesterday,1,10\n", wantCode: http.StatusBadRequest, wantErr: "RFC3339"},
+		{name: "not finite", target: "/ingest/csv/web", body: "1703462400,NaN,10\n", wantCode: http.StatusBadRequest, wantErr: "finite"},
+		{name: "header only", target: "/ingest/csv/web", body: "timestamp,cpu,rps\n", wantCode: http.StatusBadRequest, wantErr: "no rows"},
+		{name: "invalid stream", target: "/ingest/csv/bad%20name", body: "1703462400,1,10\n", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantErr) {
+				t.Errorf("body %s, want it to mention %q", w.Body.String(), tt.wantErr)
+			}
+			stored := rpsOf(appState.buffer.window("web"))
+			if !equalFloats(stored, tt.wantStored) {
+				t.Errorf("window = %v, want %v", stored, tt.wantStored)
+			}
+			if tt.wantCode != http.StatusOK {
+				if mr.Exists("metrics:web") {
+					t.Error("rejected upload stored samples")
+				}
+				return
+			}
+			var res CSVIngestResult
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Rows != len(tt.wantStored) || res.Processed != len(tt.wantStored) {
+				t.Errorf("result = %+v, want %d rows processed", res, len(tt.wantStored))
+			}
+		})
+	}
+}
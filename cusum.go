@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+const (
+	// defaultCUSUMThreshold is the decision interval h, in reference
+	// standard deviations.
+	defaultCUSUMThreshold = 5.0
+	// defaultCUSUMSlack is the allowance k, in reference standard
+	// deviations, for shifts of about 2k to be caught fastest.
+	defaultCUSUMSlack = 0.5
+)
+
+func init() {
+	registerDetector("cusum", newCUSUMDetector)
+}
+
+func newCUSUMDetector(cfg DetectorConfig) (AnomalyDetector, error) {
+	threshold, err := thresholdOr(cfg.Threshold, defaultCUSUMThreshold)
+	if err != nil {
+		return nil, err
+	}
+	slack := cfg.Slack
+	if slack == 0 {
+		slack = defaultCUSUMSlack
+	}
+	if slack < 0 {
+		return nil, fmt.Errorf("slack must be positive, got %v", slack)
+	}
+	if threshold <= 2*slack {
+		return nil, fmt.Errorf("threshold must be above twice the slack (%v), got %v", 2*slack, threshold)
+	}
+	return &CUSUMDetector{Threshold: threshold, Slack: slack}, nil
+}
+
+// CUSUMDetector flags sustained level shifts with a two-sided tabular
+// CUSUM. The older half of the window is the reference: every value of
+// the newer half, current included, is standardised against its mean and
+// standard deviation, less Slack, and added up, separately upwards and
+// downwards, with each sum floored at zero. A sum above Threshold is a
+// shift; the score is the larger sum, negative for a downward shift.
+//
+// Each value counts for at most Threshold/2 deviations, so one spike
+// however large cannot be flagged on its own: it takes at least three
+// samples beyond the reference in a row, with the defaults. Once the
+// shifted level fills the older half too, it is the reference and the
+// shift stops being flagged.
+type CUSUMDetector struct {
+	Threshold float64
+	Slack     float64
+}
+
+func (d *CUSUMDetector) Name() string { return "cusum" }
+
+func (d *CUSUMDetector) Detect(window []float64, current float64) (float64, bool) {
+	if len(window) < 4 { // Need at least 2 reference values
+		return 0, false
+	}
+	reference, recent := window[:len(window)/2], window[len(window)/2:]
+	mean := calculateAverage(reference)
+	stdDev := calculateStandardDeviation(reference, mean, StdDevSample)
+	if stdDev == 0 {
+		return 0, false
+	}
+	limit := d.Threshold / 2
+	var up, down float64
+	for _, v := range recent {
+		z := max(-limit, min(limit, (v-mean)/stdDev))
+		up = max(0, up+z-d.Slack)
+		down = max(0, down-z-d.Slack)
+	}
+	if up >= down {
+		return up, up > d.Threshold
+	}
+	return -down, down > d.Threshold
+}
+
+// severityScore scales a CUSUM sum, which is never below Threshold once
+// flagged, so that Threshold grades like the default z-score threshold and
+// twice it like a shift twice as strong.
+func (d *CUSUMDetector) severityScore(score float64) float64 {
+	return score / d.Threshold * defaultZScoreThreshold
+}
+
+// parseLevelShiftSeries parses LEVEL_SHIFT_SERIES, the comma separated
+// series checked for level shifts, such as "rps,cpu".
+func parseLevelShiftSeries(spec string) ([]string, error) {
+	var series []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(knownSeries, name) {
+			return nil, fmt.Errorf("unknown metric %q, want one of %s", name, strings.Join(knownSeries, ", "))
+		}
+		if slices.Contains(series, name) {
+			return nil, fmt.Errorf("metric %q listed more than once", name)
+		}
+		series = append(series, name)
+	}
+	return series, nil
+}
+
+// checkLevelShifts runs the level shift detector over the window of each
+// LEVEL_SHIFT_SERIES series and returns the direction of every shift,
+// keyed by series. Shifts are reported and counted on every sample for as
+// long as they are detected.
+func checkLevelShifts(logger *slog.Logger, windows map[string][]float64) map[string]Direction {
+	var shifts map[string]Direction
+	for _, series := range appState.levelShiftSeries {
+		values := windows[series]
+		if len(values) == 0 {
+			continue
+		}
+		score, shifted := appState.levelShift.Detect(values, values[len(values)-1])
+		if !shifted {
+			continue
+		}
+		direction := DirectionUp
+		if score < 0 {
+			direction = DirectionDown
+		}
+		if shifts == nil {
+			shifts = make(map[string]Direction)
+		}
+		shifts[series] = direction
+		appState.levelShiftCounter.WithLabelValues(series, string(direction)).Inc()
+		logger.Warn("LEVEL SHIFT DETECTED!", "metric", series, "direction", direction, "score", score)
+	}
+	return shifts
+}
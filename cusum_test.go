@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+// steadyTraffic is ten samples around 100 RPS.
+var steadyTraffic = []float64{100, 102, 98, 101, 99, 103, 97, 100, 102, 98}
+
+func TestCUSUMDetector(t *testing.T) {
+	d := &CUSUMDetector{Threshold: defaultCUSUMThreshold, Slack: defaultCUSUMSlack}
+	tests := []struct {
+		name          string
+		window        []float64
+		wantAnomalous bool
+		wantDown      bool
+	}{
+		{name: "too short", window: []float64{1, 2, 50}},
+		{name: "flat reference", window: []float64{5, 5, 5, 5, 9, 9, 9, 9}},
+		{name: "steady", window: slices.Concat(steadyTraffic, steadyTraffic)},
+		{name: "drop that stays", window: slices.Concat(steadyTraffic, []float64{70, 71, 69, 70}), wantAnomalous: true, wantDown: true},
+		{name: "rise that stays", window: slices.Concat(steadyTraffic, []float64{130, 128, 131, 129}), wantAnomalous: true},
+		{name: "single spike", window: slices.Concat(steadyTraffic, steadyTraffic[:5], []float64{1000})},
+		{name: "two-sample dip", window: slices.Concat(steadyTraffic, steadyTraffic[:5], []float64{0, 0})},
+		{name: "dip that recovered", window: slices.Concat(steadyTraffic, []float64{70, 70}, steadyTraffic)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, anomalous := d.Detect(tt.window, tt.window[len(tt.window)-1])
+			if anomalous != tt.wantAnomalous || (anomalous && (score < 0) != tt.wantDown) {
+				t.Errorf("Detect = (%v, %v), want anomalous %v, down %v", score, anomalous, tt.wantAnomalous, tt.wantDown)
+			}
+		})
+	}
+}
+
+func TestParseLevelShiftSeries(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{spec: "", want: nil},
+		{spec: "rps", want: []string{"rps"}},
+		{spec: " rps , cpu ", want: []string{"rps", "cpu"}},
+		{spec: "rps,memory", wantErr: true},
+		{spec: "cpu,cpu", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseLevelShiftSeries(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeWindowLevelShifts(t *testing.T) {
+	newTestAppState(t)
+	appState.windowSize = 20
+	appState.levelShiftSeries = []string{"rps", "cpu"}
+
+	// RPS drops by 30% and stays there; CPU holds steady.
+	var window []Metric
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, rps := range slices.Concat(steadyTraffic, []float64{70, 71, 69, 70}) {
+		window = append(window, Metric{Timestamp: start.Add(time.Duration(i) * time.Second), RPS: rps, CPU: steadyTraffic[i%len(steadyTraffic)] / 2})
+	}
+	got := analyzeWindow(t.Context(), slog.Default(), "web", window[len(window)-1], window)
+	if len(got.LevelShifts) != 1 || got.LevelShifts["rps"] != DirectionDown {
+		t.Errorf("LevelShifts = %v, want rps down", got.LevelShifts)
+	}
+	if v := counterValue(appState.levelShiftCounter.WithLabelValues("rps", "down")); v != 1 {
+		t.Errorf("level_shifts_total{rps,down} = %v, want 1", v)
+	}
+
+	appState.levelShiftSeries = nil
+	if got := analyzeWindow(t.Context(), slog.Default(), "web", window[len(window)-1], window); got.LevelShifts != nil {
+		t.Errorf("LevelShifts = %v with LEVEL_SHIFT_SERIES unset, want none", got.LevelShifts)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+)
+
+//go:embed static/dashboard.html
+var dashboardFS embed.FS
+
+// registerDashboard adds GET /dashboard, a single static page for a quick
+// look at the service without Grafana. It is only called when
+// ENABLE_DASHBOARD=true. The page has no server-side state: it follows
+// /metrics/stream for the live gauges and polls /anomalies for the recent
+// ones, so it shows nothing those endpoints do not already expose.
+func registerDashboard(mux *http.ServeMux) {
+	mux.HandleFunc("GET /dashboard", handleDashboard)
+}
+
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFileFS(w, r, dashboardFS, "static/dashboard.html")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterDashboard(t *testing.T) {
+	newTestAppState(t)
+	tests := []struct {
+		name     string
+		enabled  bool
+		method   string
+		wantCode int
+	}{
+		{name: "disabled", method: http.MethodGet, wantCode: http.StatusNotFound},
+		{name: "enabled", enabled: true, method: http.MethodGet, wantCode: http.StatusOK},
+		{name: "head", enabled: true, method: http.MethodHead, wantCode: http.StatusOK},
+		{name: "post", enabled: true, method: http.MethodPost, wantCode: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := newMux()
+			if tt.enabled {
+				registerDashboard(mux)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, "/dashboard", nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+				t.Errorf("Content-Type = %q, want text/html", ct)
+			}
+			if tt.method == http.MethodGet && !strings.Contains(w.Body.String(), `EventSource("metrics/stream")`) {
+				t.Errorf("page does not follow /metrics/stream")
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DeadLetter is a metric that could not be processed, kept so it can be
+// inspected and retried instead of being lost.
+type DeadLetter struct {
+	Stream   string    `json:"stream"`
+	Metric   Metric    `json:"metric"`
+	Reason   string    `json:"reason"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+const defaultDeadLetterLimit = 100
+
+// redeliveredKey marks the context of a metric whose transport keeps a
+// durable copy and redelivers it on failure, as JetStream does.
+type redeliveredKey struct{}
+
+// withRedelivery marks ctx as processing a metric its transport redelivers
+// on failure, so that a failure does not also dead-letter it.
+func withRedelivery(ctx context.Context) context.Context {
+	return context.WithValue(ctx, redeliveredKey{}, true)
+}
+
+// deadLetter records a failed metric on the Redis dead-letter list. When
+// Redis itself is the problem the entry is kept in memory instead, bounded by
+// deadLetterMax, so a transient outage does not lose it. Metrics whose
+// transport redelivers them (withRedelivery) are left to that instead, so
+// they are not recovered twice.
+func deadLetter(ctx context.Context, logger *slog.Logger, stream string, m Metric, reason error) {
+	if ctx.Value(redeliveredKey{}) != nil {
+		logger.Warn("Metric failed, leaving it to the transport to redeliver", "error", reason)
+		return
+	}
+	entry := DeadLetter{
+		Stream:   stream,
+		Metric:   m,
+		Reason:   reason.Error(),
+		FailedAt: appState.now().UTC(),
+	}
+	data, _ := json.Marshal(entry)
+
+	key := appState.key("deadletter")
+	pipe := appState.redisClient.TxPipeline()
+	pipe.RPush(ctx, key, data)
+	pipe.LTrim(ctx, key, -int64(appState.deadLetterMax), -1)
+	_, err := pipe.Exec(ctx)
+	if err == nil {
+		return
+	}
+	logger.Warn("Redis dead-letter push failed, keeping metric in memory", "error", err)
+
+	appState.mu.Lock()
+	defer appState.mu.Unlock()
+	appState.deadLetters = append(appState.deadLetters, entry)
+	if over := len(appState.deadLetters) - appState.deadLetterMax; over > 0 {
+		appState.deadLetters = appState.deadLetters[over:]
+	}
+}
+
+// takeMemoryDeadLetters removes and returns up to limit in-memory entries.
+func takeMemoryDeadLetters(limit int) []DeadLetter {
+	appState.mu.Lock()
+	defer appState.mu.Unlock()
+	n := min(limit, len(appState.deadLetters))
+	taken := append([]DeadLetter(nil), appState.deadLetters[:n]...)
+	appState.deadLetters = appState.deadLetters[n:]
+	return taken
+}
+
+func limitFromRequest(r *http.Request, defaultLimit int) (int, error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return limit, nil
+}
+
+// handleDeadLetter lists dead-lettered metrics, in-memory entries first.
+func handleDeadLetter(w http.ResponseWriter, r *http.Request) {
+	limit, err := limitFromRequest(r, defaultDeadLetterLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "limit must be a positive integer")
+		return
+	}
+
+	appState.mu.Lock()
+	entries := append([]DeadLetter{}, appState.deadLetters[:min(limit, len(appState.deadLetters))]...)
+	inMemory := len(appState.deadLetters)
+	appState.mu.Unlock()
+
+	ctx := r.Context()
+	logger := loggerFrom(ctx)
+	key := appState.key("deadletter")
+	stored, err := appState.redisClient.LLen(ctx, key).Result()
+	if err != nil {
+		logger.Error("Redis LLEN error", "error", err)
+	}
+	if remaining := limit - len(entries); remaining > 0 && err == nil {
+		items, err := appState.redisClient.LRange(ctx, key, 0, int64(remaining-1)).Result()
+		if err != nil {
+			logger.Error("Redis LRANGE error", "error", err)
+		}
+		for _, item := range items {
+			var entry DeadLetter
+			if json.Unmarshal([]byte(item), &entry) == nil {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, map[string]interface{}{
+		"in_memory": inMemory,
+		"stored":    stored,
+		"entries":   entries,
+	})
+}
+
+// handleDeadLetterRetry takes up to limit dead-lettered metrics and runs them
+// through the pipeline again. Metrics that fail again are dead-lettered anew
+// by processMetric.
+func handleDeadLetterRetry(w http.ResponseWriter, r *http.Request) {
+	limit, err := limitFromRequest(r, defaultDeadLetterLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "limit must be a positive integer")
+		return
+	}
+
+	ctx := r.Context()
+	logger := loggerFrom(ctx)
+	entries := takeMemoryDeadLetters(limit)
+	if remaining := limit - len(entries); remaining > 0 {
+		items, err := appState.redisClient.LPopCount(ctx, appState.key("deadletter"), remaining).Result()
+		if err != nil && err != redis.Nil {
+			logger.Error("Redis LPOP error", "error", err)
+		}
+		for _, item := range items {
+			var entry DeadLetter
+			if json.Unmarshal([]byte(item), &entry) == nil {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	succeeded := 0
+	for _, entry := range entries {
+		if _, err := processMetric(ctx, logger.With("stream", entry.Stream), entry.Stream, entry.Metric); err == nil {
+			succeeded++
+		}
+	}
+	logger.Info("Retried dead-lettered metrics", "retried", len(entries), "succeeded", succeeded)
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, map[string]int{
+		"retried":   len(entries),
+		"succeeded": succeeded,
+		"failed":    len(entries) - succeeded,
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeadLetterRoundTrip(t *testing.T) {
+	mr := newTestAppState(t)
+	ctx := context.Background()
+
+	// With Redis down the metric cannot be stored and lands in memory.
+	mr.Close()
+	if _, err := processMetric(ctx, slog.Default(), "web", Metric{RPS: 42}); err == nil {
+		t.Fatal("processMetric succeeded with Redis down")
+	}
+	if n := len(appState.deadLetters); n != 1 {
+		t.Fatalf("in-memory dead letters = %d, want 1", n)
+	}
+
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	// A dead letter that made it to Redis during an earlier failure.
+	deadLetter(ctx, slog.Default(), defaultStream, Metric{RPS: 7}, context.DeadlineExceeded)
+	if stored, _ := mr.List("deadletter"); len(stored) != 1 {
+		t.Fatalf("stored dead letters = %d, want 1", len(stored))
+	}
+
+	w := httptest.NewRecorder()
+	handleDeadLetter(w, httptest.NewRequest(http.MethodGet, "/deadletter", nil))
+	var listed struct {
+		InMemory int          `json:"in_memory"`
+		Stored   int          `json:"stored"`
+		Entries  []DeadLetter `json:"entries"`
+	}
+	json.NewDecoder(w.Body).Decode(&listed)
+	if listed.InMemory != 1 || listed.Stored != 1 || len(listed.Entries) != 2 {
+		t.Fatalf("listing = %+v, want 1 in memory, 1 stored, 2 entries", listed)
+	}
+	if listed.Entries[0].Stream != "web" || listed.Entries[0].Reason == "" {
+		t.Errorf("first entry = %+v, want stream web with a reason", listed.Entries[0])
+	}
+
+	w = httptest.NewRecorder()
+	handleDeadLetterRetry(w, httptest.NewRequest(http.MethodPost, "/deadletter/retry", nil))
+	var retried map[string]int
+	json.NewDecoder(w.Body).Decode(&retried)
+	if retried["retried"] != 2 || retried["succeeded"] != 2 {
+		t.Fatalf("retry = %v, want 2 retried and succeeded", retried)
+	}
+	if web, _ := mr.List("metrics:web"); len(web) != 1 {
+		t.Errorf("metrics:web length = %d, want 1", len(web))
+	}
+	if stored, _ := mr.List("deadletter"); len(stored) != 0 || len(appState.deadLetters) != 0 {
+		t.Errorf("dead letters left: %d stored, %d in memory", len(stored), len(appState.deadLetters))
+	}
+}
+
+func TestDeadLetterMemoryBound(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.deadLetterMax = 3
+	mr.Close()
+	for i := 0; i < 5; i++ {
+		deadLetter(context.Background(), slog.Default(), defaultStream, Metric{RPS: float64(i)}, context.Canceled)
+	}
+	if n := len(appState.deadLetters); n != 3 {
+		t.Fatalf("in-memory dead letters = %d, want 3", n)
+	}
+	if first := appState.deadLetters[0].Metric.RPS; first != 2 {
+		t.Errorf("oldest kept RPS = %v, want 2", first)
+	}
+}
+
+func TestDeadLetterLimitValidation(t *testing.T) {
+	newTestAppState(t)
+	for _, target := range []string{"/deadletter?limit=0", "/deadletter?limit=x"} {
+		w := httptest.NewRecorder()
+		handleDeadLetter(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", target, w.Code)
+		}
+	}
+}
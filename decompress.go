@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxDecompressedBytes caps a decompressed request body, so a small
+// compressed payload cannot expand without bound.
+const maxDecompressedBytes = 64 << 20
+
+// decompressBody lets ingest endpoints take bodies sent with
+// Content-Encoding gzip or deflate, decompressing them before next decodes
+// the JSON. Deflate is accepted both zlib-wrapped, as HTTP specifies, and
+// raw, as some clients send it. Other encodings are answered with 415.
+func decompressBody(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if encoding == "" || encoding == "identity" {
+			next(w, r)
+			return
+		}
+		body, err := decompressReader(encoding, r.Body)
+		if err != nil {
+			var unsupported unsupportedEncodingError
+			if errors.As(err, &unsupported) {
+				w.Header().Set("Accept-Encoding", "gzip, deflate")
+				writeError(w, http.StatusUnsupportedMediaType, "unsupported_encoding", err.Error())
+				return
+			}
+			writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid %s body", encoding))
+			return
+		}
+		defer body.Close()
+		r.Body = http.MaxBytesReader(w, body, maxDecompressedBytes)
+		// The length is unknown once decompressed.
+		r.ContentLength = -1
+		r.Header.Del("Content-Length")
+		r.Header.Del("Content-Encoding")
+		next(w, r)
+	}
+}
+
+type unsupportedEncodingError string
+
+func (e unsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported Content-Encoding %q, want gzip or deflate", string(e))
+}
+
+func decompressReader(encoding string, body io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		br := bufio.NewReader(body)
+		header, err := br.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		// A zlib header is a deflate CMF byte whose check bits make the
+		// first two bytes a multiple of 31.
+		if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+	return nil, unsupportedEncodingError(encoding)
+}
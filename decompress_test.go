@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func compressed(t *testing.T, encoding, body string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		return []byte(body)
+	}
+	if _, err := io.WriteString(w, body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		encoding    string
+		header      string
+		body        []byte
+		wantCode    int
+		wantErrCode string
+		wantSamples int
+	}{
+		{name: "gzip", target: "/analyze/web?sync=true", encoding: "gzip", header: "gzip", wantCode: http.StatusOK, wantSamples: 1},
+		{name: "zlib deflate", target: "/analyze/web?sync=true", encoding: "deflate", header: "deflate", wantCode: http.StatusOK, wantSamples: 1},
+		{name: "raw deflate", target: "/analyze/web?sync=true", encoding: "raw-deflate", header: "deflate", wantCode: http.StatusOK, wantSamples: 1},
+		{name: "identity", target: "/analyze/web?sync=true", header: "identity", wantCode: http.StatusOK, wantSamples: 1},
+		{name: "batch gzip", target: "/analyze/batch?stream=web", encoding: "gzip", header: "gzip", wantCode: http.StatusOK, wantSamples: 1},
+		{name: "unsupported", target: "/analyze/web?sync=true", header: "br", wantCode: http.StatusUnsupportedMediaType, wantErrCode: "unsupported_encoding"},
+		{name: "corrupt gzip", target: "/analyze/web?sync=true", header: "gzip", body: []byte("not gzip"), wantCode: http.StatusBadRequest, wantErrCode: "invalid_request"},
+		{name: "empty gzip", target: "/analyze/web?sync=true", encoding: "gzip", header: "gzip", body: []byte{}, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			payload := `{"rps": 10}`
+			if strings.Contains(tt.target, "batch") {
+				payload = `[{"rps": 10}]`
+			}
+			body := tt.body
+			if body == nil {
+				body = compressed(t, tt.encoding, payload)
+			} else if len(body) == 0 {
+				body = compressed(t, tt.encoding, "")
+			}
+			req := httptest.NewRequest(http.MethodPost, tt.target, bytes.NewReader(body))
+			req.Header.Set("Content-Encoding", tt.header)
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantErrCode != "" && !strings.Contains(w.Body.String(), `"code":"`+tt.wantErrCode+`"`) {
+				t.Errorf("body %s, want error code %s", w.Body.String(), tt.wantErrCode)
+			}
+			if stored, _ := mr.List("metrics:web"); len(stored) != tt.wantSamples {
+				t.Errorf("stored %d samples, want %d", len(stored), tt.wantSamples)
+			}
+		})
+	}
+}
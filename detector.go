@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strings"
+)
+
+// AnomalyDetector scores the current value against a window of recent
+// values. The window already contains current as its last element, matching
+// what the analyze pipeline reads back from Redis.
+type AnomalyDetector interface {
+	Name() string
+	Detect(window []float64, current float64) (score float64, anomalous bool)
+}
+
+// DetectorConfig selects and tunes a detector. It is accepted as JSON by
+// endpoints that let callers try out settings without touching live config.
+type DetectorConfig struct {
+	Type      string  `json:"type"`
+	Threshold float64 `json:"threshold"`
+	// StdDevMethod is "sample" (default) or "population"; zscore only.
+	StdDevMethod string `json:"stddev_method,omitempty"`
+	// MinStdDev floors the standard deviation scores are divided by;
+	// zscore only.
+	MinStdDev float64 `json:"min_stddev,omitempty"`
+	// Alpha is the EWMA smoothing factor in (0, 1]; ewma, and holtwinters
+	// for its level.
+	Alpha float64 `json:"alpha,omitempty"`
+	// Season is the length of the seasonal cycle in samples, and Beta and
+	// Gamma the trend and seasonal smoothing factors; holtwinters only.
+	Season int     `json:"season,omitempty"`
+	Beta   float64 `json:"beta,omitempty"`
+	Gamma  float64 `json:"gamma,omitempty"`
+	// Slack is the CUSUM allowance in reference standard deviations; cusum
+	// only.
+	Slack float64 `json:"slack,omitempty"`
+	// Members and MinVotes configure an ensemble: it flags a value when at
+	// least MinVotes members do (default: a majority).
+	Members  []DetectorConfig `json:"members,omitempty"`
+	MinVotes int              `json:"min_votes,omitempty"`
+	// ShortWindow and LongWindow are the window sizes compared by the
+	// divergence detector; divergence only.
+	ShortWindow int `json:"short_window,omitempty"`
+	LongWindow  int `json:"long_window,omitempty"`
+}
+
+const (
+	defaultZScoreThreshold = 2.0
+	defaultEWMAThreshold   = 3.0
+	defaultEWMAAlpha       = 0.3
+	// defaultMADThreshold is the usual cut-off for the modified z-score.
+	defaultMADThreshold = 3.5
+	// defaultDivergenceThreshold is in long-window standard deviations.
+	defaultDivergenceThreshold = 3.0
+	defaultShortWindow         = 5
+)
+
+// thresholdOr returns threshold, or def when it is unset.
+func thresholdOr(threshold, def float64) (float64, error) {
+	if threshold == 0 {
+		return def, nil
+	}
+	if threshold < 0 {
+		return 0, fmt.Errorf("threshold must be positive, got %v", threshold)
+	}
+	return threshold, nil
+}
+
+// DetectorFactory builds a detector of one type from cfg, filling in the
+// type's defaults for what cfg leaves unset.
+type DetectorFactory func(cfg DetectorConfig) (AnomalyDetector, error)
+
+// detectors maps each detector type to its factory. Adding an algorithm
+// takes a type implementing AnomalyDetector and a registerDetector call;
+// it is then accepted everywhere a detector is configured: DETECTOR,
+// ENSEMBLE_DETECTORS, ensemble members and POST /replay.
+var detectors = make(map[string]DetectorFactory)
+
+// registerDetector makes factory available under name. Registering a name
+// twice is a programming error.
+func registerDetector(name string, factory DetectorFactory) {
+	if _, ok := detectors[name]; ok {
+		panic("detector " + name + " registered twice")
+	}
+	detectors[name] = factory
+}
+
+// detectorTypes returns the registered detector types, sorted.
+func detectorTypes() []string {
+	return slices.Sorted(maps.Keys(detectors))
+}
+
+func init() {
+	registerDetector("zscore", newZScoreDetector)
+	registerDetector("trend", newTrendDetector)
+	registerDetector("ewma", newEWMADetector)
+	registerDetector("mad", newMADDetector)
+	registerDetector("divergence", newDivergenceDetector)
+	registerDetector("ensemble", func(cfg DetectorConfig) (AnomalyDetector, error) {
+		return newEnsembleDetector(cfg.Members, cfg.MinVotes)
+	})
+}
+
+// detectorFromEnv builds the live detector of type mode, as DETECTOR
+// names it, from detectorConfigFromEnv. Only the types PATCH /config can
+// switch to and holtwinters are accepted; trend, divergence and ensembles
+// have settings of their own.
+func detectorFromEnv(mode string) (AnomalyDetector, error) {
+	if !isRuntimeDetector(mode) && mode != "holtwinters" {
+		return nil, fmt.Errorf("detector must be one of %v or holtwinters, got %q", runtimeDetectors, mode)
+	}
+	cfg, err := detectorConfigFromEnv(mode)
+	if err != nil {
+		return nil, err
+	}
+	return newDetector(cfg)
+}
+
+// detectorConfigFromEnv returns the config of a detector of type mode as
+// the environment tunes it: the threshold in <MODE>_THRESHOLD
+// (ZSCORE_THRESHOLD, EWMA_THRESHOLD, ...), the EWMA smoothing factor in
+// EWMA_ALPHA, the HOLTWINTERS_SEASON, _ALPHA, _BETA and _GAMMA of
+// holtwinters, CUSUM_SLACK, and STDDEV_METHOD and MIN_STDDEV. Unset values
+// are left to the type's defaults.
+func detectorConfigFromEnv(mode string) (DetectorConfig, error) {
+	key := strings.ToUpper(mode) + "_THRESHOLD"
+	threshold := getEnvFloat(key, 0)
+	if os.Getenv(key) != "" && threshold <= 0 {
+		return DetectorConfig{}, fmt.Errorf("%s must be positive, got %v", key, threshold)
+	}
+	cfg := DetectorConfig{
+		Type:         mode,
+		Threshold:    threshold,
+		StdDevMethod: os.Getenv("STDDEV_METHOD"),
+		MinStdDev:    getEnvFloat("MIN_STDDEV", 0),
+	}
+	if mode == "ewma" {
+		cfg.Alpha = getEnvFloat("EWMA_ALPHA", 0)
+		if os.Getenv("EWMA_ALPHA") != "" && cfg.Alpha <= 0 {
+			return DetectorConfig{}, fmt.Errorf("EWMA_ALPHA must be in (0, 1], got %v", cfg.Alpha)
+		}
+	}
+	if mode == "holtwinters" {
+		// newHoltWintersDetector range-checks these; zero means the default.
+		cfg.Season = getEnvInt("HOLTWINTERS_SEASON", 0)
+		cfg.Alpha = getEnvFloat("HOLTWINTERS_ALPHA", 0)
+		cfg.Beta = getEnvFloat("HOLTWINTERS_BETA", 0)
+		cfg.Gamma = getEnvFloat("HOLTWINTERS_GAMMA", 0)
+	}
+	if mode == "cusum" {
+		cfg.Slack = getEnvFloat("CUSUM_SLACK", 0)
+	}
+	return cfg, nil
+}
+
+// newDetector builds the detector cfg selects; an empty type is zscore.
+func newDetector(cfg DetectorConfig) (AnomalyDetector, error) {
+	name := cfg.Type
+	if name == "" {
+		name = "zscore"
+	}
+	factory, ok := detectors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown detector type %q, want one of %s", cfg.Type, strings.Join(detectorTypes(), ", "))
+	}
+	return factory(cfg)
+}
+
+func newZScoreDetector(cfg DetectorConfig) (AnomalyDetector, error) {
+	threshold, err := thresholdOr(cfg.Threshold, defaultZScoreThreshold)
+	if err != nil {
+		return nil, err
+	}
+	method, err := parseStdDevMethod(cfg.StdDevMethod)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MinStdDev < 0 {
+		return nil, fmt.Errorf("min_stddev must not be negative, got %v", cfg.MinStdDev)
+	}
+	return &ZScoreDetector{Threshold: threshold, Method: method, MinStdDev: cfg.MinStdDev}, nil
+}
+
+func newTrendDetector(cfg DetectorConfig) (AnomalyDetector, error) {
+	if cfg.Threshold <= 0 {
+		return nil, fmt.Errorf("trend detector needs a positive slope threshold")
+	}
+	return &TrendDetector{MaxSlope: cfg.Threshold}, nil
+}
+
+func newEWMADetector(cfg DetectorConfig) (AnomalyDetector, error) {
+	threshold, err := thresholdOr(cfg.Threshold, defaultEWMAThreshold)
+	if err != nil {
+		return nil, err
+	}
+	alpha := cfg.Alpha
+	if alpha == 0 {
+		alpha = defaultEWMAAlpha
+	}
+	if alpha < 0 || alpha > 1 {
+		return nil, fmt.Errorf("alpha must be in (0, 1], got %v", alpha)
+	}
+	return &EWMADetector{Alpha: alpha, Threshold: threshold}, nil
+}
+
+func newMADDetector(cfg DetectorConfig) (AnomalyDetector, error) {
+	threshold, err := thresholdOr(cfg.Threshold, defaultMADThreshold)
+	if err != nil {
+		return nil, err
+	}
+	return &MADDetector{Threshold: threshold}, nil
+}
+
+func newDivergenceDetector(cfg DetectorConfig) (AnomalyDetector, error) {
+	threshold, err := thresholdOr(cfg.Threshold, defaultDivergenceThreshold)
+	if err != nil {
+		return nil, err
+	}
+	short := cfg.ShortWindow
+	if short == 0 {
+		short = defaultShortWindow
+	}
+	if short < 1 || cfg.LongWindow <= short {
+		return nil, fmt.Errorf("divergence needs 1 <= short_window < long_window, got %d and %d", short, cfg.LongWindow)
+	}
+	return &DivergenceDetector{Short: short, Long: cfg.LongWindow, Threshold: threshold}, nil
+}
+
+// ZScoreDetector flags values more than Threshold standard deviations away
+// from the window mean. Method picks the standard deviation denominator;
+// the zero value means sample. Scores are computed against at least
+// MinStdDev, so a near-constant window does not turn a tiny bump into a
+// huge score.
+type ZScoreDetector struct {
+	Threshold float64
+	Method    StdDevMethod
+	MinStdDev float64
+}
+
+func (d *ZScoreDetector) Name() string { return "zscore" }
+
+func (d *ZScoreDetector) Detect(window []float64, current float64) (float64, bool) {
+	if len(window) < 2 { // Need at least 2 values for std deviation
+		return 0, false
+	}
+	mean := calculateAverage(window)
+	stdDev := max(calculateStandardDeviation(window, mean, d.Method), d.MinStdDev)
+	if stdDev == 0 {
+		return 0, false
+	}
+	zScore := (current - mean) / stdDev
+	return zScore, math.Abs(zScore) > d.Threshold
+}
+
+// TrendDetector fits a least-squares line over the window and flags sustained
+// drift when the slope (change in value per sample) exceeds MaxSlope in
+// either direction. It catches gradual degradations that never produce a
+// single outlier large enough for the z-score detector.
+type TrendDetector struct {
+	MaxSlope float64
+}
+
+func (d *TrendDetector) Name() string { return "trend" }
+
+func (d *TrendDetector) Detect(window []float64, current float64) (float64, bool) {
+	if len(window) < 2 {
+		return 0, false
+	}
+	slope := calculateSlope(window)
+	return slope, math.Abs(slope) > d.MaxSlope
+}
+
+// EWMADetector compares the current value with an exponentially weighted
+// moving average and standard deviation of the values before it, so recent
+// samples count more than old ones. Alpha is the weight of each new sample.
+type EWMADetector struct {
+	Alpha     float64
+	Threshold float64
+}
+
+func (d *EWMADetector) Name() string { return "ewma" }
+
+func (d *EWMADetector) Detect(window []float64, current float64) (float64, bool) {
+	if len(window) < 3 { // Need at least 2 values before current
+		return 0, false
+	}
+	history := window[:len(window)-1]
+	mean, variance := history[0], 0.0
+	for _, v := range history[1:] {
+		diff := v - mean
+		incr := d.Alpha * diff
+		mean += incr
+		variance = (1 - d.Alpha) * (variance + diff*incr)
+	}
+	stdDev := math.Sqrt(variance)
+	if stdDev == 0 {
+		return 0, false
+	}
+	score := (current - mean) / stdDev
+	return score, math.Abs(score) > d.Threshold
+}
+
+// MADDetector flags values whose modified z-score, based on the median and
+// the median absolute deviation of the window, exceeds Threshold. Unlike the
+// mean and standard deviation, neither is dragged along by the outliers it
+// is looking for, so one large spike does not hide the ones after it.
+//
+// On a window where more than half the values are equal the MAD is zero;
+// the mean absolute deviation around the median stands in for it then, so
+// a mostly flat stream still has its anomalies flagged.
+type MADDetector struct {
+	Threshold float64
+}
+
+func (d *MADDetector) Name() string { return "mad" }
+
+func (d *MADDetector) Detect(window []float64, current float64) (float64, bool) {
+	if len(window) < 2 {
+		return 0, false
+	}
+	median := calculateMedian(window)
+	deviations := make([]float64, len(window))
+	for i, v := range window {
+		deviations[i] = math.Abs(v - median)
+	}
+	// 0.6745 and 0.7979 make the MAD and the mean absolute deviation
+	// consistent with the standard deviation for normally distributed data.
+	var score float64
+	if mad := calculateMedian(deviations); mad != 0 {
+		score = 0.6745 * (current - median) / mad
+	} else if meanAD := calculateAverage(deviations); meanAD != 0 {
+		score = 0.7979 * (current - median) / meanAD
+	} else {
+		return 0, false
+	}
+	return score, math.Abs(score) > d.Threshold
+}
+
+// DivergenceDetector compares the average of the Short most recent values
+// with the average of the Long most recent ones, in the spirit of
+// multi-window burn-rate alerts. The score is
+//
+//	(short_avg - long_avg) / long_stddev
+//
+// so a fast spike moves the short average away quickly while a sustained
+// shift keeps it away until the long window catches up. It flags values
+// whose score exceeds Threshold in either direction.
+type DivergenceDetector struct {
+	Short     int
+	Long      int
+	Threshold float64
+}
+
+func (d *DivergenceDetector) Name() string { return "divergence" }
+
+// Averages returns the short- and long-window averages of window.
+func (d *DivergenceDetector) Averages(window []float64) (short, long float64) {
+	return calculateAverage(lastN(window, d.Short)), calculateAverage(lastN(window, d.Long))
+}
+
+func (d *DivergenceDetector) Detect(window []float64, current float64) (float64, bool) {
+	long := lastN(window, d.Long)
+	if len(long) <= d.Short { // The short window must be a proper subset
+		return 0, false
+	}
+	shortAvg, longAvg := d.Averages(window)
+	stdDev := calculateStandardDeviation(long, longAvg, StdDevSample)
+	if stdDev == 0 {
+		return 0, false
+	}
+	score := (shortAvg - longAvg) / stdDev
+	return score, math.Abs(score) > d.Threshold
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewDetector(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      DetectorConfig
+		wantName string
+		wantErr  bool
+	}{
+		{name: "default is zscore", cfg: DetectorConfig{}, wantName: "zscore"},
+		{name: "zscore custom threshold", cfg: DetectorConfig{Type: "zscore", Threshold: 3}, wantName: "zscore"},
+		{name: "zscore negative threshold", cfg: DetectorConfig{Type: "zscore", Threshold: -1}, wantErr: true},
+		{name: "trend", cfg: DetectorConfig{Type: "trend", Threshold: 0.5}, wantName: "trend"},
+		{name: "trend without threshold", cfg: DetectorConfig{Type: "trend"}, wantErr: true},
+		{name: "trend negative threshold", cfg: DetectorConfig{Type: "trend", Threshold: -2}, wantErr: true},
+		{name: "unknown type", cfg: DetectorConfig{Type: "magic"}, wantErr: true},
+		{name: "zscore population", cfg: DetectorConfig{StdDevMethod: "population"}, wantName: "zscore"},
+		{name: "zscore unknown stddev method", cfg: DetectorConfig{StdDevMethod: "median"}, wantErr: true},
+		{name: "zscore stddev floor", cfg: DetectorConfig{MinStdDev: 0.5}, wantName: "zscore"},
+		{name: "zscore negative stddev floor", cfg: DetectorConfig{MinStdDev: -1}, wantErr: true},
+		{name: "ewma", cfg: DetectorConfig{Type: "ewma"}, wantName: "ewma"},
+		{name: "ewma alpha out of range", cfg: DetectorConfig{Type: "ewma", Alpha: 1.5}, wantErr: true},
+		{name: "ewma negative threshold", cfg: DetectorConfig{Type: "ewma", Threshold: -1}, wantErr: true},
+		{name: "mad", cfg: DetectorConfig{Type: "mad", Threshold: 5}, wantName: "mad"},
+		{name: "mad negative threshold", cfg: DetectorConfig{Type: "mad", Threshold: -1}, wantErr: true},
+		{name: "ensemble", cfg: DetectorConfig{Type: "ensemble", Members: []DetectorConfig{{Type: "zscore"}, {Type: "mad"}}}, wantName: "ensemble"},
+		{name: "ensemble without members", cfg: DetectorConfig{Type: "ensemble"}, wantErr: true},
+		{name: "ensemble bad member", cfg: DetectorConfig{Type: "ensemble", Members: []DetectorConfig{{Type: "magic"}}}, wantErr: true},
+		{name: "ensemble nested", cfg: DetectorConfig{Type: "ensemble", Members: []DetectorConfig{{Type: "ensemble"}}}, wantErr: true},
+		{name: "ensemble too many votes", cfg: DetectorConfig{Type: "ensemble", Members: []DetectorConfig{{Type: "mad"}}, MinVotes: 2}, wantErr: true},
+		{name: "divergence", cfg: DetectorConfig{Type: "divergence", LongWindow: 50}, wantName: "divergence"},
+		{name: "divergence without long window", cfg: DetectorConfig{Type: "divergence"}, wantErr: true},
+		{name: "divergence short not below long", cfg: DetectorConfig{Type: "divergence", ShortWindow: 10, LongWindow: 10}, wantErr: true},
+		{name: "divergence negative threshold", cfg: DetectorConfig{Type: "divergence", LongWindow: 50, Threshold: -1}, wantErr: true},
+		{name: "cusum", cfg: DetectorConfig{Type: "cusum"}, wantName: "cusum"},
+		{name: "cusum negative slack", cfg: DetectorConfig{Type: "cusum", Slack: -1}, wantErr: true},
+		{name: "cusum threshold within twice the slack", cfg: DetectorConfig{Type: "cusum", Threshold: 2, Slack: 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newDetector(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && d.Name() != tt.wantName {
+				t.Errorf("Name() = %q, want %q", d.Name(), tt.wantName)
+			}
+		})
+	}
+
+	d, _ := newDetector(DetectorConfig{})
+	if z := d.(*ZScoreDetector); z.Threshold != defaultZScoreThreshold {
+		t.Errorf("default threshold = %v, want %v", z.Threshold, defaultZScoreThreshold)
+	}
+}
+
+func TestRegisterDetector(t *testing.T) {
+	registerDetector("always", func(cfg DetectorConfig) (AnomalyDetector, error) {
+		return fixedDetector{name: "always", anomalous: true}, nil
+	})
+	t.Cleanup(func() { delete(detectors, "always") })
+
+	d, err := newDetector(DetectorConfig{Type: "always"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, anomalous := d.Detect(nil, 0); !anomalous || d.Name() != "always" {
+		t.Errorf("registered detector not used, got %s", d.Name())
+	}
+	// A registered type is usable as an ensemble member at once.
+	if _, err := newDetector(DetectorConfig{Type: "ensemble", Members: []DetectorConfig{{Type: "always"}, {Type: "mad"}}}); err != nil {
+		t.Errorf("ensemble with registered member: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	registerDetector("zscore", newZScoreDetector)
+}
+
+func TestDetectorFromEnv(t *testing.T) {
+	tests := []struct {
+		name          string
+		mode          string
+		env           map[string]string
+		wantThreshold float64
+		wantAlpha     float64
+		wantErr       bool
+	}{
+		{name: "mad default threshold", mode: "mad", wantThreshold: defaultMADThreshold},
+		{name: "mad threshold", mode: "mad", env: map[string]string{"MAD_THRESHOLD": "5"}, wantThreshold: 5},
+		{name: "ewma threshold", mode: "ewma", env: map[string]string{"EWMA_THRESHOLD": "2.5"}, wantThreshold: 2.5, wantAlpha: defaultEWMAAlpha},
+		{name: "ewma alpha", mode: "ewma", env: map[string]string{"EWMA_ALPHA": "0.1"}, wantThreshold: defaultEWMAThreshold, wantAlpha: 0.1},
+		{name: "ewma alpha above one", mode: "ewma", env: map[string]string{"EWMA_ALPHA": "1.5"}, wantErr: true},
+		{name: "ewma negative alpha", mode: "ewma", env: map[string]string{"EWMA_ALPHA": "-0.5"}, wantErr: true},
+		{name: "other type's threshold ignored", mode: "mad", env: map[string]string{"ZSCORE_THRESHOLD": "9"}, wantThreshold: defaultMADThreshold},
+		{name: "negative threshold", mode: "mad", env: map[string]string{"MAD_THRESHOLD": "-1"}, wantErr: true},
+		{name: "holtwinters", mode: "holtwinters", env: map[string]string{"HOLTWINTERS_SEASON": "24", "HOLTWINTERS_THRESHOLD": "4"}, wantThreshold: 4},
+		{name: "holtwinters without season", mode: "holtwinters", wantErr: true},
+		{name: "cusum", mode: "cusum", env: map[string]string{"CUSUM_THRESHOLD": "8", "CUSUM_SLACK": "1"}, wantThreshold: 8},
+		{name: "cusum slack too large", mode: "cusum", env: map[string]string{"CUSUM_SLACK": "3"}, wantErr: true},
+		{name: "not a runtime type", mode: "trend", wantErr: true},
+		{name: "unknown type", mode: "magic", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			d, err := detectorFromEnv(tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if threshold, _ := detectorThreshold(d); d.Name() != tt.mode || threshold != tt.wantThreshold {
+				t.Errorf("got %s with threshold %v, want %s with %v", d.Name(), threshold, tt.mode, tt.wantThreshold)
+			}
+			if ewma, ok := d.(*EWMADetector); ok && ewma.Alpha != tt.wantAlpha {
+				t.Errorf("alpha = %v, want %v", ewma.Alpha, tt.wantAlpha)
+			}
+		})
+	}
+}
+
+func TestZScoreDetector(t *testing.T) {
+	d := &ZScoreDetector{Threshold: 2}
+	tests := []struct {
+		name          string
+		window        []float64
+		wantScore     float64
+		wantAnomalous bool
+	}{
+		{name: "empty window", window: nil},
+		{name: "single value", window: []float64{100}},
+		{name: "constant window", window: []float64{5, 5, 5, 5}},
+		{name: "within band", window: []float64{10, 12, 11, 13, 12}, wantScore: 0.35082320772281206},
+		{name: "spike", window: []float64{10, 10, 10, 10, 10, 10, 10, 10, 10, 100}, wantScore: 2.846049894151541, wantAnomalous: true},
+		{name: "dip", window: []float64{100, 100, 100, 100, 100, 100, 100, 100, 100, 10}, wantScore: -2.846049894151541, wantAnomalous: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var current float64
+			if len(tt.window) > 0 {
+				current = tt.window[len(tt.window)-1]
+			}
+			score, anomalous := d.Detect(tt.window, current)
+			if math.Abs(score-tt.wantScore) > 1e-9 || anomalous != tt.wantAnomalous {
+				t.Errorf("Detect = (%v, %v), want (%v, %v)", score, anomalous, tt.wantScore, tt.wantAnomalous)
+			}
+		})
+	}
+}
+
+func TestZScoreDetectorMinStdDev(t *testing.T) {
+	// A near-constant window: stddev is about 0.036, so a bump of 0.1
+	// scores 2.42 without a floor and 0.09 against a floor of 1.
+	window := []float64{100, 100.01, 99.99, 100, 100.01, 99.99, 100, 100.1}
+	tests := []struct {
+		name          string
+		floor         float64
+		window        []float64
+		wantAnomalous bool
+		maxScore      float64
+	}{
+		{name: "no floor over-fires", window: window, wantAnomalous: true, maxScore: math.Inf(1)},
+		{name: "floor absorbs small bump", floor: 1, window: window, maxScore: 0.1},
+		{name: "floor keeps real spikes", floor: 1, window: append(window[:7:7], 110), wantAnomalous: true, maxScore: math.Inf(1)},
+		{name: "floor on constant window", floor: 1, window: []float64{5, 5, 5, 5}, maxScore: 0},
+		{name: "floor below stddev changes nothing", floor: 0.001, window: window, wantAnomalous: true, maxScore: math.Inf(1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &ZScoreDetector{Threshold: 2, MinStdDev: tt.floor}
+			score, anomalous := d.Detect(tt.window, tt.window[len(tt.window)-1])
+			if anomalous != tt.wantAnomalous || math.Abs(score) > tt.maxScore {
+				t.Errorf("Detect = (%v, %v), want anomalous %v with |score| <= %v", score, anomalous, tt.wantAnomalous, tt.maxScore)
+			}
+		})
+	}
+}
+
+func TestCalculateStandardDeviation(t *testing.T) {
+	// Classic dataset: mean 5, sum of squared deviations 32.
+	data := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	tests := []struct {
+		name   string
+		values []float64
+		method StdDevMethod
+		want   float64
+	}{
+		{name: "sample", values: data, method: StdDevSample, want: math.Sqrt(32.0 / 7)},
+		{name: "population", values: data, method: StdDevPopulation, want: 2},
+		{name: "zero value is sample", values: data, want: math.Sqrt(32.0 / 7)},
+		{name: "single value sample", values: []float64{3}, method: StdDevSample},
+		{name: "single value population", values: []float64{3}, method: StdDevPopulation},
+		{name: "empty", method: StdDevPopulation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := calculateStandardDeviation(tt.values, calculateAverage(tt.values), tt.method)
+			if math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("calculateStandardDeviation = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStdDevMethod(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    StdDevMethod
+		wantErr bool
+	}{
+		{in: "", want: StdDevSample},
+		{in: "sample", want: StdDevSample},
+		{in: "population", want: StdDevPopulation},
+		{in: "Population", wantErr: true},
+		{in: "n-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseStdDevMethod(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseStdDevMethod(%q) = (%q, %v), want (%q, wantErr %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestZScoreDetectorPopulation(t *testing.T) {
+	// With a population stddev of 2 the last value (9) scores exactly 2.
+	d := &ZScoreDetector{Threshold: 1.9, Method: StdDevPopulation}
+	score, anomalous := d.Detect([]float64{2, 4, 4, 4, 5, 5, 7, 9}, 9)
+	if score != 2 || !anomalous {
+		t.Errorf("Detect = (%v, %v), want (2, true)", score, anomalous)
+	}
+}
+
+func TestEWMADetector(t *testing.T) {
+	d := &EWMADetector{Alpha: defaultEWMAAlpha, Threshold: defaultEWMAThreshold}
+	tests := []struct {
+		name          string
+		window        []float64
+		wantScore     float64
+		wantAnomalous bool
+	}{
+		{name: "too short", window: []float64{10, 100}},
+		{name: "constant history", window: []float64{10, 10, 10, 10, 100}},
+		{name: "within band", window: []float64{10, 12, 10, 12, 10, 12, 11}, wantScore: -0.038087596150013316},
+		{name: "spike", window: []float64{10, 12, 10, 12, 10, 12, 40}, wantScore: 28.982939423040555, wantAnomalous: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, anomalous := d.Detect(tt.window, tt.window[len(tt.window)-1])
+			if math.Abs(score-tt.wantScore) > 1e-9 || anomalous != tt.wantAnomalous {
+				t.Errorf("Detect = (%v, %v), want (%v, %v)", score, anomalous, tt.wantScore, tt.wantAnomalous)
+			}
+		})
+	}
+}
+
+func TestMADDetector(t *testing.T) {
+	d := &MADDetector{Threshold: defaultMADThreshold}
+	tests := []struct {
+		name          string
+		window        []float64
+		wantScore     float64
+		wantAnomalous bool
+	}{
+		{name: "single value", window: []float64{10}},
+		{name: "constant window", window: []float64{5, 5, 5, 5}},
+		{name: "zero MAD falls back to mean deviation", window: []float64{5, 5, 5, 9}, wantScore: 3.1916},
+		{name: "zero MAD spike", window: []float64{5, 5, 5, 5, 5, 5, 5, 9}, wantScore: 6.3832, wantAnomalous: true},
+		{name: "within band", window: []float64{10, 12, 11, 13, 12, 11, 13}, wantScore: 0.6745},
+		{name: "spike", window: []float64{10, 12, 11, 13, 12, 11, 50}, wantScore: 25.631, wantAnomalous: true},
+		// The 200 inflates the standard deviation enough that a z-score
+		// scores the 25 below zero; the median and MAD barely move.
+		{name: "spike after outlier", window: []float64{10, 11, 10, 12, 11, 10, 200, 11, 10, 12, 11, 25}, wantScore: 9.443, wantAnomalous: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, anomalous := d.Detect(tt.window, tt.window[len(tt.window)-1])
+			if math.Abs(score-tt.wantScore) > 1e-9 || anomalous != tt.wantAnomalous {
+				t.Errorf("Detect = (%v, %v), want (%v, %v)", score, anomalous, tt.wantScore, tt.wantAnomalous)
+			}
+		})
+	}
+}
+
+func TestCalculateMedian(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   float64
+	}{
+		{values: nil, want: 0},
+		{values: []float64{7}, want: 7},
+		{values: []float64{3, 1, 2}, want: 2},
+		{values: []float64{4, 1, 3, 2}, want: 2.5},
+	}
+	for _, tt := range tests {
+		in := append([]float64(nil), tt.values...)
+		if got := calculateMedian(in); got != tt.want {
+			t.Errorf("calculateMedian(%v) = %v, want %v", tt.values, got, tt.want)
+		}
+		for i := range in {
+			if in[i] != tt.values[i] {
+				t.Errorf("calculateMedian reordered its input: %v", in)
+				break
+			}
+		}
+	}
+}
+
+func TestCalculateCorrelation(t *testing.T) {
+	tests := []struct {
+		name   string
+		x, y   []float64
+		want   float64
+		wantOK bool
+	}{
+		{name: "empty", wantOK: false},
+		{name: "single pair", x: []float64{1}, y: []float64{2}, wantOK: false},
+		{name: "perfectly correlated", x: []float64{1, 2, 3, 4}, y: []float64{10, 20, 30, 40}, want: 1, wantOK: true},
+		{name: "perfectly anti-correlated", x: []float64{1, 2, 3, 4}, y: []float64{8, 6, 4, 2}, want: -1, wantOK: true},
+		{name: "uncorrelated", x: []float64{1, 2, 3, 4}, y: []float64{1, -1, -1, 1}, want: 0, wantOK: true},
+		{name: "partly correlated", x: []float64{1, 2, 3, 4, 5}, y: []float64{2, 4, 5, 4, 5}, want: 0.7746, wantOK: true},
+		{name: "flat cpu", x: []float64{1, 2, 3}, y: []float64{5, 5, 5}, wantOK: false},
+		{name: "flat rps", x: []float64{7, 7, 7}, y: []float64{1, 2, 3}, wantOK: false},
+		{name: "trailing pairs of unequal windows", x: []float64{100, 1, 2, 3}, y: []float64{3, 6, 9}, want: 1, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := calculateCorrelation(tt.x, tt.y)
+			if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-4 {
+				t.Errorf("calculateCorrelation = %v, %v; want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCalculateSlope(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   float64
+	}{
+		{name: "empty", values: nil, want: 0},
+		{name: "single", values: []float64{4}, want: 0},
+		{name: "flat", values: []float64{3, 3, 3, 3}, want: 0},
+		{name: "rising line", values: []float64{1, 3, 5, 7}, want: 2},
+		{name: "falling line", values: []float64{10, 9, 8}, want: -1},
+		{name: "noisy rise", values: []float64{1, 2, 2, 4}, want: 0.9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calculateSlope(tt.values); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("calculateSlope(%v) = %v, want %v", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrendDetector(t *testing.T) {
+	d := &TrendDetector{MaxSlope: 1}
+	tests := []struct {
+		name     string
+		window   []float64
+		drifting bool
+	}{
+		{name: "too short", window: []float64{100}},
+		{name: "gentle rise", window: []float64{10, 10.5, 11, 11.5}},
+		{name: "steady climb", window: []float64{10, 12, 14, 16, 18}, drifting: true},
+		{name: "steady fall", window: []float64{18, 16, 14, 12, 10}, drifting: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, drifting := d.Detect(tt.window, 0)
+			if drifting != tt.drifting {
+				t.Errorf("Detect(%v) drifting = %v, want %v", tt.window, drifting, tt.drifting)
+			}
+		})
+	}
+}
+
+func TestDivergenceDetector(t *testing.T) {
+	d := &DivergenceDetector{Short: 2, Long: 6, Threshold: 1}
+	tests := []struct {
+		name      string
+		window    []float64
+		wantScore float64
+		diverging bool
+	}{
+		{name: "too short", window: []float64{10, 20}},
+		{name: "flat", window: []float64{10, 10, 10, 10, 10, 10}},
+		{name: "steady", window: []float64{10, 11, 10, 11, 10, 11}},
+		{name: "recent shift", window: []float64{10, 11, 10, 11, 30, 30}, wantScore: 1.2897, diverging: true},
+		{name: "older than long window ignored", window: []float64{500, 10, 11, 10, 11, 10, 11}},
+		{name: "recent drop", window: []float64{30, 31, 30, 31, 10, 10}, wantScore: -1.2898, diverging: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, diverging := d.Detect(tt.window, tt.window[len(tt.window)-1])
+			if diverging != tt.diverging || math.Abs(score-tt.wantScore) > 1e-4 {
+				t.Errorf("Detect(%v) = %v, %v; want %v, %v", tt.window, score, diverging, tt.wantScore, tt.diverging)
+			}
+		})
+	}
+
+	short, long := d.Averages([]float64{500, 10, 11, 10, 11, 30, 30})
+	if short != 30 || long != 17 {
+		t.Errorf("Averages = %v, %v; want 30, 17", short, long)
+	}
+}
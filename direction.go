@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Direction selects which deviations of a series count as anomalies.
+type Direction string
+
+const (
+	// DirectionBoth flags spikes and dips. It is the default.
+	DirectionBoth Direction = "both"
+	// DirectionUp only flags values above the window mean.
+	DirectionUp Direction = "up"
+	// DirectionDown only flags values below the window mean.
+	DirectionDown Direction = "down"
+)
+
+// parseDirections parses an ANOMALY_DIRECTIONS spec such as
+// "rps=up,cpu=both". Series missing from the spec use DirectionBoth.
+func parseDirections(spec string) (map[string]Direction, error) {
+	directions := make(map[string]Direction, len(knownSeries))
+	for _, name := range knownSeries {
+		directions[name] = DirectionBoth
+	}
+	if strings.TrimSpace(spec) == "" {
+		return directions, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("malformed entry %q, want name=direction", entry)
+		}
+		if _, known := directions[name]; !known {
+			return nil, fmt.Errorf("unknown metric %q, want one of %s", name, strings.Join(knownSeries, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("metric %q listed more than once", name)
+		}
+		switch d := Direction(value); d {
+		case DirectionBoth, DirectionUp, DirectionDown:
+			directions[name] = d
+		default:
+			return nil, fmt.Errorf("direction for %q must be both, up or down, got %q", name, value)
+		}
+		seen[name] = true
+	}
+	return directions, nil
+}
+
+// directionOf tells whether current is a spike (up) or a dip (down)
+// relative to the window mean. It looks at the values rather than the
+// score, since not every detector's score is signed: an ensemble's counts
+// votes.
+func directionOf(window []float64, current float64) Direction {
+	if current < calculateAverage(window) {
+		return DirectionDown
+	}
+	return DirectionUp
+}
+
+// allows reports whether an anomaly in direction dir is flagged under d.
+func (d Direction) allows(dir Direction) bool {
+	return d == "" || d == DirectionBoth || d == dir
+}
+
+// directionFor returns the direction configured for a series.
+func (s *AppState) directionFor(series string) Direction {
+	if d, ok := s.directions[series]; ok {
+		return d
+	}
+	return DirectionBoth
+}
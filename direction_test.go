@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log/slog"
+	"maps"
+	"testing"
+)
+
+func TestParseDirections(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]Direction
+		wantErr bool
+	}{
+		{spec: "", want: map[string]Direction{"rps": DirectionBoth, "cpu": DirectionBoth}},
+		{spec: "rps=up", want: map[string]Direction{"rps": DirectionUp, "cpu": DirectionBoth}},
+		{spec: " rps = down , cpu=up ", want: map[string]Direction{"rps": DirectionDown, "cpu": DirectionUp}},
+		{spec: "rps", wantErr: true},
+		{spec: "mem=up", wantErr: true},
+		{spec: "rps=sideways", wantErr: true},
+		{spec: "rps=up,rps=down", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDirections(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !maps.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyzeWindowDirection(t *testing.T) {
+	tests := []struct {
+		name          string
+		direction     Direction
+		current       float64
+		wantStatus    string
+		wantDirection Direction
+	}{
+		{name: "both flags spike", direction: DirectionBoth, current: 100, wantStatus: statusAnomaly, wantDirection: DirectionUp},
+		{name: "both flags dip", direction: DirectionBoth, current: 0, wantStatus: statusAnomaly, wantDirection: DirectionDown},
+		{name: "up flags spike", direction: DirectionUp, current: 100, wantStatus: statusAnomaly, wantDirection: DirectionUp},
+		{name: "up ignores dip", direction: DirectionUp, current: 0, wantStatus: statusNormal},
+		{name: "down ignores spike", direction: DirectionDown, current: 100, wantStatus: statusNormal},
+		{name: "down flags dip", direction: DirectionDown, current: 0, wantStatus: statusAnomaly, wantDirection: DirectionDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			appState.directions = map[string]Direction{"rps": tt.direction}
+			appState.detector = &ZScoreDetector{Threshold: 1.5}
+			var window []Metric
+			for i := 0; i < 10; i++ {
+				window = append(window, Metric{RPS: 50 + float64(i%2)})
+			}
+			window = append(window, Metric{RPS: tt.current})
+
+			got := analyzeWindow(t.Context(), slog.Default(), "web", window[len(window)-1], window)
+			if got.Status != tt.wantStatus || got.Direction != tt.wantDirection {
+				t.Errorf("status %q direction %q, want %q and %q", got.Status, got.Direction, tt.wantStatus, tt.wantDirection)
+			}
+			if flagged := anomalyCount() > 0; flagged != (tt.wantStatus == statusAnomaly) {
+				t.Errorf("anomaly counted = %v, want %v", flagged, tt.wantStatus == statusAnomaly)
+			}
+		})
+	}
+}
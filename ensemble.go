@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// EnsembleDetector runs several detectors over the same window and flags a
+// value only when at least MinVotes of them do. The score is the number of
+// votes. When Votes is set, every member that flags a value increments its
+// "detector" label, whether or not the ensemble as a whole agrees.
+type EnsembleDetector struct {
+	Members  []AnomalyDetector
+	MinVotes int
+	Votes    *prometheus.CounterVec
+}
+
+func newEnsembleDetector(members []DetectorConfig, minVotes int) (*EnsembleDetector, error) {
+	if len(members) == 0 {
+		return nil, errors.New("ensemble needs at least one member")
+	}
+	e := &EnsembleDetector{MinVotes: minVotes}
+	for _, cfg := range members {
+		if cfg.Type == "ensemble" {
+			return nil, errors.New("ensembles cannot be nested")
+		}
+		d, err := newDetector(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("ensemble member %q: %w", cfg.Type, err)
+		}
+		e.Members = append(e.Members, d)
+	}
+	if e.MinVotes == 0 {
+		e.MinVotes = len(e.Members)/2 + 1
+	}
+	if e.MinVotes < 0 || e.MinVotes > len(e.Members) {
+		return nil, fmt.Errorf("min votes must be between 1 and %d, got %d", len(e.Members), e.MinVotes)
+	}
+	return e, nil
+}
+
+// ensembleFromEnv builds the live ensemble from ENSEMBLE_DETECTORS, a comma
+// separated list of detector types tuned as detectorConfigFromEnv reads
+// them, and ENSEMBLE_MIN_VOTES. It returns nil when no ensemble is
+// configured.
+func ensembleFromEnv() (*EnsembleDetector, error) {
+	spec := os.Getenv("ENSEMBLE_DETECTORS")
+	if spec == "" {
+		return nil, nil
+	}
+	var members []DetectorConfig
+	for _, name := range strings.Split(spec, ",") {
+		cfg, err := detectorConfigFromEnv(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, cfg)
+	}
+	return newEnsembleDetector(members, getEnvInt("ENSEMBLE_MIN_VOTES", 0))
+}
+
+func (e *EnsembleDetector) Name() string { return "ensemble" }
+
+func (e *EnsembleDetector) Detect(window []float64, current float64) (float64, bool) {
+	votes := 0
+	for _, d := range e.Members {
+		if _, anomalous := d.Detect(window, current); anomalous {
+			votes++
+			if e.Votes != nil {
+				e.Votes.WithLabelValues(d.Name()).Inc()
+			}
+		}
+	}
+	return float64(votes), votes >= e.MinVotes
+}
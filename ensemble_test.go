@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fixedDetector always returns the same verdict.
+type fixedDetector struct {
+	name      string
+	anomalous bool
+}
+
+func (d fixedDetector) Name() string { return d.name }
+
+func (d fixedDetector) Detect([]float64, float64) (float64, bool) { return 0, d.anomalous }
+
+func TestEnsembleDetectorVoting(t *testing.T) {
+	yes := func(name string) AnomalyDetector { return fixedDetector{name, true} }
+	no := func(name string) AnomalyDetector { return fixedDetector{name, false} }
+	tests := []struct {
+		name          string
+		members       []AnomalyDetector
+		minVotes      int
+		wantVotes     float64
+		wantAnomalous bool
+	}{
+		{name: "all agree", members: []AnomalyDetector{yes("a"), yes("b"), yes("c")}, minVotes: 2, wantVotes: 3, wantAnomalous: true},
+		{name: "exactly k", members: []AnomalyDetector{yes("a"), no("b"), yes("c")}, minVotes: 2, wantVotes: 2, wantAnomalous: true},
+		{name: "below k", members: []AnomalyDetector{yes("a"), no("b"), no("c")}, minVotes: 2, wantVotes: 1},
+		{name: "none", members: []AnomalyDetector{no("a"), no("b")}, minVotes: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &EnsembleDetector{Members: tt.members, MinVotes: tt.minVotes}
+			votes, anomalous := e.Detect(nil, 0)
+			if votes != tt.wantVotes || anomalous != tt.wantAnomalous {
+				t.Errorf("Detect = (%v, %v), want (%v, %v)", votes, anomalous, tt.wantVotes, tt.wantAnomalous)
+			}
+		})
+	}
+}
+
+func TestEnsembleCountsVotesPerDetector(t *testing.T) {
+	votes := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "votes"}, []string{"detector"})
+	e := &EnsembleDetector{
+		Members:  []AnomalyDetector{fixedDetector{"zscore", true}, fixedDetector{"ewma", false}, fixedDetector{"mad", true}},
+		MinVotes: 3,
+		Votes:    votes,
+	}
+	e.Detect(nil, 0)
+	e.Detect(nil, 0)
+	for name, want := range map[string]float64{"zscore": 2, "ewma": 0, "mad": 2} {
+		if got := counterValue(votes.WithLabelValues(name)); got != want {
+			t.Errorf("votes{detector=%q} = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestEnsembleFromEnv(t *testing.T) {
+	tests := []struct {
+		name         string
+		detectors    string
+		minVotes     string
+		wantNil      bool
+		wantMembers  int
+		wantMinVotes int
+		wantErr      bool
+	}{
+		{name: "unset", wantNil: true},
+		{name: "majority by default", detectors: "zscore, ewma,mad", wantMembers: 3, wantMinVotes: 2},
+		{name: "explicit k", detectors: "zscore,ewma,mad", minVotes: "3", wantMembers: 3, wantMinVotes: 3},
+		{name: "unknown member", detectors: "zscore,magic", wantErr: true},
+		{name: "k too large", detectors: "zscore,mad", minVotes: "3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ENSEMBLE_DETECTORS", tt.detectors)
+			t.Setenv("ENSEMBLE_MIN_VOTES", tt.minVotes)
+			e, err := ensembleFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (e == nil) != tt.wantNil {
+				t.Fatalf("ensemble = %v, wantNil %v", e, tt.wantNil)
+			}
+			if e != nil && (len(e.Members) != tt.wantMembers || e.MinVotes != tt.wantMinVotes) {
+				t.Errorf("ensemble has %d members, k=%d; want %d, k=%d", len(e.Members), e.MinVotes, tt.wantMembers, tt.wantMinVotes)
+			}
+		})
+	}
+}
+
+func TestEnsembleFromEnvTunesMembers(t *testing.T) {
+	t.Setenv("ENSEMBLE_DETECTORS", "zscore,ewma")
+	t.Setenv("ZSCORE_THRESHOLD", "3")
+	t.Setenv("EWMA_THRESHOLD", "2.5")
+	t.Setenv("EWMA_ALPHA", "0.1")
+	e, err := ensembleFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z := e.Members[0].(*ZScoreDetector); z.Threshold != 3 {
+		t.Errorf("zscore threshold = %v, want 3", z.Threshold)
+	}
+	if ewma := e.Members[1].(*EWMADetector); ewma.Threshold != 2.5 || ewma.Alpha != 0.1 {
+		t.Errorf("ewma = %+v, want threshold 2.5, alpha 0.1", ewma)
+	}
+}
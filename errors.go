@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+)
+
+// retryAfterSeconds is the Retry-After sent with 429 and 503 responses
+// unless the handler set its own: every such condition here (a full queue,
+// an open breaker, a pod still bootstrapping) clears within seconds.
+const retryAfterSeconds = "1"
+
+// errorBody is the JSON shape of every error response, so clients can
+// branch on a stable code instead of parsing the message.
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError answers with status and {"error":{"code":...,"message":...}}.
+// It adds Retry-After to 429 and 503 responses if not already set.
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	h := w.Header()
+	if (status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable) && h.Get("Retry-After") == "" {
+		h.Set("Retry-After", retryAfterSeconds)
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	encodeJSON(w, errorBody{Error: errorDetail{Code: code, Message: msg}})
+}
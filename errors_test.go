@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		presetRetry    string
+		wantRetryAfter string
+	}{
+		{name: "bad request", status: http.StatusBadRequest},
+		{name: "too many requests", status: http.StatusTooManyRequests, wantRetryAfter: retryAfterSeconds},
+		{name: "unavailable", status: http.StatusServiceUnavailable, wantRetryAfter: retryAfterSeconds},
+		{name: "handler retry after kept", status: http.StatusServiceUnavailable, presetRetry: "30", wantRetryAfter: "30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if tt.presetRetry != "" {
+				w.Header().Set("Retry-After", tt.presetRetry)
+			}
+			writeError(w, tt.status, "some_code", "some message")
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+			var body errorBody
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q is not JSON: %v", w.Body.String(), err)
+			}
+			if body.Error.Code != "some_code" || body.Error.Message != "some message" {
+				t.Errorf("body = %+v", body)
+			}
+		})
+	}
+}
+
+func TestHandlerErrorBodies(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		wantCode int
+		wantErr  string
+	}{
+		{name: "invalid json", method: http.MethodPost, target: "/analyze", body: "{", wantCode: http.StatusBadRequest, wantErr: "invalid_json"},
+		{name: "invalid stream", method: http.MethodGet, target: "/history/bad%20name", wantCode: http.StatusBadRequest, wantErr: "invalid_request"},
+		{name: "unknown anomaly", method: http.MethodGet, target: "/anomalies/nope/context", wantCode: http.StatusNotFound, wantErr: "not_found"},
+		{name: "admin disabled", method: http.MethodPost, target: "/config/reload", wantCode: http.StatusForbidden, wantErr: "admin_disabled"},
+		{name: "not ready", method: http.MethodGet, target: "/ready", wantCode: http.StatusServiceUnavailable, wantErr: "not_ready"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			var body errorBody
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q is not JSON: %v", w.Body.String(), err)
+			}
+			if body.Error.Code != tt.wantErr || body.Error.Message == "" {
+				t.Errorf("error = %+v, want code %q with a message", body.Error, tt.wantErr)
+			}
+		})
+	}
+}
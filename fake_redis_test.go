@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// faultyRedis wraps the miniredis client from newTestAppState and fails the
+// commands named in fail, so handlers can be tested against Redis errors
+// without stopping the server. Every other command goes through.
+type faultyRedis struct {
+	redis.UniversalClient
+	fail map[string]error
+}
+
+func (f *faultyRedis) Ping(ctx context.Context) *redis.StatusCmd {
+	if err := f.fail["ping"]; err != nil {
+		cmd := redis.NewStatusCmd(ctx, "ping")
+		cmd.SetErr(err)
+		return cmd
+	}
+	return f.UniversalClient.Ping(ctx)
+}
+
+func (f *faultyRedis) Get(ctx context.Context, key string) *redis.StringCmd {
+	if err := f.fail["get"]; err != nil {
+		cmd := redis.NewStringCmd(ctx, "get", key)
+		cmd.SetErr(err)
+		return cmd
+	}
+	return f.UniversalClient.Get(ctx, key)
+}
+
+func (f *faultyRedis) Incr(ctx context.Context, key string) *redis.IntCmd {
+	if err := f.fail["incr"]; err != nil {
+		cmd := redis.NewIntCmd(ctx, "incr", key)
+		cmd.SetErr(err)
+		return cmd
+	}
+	return f.UniversalClient.Incr(ctx, key)
+}
+
+func TestHandlersWithFaultyRedis(t *testing.T) {
+	errDown := errors.New("connection refused")
+	tests := []struct {
+		name     string
+		fail     string
+		method   string
+		target   string
+		body     string
+		wantCode int
+		wantJSON string
+	}{
+		{name: "count", fail: "get", method: http.MethodGet, target: "/count", wantCode: http.StatusInternalServerError, wantJSON: `"internal_error"`},
+		{name: "analyze", fail: "incr", method: http.MethodPost, target: "/analyze", body: `{"rps": 1}`, wantCode: http.StatusInternalServerError, wantJSON: `"internal_error"`},
+		// The health check reports Redis but stays up itself.
+		{name: "health", fail: "ping", method: http.MethodGet, target: "/health", wantCode: http.StatusOK, wantJSON: `"redis":"unhealthy"`},
+		{name: "unaffected", fail: "ping", method: http.MethodGet, target: "/count", wantCode: http.StatusOK, wantJSON: `"count":0`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			appState.redisClient = &faultyRedis{UniversalClient: appState.redisClient, fail: map[string]error{tt.fail: errDown}}
+
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if !json.Valid(w.Body.Bytes()) || !strings.Contains(w.Body.String(), tt.wantJSON) {
+				t.Errorf("body = %s, want JSON containing %s", w.Body.String(), tt.wantJSON)
+			}
+			// A failed /analyze must not have stored its sample.
+			if mr.Exists("metrics") {
+				t.Error("sample stored despite the failed request")
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Paces of the -replay-pace flag.
+const (
+	// replayPaceFull feeds records as fast as the pipeline takes them.
+	replayPaceFull = "full"
+	// replayPaceOriginal waits between records as long as their
+	// timestamps are apart.
+	replayPaceOriginal = "original"
+)
+
+// maxReplayLineBytes bounds one record of a replay file.
+const maxReplayLineBytes = 1 << 20
+
+func parseReplayPace(s string) (string, error) {
+	switch s {
+	case replayPaceFull, replayPaceOriginal:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown pace %q, want %s or %s", s, replayPaceFull, replayPaceOriginal)
+}
+
+// FileReplayResult sums up a -replay run.
+type FileReplayResult struct {
+	Records   int `json:"records"`
+	Processed int `json:"processed"`
+	Invalid   int `json:"invalid"`
+	Failed    int `json:"failed"`
+	Anomalies int `json:"anomalies"`
+}
+
+// replayFile feeds the NDJSON metric records of r through the pipeline in
+// file order, for deterministic replays when tuning detector thresholds.
+// Each line is a metric as POST /analyze takes it, FIELD_MAPPING included,
+// with an optional "stream" key naming its stream (default "default").
+// Unlike /replay, which is a dry run, records are stored and detected as
+// live samples are. As with /ingest/csv, CLOCK_SKEW_TOLERANCE does not
+// apply, and records without a timestamp get the time they are fed.
+//
+// At replayPaceOriginal it sleeps between records as long as their
+// timestamps are apart; records out of order are fed at once. Invalid
+// records are logged and skipped. It stops when ctx is done.
+func replayFile(ctx context.Context, logger *slog.Logger, r io.Reader, pace string) (FileReplayResult, error) {
+	var res FileReplayResult
+	var prev time.Time
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), maxReplayLineBytes)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		res.Records++
+		stream, m, err := decodeReplayRecord(sc.Bytes())
+		if err != nil {
+			logger.Warn("Skipping invalid replay record", "line", line, "error", err)
+			res.Invalid++
+			continue
+		}
+		if pace == replayPaceOriginal && !m.Timestamp.IsZero() {
+			if !prev.IsZero() && m.Timestamp.After(prev) {
+				timer := time.NewTimer(m.Timestamp.Sub(prev))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return res, ctx.Err()
+				case <-timer.C:
+				}
+			}
+			prev = m.Timestamp
+		}
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		if m.Timestamp.IsZero() {
+			m.Timestamp = appState.now().UTC()
+		}
+
+		logger := logger.With("stream", stream)
+		if err := recordIntake(ctx, logger, stream, m); err != nil {
+			res.Failed++
+			continue
+		}
+		result, err := processMetric(ctx, logger, stream, m)
+		if err != nil {
+			res.Failed++
+			continue
+		}
+		res.Processed++
+		if result.Status == statusAnomaly {
+			res.Anomalies++
+		}
+	}
+	return res, sc.Err()
+}
+
+// decodeReplayRecord decodes one replay line into its stream and a metric
+// in canonical units.
+func decodeReplayRecord(line []byte) (string, Metric, error) {
+	var rec struct {
+		Stream string `json:"stream"`
+	}
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return "", Metric{}, err
+	}
+	stream := rec.Stream
+	if stream == "" {
+		stream = defaultStream
+	}
+	if !streamNamePattern.MatchString(stream) {
+		return "", Metric{}, fmt.Errorf("invalid stream name %q", stream)
+	}
+	m, err := decodeIngested(line, appState.fieldMapping)
+	if err != nil {
+		return "", Metric{}, err
+	}
+	if m, err = normalizeUnits(m); err != nil {
+		return "", Metric{}, err
+	}
+	return stream, m, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		pace        string
+		input       string
+		want        FileReplayResult
+		wantStored  map[string][]float64
+		wantAtLeast time.Duration
+	}{
+		{
+			name: "full speed, file order",
+			pace: replayPaceFull,
+			input: `{"timestamp":"2023-12-25T00:00:02Z","cpu":50,"rps":30}` + "\n" +
+				`{"stream":"web","timestamp":1703462400,"cpu":50,"rps":10}` + "\n" +
+				"\n" +
+				`{"stream":"web","timestamp":1703462401,"cpu":0.5,"cpu_unit":"fraction","rps":20}` + "\n",
+			want:       FileReplayResult{Records: 3, Processed: 3},
+			wantStored: map[string][]float64{"web": {10, 20}, defaultStream: {30}},
+		},
+		{
+			name: "invalid records skipped",
+			pace: replayPaceFull,
+			input: `{"stream":"web","rps":10}` + "\n" +
+				"not json\n" +
+				`{"stream":"bad name","rps":1}` + "\n" +
+				`{"stream":"web","rps":1,"rps_unit":"furlongs"}` + "\n",
+			want:       FileReplayResult{Records: 4, Processed: 1, Invalid: 3},
+			wantStored: map[string][]float64{"web": {10}},
+		},
+		{
+			name: "original pacing",
+			pace: replayPaceOriginal,
+			input: `{"stream":"web","timestamp":"2023-12-25T00:00:00Z","rps":10}` + "\n" +
+				`{"stream":"web","timestamp":"2023-12-25T00:00:00.05Z","rps":20}` + "\n" +
+				`{"stream":"web","timestamp":"2023-12-25T00:00:00.03Z","rps":30}` + "\n" +
+				`{"stream":"web","timestamp":"2023-12-25T00:00:00.08Z","rps":40}` + "\n",
+			want:        FileReplayResult{Records: 4, Processed: 4},
+			wantStored:  map[string][]float64{"web": {10, 20, 30, 40}},
+			wantAtLeast: 80 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			start := time.Now()
+			got, err := replayFile(context.Background(), slog.Default(), strings.NewReader(tt.input), tt.pace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed < tt.wantAtLeast {
+				t.Errorf("took %v, want at least %v", elapsed, tt.wantAtLeast)
+			}
+			if got != tt.want {
+				t.Errorf("result = %+v, want %+v", got, tt.want)
+			}
+			for stream, want := range tt.wantStored {
+				if got := rpsOf(appState.buffer.window(stream)); !equalFloats(got, want) {
+					t.Errorf("%s rps = %v, want %v", stream, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayFileCancelled(t *testing.T) {
+	newTestAppState(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	input := `{"timestamp":"2023-12-25T00:00:00Z","rps":10}` + "\n" + `{"timestamp":"2023-12-25T01:00:00Z","rps":20}` + "\n"
+	got, err := replayFile(ctx, slog.Default(), strings.NewReader(input), replayPaceOriginal)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got.Processed != 1 {
+		t.Errorf("processed = %d, want 1", got.Processed)
+	}
+}
+
+func TestParseReplayPace(t *testing.T) {
+	for _, s := range []string{"full", "original"} {
+		if got, err := parseReplayPace(s); err != nil || got != s {
+			t.Errorf("parseReplayPace(%q) = %q, %v", s, got, err)
+		}
+	}
+	if _, err := parseReplayPace("fast"); err == nil {
+		t.Error("parseReplayPace(\"fast\") succeeded")
+	}
+}
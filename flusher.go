@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// writeBehind batches sample writes (FLUSH_INTERVAL, FLUSH_BATCH_SIZE).
+// Samples go into the in-memory buffer as pending, where detection sees
+// them at once, and are written to Redis by runFlusher every interval or
+// as soon as batchSize samples are waiting, whichever comes first. A crash
+// loses at most that many samples; a clean shutdown flushes the rest.
+type writeBehind struct {
+	interval  time.Duration
+	batchSize int
+	// kick wakes runFlusher early once a batch is full.
+	kick chan struct{}
+}
+
+func newWriteBehind(interval time.Duration, batchSize int) *writeBehind {
+	return &writeBehind{interval: interval, batchSize: batchSize, kick: make(chan struct{}, 1)}
+}
+
+// add buffers m for the next flush.
+func (wb *writeBehind) add(stream string, m Metric) {
+	appState.buffer.addPending(stream, m)
+	if appState.buffer.pendingCount() >= wb.batchSize {
+		select {
+		case wb.kick <- struct{}{}:
+		default: // a flush is already due
+		}
+	}
+}
+
+// runFlusher writes the pending samples every interval, or early when a
+// batch fills up, until ctx is done. Failed streams stay pending for the
+// next round.
+func runFlusher(ctx context.Context, wb *writeBehind) {
+	ticker := time.NewTicker(wb.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wb.kick:
+		}
+		if appState.buffer.pendingCount() > 0 {
+			flushPendingSamples(ctx)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestWriteBehind(t *testing.T) {
+	tests := []struct {
+		name      string
+		interval  time.Duration
+		batchSize int
+		samples   int
+		shutdown  bool
+		// wantStored is how many samples reach Redis within the wait.
+		wantStored  int
+		wantFlushes float64
+	}{
+		{name: "batch full", interval: time.Hour, batchSize: 3, samples: 3, wantStored: 3, wantFlushes: 1},
+		{name: "interval", interval: 20 * time.Millisecond, batchSize: 5, samples: 2, wantStored: 2, wantFlushes: 1},
+		{name: "waiting", interval: time.Hour, batchSize: 3, samples: 2, wantStored: 0},
+		{name: "shutdown", interval: time.Hour, batchSize: 5, samples: 2, shutdown: true, wantStored: 2, wantFlushes: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			appState.writeBehind = newWriteBehind(tt.interval, tt.batchSize)
+			go runFlusher(t.Context(), appState.writeBehind)
+
+			for i := 0; i < tt.samples; i++ {
+				got, err := processMetric(t.Context(), slog.Default(), "web", Metric{RPS: float64(i)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Detection sees the sample before it is written.
+				if got.Samples != i+1 {
+					t.Fatalf("sample %d: window of %d samples, want %d", i, got.Samples, i+1)
+				}
+			}
+			if tt.shutdown {
+				flushOnShutdown(time.Second)
+			}
+
+			stored := func() int { l, _ := mr.List("metrics:web"); return len(l) }
+			// The counters are updated after the write, so wait on them.
+			deadline := time.Now().Add(time.Second)
+			for counterValue(appState.flushedSamplesCounter) < float64(tt.wantStored) && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if tt.wantStored == 0 {
+				time.Sleep(50 * time.Millisecond)
+			}
+			if n := stored(); n != tt.wantStored {
+				t.Errorf("stored %d samples, want %d", n, tt.wantStored)
+			}
+			if n := appState.buffer.pendingCount(); n != tt.samples-tt.wantStored {
+				t.Errorf("pending = %d, want %d", n, tt.samples-tt.wantStored)
+			}
+			if got := counterValue(appState.flushCounter.WithLabelValues("ok")); got != tt.wantFlushes {
+				t.Errorf("flushes = %v, want %v", got, tt.wantFlushes)
+			}
+			if got := counterValue(appState.flushedSamplesCounter); got != float64(tt.wantStored) {
+				t.Errorf("flushed samples = %v, want %d", got, tt.wantStored)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const defaultGapMultiplier = 3.0
+
+// parseExpectedIntervals parses an EXPECTED_INTERVALS spec such as
+// "default=1s,web=10s": how often each stream is supposed to report.
+// Streams not listed are not checked for gaps.
+func parseExpectedIntervals(spec string) (map[string]time.Duration, error) {
+	intervals := make(map[string]time.Duration)
+	if strings.TrimSpace(spec) == "" {
+		return intervals, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		stream, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		stream = strings.TrimSpace(stream)
+		if !ok || !streamNamePattern.MatchString(stream) {
+			return nil, fmt.Errorf("malformed entry %q, want stream=interval", entry)
+		}
+		if _, dup := intervals[stream]; dup {
+			return nil, fmt.Errorf("stream %q listed more than once", stream)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("interval for %q must be a positive duration, got %q", stream, value)
+		}
+		intervals[stream] = d
+	}
+	return intervals, nil
+}
+
+// checkGap compares m, the newest sample of window, with the latest earlier
+// sample and returns the gap between them if it exceeds GAP_MULTIPLIER
+// times the stream's expected interval. Samples that arrive out of order,
+// at or before an earlier timestamp, are never a gap.
+func checkGap(logger *slog.Logger, stream string, m Metric, window []Metric) (time.Duration, bool) {
+	expected, ok := appState.expectedIntervals[stream]
+	if !ok || len(window) < 2 {
+		return 0, false
+	}
+	var prev time.Time
+	for _, p := range window[:len(window)-1] {
+		if p.Timestamp.After(prev) {
+			prev = p.Timestamp
+		}
+	}
+	gap := m.Timestamp.Sub(prev)
+	if gap <= time.Duration(appState.gapMultiplier*float64(expected)) {
+		return 0, false
+	}
+	appState.sampleGapCounter.WithLabelValues(stream).Inc()
+	logger.Warn("SAMPLE GAP DETECTED!", "gap", gap, "expected_interval", expected)
+	return gap, true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseExpectedIntervals(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{spec: "", want: map[string]time.Duration{}},
+		{spec: "default=1s, web = 10s", want: map[string]time.Duration{"default": time.Second, "web": 10 * time.Second}},
+		{spec: "web", wantErr: true},
+		{spec: "bad name=1s", wantErr: true},
+		{spec: "web=0s", wantErr: true},
+		{spec: "web=soon", wantErr: true},
+		{spec: "web=1s,web=2s", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseExpectedIntervals(tt.spec)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("parseExpectedIntervals(%q) = %v, %v; want %v, error %v", tt.spec, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckGap(t *testing.T) {
+	newTestAppState(t)
+	appState.expectedIntervals = map[string]time.Duration{"web": time.Second}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(secs ...float64) []Metric {
+		var window []Metric
+		for _, s := range secs {
+			window = append(window, Metric{Timestamp: base.Add(time.Duration(s * float64(time.Second)))})
+		}
+		return window
+	}
+
+	tests := []struct {
+		name    string
+		stream  string
+		window  []Metric
+		wantGap time.Duration
+	}{
+		{name: "on time", stream: "web", window: at(0, 1, 2)},
+		{name: "late but within multiple", stream: "web", window: at(0, 1, 4)},
+		{name: "gap", stream: "web", window: at(0, 1, 5), wantGap: 4 * time.Second},
+		{name: "gap from the latest earlier sample", stream: "web", window: at(3, 1, 8), wantGap: 5 * time.Second},
+		{name: "out of order", stream: "web", window: at(0, 10, 2)},
+		{name: "first sample", stream: "web", window: at(100)},
+		{name: "stream without interval", stream: "api", window: at(0, 100)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := counterValue(appState.sampleGapCounter.WithLabelValues(tt.stream))
+			gap, ok := checkGap(slog.Default(), tt.stream, tt.window[len(tt.window)-1], tt.window)
+			if gap != tt.wantGap || ok != (tt.wantGap > 0) {
+				t.Errorf("checkGap = %v, %v; want %v", gap, ok, tt.wantGap)
+			}
+			wantInc := 0.0
+			if tt.wantGap > 0 {
+				wantInc = 1
+			}
+			if got := counterValue(appState.sampleGapCounter.WithLabelValues(tt.stream)) - before; got != wantInc {
+				t.Errorf("gap counter rose by %v, want %v", got, wantInc)
+			}
+		})
+	}
+}
+
+func TestAnalyzeReportsGap(t *testing.T) {
+	newTestAppState(t)
+	appState.expectedIntervals = map[string]time.Duration{defaultStream: time.Second}
+	clock := appState.clock.(*fakeClock)
+	ctx := context.Background()
+
+	processMetric(ctx, slog.Default(), defaultStream, Metric{RPS: 1, Timestamp: clock.Now()})
+	clock.Advance(10 * time.Second)
+	result, err := processMetric(ctx, slog.Default(), defaultStream, Metric{RPS: 1, Timestamp: clock.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.GapSeconds == nil || *result.GapSeconds != 10 {
+		t.Errorf("gap_seconds = %v, want 10", result.GapSeconds)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// GaugeSnapshot is the compact view of the computed gauges sent on
+// /metrics/stream after each processed sample.
+type GaugeSnapshot struct {
+	Stream     string    `json:"stream"`
+	Timestamp  time.Time `json:"timestamp"`
+	RPS        float64   `json:"rps"`
+	CPU        float64   `json:"cpu"`
+	RollingAvg float64   `json:"rolling_avg"`
+	TrendSlope float64   `json:"trend_slope"`
+	Status     string    `json:"status"`
+}
+
+// publishGauges records snap as the latest snapshot and fans it out.
+func publishGauges(snap GaugeSnapshot) {
+	appState.lastGauges.Store(&snap)
+	appState.gaugeHub.publish(snap)
+}
+
+// handleGaugeStream is a server-sent events feed of gauge snapshots. The
+// latest snapshot is sent on connect; after that at most one is sent per
+// METRICS_STREAM_INTERVAL, always the newest, so slow browsers see the
+// current state rather than a backlog. HEAD gets the headers and returns
+// instead of holding the connection open.
+func handleGaugeStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Streaming unsupported")
+		return
+	}
+	updates, unsubscribe := appState.gaugeHub.subscribe(16)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	var latest *GaugeSnapshot
+	if snap := appState.lastGauges.Load(); snap != nil {
+		writeSSE(w, snap)
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(appState.gaugeStreamInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case snap := <-updates:
+			latest = &snap
+		case <-ticker.C:
+			if latest == nil {
+				continue
+			}
+			if err := writeSSE(w, latest); err != nil {
+				return
+			}
+			flusher.Flush()
+			latest = nil
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, v interface{}) error {
+	data, err := marshalJSON(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
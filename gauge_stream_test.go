@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHubFanOut(t *testing.T) {
+	h := newHub[int]()
+	a, unsubA := h.subscribe(1)
+	b, unsubB := h.subscribe(1)
+	defer unsubB()
+
+	h.publish(1)
+	h.publish(2) // buffers are full; dropped rather than blocking
+	if got := <-a; got != 1 {
+		t.Errorf("a got %d, want 1", got)
+	}
+	if got := <-b; got != 1 {
+		t.Errorf("b got %d, want 1", got)
+	}
+
+	unsubA()
+	unsubA() // idempotent
+	if _, ok := <-a; ok {
+		t.Error("channel still open after unsubscribe")
+	}
+	h.publish(3)
+	if got := <-b; got != 3 {
+		t.Errorf("b got %d, want 3", got)
+	}
+}
+
+func TestGaugeStreamThrottlesToLatest(t *testing.T) {
+	newTestAppState(t)
+	appState.gaugeStreamInterval = 50 * time.Millisecond
+	publishGauges(GaugeSnapshot{Stream: "web", RPS: 1})
+
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/metrics/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	events := make(chan GaugeSnapshot)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				var snap GaugeSnapshot
+				json.Unmarshal([]byte(data), &snap)
+				events <- snap
+			}
+		}
+		close(events)
+	}()
+
+	if first := <-events; first.RPS != 1 {
+		t.Fatalf("initial snapshot rps = %v, want 1", first.RPS)
+	}
+
+	// A burst within one interval collapses to its newest snapshot.
+	time.Sleep(10 * time.Millisecond)
+	for rps := 2.0; rps <= 5; rps++ {
+		publishGauges(GaugeSnapshot{Stream: "web", RPS: rps, Status: statusNormal})
+	}
+	select {
+	case snap := <-events:
+		if snap.RPS != 5 || snap.Status != statusNormal {
+			t.Errorf("throttled snapshot = %+v, want rps 5", snap)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no snapshot after burst")
+	}
+	select {
+	case snap := <-events:
+		t.Errorf("unexpected extra snapshot %+v", snap)
+	case <-time.After(120 * time.Millisecond):
+	}
+}
+
+func TestAnalyzePublishesGaugeSnapshot(t *testing.T) {
+	newTestAppState(t)
+	updates, unsubscribe := appState.gaugeHub.subscribe(4)
+	defer unsubscribe()
+
+	window := []Metric{{RPS: 10, CPU: 1}, {RPS: 20, CPU: 2}}
+	analyzeWindow(t.Context(), slog.Default(), "web", window[1], window)
+	snap := <-updates
+	if snap.Stream != "web" || snap.RPS != 20 || snap.RollingAvg != 15 || snap.Status != statusNormal {
+		t.Errorf("snapshot = %+v, want web rps 20 avg 15 normal", snap)
+	}
+}
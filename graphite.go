@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// graphiteStreamNode is the pattern node that captures the stream name.
+const graphiteStreamNode = "{stream}"
+
+// graphitePattern maps Graphite paths matching nodes to the CPU or RPS of
+// a stream. A node is a literal, "*" for any one node, or "{stream}" for
+// the node that names the stream; without one, points go to the default
+// stream.
+type graphitePattern struct {
+	cpu   bool
+	nodes []string
+}
+
+// parseGraphiteMapping parses a GRAPHITE_MAPPING spec such as
+// "cpu=collectd.{stream}.cpu.percent-active,rps=stats.{stream}.requests".
+// A field may be mapped by several patterns; the first that matches a
+// path is used.
+func parseGraphiteMapping(spec string) ([]graphitePattern, error) {
+	var patterns []graphitePattern
+	for _, entry := range strings.Split(spec, ",") {
+		field, pattern, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		field, pattern = strings.TrimSpace(field), strings.TrimSpace(pattern)
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("malformed entry %q, want cpu=pattern or rps=pattern", entry)
+		}
+		if field != "cpu" && field != "rps" {
+			return nil, fmt.Errorf("unknown field %q, want cpu or rps", field)
+		}
+		nodes := strings.Split(pattern, ".")
+		captures := 0
+		for _, node := range nodes {
+			if node == "" {
+				return nil, fmt.Errorf("empty node in pattern %q", pattern)
+			}
+			if node == graphiteStreamNode {
+				captures++
+			}
+		}
+		if captures > 1 {
+			return nil, fmt.Errorf("pattern %q names the stream more than once", pattern)
+		}
+		patterns = append(patterns, graphitePattern{cpu: field == "cpu", nodes: nodes})
+	}
+	return patterns, nil
+}
+
+// match reports whether path matches p, and the stream it names.
+func (p graphitePattern) match(path string) (string, bool) {
+	nodes := strings.Split(path, ".")
+	if len(nodes) != len(p.nodes) {
+		return "", false
+	}
+	stream := defaultStream
+	for i, node := range p.nodes {
+		switch node {
+		case "*":
+		case graphiteStreamNode:
+			stream = nodes[i]
+		default:
+			if nodes[i] != node {
+				return "", false
+			}
+		}
+	}
+	return stream, true
+}
+
+// parseGraphiteLine parses "path value timestamp". A timestamp of -1, as
+// some emitters send, is the arrival time, returned as the zero time.
+func parseGraphiteLine(line string) (string, float64, time.Time, error) {
+	var ts time.Time
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return "", 0, ts, fmt.Errorf("want path, value and timestamp in %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return "", 0, ts, fmt.Errorf("value %q is not a finite number", fields[1])
+	}
+	secs, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) || (secs < 0 && secs != -1) {
+		return "", 0, ts, fmt.Errorf("invalid timestamp %q", fields[2])
+	}
+	if secs != -1 {
+		ts = time.UnixMilli(int64(secs * 1000)).UTC()
+	}
+	return fields[0], value, ts, nil
+}
+
+// graphiteListener takes the Graphite plaintext protocol on TCP
+// (GRAPHITE_ADDR) from emitters such as collectd that cannot be changed,
+// mapping their paths to streams with GRAPHITE_MAPPING. Points are joined
+// per stream and timestamp by a pointJoiner and run through the pipeline
+// every flush interval (GRAPHITE_FLUSH_INTERVAL), so a sample's CPU and RPS
+// may come on separate lines. With both fields mapped, a sample still
+// missing one at a flush is held for one more interval, in case its lines
+// came on either side of the flush. Paths that match no pattern are
+// ignored.
+type graphiteListener struct {
+	ln       net.Listener
+	patterns []graphitePattern
+	interval time.Duration
+	// lines counts received lines by result: "accepted", "ignored" for
+	// unmapped paths, or "invalid".
+	lines *prometheus.CounterVec
+	// hold keeps incomplete samples from one flush to the next; needCPU
+	// and needRPS say which fields patterns map.
+	hold             *pointHold
+	needCPU, needRPS bool
+
+	mu     sync.Mutex
+	joiner *pointJoiner
+}
+
+func newGraphiteListener(addr string, patterns []graphitePattern, interval time.Duration, lines *prometheus.CounterVec) (*graphiteListener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	g := &graphiteListener{ln: ln, patterns: patterns, interval: interval, lines: lines, hold: newPointHold(interval)}
+	for _, p := range patterns {
+		g.needCPU = g.needCPU || p.cpu
+		g.needRPS = g.needRPS || !p.cpu
+	}
+	g.joiner = g.newJoiner()
+	return g, nil
+}
+
+func (g *graphiteListener) newJoiner() *pointJoiner {
+	return newHeldPointJoiner(g.hold, g.needCPU, g.needRPS)
+}
+
+// handleLine parses one line and adds its point, returning the result it
+// was counted under.
+func (g *graphiteListener) handleLine(line string) string {
+	path, value, ts, err := parseGraphiteLine(line)
+	if err != nil {
+		logSampled(slog.Default(), "Invalid Graphite line", "error", err)
+		return "invalid"
+	}
+	for _, p := range g.patterns {
+		stream, ok := p.match(path)
+		if !ok {
+			continue
+		}
+		if !streamNamePattern.MatchString(stream) {
+			logSampled(slog.Default(), "Invalid Graphite stream", "path", path, "stream", stream)
+			return "invalid"
+		}
+		g.mu.Lock()
+		g.joiner.add(stream, ts, p.cpu, value)
+		g.mu.Unlock()
+		return "accepted"
+	}
+	return "ignored"
+}
+
+// flush runs the samples completed so far through the pipeline.
+func (g *graphiteListener) flush(ctx context.Context) {
+	g.mu.Lock()
+	j := g.joiner
+	g.joiner = g.newJoiner()
+	g.mu.Unlock()
+	if rejected, reason := j.process(ctx, slog.Default().With("transport", "graphite")); rejected > 0 {
+		slog.Warn("Graphite points rejected", "points", rejected, "reason", reason)
+	}
+}
+
+// serve reads lines from conn until it is closed.
+func (g *graphiteListener) serve(conn net.Conn) {
+	defer conn.Close()
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		g.lines.WithLabelValues(g.handleLine(line)).Inc()
+	}
+	if err := sc.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Warn("Graphite connection error", "remote", conn.RemoteAddr(), "error", err)
+	}
+}
+
+// run accepts connections and flushes every interval until ctx is
+// cancelled, then closes every connection and flushes what it has left.
+// Samples still missing a field at that point are not stored.
+func (g *graphiteListener) run(ctx context.Context) {
+	stopClose := context.AfterFunc(ctx, func() { g.ln.Close() })
+	defer stopClose()
+	go func() {
+		ticker := time.NewTicker(g.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				g.flush(ctx)
+			}
+		}
+	}()
+
+	var conns sync.WaitGroup
+	for {
+		conn, err := g.ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			break
+		}
+		if err != nil {
+			slog.Warn("Graphite accept error", "error", err)
+			continue
+		}
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		conns.Go(func() {
+			defer stop()
+			g.serve(conn)
+		})
+	}
+	conns.Wait()
+	// The pipeline's own context is gone by now.
+	g.flush(context.Background())
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGraphiteMapping(t *testing.T) {
+	patterns, err := parseGraphiteMapping("cpu=collectd.{stream}.cpu.*.percent-active, rps=stats.{stream}.requests,rps=lb.total.rps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path       string
+		wantStream string
+		wantCPU    bool
+		wantOK     bool
+	}{
+		{path: "collectd.web.cpu.0.percent-active", wantStream: "web", wantCPU: true, wantOK: true},
+		{path: "stats.api.requests", wantStream: "api", wantOK: true},
+		{path: "lb.total.rps", wantStream: defaultStream, wantOK: true},
+		{path: "stats.api.requests.p99"},
+		{path: "collectd.web.memory.used"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			for _, p := range patterns {
+				if stream, ok := p.match(tt.path); ok {
+					if !tt.wantOK || stream != tt.wantStream || p.cpu != tt.wantCPU {
+						t.Errorf("matched stream %q cpu %v, want %q cpu %v (ok %v)", stream, p.cpu, tt.wantStream, tt.wantCPU, tt.wantOK)
+					}
+					return
+				}
+			}
+			if tt.wantOK {
+				t.Error("no pattern matched")
+			}
+		})
+	}
+
+	for _, spec := range []string{"cpu", "mem=a.b", "rps=a..b", "rps={stream}.{stream}"} {
+		if _, err := parseGraphiteMapping(spec); err == nil {
+			t.Errorf("parseGraphiteMapping(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestParseGraphiteLine(t *testing.T) {
+	tests := []struct {
+		line      string
+		wantValue float64
+		wantTS    time.Time
+		wantErr   bool
+	}{
+		{line: "a.b 42.5 1703462400", wantValue: 42.5, wantTS: time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)},
+		{line: "a.b 1 -1", wantValue: 1},
+		{line: "a.b 1", wantErr: true},
+		{line: "a.b nan 1703462400", wantErr: true},
+		{line: "a.b 1 yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			_, value, ts, err := parseGraphiteLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if value != tt.wantValue || !ts.Equal(tt.wantTS) {
+				t.Errorf("got %v at %v, want %v at %v", value, ts, tt.wantValue, tt.wantTS)
+			}
+		})
+	}
+}
+
+func TestGraphiteListener(t *testing.T) {
+	newTestAppState(t)
+	patterns, err := parseGraphiteMapping("cpu=servers.{stream}.cpu,rps=servers.{stream}.rps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "graphite_lines"}, []string{"result"})
+	g, err := newGraphiteListener("127.0.0.1:0", patterns, time.Hour, lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.run(ctx)
+	}()
+
+	conn, err := net.Dial("tcp", g.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	payload := "servers.web.rps 20 1703462401\n" +
+		"servers.web.cpu 40 1703462400\n" +
+		"servers.web.rps 10 1703462400\n" +
+		"servers.web.memory 1 1703462400\n" +
+		"servers.web.rps oops 1703462400\n"
+	if _, err := conn.Write([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for counterValue(lines.WithLabelValues("invalid")) < 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Shutting down closes the connection and flushes what is left.
+	cancel()
+	<-done
+
+	for result, want := range map[string]float64{"accepted": 3, "ignored": 1, "invalid": 1} {
+		if got := counterValue(lines.WithLabelValues(result)); got != want {
+			t.Errorf("%s lines = %v, want %v", result, got, want)
+		}
+	}
+	// The sample at ...401 never got its CPU line.
+	window := appState.buffer.window("web")
+	if got := rpsOf(window); !equalFloats(got, []float64{10}) {
+		t.Errorf("rps = %v, want [10]", got)
+	}
+	if len(window) > 0 && window[0].CPU != 40 {
+		t.Errorf("cpu = %v, want 40", window[0].CPU)
+	}
+}
+
+func TestGraphiteJoinsAcrossFlushes(t *testing.T) {
+	newTestAppState(t)
+	patterns, err := parseGraphiteMapping("cpu=servers.{stream}.cpu,rps=servers.{stream}.rps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "graphite_lines"}, []string{"result"})
+	g, err := newGraphiteListener("127.0.0.1:0", patterns, time.Minute, lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.ln.Close()
+	clock := appState.clock.(*fakeClock)
+
+	g.handleLine("servers.web.cpu 40 1703462400")
+	g.handleLine("servers.web.cpu 50 1703462460")
+	g.flush(t.Context())
+	if window := appState.buffer.window("web"); len(window) != 0 {
+		t.Fatalf("half-filled samples stored: %+v", window)
+	}
+
+	// The RPS line of the first sample comes after the flush.
+	clock.Advance(time.Minute)
+	g.handleLine("servers.web.rps 10 1703462400")
+	g.flush(t.Context())
+	window := appState.buffer.window("web")
+	if len(window) != 1 || window[0].RPS != 10 || window[0].CPU != 40 {
+		t.Errorf("window = %+v, want the joined sample", window)
+	}
+	// The second sample waited its interval and was dropped.
+	if n := len(g.hold.samples); n != 0 {
+		t.Errorf("%d samples still held", n)
+	}
+}
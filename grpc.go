@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"time"
+
+	"go-stream-processing/ingestpb"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	// OTLP exporters compress with gzip by default.
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+)
+
+// ingestServer implements the gRPC Ingest service (proto/ingest.proto) on
+// top of the same pipeline as /analyze.
+type ingestServer struct {
+	ingestpb.UnimplementedIngestServer
+}
+
+// newGRPCServer returns a gRPC server with the Ingest and OTLP metrics
+// services registered, over TLS when t is enabled.
+func newGRPCServer(t tlsSettings) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if t.enabled() {
+		cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf := t.config.Clone()
+		conf.Certificates = []tls.Certificate{cert}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(conf)))
+	}
+	srv := grpc.NewServer(opts...)
+	ingestpb.RegisterIngestServer(srv, ingestServer{})
+	colmetricspb.RegisterMetricsServiceServer(srv, otlpMetricsServer{})
+	return srv, nil
+}
+
+// runGRPCServer serves srv on lis until ctx is cancelled and then stops it
+// gracefully, cutting off RPCs still running after shutdownTimeout.
+func runGRPCServer(ctx context.Context, srv *grpc.Server, lis net.Listener, shutdownTimeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(lis) }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down gRPC server on %s", lis.Addr())
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		srv.Stop()
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// acceptMetric validates pm and records it with recordIntake. The returned
+// error is meant for the client.
+func acceptMetric(ctx context.Context, logger *slog.Logger, pm *ingestpb.Metric) (string, Metric, error) {
+	stream := pm.GetStream()
+	if stream == "" {
+		stream = defaultStream
+	}
+	if !streamNamePattern.MatchString(stream) {
+		return "", Metric{}, status.Errorf(codes.InvalidArgument, "invalid stream name %q", stream)
+	}
+	logger = logger.With("stream", stream)
+	m, err := stampMetric(logger, metricFromProto(pm))
+	if err != nil {
+		return "", Metric{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := recordIntake(ctx, logger, stream, m); err != nil {
+		return "", Metric{}, status.Error(codes.Unavailable, "error incrementing counter")
+	}
+	return stream, m, nil
+}
+
+func (ingestServer) SubmitMetric(ctx context.Context, pm *ingestpb.Metric) (*ingestpb.SubmitMetricResponse, error) {
+	logger := slog.Default().With("transport", "grpc")
+	stream, m, err := acceptMetric(ctx, logger, pm)
+	if err != nil {
+		return nil, err
+	}
+	result, err := processMetric(ctx, logger.With("stream", stream), stream, m)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, "error processing metric")
+	}
+	return &ingestpb.SubmitMetricResponse{Result: analysisResultProto(result)}, nil
+}
+
+// StreamMetrics hands each metric to the stream workers as async /analyze
+// does. A metric that fails validation or finds the queue full is counted
+// as rejected rather than ending the stream, so one bad sample does not
+// cost the collector its connection.
+func (ingestServer) StreamMetrics(stream grpc.ClientStreamingServer[ingestpb.Metric, ingestpb.StreamMetricsResponse]) error {
+	logger := slog.Default().With("transport", "grpc")
+	var resp ingestpb.StreamMetricsResponse
+	for {
+		pm, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&resp)
+		}
+		if err != nil {
+			return err
+		}
+		name, m, err := acceptMetric(stream.Context(), logger, pm)
+		if err != nil {
+			resp.Rejected++
+			continue
+		}
+		if !appState.workers.submit(logger.With("stream", name), name, m) {
+			logger.Warn("Processing queue full, metric rejected", "stream", name)
+			resp.Rejected++
+			continue
+		}
+		resp.Accepted++
+	}
+}
+
+func analysisResultProto(r AnalysisResult) *ingestpb.AnalysisResult {
+	return &ingestpb.AnalysisResult{
+		Status:      r.Status,
+		Samples:     int32(r.Samples),
+		MinSamples:  int32(r.MinSamples),
+		RollingAvg:  r.RollingAvg,
+		WeightedAvg: r.WeightedAvg,
+		Score:       r.Score,
+		Severity:    r.Severity,
+		Direction:   string(r.Direction),
+		Breaches:    r.Breaches,
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go-stream-processing/ingestpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestIngestClient serves the Ingest service over an in-memory listener.
+func newTestIngestClient(t *testing.T) ingestpb.IngestClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv, err := newGRPCServer(tlsSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- runGRPCServer(ctx, srv, lis, time.Second) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("runGRPCServer: %v", err)
+		}
+	})
+	return ingestpb.NewIngestClient(conn)
+}
+
+func TestGRPCSubmitMetric(t *testing.T) {
+	tests := []struct {
+		name       string
+		metric     *ingestpb.Metric
+		wantCode   codes.Code
+		wantStream string
+	}{
+		{name: "valid", metric: &ingestpb.Metric{Stream: "web", Rps: 10}, wantCode: codes.OK, wantStream: "web"},
+		{name: "default stream", metric: &ingestpb.Metric{Cpu: 50}, wantCode: codes.OK, wantStream: defaultStream},
+		{name: "invalid stream", metric: &ingestpb.Metric{Stream: "bad name"}, wantCode: codes.InvalidArgument},
+		{name: "bad unit", metric: &ingestpb.Metric{Stream: "web", Rps: 1, RpsUnit: "furlongs"}, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			client := newTestIngestClient(t)
+			resp, err := client.SubmitMetric(t.Context(), tt.metric)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("code = %v, want %v (%v)", got, tt.wantCode, err)
+			}
+			if tt.wantCode != codes.OK {
+				if mr.Exists("request_count") {
+					t.Error("rejected metric was counted")
+				}
+				return
+			}
+			if resp.GetResult().GetSamples() != 1 {
+				t.Errorf("result over %d samples, want 1", resp.GetResult().GetSamples())
+			}
+			if stored, _ := mr.List(appState.metricsKey(tt.wantStream)); len(stored) != 1 {
+				t.Errorf("stored %d samples on %q, want 1", len(stored), tt.wantStream)
+			}
+		})
+	}
+}
+
+func TestGRPCStreamMetrics(t *testing.T) {
+	newTestAppState(t)
+	client := newTestIngestClient(t)
+	stream, err := client.StreamMetrics(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*ingestpb.Metric{
+		{Stream: "web", Rps: 1},
+		{Stream: "bad name", Rps: 2},
+		{Stream: "web", Rps: 3, RpsUnit: "furlongs"},
+		{Stream: "web", Rps: 4},
+	} {
+		if err := stream.Send(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetAccepted() != 2 || resp.GetRejected() != 2 {
+		t.Errorf("accepted %d, rejected %d; want 2 and 2", resp.GetAccepted(), resp.GetRejected())
+	}
+}
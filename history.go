@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// handleHistory returns the newest raw samples of a stream, oldest first.
+// limit defaults to the largest window and may reach back as far as
+// RAW_RETENTION.
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	stream, err := streamFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	limit, err := limitFromRequest(r, appState.maxWindow())
+	if err != nil || limit > appState.rawRetention {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("limit must be between 1 and %d", appState.rawRetention))
+		return
+	}
+
+	ctx := r.Context()
+	items, err := recentSamples(ctx, stream, limit)
+	if err != nil {
+		loggerFrom(ctx).Error("Redis LRANGE error", "stream", stream, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Error reading history")
+		return
+	}
+
+	samples := decodeWindow(items)
+
+	writeBody(w, r, http.StatusOK, map[string]interface{}{
+		"stream":  stream,
+		"samples": samples,
+	})
+}
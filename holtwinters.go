@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	defaultHoltWintersThreshold = 3.0
+	defaultHoltWintersAlpha     = 0.3
+	defaultHoltWintersBeta      = 0.05
+	defaultHoltWintersGamma     = 0.3
+)
+
+func init() {
+	registerDetector("holtwinters", newHoltWintersDetector)
+}
+
+func newHoltWintersDetector(cfg DetectorConfig) (AnomalyDetector, error) {
+	threshold, err := thresholdOr(cfg.Threshold, defaultHoltWintersThreshold)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Season < 2 {
+		return nil, fmt.Errorf("holtwinters needs a season of at least 2 samples, got %d", cfg.Season)
+	}
+	d := &HoltWintersDetector{Season: cfg.Season, Threshold: threshold}
+	for _, p := range []struct {
+		name  string
+		value float64
+		def   float64
+		dst   *float64
+	}{
+		{"alpha", cfg.Alpha, defaultHoltWintersAlpha, &d.Alpha},
+		{"beta", cfg.Beta, defaultHoltWintersBeta, &d.Beta},
+		{"gamma", cfg.Gamma, defaultHoltWintersGamma, &d.Gamma},
+	} {
+		if p.value == 0 {
+			p.value = p.def
+		}
+		if p.value < 0 || p.value > 1 {
+			return nil, fmt.Errorf("%s must be in (0, 1], got %v", p.name, p.value)
+		}
+		*p.dst = p.value
+	}
+	return d, nil
+}
+
+// HoltWintersDetector forecasts the current value with additive triple
+// exponential smoothing, a level, a trend and one seasonal offset per
+// position in a Season samples long cycle, and flags values more than
+// Threshold standard deviations of the one-step forecast errors away from
+// the forecast. A daily rush that the window mean treats as an anomaly
+// every morning is part of the forecast here.
+//
+// Alpha, Beta and Gamma are the smoothing factors of the level, trend and
+// seasonal offsets. The model is fitted over the window on every call, so
+// the window has to span at least two seasons, the first of which only
+// initialises it; with a shorter window nothing is flagged.
+type HoltWintersDetector struct {
+	Season    int
+	Alpha     float64
+	Beta      float64
+	Gamma     float64
+	Threshold float64
+}
+
+func (d *HoltWintersDetector) Name() string { return "holtwinters" }
+
+// Forecast fits the model to history and returns its forecast for the
+// next value, with the standard deviation of the one-step forecast errors
+// over history. ok is false when history spans fewer than two seasons.
+func (d *HoltWintersDetector) Forecast(history []float64) (forecast, stdDev float64, ok bool) {
+	m := d.Season
+	if len(history) < 2*m {
+		return 0, 0, false
+	}
+	first, second := calculateAverage(history[:m]), calculateAverage(history[m:2*m])
+	level, trend := first, (second-first)/float64(m)
+	seasonal := make([]float64, m)
+	for i := range seasonal {
+		seasonal[i] = history[i] - first
+	}
+
+	var sumSquares float64
+	for t := m; t < len(history); t++ {
+		x, s := history[t], seasonal[t%m]
+		diff := x - (level + trend + s)
+		sumSquares += diff * diff
+		prevLevel := level
+		level = d.Alpha*(x-s) + (1-d.Alpha)*(level+trend)
+		trend = d.Beta*(level-prevLevel) + (1-d.Beta)*trend
+		seasonal[t%m] = d.Gamma*(x-level) + (1-d.Gamma)*s
+	}
+	stdDev = math.Sqrt(sumSquares / float64(len(history)-m))
+	return level + trend + seasonal[len(history)%m], stdDev, true
+}
+
+func (d *HoltWintersDetector) Detect(window []float64, current float64) (float64, bool) {
+	if len(window) < 1 {
+		return 0, false
+	}
+	forecast, stdDev, ok := d.Forecast(window[:len(window)-1])
+	if !ok || stdDev == 0 {
+		return 0, false
+	}
+	score := (current - forecast) / stdDev
+	return score, math.Abs(score) > d.Threshold
+}
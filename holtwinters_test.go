@@ -0,0 +1,83 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// dailyRush returns seasons cycles of 7 quiet samples and a rush of 60,
+// with a little noise so the forecast errors are not all zero.
+func dailyRush(seasons int) []float64 {
+	var values []float64
+	for s := 0; s < seasons; s++ {
+		for i := 0; i < 7; i++ {
+			values = append(values, 10+float64((s+i)%3)-1)
+		}
+		values = append(values, 60)
+	}
+	return values
+}
+
+func TestHoltWintersDetector(t *testing.T) {
+	d, err := newDetector(DetectorConfig{Type: "holtwinters", Season: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := dailyRush(4)
+	tests := []struct {
+		name          string
+		window        []float64
+		wantAnomalous bool
+	}{
+		{name: "expected rush", window: slices.Concat(history, dailyRush(1))},
+		{name: "quiet slot", window: slices.Concat(history, []float64{10})},
+		{name: "rush out of season", window: slices.Concat(history, []float64{60}), wantAnomalous: true},
+		{name: "missing rush", window: slices.Concat(history, dailyRush(1)[:7], []float64{10}), wantAnomalous: true},
+		{name: "under two seasons", window: slices.Concat(dailyRush(1), []float64{10, 10, 10, 10, 10, 10, 10, 500})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := tt.window[len(tt.window)-1]
+			if score, anomalous := d.Detect(tt.window, current); anomalous != tt.wantAnomalous {
+				t.Errorf("Detect(%v) = (%v, %v), want anomalous %v", current, score, anomalous, tt.wantAnomalous)
+			}
+		})
+	}
+
+	// The rush a z-score over the same window flags is the forecast here.
+	window := slices.Concat(history, dailyRush(1))
+	if _, anomalous := (&ZScoreDetector{Threshold: defaultZScoreThreshold}).Detect(window, 60); !anomalous {
+		t.Errorf("z-score did not flag the rush, so the comparison proves nothing")
+	}
+}
+
+func TestNewHoltWintersDetector(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     DetectorConfig
+		want    HoltWintersDetector
+		wantErr bool
+	}{
+		{name: "defaults", cfg: DetectorConfig{Season: 24},
+			want: HoltWintersDetector{Season: 24, Alpha: defaultHoltWintersAlpha, Beta: defaultHoltWintersBeta, Gamma: defaultHoltWintersGamma, Threshold: defaultHoltWintersThreshold}},
+		{name: "tuned", cfg: DetectorConfig{Season: 7, Alpha: 0.5, Beta: 0.1, Gamma: 0.2, Threshold: 4},
+			want: HoltWintersDetector{Season: 7, Alpha: 0.5, Beta: 0.1, Gamma: 0.2, Threshold: 4}},
+		{name: "no season", cfg: DetectorConfig{}, wantErr: true},
+		{name: "season of one", cfg: DetectorConfig{Season: 1}, wantErr: true},
+		{name: "gamma above one", cfg: DetectorConfig{Season: 7, Gamma: 1.5}, wantErr: true},
+		{name: "negative beta", cfg: DetectorConfig{Season: 7, Beta: -0.1}, wantErr: true},
+		{name: "negative threshold", cfg: DetectorConfig{Season: 7, Threshold: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Type = "holtwinters"
+			d, err := newDetector(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *d.(*HoltWintersDetector) != tt.want {
+				t.Errorf("got %+v, want %+v", *d.(*HoltWintersDetector), tt.want)
+			}
+		})
+	}
+}
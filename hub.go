@@ -0,0 +1,45 @@
+package main
+
+import "sync"
+
+// hub fans values out to any number of subscribers. Publishing never
+// blocks: a subscriber whose buffer is full misses the value, so one slow
+// client cannot stall the pipeline.
+type hub[T any] struct {
+	mu   sync.Mutex
+	subs map[chan T]struct{}
+}
+
+func newHub[T any]() *hub[T] {
+	return &hub[T]{subs: make(map[chan T]struct{})}
+}
+
+// subscribe returns a channel receiving published values and a function
+// that unsubscribes and closes it.
+func (h *hub[T]) subscribe(buffer int) (<-chan T, func()) {
+	ch := make(chan T, buffer)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, ch)
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (h *hub[T]) publish(v T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- v:
+		default:
+		}
+	}
+}
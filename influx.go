@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxInfluxLineBytes bounds one line of an InfluxDB line protocol body.
+const maxInfluxLineBytes = 1 << 20
+
+// maxInfluxBodyBytes bounds an InfluxDB line protocol body.
+const maxInfluxBodyBytes = 32 << 20
+
+// influxStreamTag is the tag that names a line's stream.
+const influxStreamTag = "stream"
+
+// influxPrecisions maps the precision parameter of the InfluxDB 1.x and 2.x
+// write APIs to the unit of line timestamps.
+var influxPrecisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// influxPoint is one parsed line of line protocol. Field values are kept
+// raw, since only cpu and rps are read.
+type influxPoint struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]string
+	// timestamp is the raw timestamp, empty if the line has none.
+	timestamp string
+}
+
+// handleInfluxWrite takes InfluxDB line protocol, as the InfluxDB 1.x
+// /write endpoint does, so Telegraf's influxdb output can send to the
+// service unchanged. The cpu and rps fields of each line are read as a
+// percentage and requests per second; other fields, and lines with
+// neither, are ignored. The stream is the "stream" tag, else the
+// measurement. Lines are joined per stream and timestamp by a pointJoiner,
+// so cpu and rps may come on separate lines.
+//
+// Lines that cannot be used are answered with 400 once the rest are
+// processed, naming the first few by line number, as InfluxDB answers a
+// partial write.
+func handleInfluxWrite(w http.ResponseWriter, r *http.Request) {
+	precision, ok := influxPrecisions[r.URL.Query().Get("precision")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid precision %q, want ns, us, ms, s, m or h", r.URL.Query().Get("precision")))
+		return
+	}
+
+	j := newPointJoiner()
+	sc := bufio.NewScanner(http.MaxBytesReader(w, r.Body, maxInfluxBodyBytes))
+	sc.Buffer(make([]byte, 0, 64<<10), maxInfluxLineBytes)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := addInfluxLine(j, line, precision); err != nil {
+			j.reject(1, fmt.Sprintf("line %d: %v", n, err))
+		}
+	}
+	var tooLarge *http.MaxBytesError
+	switch err := sc.Err(); {
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, "too_large", "Request body too large")
+		return
+	case errors.Is(err, bufio.ErrTooLong):
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("line longer than %d bytes", maxInfluxLineBytes))
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "invalid_request", "Error reading body")
+		return
+	}
+
+	rejected, reason := j.process(r.Context(), loggerFrom(r.Context()).With("transport", "influx"))
+	if rejected > 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("partial write: %d points rejected: %s", rejected, reason))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addInfluxLine parses line and adds its cpu and rps fields to j.
+func addInfluxLine(j *pointJoiner, line string, precision time.Duration) error {
+	p, err := parseInfluxLine(line)
+	if err != nil {
+		return err
+	}
+	stream := p.tags[influxStreamTag]
+	if stream == "" {
+		stream = p.measurement
+	}
+	var ts time.Time
+	if p.timestamp != "" {
+		n, err := strconv.ParseInt(p.timestamp, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp %q", p.timestamp)
+		}
+		ts = time.Unix(0, n*int64(precision)).UTC()
+	}
+
+	var values [2]float64
+	var found [2]bool
+	for i, name := range []string{"cpu", "rps"} {
+		raw, ok := p.fields[name]
+		if !ok {
+			continue
+		}
+		if values[i], err = parseInfluxNumber(raw); err != nil {
+			return fmt.Errorf("field %s: %w", name, err)
+		}
+		found[i] = true
+	}
+	if !found[0] && !found[1] {
+		return nil
+	}
+	if !streamNamePattern.MatchString(stream) {
+		return fmt.Errorf("invalid stream name %q", stream)
+	}
+	for i, cpu := range []bool{true, false} {
+		if found[i] {
+			j.add(stream, ts, cpu, values[i])
+		}
+	}
+	return nil
+}
+
+// parseInfluxLine parses "measurement[,tag=value...] field=value[,...]
+// [timestamp]", with the backslash escapes of the line protocol.
+func parseInfluxLine(line string) (influxPoint, error) {
+	var p influxPoint
+	sections := splitInflux(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return p, errors.New("want measurement, fields and an optional timestamp")
+	}
+	if len(sections) == 3 {
+		p.timestamp = sections[2]
+	}
+
+	key := splitInflux(sections[0], ',', false)
+	p.measurement = unescapeInflux(key[0])
+	if p.measurement == "" {
+		return p, errors.New("missing measurement")
+	}
+	p.tags = make(map[string]string, len(key)-1)
+	for _, tag := range key[1:] {
+		k, v, ok := cutInflux(tag)
+		if !ok || k == "" || v == "" {
+			return p, fmt.Errorf("invalid tag %q", tag)
+		}
+		p.tags[k] = v
+	}
+
+	p.fields = make(map[string]string)
+	for _, field := range splitInflux(sections[1], ',', true) {
+		k, v, ok := cutInflux(field)
+		if !ok || k == "" || v == "" {
+			return p, fmt.Errorf("invalid field %q", field)
+		}
+		p.fields[k] = v
+	}
+	return p, nil
+}
+
+// splitInflux splits s at each unescaped sep, outside double quotes if
+// quoted is set.
+func splitInflux(s string, sep byte, quoted bool) []string {
+	var parts []string
+	start, inQuotes := 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"' && quoted:
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cutInflux splits "key=value" at the first unescaped '=', unescaping the
+// key. The value is unescaped too unless it is a quoted string field.
+func cutInflux(s string) (string, string, bool) {
+	parts := splitInflux(s, '=', true)
+	if len(parts) < 2 {
+		return "", "", false
+	}
+	key := unescapeInflux(parts[0])
+	value := s[len(parts[0])+1:]
+	if !strings.HasPrefix(value, `"`) {
+		value = unescapeInflux(value)
+	}
+	return key, value, true
+}
+
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`, ="\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseInfluxNumber parses a float, integer ("i") or unsigned ("u") field
+// value. Strings and booleans are not numbers.
+func parseInfluxNumber(raw string) (float64, error) {
+	var v float64
+	var err error
+	switch {
+	case strings.HasSuffix(raw, "i"):
+		var n int64
+		n, err = strconv.ParseInt(strings.TrimSuffix(raw, "i"), 10, 64)
+		v = float64(n)
+	case strings.HasSuffix(raw, "u"):
+		var n uint64
+		n, err = strconv.ParseUint(strings.TrimSuffix(raw, "u"), 10, 64)
+		v = float64(n)
+	default:
+		v, err = strconv.ParseFloat(raw, 64)
+	}
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("value %s is not a finite number", raw)
+	}
+	return v, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseInfluxLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    influxPoint
+		wantErr bool
+	}{
+		{
+			line: "web,host=a cpu=42.5,rps=100i 1700000000000000000",
+			want: influxPoint{measurement: "web", tags: map[string]string{"host": "a"}, fields: map[string]string{"cpu": "42.5", "rps": "100i"}, timestamp: "1700000000000000000"},
+		},
+		{
+			line: `my\ app,stream=api,dc=eu\,west rps=5u,note="a b,c=d"`,
+			want: influxPoint{measurement: "my app", tags: map[string]string{"stream": "api", "dc": "eu,west"}, fields: map[string]string{"rps": "5u", "note": `"a b,c=d"`}},
+		},
+		{line: "web", wantErr: true},
+		{line: "web,host cpu=1", wantErr: true},
+		{line: "web cpu=", wantErr: true},
+		{line: "web cpu=1 2 3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := parseInfluxLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.measurement != tt.want.measurement || got.timestamp != tt.want.timestamp {
+				t.Errorf("got %q at %q, want %q at %q", got.measurement, got.timestamp, tt.want.measurement, tt.want.timestamp)
+			}
+			for _, m := range []struct{ got, want map[string]string }{{got.tags, tt.want.tags}, {got.fields, tt.want.fields}} {
+				if len(m.got) != len(m.want) {
+					t.Errorf("got %v, want %v", m.got, m.want)
+				}
+				for k, v := range m.want {
+					if m.got[k] != v {
+						t.Errorf("%s = %q, want %q", k, m.got[k], v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestInfluxWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		body     string
+		wantCode int
+		wantRPS  []float64
+		wantCPU  []float64
+		wantBody string
+	}{
+		{
+			name: "cpu and rps joined across lines",
+			body: "# telegraf\n" +
+				"web rps=20i 1700000001000000000\n" +
+				"web cpu=50 1700000000000000000\n" +
+				"web,host=a rps=10 1700000000000000000\n" +
+				"web cpu=60,rps=30u 1700000001000000000\n" +
+				"mem used=1 1700000000000000000\n",
+			wantCode: http.StatusNoContent,
+			wantRPS:  []float64{10, 50},
+			wantCPU:  []float64{50, 60},
+		},
+		{
+			name:     "stream tag and precision",
+			query:    "?precision=s",
+			body:     "http,stream=web rps=7 1700000000\n",
+			wantCode: http.StatusNoContent,
+			wantRPS:  []float64{7},
+		},
+		{
+			name:     "bad lines rejected, rest written",
+			body:     "web rps=5 1700000000000000000\nweb rps=\"high\"\nweb rps\n",
+			wantCode: http.StatusBadRequest,
+			wantRPS:  []float64{5},
+			wantBody: "2 points rejected: line 2",
+		},
+		{name: "invalid precision", query: "?precision=d", body: "web rps=1\n", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			r := httptest.NewRequest(http.MethodPost, "/write"+tt.query, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body %s, want %q", w.Body.String(), tt.wantBody)
+			}
+			window := appState.buffer.window("web")
+			if got := rpsOf(window); !equalFloats(got, tt.wantRPS) {
+				t.Errorf("rps = %v, want %v", got, tt.wantRPS)
+			}
+			for i, want := range tt.wantCPU {
+				if i < len(window) && window[i].CPU != want {
+					t.Errorf("sample %d cpu = %v, want %v", i, window[i].CPU, want)
+				}
+			}
+		})
+	}
+}
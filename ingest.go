@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go-stream-processing/ingestpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxMetricBodyBytes bounds the body of a single-metric /analyze request,
+// which is a few dozen bytes in any encoding.
+const maxMetricBodyBytes = 16 << 10
+
+// metricFields are the Metric JSON fields an ingestion payload can rename.
+var metricFields = []string{"timestamp", "cpu", "rps", "cpu_unit", "rps_unit"}
+
+// parseFieldMapping parses a FIELD_MAPPING spec such as
+// "cpu=cpu_pct,rps=req_per_sec", mapping Metric fields to the keys clients
+// send them under. Fields missing from the spec keep their own name.
+func parseFieldMapping(spec string) (map[string]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	mapping := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		field, key, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		field, key = strings.TrimSpace(field), strings.TrimSpace(key)
+		if !ok || field == "" || key == "" {
+			return nil, fmt.Errorf("malformed entry %q, want field=key", entry)
+		}
+		known := false
+		for _, f := range metricFields {
+			known = known || f == field
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown field %q, want one of %s", field, strings.Join(metricFields, ", "))
+		}
+		if _, dup := mapping[field]; dup {
+			return nil, fmt.Errorf("field %q mapped more than once", field)
+		}
+		mapping[field] = key
+	}
+	return mapping, nil
+}
+
+// decodeIngested decodes one metric from an ingestion payload, reading each
+// field from its mapped key. A field whose mapped key is absent falls back
+// to its own name, so clients can move to the new names gradually.
+func decodeIngested(raw json.RawMessage, mapping map[string]string) (Metric, error) {
+	var m Metric
+	if len(mapping) == 0 {
+		err := json.Unmarshal(raw, &m)
+		return m, err
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return m, err
+	}
+	for _, field := range metricFields {
+		value, ok := obj[mapping[field]]
+		if !ok {
+			value, ok = obj[field]
+		}
+		if !ok || string(value) == "null" {
+			continue
+		}
+		var err error
+		switch field {
+		case "timestamp":
+			m.Timestamp, err = parseTimestampJSON(value)
+		case "cpu":
+			err = json.Unmarshal(value, &m.CPU)
+		case "rps":
+			err = json.Unmarshal(value, &m.RPS)
+		case "cpu_unit":
+			err = json.Unmarshal(value, &m.CPUUnit)
+		case "rps_unit":
+			err = json.Unmarshal(value, &m.RPSUnit)
+		}
+		if err != nil {
+			return m, fmt.Errorf("field %s: %w", field, err)
+		}
+	}
+	return m, nil
+}
+
+// ingestRecord processes one metric record of a streaming transport
+// (/ws/ingest, /analyze/stream) like a synchronous /analyze request, and
+// returns the reply for it: its AnalysisResult, or an error body like the
+// HTTP endpoints'.
+func ingestRecord(ctx context.Context, logger *slog.Logger, stream string, data []byte) interface{} {
+	m, err := decodeIngested(data, appState.fieldMapping)
+	if err != nil {
+		return errorBody{Error: errorDetail{Code: "invalid_json", Message: "Invalid JSON"}}
+	}
+	if m, err = stampMetric(logger, m); err != nil {
+		return errorBody{Error: errorDetail{Code: "invalid_request", Message: err.Error()}}
+	}
+	if err := recordIntake(ctx, logger, stream, m); err != nil {
+		return errorBody{Error: errorDetail{Code: "internal_error", Message: "Error incrementing counter"}}
+	}
+	result, err := processMetric(ctx, logger, stream, m)
+	if err != nil {
+		return errorBody{Error: errorDetail{Code: "unavailable", Message: "Error processing metric"}}
+	}
+	return result
+}
+
+// decodeIngestedBody reads a single metric payload from the body of r, as
+// JSON, as MessagePack for isMsgpack requests or, for isProtobuf requests,
+// as an ingestpb.Metric.
+func decodeIngestedBody(r *http.Request) (Metric, error) {
+	if isProtobuf(r) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return Metric{}, err
+		}
+		var pm ingestpb.Metric
+		if err := proto.Unmarshal(data, &pm); err != nil {
+			return Metric{}, err
+		}
+		return metricFromProto(&pm), nil
+	}
+	if isMsgpack(r) {
+		var obj map[string]interface{}
+		if err := decodeMsgpack(r.Body, &obj); err != nil {
+			return Metric{}, err
+		}
+		return decodeIngestedMsgpack(obj, appState.fieldMapping)
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return Metric{}, err
+	}
+	return decodeIngested(raw, appState.fieldMapping)
+}
+
+// isProtobuf reports whether r carries a protobuf body (proto/ingest.proto)
+// rather than JSON. FIELD_MAPPING does not apply to those.
+func isProtobuf(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-protobuf" || mediaType == "application/protobuf"
+}
+
+// metricFromProto converts pm, leaving out its stream.
+func metricFromProto(pm *ingestpb.Metric) Metric {
+	m := Metric{CPU: pm.GetCpu(), RPS: pm.GetRps(), CPUUnit: pm.GetCpuUnit(), RPSUnit: pm.GetRpsUnit()}
+	if pm.GetTimestamp() != nil {
+		m.Timestamp = pm.GetTimestamp().AsTime()
+	}
+	return m
+}
+
+// emptyBody reports whether r carries no body at all, as sent by probes
+// pointed at /analyze. A body of unknown length is peeked at, and r.Body
+// replaced so the peeked byte is still read by the decoder.
+func emptyBody(r *http.Request) bool {
+	if r.ContentLength == 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > 0 {
+		return false
+	}
+	var first [1]byte
+	n, _ := io.ReadFull(r.Body, first[:])
+	if n == 0 {
+		return true
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(first[:n]), r.Body), r.Body}
+	return false
+}
+
+// recordIntake does the per-request bookkeeping of /analyze for metrics
+// arriving some other way: the request counter, the last-seen time and the
+// current gauges. An error means the counter could not be incremented.
+func recordIntake(ctx context.Context, logger *slog.Logger, stream string, m Metric) error {
+	newCount, err := appState.redisClient.Incr(ctx, appState.key("request_count")).Result()
+	switch {
+	case isBreakerRejection(err):
+		logger.Warn("Redis circuit open, request not counted")
+	case err != nil:
+		logger.Error("Redis INCR error", "error", err)
+		return err
+	default:
+		logSampled(logger, "Redis counter incremented", "count", newCount)
+	}
+	appState.requestCounter.Inc()
+	appState.markSeen(stream)
+	appState.cpuGauge.WithLabelValues(stream).Set(m.CPU)
+	appState.rpsGauge.WithLabelValues(stream).Set(m.RPS)
+	return nil
+}
+
+// ingestMessage runs a metric payload received from a message broker
+// through the /analyze pipeline and returns the result label for the
+// transport's message counter: "processed", "invalid" for a message that
+// can never be processed, or "error" when Redis failed and the sample went
+// to the dead-letter list, or is left to redelivery for a withRedelivery
+// ctx. Unlike /analyze, a failed INCR does not reject the sample, as there
+// is no client to retry it.
+func ingestMessage(ctx context.Context, logger *slog.Logger, stream string, payload []byte) string {
+	if !streamNamePattern.MatchString(stream) {
+		logger.Warn("Skipping message with invalid stream name")
+		return "invalid"
+	}
+	m, err := decodeIngested(payload, appState.fieldMapping)
+	if err != nil {
+		logger.Warn("Skipping undecodable message", "error", err)
+		return "invalid"
+	}
+	if m, err = stampMetric(logger, m); err != nil {
+		return "invalid"
+	}
+	_ = recordIntake(ctx, logger, stream, m)
+	if _, err := processMetric(ctx, logger, stream, m); err != nil {
+		return "error"
+	}
+	return "processed"
+}
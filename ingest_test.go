@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFieldMapping(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{spec: "", want: nil},
+		{spec: "cpu=cpu_pct, rps=req_per_sec", want: map[string]string{"cpu": "cpu_pct", "rps": "req_per_sec"}},
+		{spec: "timestamp=ts", want: map[string]string{"timestamp": "ts"}},
+		{spec: "rps_unit=rate_unit", want: map[string]string{"rps_unit": "rate_unit"}},
+		{spec: "cpu", wantErr: true},
+		{spec: "cpu=", wantErr: true},
+		{spec: "memory=mem", wantErr: true},
+		{spec: "cpu=a,cpu=b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseFieldMapping(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFieldMapping(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseFieldMapping(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("parseFieldMapping(%q)[%s] = %q, want %q", tt.spec, k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestDecodeIngested(t *testing.T) {
+	mapping := map[string]string{"cpu": "cpu_pct", "rps": "req_per_sec", "timestamp": "ts"}
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		mapping map[string]string
+		body    string
+		want    Metric
+		wantErr bool
+	}{
+		{name: "default names", body: `{"cpu": 1, "rps": 2, "timestamp": "2024-01-01T00:00:00Z"}`, want: Metric{Timestamp: ts, CPU: 1, RPS: 2}},
+		{name: "mapped names", mapping: mapping, body: `{"cpu_pct": 1, "req_per_sec": 2, "ts": "2024-01-01T00:00:00Z"}`, want: Metric{Timestamp: ts, CPU: 1, RPS: 2}},
+		{name: "falls back to default name", mapping: mapping, body: `{"cpu": 1, "req_per_sec": 2}`, want: Metric{CPU: 1, RPS: 2}},
+		{name: "mapped name wins", mapping: mapping, body: `{"rps": 1, "req_per_sec": 2}`, want: Metric{RPS: 2}},
+		{name: "unmapped keys ignored", mapping: mapping, body: `{"req_per_sec": 2, "host": "a"}`, want: Metric{RPS: 2}},
+		{name: "null left zero", mapping: mapping, body: `{"req_per_sec": null}`},
+		{name: "wrong type", mapping: mapping, body: `{"req_per_sec": "fast"}`, wantErr: true},
+		{name: "not an object", mapping: mapping, body: `[1, 2]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := decodeIngested(json.RawMessage(tt.body), tt.mapping)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyzeWithFieldMapping(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.fieldMapping = map[string]string{"cpu": "cpu_pct", "rps": "req_per_sec"}
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"cpu_pct": 12.5, "req_per_sec": 125}`)
+	newMux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze?sync=true", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	stored, _ := mr.List("metrics")
+	if len(stored) != 1 {
+		t.Fatalf("stored %d samples, want 1", len(stored))
+	}
+	m, _ := decodeMetric([]byte(stored[0]))
+	if m.CPU != 12.5 || m.RPS != 125 {
+		t.Errorf("stored %+v, want cpu 12.5 rps 125", m)
+	}
+}
+
+func TestAnalyzeRejectsEmptyBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     io.Reader
+		wantCode int
+	}{
+		{name: "no body", wantCode: http.StatusBadRequest},
+		{name: "zero length", body: strings.NewReader(""), wantCode: http.StatusBadRequest},
+		// Readers of unknown length, as with a chunked request.
+		{name: "chunked empty", body: io.MultiReader(), wantCode: http.StatusBadRequest},
+		{name: "chunked metric", body: io.MultiReader(strings.NewReader(`{"rps": 1}`)), wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze?sync=true", tt.body))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			counted := mr.Exists("request_count") || counterValue(appState.requestCounter) != 0
+			if wantCounted := tt.wantCode == http.StatusOK; counted != wantCounted {
+				t.Errorf("request counted = %v, want %v", counted, wantCounted)
+			}
+		})
+	}
+}
@@ -0,0 +1,475 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.29.3
+// source: proto/ingest.proto
+
+// Ingestion API served on GRPC_PORT, for collectors that speak gRPC. It
+// mirrors POST /analyze: SubmitMetric is the ?sync=true call, and
+// StreamMetrics the async one for a stream of samples.
+//
+// Metric and MetricBatch are also the bodies of POST /analyze and
+// POST /analyze/batch sent as Content-Type application/x-protobuf.
+//
+// Regenerate the Go code in ingestpb/ with:
+//
+//   protoc --go_out=. --go_opt=module=go-stream-processing \
+//     --go-grpc_out=. --go-grpc_opt=module=go-stream-processing \
+//     proto/ingest.proto
+
+package ingestpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Metric struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// stream defaults to "default". Over HTTP the stream is named by the
+	// path or ?stream= and this field is ignored.
+	Stream string `protobuf:"bytes,1,opt,name=stream,proto3" json:"stream,omitempty"`
+	// timestamp defaults to the arrival time.
+	Timestamp *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Cpu       float64                `protobuf:"fixed64,3,opt,name=cpu,proto3" json:"cpu,omitempty"`
+	Rps       float64                `protobuf:"fixed64,4,opt,name=rps,proto3" json:"rps,omitempty"`
+	// cpu_unit and rps_unit are the units the values were reported in, as
+	// accepted by /analyze; empty means the canonical unit.
+	CpuUnit       string `protobuf:"bytes,5,opt,name=cpu_unit,json=cpuUnit,proto3" json:"cpu_unit,omitempty"`
+	RpsUnit       string `protobuf:"bytes,6,opt,name=rps_unit,json=rpsUnit,proto3" json:"rps_unit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Metric) Reset() {
+	*x = Metric{}
+	mi := &file_proto_ingest_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Metric) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Metric) ProtoMessage() {}
+
+func (x *Metric) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ingest_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Metric.ProtoReflect.Descriptor instead.
+func (*Metric) Descriptor() ([]byte, []int) {
+	return file_proto_ingest_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Metric) GetStream() string {
+	if x != nil {
+		return x.Stream
+	}
+	return ""
+}
+
+func (x *Metric) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Metric) GetCpu() float64 {
+	if x != nil {
+		return x.Cpu
+	}
+	return 0
+}
+
+func (x *Metric) GetRps() float64 {
+	if x != nil {
+		return x.Rps
+	}
+	return 0
+}
+
+func (x *Metric) GetCpuUnit() string {
+	if x != nil {
+		return x.CpuUnit
+	}
+	return ""
+}
+
+func (x *Metric) GetRpsUnit() string {
+	if x != nil {
+		return x.RpsUnit
+	}
+	return ""
+}
+
+// MetricBatch is the protobuf body of POST /analyze/batch, oldest first.
+type MetricBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Metrics       []*Metric              `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MetricBatch) Reset() {
+	*x = MetricBatch{}
+	mi := &file_proto_ingest_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MetricBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetricBatch) ProtoMessage() {}
+
+func (x *MetricBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ingest_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetricBatch.ProtoReflect.Descriptor instead.
+func (*MetricBatch) Descriptor() ([]byte, []int) {
+	return file_proto_ingest_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *MetricBatch) GetMetrics() []*Metric {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+// AnalysisResult carries the fields of the /analyze sync response.
+type AnalysisResult struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Status      string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	Samples     int32                  `protobuf:"varint,2,opt,name=samples,proto3" json:"samples,omitempty"`
+	MinSamples  int32                  `protobuf:"varint,3,opt,name=min_samples,json=minSamples,proto3" json:"min_samples,omitempty"`
+	RollingAvg  float64                `protobuf:"fixed64,4,opt,name=rolling_avg,json=rollingAvg,proto3" json:"rolling_avg,omitempty"`
+	WeightedAvg float64                `protobuf:"fixed64,5,opt,name=weighted_avg,json=weightedAvg,proto3" json:"weighted_avg,omitempty"`
+	// score is only set once the window is warm.
+	Score         *float64 `protobuf:"fixed64,6,opt,name=score,proto3,oneof" json:"score,omitempty"`
+	Severity      string   `protobuf:"bytes,7,opt,name=severity,proto3" json:"severity,omitempty"`
+	Direction     string   `protobuf:"bytes,8,opt,name=direction,proto3" json:"direction,omitempty"`
+	Breaches      []string `protobuf:"bytes,9,rep,name=breaches,proto3" json:"breaches,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AnalysisResult) Reset() {
+	*x = AnalysisResult{}
+	mi := &file_proto_ingest_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnalysisResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnalysisResult) ProtoMessage() {}
+
+func (x *AnalysisResult) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ingest_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnalysisResult.ProtoReflect.Descriptor instead.
+func (*AnalysisResult) Descriptor() ([]byte, []int) {
+	return file_proto_ingest_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *AnalysisResult) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *AnalysisResult) GetSamples() int32 {
+	if x != nil {
+		return x.Samples
+	}
+	return 0
+}
+
+func (x *AnalysisResult) GetMinSamples() int32 {
+	if x != nil {
+		return x.MinSamples
+	}
+	return 0
+}
+
+func (x *AnalysisResult) GetRollingAvg() float64 {
+	if x != nil {
+		return x.RollingAvg
+	}
+	return 0
+}
+
+func (x *AnalysisResult) GetWeightedAvg() float64 {
+	if x != nil {
+		return x.WeightedAvg
+	}
+	return 0
+}
+
+func (x *AnalysisResult) GetScore() float64 {
+	if x != nil && x.Score != nil {
+		return *x.Score
+	}
+	return 0
+}
+
+func (x *AnalysisResult) GetSeverity() string {
+	if x != nil {
+		return x.Severity
+	}
+	return ""
+}
+
+func (x *AnalysisResult) GetDirection() string {
+	if x != nil {
+		return x.Direction
+	}
+	return ""
+}
+
+func (x *AnalysisResult) GetBreaches() []string {
+	if x != nil {
+		return x.Breaches
+	}
+	return nil
+}
+
+type SubmitMetricResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Result        *AnalysisResult        `protobuf:"bytes,1,opt,name=result,proto3" json:"result,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitMetricResponse) Reset() {
+	*x = SubmitMetricResponse{}
+	mi := &file_proto_ingest_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitMetricResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitMetricResponse) ProtoMessage() {}
+
+func (x *SubmitMetricResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ingest_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitMetricResponse.ProtoReflect.Descriptor instead.
+func (*SubmitMetricResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ingest_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SubmitMetricResponse) GetResult() *AnalysisResult {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+type StreamMetricsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// accepted metrics were queued; rejected ones failed validation or found
+	// the processing queue full.
+	Accepted      int64 `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	Rejected      int64 `protobuf:"varint,2,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamMetricsResponse) Reset() {
+	*x = StreamMetricsResponse{}
+	mi := &file_proto_ingest_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamMetricsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamMetricsResponse) ProtoMessage() {}
+
+func (x *StreamMetricsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ingest_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamMetricsResponse.ProtoReflect.Descriptor instead.
+func (*StreamMetricsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ingest_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StreamMetricsResponse) GetAccepted() int64 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+func (x *StreamMetricsResponse) GetRejected() int64 {
+	if x != nil {
+		return x.Rejected
+	}
+	return 0
+}
+
+var File_proto_ingest_proto protoreflect.FileDescriptor
+
+const file_proto_ingest_proto_rawDesc = "" +
+	"\n" +
+	"\x12proto/ingest.proto\x12\x12gostream.ingest.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xb4\x01\n" +
+	"\x06Metric\x12\x16\n" +
+	"\x06stream\x18\x01 \x01(\tR\x06stream\x128\n" +
+	"\ttimestamp\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12\x10\n" +
+	"\x03cpu\x18\x03 \x01(\x01R\x03cpu\x12\x10\n" +
+	"\x03rps\x18\x04 \x01(\x01R\x03rps\x12\x19\n" +
+	"\bcpu_unit\x18\x05 \x01(\tR\acpuUnit\x12\x19\n" +
+	"\brps_unit\x18\x06 \x01(\tR\arpsUnit\"C\n" +
+	"\vMetricBatch\x124\n" +
+	"\ametrics\x18\x01 \x03(\v2\x1a.gostream.ingest.v1.MetricR\ametrics\"\xa2\x02\n" +
+	"\x0eAnalysisResult\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12\x18\n" +
+	"\asamples\x18\x02 \x01(\x05R\asamples\x12\x1f\n" +
+	"\vmin_samples\x18\x03 \x01(\x05R\n" +
+	"minSamples\x12\x1f\n" +
+	"\vrolling_avg\x18\x04 \x01(\x01R\n" +
+	"rollingAvg\x12!\n" +
+	"\fweighted_avg\x18\x05 \x01(\x01R\vweightedAvg\x12\x19\n" +
+	"\x05score\x18\x06 \x01(\x01H\x00R\x05score\x88\x01\x01\x12\x1a\n" +
+	"\bseverity\x18\a \x01(\tR\bseverity\x12\x1c\n" +
+	"\tdirection\x18\b \x01(\tR\tdirection\x12\x1a\n" +
+	"\bbreaches\x18\t \x03(\tR\bbreachesB\b\n" +
+	"\x06_score\"R\n" +
+	"\x14SubmitMetricResponse\x12:\n" +
+	"\x06result\x18\x01 \x01(\v2\".gostream.ingest.v1.AnalysisResultR\x06result\"O\n" +
+	"\x15StreamMetricsResponse\x12\x1a\n" +
+	"\baccepted\x18\x01 \x01(\x03R\baccepted\x12\x1a\n" +
+	"\brejected\x18\x02 \x01(\x03R\brejected2\xb8\x01\n" +
+	"\x06Ingest\x12T\n" +
+	"\fSubmitMetric\x12\x1a.gostream.ingest.v1.Metric\x1a(.gostream.ingest.v1.SubmitMetricResponse\x12X\n" +
+	"\rStreamMetrics\x12\x1a.gostream.ingest.v1.Metric\x1a).gostream.ingest.v1.StreamMetricsResponse(\x01B\x1fZ\x1dgo-stream-processing/ingestpbb\x06proto3"
+
+var (
+	file_proto_ingest_proto_rawDescOnce sync.Once
+	file_proto_ingest_proto_rawDescData []byte
+)
+
+func file_proto_ingest_proto_rawDescGZIP() []byte {
+	file_proto_ingest_proto_rawDescOnce.Do(func() {
+		file_proto_ingest_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_ingest_proto_rawDesc), len(file_proto_ingest_proto_rawDesc)))
+	})
+	return file_proto_ingest_proto_rawDescData
+}
+
+var file_proto_ingest_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_proto_ingest_proto_goTypes = []any{
+	(*Metric)(nil),                // 0: gostream.ingest.v1.Metric
+	(*MetricBatch)(nil),           // 1: gostream.ingest.v1.MetricBatch
+	(*AnalysisResult)(nil),        // 2: gostream.ingest.v1.AnalysisResult
+	(*SubmitMetricResponse)(nil),  // 3: gostream.ingest.v1.SubmitMetricResponse
+	(*StreamMetricsResponse)(nil), // 4: gostream.ingest.v1.StreamMetricsResponse
+	(*timestamppb.Timestamp)(nil), // 5: google.protobuf.Timestamp
+}
+var file_proto_ingest_proto_depIdxs = []int32{
+	5, // 0: gostream.ingest.v1.Metric.timestamp:type_name -> google.protobuf.Timestamp
+	0, // 1: gostream.ingest.v1.MetricBatch.metrics:type_name -> gostream.ingest.v1.Metric
+	2, // 2: gostream.ingest.v1.SubmitMetricResponse.result:type_name -> gostream.ingest.v1.AnalysisResult
+	0, // 3: gostream.ingest.v1.Ingest.SubmitMetric:input_type -> gostream.ingest.v1.Metric
+	0, // 4: gostream.ingest.v1.Ingest.StreamMetrics:input_type -> gostream.ingest.v1.Metric
+	3, // 5: gostream.ingest.v1.Ingest.SubmitMetric:output_type -> gostream.ingest.v1.SubmitMetricResponse
+	4, // 6: gostream.ingest.v1.Ingest.StreamMetrics:output_type -> gostream.ingest.v1.StreamMetricsResponse
+	5, // [5:7] is the sub-list for method output_type
+	3, // [3:5] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_proto_ingest_proto_init() }
+func file_proto_ingest_proto_init() {
+	if File_proto_ingest_proto != nil {
+		return
+	}
+	file_proto_ingest_proto_msgTypes[2].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_ingest_proto_rawDesc), len(file_proto_ingest_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_ingest_proto_goTypes,
+		DependencyIndexes: file_proto_ingest_proto_depIdxs,
+		MessageInfos:      file_proto_ingest_proto_msgTypes,
+	}.Build()
+	File_proto_ingest_proto = out.File
+	file_proto_ingest_proto_goTypes = nil
+	file_proto_ingest_proto_depIdxs = nil
+}
@@ -0,0 +1,174 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: proto/ingest.proto
+
+// Ingestion API served on GRPC_PORT, for collectors that speak gRPC. It
+// mirrors POST /analyze: SubmitMetric is the ?sync=true call, and
+// StreamMetrics the async one for a stream of samples.
+//
+// Metric and MetricBatch are also the bodies of POST /analyze and
+// POST /analyze/batch sent as Content-Type application/x-protobuf.
+//
+// Regenerate the Go code in ingestpb/ with:
+//
+//   protoc --go_out=. --go_opt=module=go-stream-processing \
+//     --go-grpc_out=. --go-grpc_opt=module=go-stream-processing \
+//     proto/ingest.proto
+
+package ingestpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Ingest_SubmitMetric_FullMethodName  = "/gostream.ingest.v1.Ingest/SubmitMetric"
+	Ingest_StreamMetrics_FullMethodName = "/gostream.ingest.v1.Ingest/StreamMetrics"
+)
+
+// IngestClient is the client API for Ingest service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type IngestClient interface {
+	// SubmitMetric processes one metric and returns its verdict.
+	SubmitMetric(ctx context.Context, in *Metric, opts ...grpc.CallOption) (*SubmitMetricResponse, error)
+	// StreamMetrics queues every metric the client sends for processing,
+	// like async /analyze, and reports the totals once the client closes
+	// the stream.
+	StreamMetrics(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Metric, StreamMetricsResponse], error)
+}
+
+type ingestClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewIngestClient(cc grpc.ClientConnInterface) IngestClient {
+	return &ingestClient{cc}
+}
+
+func (c *ingestClient) SubmitMetric(ctx context.Context, in *Metric, opts ...grpc.CallOption) (*SubmitMetricResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitMetricResponse)
+	err := c.cc.Invoke(ctx, Ingest_SubmitMetric_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ingestClient) StreamMetrics(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Metric, StreamMetricsResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Ingest_ServiceDesc.Streams[0], Ingest_StreamMetrics_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Metric, StreamMetricsResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Ingest_StreamMetricsClient = grpc.ClientStreamingClient[Metric, StreamMetricsResponse]
+
+// IngestServer is the server API for Ingest service.
+// All implementations must embed UnimplementedIngestServer
+// for forward compatibility.
+type IngestServer interface {
+	// SubmitMetric processes one metric and returns its verdict.
+	SubmitMetric(context.Context, *Metric) (*SubmitMetricResponse, error)
+	// StreamMetrics queues every metric the client sends for processing,
+	// like async /analyze, and reports the totals once the client closes
+	// the stream.
+	StreamMetrics(grpc.ClientStreamingServer[Metric, StreamMetricsResponse]) error
+	mustEmbedUnimplementedIngestServer()
+}
+
+// UnimplementedIngestServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedIngestServer struct{}
+
+func (UnimplementedIngestServer) SubmitMetric(context.Context, *Metric) (*SubmitMetricResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitMetric not implemented")
+}
+func (UnimplementedIngestServer) StreamMetrics(grpc.ClientStreamingServer[Metric, StreamMetricsResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamMetrics not implemented")
+}
+func (UnimplementedIngestServer) mustEmbedUnimplementedIngestServer() {}
+func (UnimplementedIngestServer) testEmbeddedByValue()                {}
+
+// UnsafeIngestServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to IngestServer will
+// result in compilation errors.
+type UnsafeIngestServer interface {
+	mustEmbedUnimplementedIngestServer()
+}
+
+func RegisterIngestServer(s grpc.ServiceRegistrar, srv IngestServer) {
+	// If the following call pancis, it indicates UnimplementedIngestServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Ingest_ServiceDesc, srv)
+}
+
+func _Ingest_SubmitMetric_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Metric)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IngestServer).SubmitMetric(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Ingest_SubmitMetric_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IngestServer).SubmitMetric(ctx, req.(*Metric))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ingest_StreamMetrics_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(IngestServer).StreamMetrics(&grpc.GenericServerStream[Metric, StreamMetricsResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Ingest_StreamMetricsServer = grpc.ClientStreamingServer[Metric, StreamMetricsResponse]
+
+// Ingest_ServiceDesc is the grpc.ServiceDesc for Ingest service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Ingest_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gostream.ingest.v1.Ingest",
+	HandlerType: (*IngestServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitMetric",
+			Handler:    _Ingest_SubmitMetric_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamMetrics",
+			Handler:       _Ingest_StreamMetrics_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "proto/ingest.proto",
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// natsSubscriber feeds Metric JSON messages from a NATS JetStream stream
+// (NATS_URL, NATS_STREAM) through the /analyze pipeline, using a durable
+// pull consumer (NATS_DURABLE) filtered on NATS_SUBJECT. The last token of
+// a message's subject names the stream, so "metrics.web" goes to web; a
+// single-token subject goes to the default stream.
+//
+// A message is acked once processMetric has written it to Redis. With
+// write-behind on, or while the Redis circuit is open, that means once it
+// is buffered in memory. Messages that fail on Redis are nak'ed for
+// redelivery, and kept off the dead-letter list, whose in-memory fallback
+// would not survive a restart; JetStream's copy is the durable one.
+// Messages that can never succeed are terminated.
+type natsSubscriber struct {
+	conn     *nats.Conn
+	consumer jetstream.Consumer
+	// messages counts consumed messages by result, as for Kafka.
+	messages *prometheus.CounterVec
+}
+
+func newNATSSubscriber(ctx context.Context, url, stream, subject, durable string, messages *prometheus.CounterVec) (*natsSubscriber, error) {
+	conn, err := nats.Connect(url, nats.Name("go-service"))
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	consumer, err := js.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{
+		Durable:       durable,
+		FilterSubject: subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &natsSubscriber{conn: conn, consumer: consumer, messages: messages}, nil
+}
+
+// run consumes messages until ctx is cancelled, then lets the ones in
+// flight finish before closing the connection. ctx only stops the
+// consumer: messages are processed under a context that outlives it, so
+// the ones handled while draining still reach Redis.
+func (s *natsSubscriber) run(ctx context.Context) error {
+	msgCtx := context.WithoutCancel(ctx)
+	cc, err := s.consumer.Consume(func(msg jetstream.Msg) {
+		s.messages.WithLabelValues(s.handle(msgCtx, msg)).Inc()
+	})
+	if err != nil {
+		s.conn.Close()
+		return err
+	}
+	<-ctx.Done()
+	cc.Drain()
+	<-cc.Closed()
+	return s.conn.Drain()
+}
+
+// handle runs one message through the pipeline, acknowledges it
+// accordingly and returns its result label.
+func (s *natsSubscriber) handle(ctx context.Context, msg jetstream.Msg) string {
+	stream := defaultStream
+	if tokens := strings.Split(msg.Subject(), "."); len(tokens) > 1 {
+		stream = tokens[len(tokens)-1]
+	}
+	logger := slog.Default().With("transport", "nats", "stream", stream, "subject", msg.Subject())
+	result := ingestMessage(withRedelivery(ctx), logger, stream, msg.Data())
+	var err error
+	switch result {
+	case "processed":
+		err = msg.Ack()
+	case "invalid":
+		err = msg.Term()
+	default:
+		err = msg.NakWithDelay(time.Second)
+	}
+	if err != nil {
+		logger.Error("NATS acknowledgement error", "result", result, "error", err)
+	}
+	return result
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fakeNATSMsg records how a message was acknowledged. Methods it does not
+// override panic through the nil embedded interface.
+type fakeNATSMsg struct {
+	jetstream.Msg
+	subject, data string
+	acked         string
+}
+
+func (m *fakeNATSMsg) Subject() string                  { return m.subject }
+func (m *fakeNATSMsg) Data() []byte                     { return []byte(m.data) }
+func (m *fakeNATSMsg) Ack() error                       { m.acked = "ack"; return nil }
+func (m *fakeNATSMsg) NakWithDelay(time.Duration) error { m.acked = "nak"; return nil }
+func (m *fakeNATSMsg) Term() error                      { m.acked = "term"; return nil }
+
+func TestNATSSubscriberHandle(t *testing.T) {
+	tests := []struct {
+		name          string
+		subject, data string
+		redisDown     bool
+		wantResult    string
+		wantAck       string
+		wantStream    string
+	}{
+		{name: "processed", subject: "metrics.web", data: `{"rps": 10}`, wantResult: "processed", wantAck: "ack", wantStream: "web"},
+		{name: "single token", subject: "metrics", data: `{"cpu": 50}`, wantResult: "processed", wantAck: "ack", wantStream: defaultStream},
+		{name: "invalid stream", subject: "metrics.*", data: `{"rps": 1}`, wantResult: "invalid", wantAck: "term"},
+		{name: "bad json", subject: "metrics.web", data: `{"rps": "high"}`, wantResult: "invalid", wantAck: "term"},
+		{name: "bad unit", subject: "metrics.web", data: `{"rps": 1, "rps_unit": "furlongs"}`, wantResult: "invalid", wantAck: "term"},
+		{name: "redis down", subject: "metrics.web", data: `{"rps": 1}`, redisDown: true, wantResult: "error", wantAck: "nak"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			if tt.redisDown {
+				mr.Close()
+			}
+			s := &natsSubscriber{messages: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "nats_messages"}, []string{"result"})}
+			msg := &fakeNATSMsg{subject: tt.subject, data: tt.data}
+			if got := s.handle(context.Background(), msg); got != tt.wantResult {
+				t.Errorf("result = %q, want %q", got, tt.wantResult)
+			}
+			if msg.acked != tt.wantAck {
+				t.Errorf("message got %q, want %q", msg.acked, tt.wantAck)
+			}
+			// A failed sample is recovered by redelivery only, never from
+			// the dead-letter list as well.
+			if n := len(appState.deadLetters); n != 0 {
+				t.Errorf("%d dead letters, want none", n)
+			}
+			if tt.wantStream == "" {
+				return
+			}
+			if stored, _ := mr.List(appState.metricsKey(tt.wantStream)); len(stored) != 1 {
+				t.Errorf("stored %d samples on %q, want 1", len(stored), tt.wantStream)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// NaN and infinities have no JSON encoding, so json.Marshal fails on any
+// value holding one and the handler is left with a 200 and no body. They
+// come from aggregates over extreme input (a sum that overflows, the
+// standard deviation of an infinite window) or from samples decoded with
+// the msgpack codec, which can carry them.
+
+// nonFiniteAsZero makes marshalJSON write non-finite floats as 0 instead of
+// null (JSON_NON_FINITE=zero), for clients that cannot take a null number.
+var nonFiniteAsZero bool
+
+// parseNonFinite parses JSON_NON_FINITE: "null" (the default) or "zero".
+func parseNonFinite(v string) (asZero bool, err error) {
+	switch v {
+	case "", "null":
+		return false, nil
+	case "zero":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown mode %q, want null or zero", v)
+}
+
+// marshalJSON is json.Marshal that never fails on NaN or infinities. Values
+// without them are encoded as usual; otherwise they are re-encoded with
+// every non-finite float replaced by null, or 0 with JSON_NON_FINITE=zero.
+func marshalJSON(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	var unsupported *json.UnsupportedValueError
+	if err == nil || !errors.As(err, &unsupported) {
+		return data, err
+	}
+	return json.Marshal(finiteValue(reflect.ValueOf(v)))
+}
+
+// encodeJSON writes v as marshalJSON encodes it, followed by a newline as
+// json.Encoder does.
+func encodeJSON(w io.Writer, v interface{}) error {
+	data, err := marshalJSON(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+var (
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// finiteValue rebuilds v from generic maps, slices and scalars, following
+// encoding/json's field naming, with non-finite floats replaced. Values
+// that marshal themselves, such as time.Time, are passed through as is.
+func finiteValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			if nonFiniteAsZero {
+				return 0.0
+			}
+			return nil
+		}
+		return f
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return finiteValue(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface() // []byte is base64, never a float
+		}
+		fallthrough
+	case reflect.Array:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = finiteValue(v.Index(i))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = finiteValue(iter.Value())
+		}
+		return out
+	case reflect.Struct:
+		out := make(map[string]interface{})
+		addStructFields(out, v)
+		return out
+	}
+	return v.Interface()
+}
+
+// addStructFields adds the exported fields of struct v to out under their
+// JSON names, honouring "-" and omitempty and flattening untagged embedded
+// structs.
+func addStructFields(out map[string]interface{}, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+		if field.Anonymous && name == "" {
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				addStructFields(out, fv)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.Contains(opts, "omitempty") && isEmptyJSON(fv) {
+			continue
+		}
+		out[name] = finiteValue(fv)
+	}
+}
+
+// isEmptyJSON reports whether omitempty drops v.
+func isEmptyJSON(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshalJSONNonFinite(t *testing.T) {
+	type embedded struct {
+		Inner float64 `json:"inner"`
+	}
+	type sample struct {
+		embedded
+		Value    float64            `json:"value"`
+		Optional *float64           `json:"optional,omitempty"`
+		Skipped  float64            `json:"-"`
+		Omitted  float64            `json:"omitted,omitempty"`
+		Values   []float64          `json:"values"`
+		ByName   map[string]float64 `json:"by_name"`
+		When     time.Time          `json:"when"`
+		Untagged string
+	}
+	nan, inf := math.NaN(), math.Inf(1)
+	v := sample{
+		embedded: embedded{Inner: inf},
+		Value:    nan,
+		Optional: &inf,
+		Skipped:  nan,
+		Values:   []float64{1, math.Inf(-1)},
+		ByName:   map[string]float64{"a": nan},
+		When:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Untagged: "x",
+	}
+	tests := []struct {
+		name   string
+		asZero bool
+		in     interface{}
+		want   string
+	}{
+		{name: "finite unchanged", in: map[string]float64{"a": 1.5}, want: `{"a":1.5}`},
+		{name: "bare NaN", in: nan, want: `null`},
+		{name: "bare NaN as zero", asZero: true, in: nan, want: `0`},
+		{name: "struct", in: v,
+			want: `{"Untagged":"x","by_name":{"a":null},"inner":null,"optional":null,"value":null,"values":[1,null],"when":"2024-01-01T00:00:00Z"}`},
+		{name: "struct as zero", asZero: true, in: &v,
+			want: `{"Untagged":"x","by_name":{"a":0},"inner":0,"optional":0,"value":0,"values":[1,0],"when":"2024-01-01T00:00:00Z"}`},
+	}
+	t.Cleanup(func() { nonFiniteAsZero = false })
+	for _, tt := range tests {
+		nonFiniteAsZero = tt.asZero
+		got, err := marshalJSON(tt.in)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("%s: marshalJSON = %s, %v; want %s", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseNonFinite(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    bool
+		wantErr bool
+	}{
+		{in: "", want: false},
+		{in: "null", want: false},
+		{in: "zero", want: true},
+		{in: "nan", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseNonFinite(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseNonFinite(%q) = %v, %v; want %v, err %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// Two samples near the float64 limit overflow the weighted sum, so the
+// weighted average is infinite. The verdict must still be a valid JSON
+// body.
+func TestAnalyzeNonFiniteWindow(t *testing.T) {
+	newTestAppState(t)
+	mux := newMux()
+	var body string
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze?sync=true", strings.NewReader(`{"rps": 1.5e308}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d", w.Code)
+		}
+		body = w.Body.String()
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatalf("invalid JSON %q: %v", body, err)
+	}
+	if v, ok := result["weighted_avg"]; !ok || v != nil {
+		t.Errorf("weighted_avg = %v, want null in %s", v, body)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaReader is the part of *kafka.Reader the consumer uses, so tests can
+// feed it messages without a broker.
+type kafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// kafkaConsumer feeds Metric JSON messages from a Kafka topic
+// (KAFKA_BROKERS, KAFKA_TOPIC) through the /analyze pipeline as a member of
+// the consumer group KAFKA_GROUP_ID. The message key names the stream; a
+// message without one goes to the default stream. Messages are processed
+// one at a time and committed once handled, so delivery is at least once
+// and samples of a stream keep their partition order.
+type kafkaConsumer struct {
+	reader kafkaReader
+	// messages counts consumed messages by result: "processed", "invalid"
+	// for ones that could not be decoded or were rejected, or "error" when
+	// Redis failed and the sample went to the dead-letter list.
+	messages *prometheus.CounterVec
+}
+
+// parseKafkaBrokers splits a KAFKA_BROKERS list such as
+// "kafka-0:9092,kafka-1:9092".
+func parseKafkaBrokers(spec string) ([]string, error) {
+	var brokers []string
+	for _, b := range strings.Split(spec, ",") {
+		b = strings.TrimSpace(b)
+		if b == "" {
+			return nil, fmt.Errorf("empty broker address in %q", spec)
+		}
+		brokers = append(brokers, b)
+	}
+	return brokers, nil
+}
+
+func newKafkaConsumer(brokers []string, topic, groupID string, messages *prometheus.CounterVec) *kafkaConsumer {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: brokers,
+		Topic:   topic,
+		GroupID: groupID,
+	})
+	return &kafkaConsumer{reader: reader, messages: messages}
+}
+
+// run consumes messages until ctx is cancelled, then leaves the group.
+func (c *kafkaConsumer) run(ctx context.Context) {
+	defer c.reader.Close()
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("Kafka fetch error", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		c.messages.WithLabelValues(c.handle(ctx, msg)).Inc()
+		// Failing to commit only means the message is seen again after a
+		// rebalance, which at-least-once delivery already allows for.
+		if err := c.reader.CommitMessages(ctx, msg); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("Kafka commit error", "error", err, "partition", msg.Partition, "offset", msg.Offset)
+		}
+	}
+}
+
+// handle runs one message through the pipeline and returns its result
+// label. Bad messages are skipped rather than retried, as they would fail
+// the same way every time.
+func (c *kafkaConsumer) handle(ctx context.Context, msg kafka.Message) string {
+	stream := string(msg.Key)
+	if stream == "" {
+		stream = defaultStream
+	}
+	logger := slog.Default().With("transport", "kafka", "stream", stream, "partition", msg.Partition, "offset", msg.Offset)
+	return ingestMessage(ctx, logger, stream, msg.Value)
+}
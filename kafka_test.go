@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeKafkaReader hands out msgs in order, then blocks until the context
+// is cancelled.
+type fakeKafkaReader struct {
+	mu        sync.Mutex
+	msgs      []kafka.Message
+	committed []int64
+	drained   chan struct{}
+}
+
+func (r *fakeKafkaReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.mu.Lock()
+	if len(r.msgs) > 0 {
+		msg := r.msgs[0]
+		r.msgs = r.msgs[1:]
+		r.mu.Unlock()
+		return msg, nil
+	}
+	r.mu.Unlock()
+	close(r.drained)
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (r *fakeKafkaReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range msgs {
+		r.committed = append(r.committed, msg.Offset)
+	}
+	return nil
+}
+
+func (r *fakeKafkaReader) Close() error { return nil }
+
+func TestKafkaConsumer(t *testing.T) {
+	tests := []struct {
+		name       string
+		key, value string
+		wantResult string
+		wantStream string
+	}{
+		{name: "keyed", key: "web", value: `{"rps": 10}`, wantResult: "processed", wantStream: "web"},
+		{name: "unkeyed", value: `{"cpu": 50}`, wantResult: "processed", wantStream: defaultStream},
+		{name: "invalid stream", key: "bad name", value: `{"rps": 1}`, wantResult: "invalid"},
+		{name: "bad json", key: "web", value: `{"rps": "high"}`, wantResult: "invalid"},
+		{name: "bad unit", key: "web", value: `{"rps": 1, "rps_unit": "furlongs"}`, wantResult: "invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			reader := &fakeKafkaReader{
+				msgs:    []kafka.Message{{Key: []byte(tt.key), Value: []byte(tt.value), Offset: 7}},
+				drained: make(chan struct{}),
+			}
+			messages := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "kafka_messages"}, []string{"result"})
+			c := &kafkaConsumer{reader: reader, messages: messages}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				c.run(ctx)
+			}()
+			<-reader.drained
+			cancel()
+			<-done
+
+			if got := counterValue(messages.WithLabelValues(tt.wantResult)); got != 1 {
+				t.Errorf("%s messages = %v, want 1", tt.wantResult, got)
+			}
+			// Bad messages are committed too, so they are not redelivered.
+			if len(reader.committed) != 1 || reader.committed[0] != 7 {
+				t.Errorf("committed offsets %v, want [7]", reader.committed)
+			}
+			if tt.wantStream == "" {
+				return
+			}
+			if stored, _ := mr.List(appState.metricsKey(tt.wantStream)); len(stored) != 1 {
+				t.Errorf("stored %d samples on %q, want 1", len(stored), tt.wantStream)
+			}
+			if count, _ := mr.Get("request_count"); count != "1" {
+				t.Errorf("request_count = %q, want 1", count)
+			}
+		})
+	}
+}
+
+func TestParseKafkaBrokers(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    int
+		wantErr bool
+	}{
+		{spec: "kafka:9092", want: 1},
+		{spec: "kafka-0:9092, kafka-1:9092", want: 2},
+		{spec: "kafka-0:9092,,kafka-1:9092", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseKafkaBrokers(tt.spec)
+		if len(got) != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseKafkaBrokers(%q) = %v, %v; want %d brokers, error %v", tt.spec, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// logSampler passes 1 in every rate calls for each message: the 1st, the
+// (rate+1)th and so on. It counts rather than rolls dice, so which lines
+// are logged depends only on the order of calls. A nil sampler passes
+// everything.
+type logSampler struct {
+	rate   uint64
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func newLogSampler(rate int) *logSampler {
+	return &logSampler{rate: uint64(rate), counts: make(map[string]uint64)}
+}
+
+func (s *logSampler) allow(msg string) bool {
+	if s == nil || s.rate <= 1 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.counts[msg]
+	s.counts[msg] = n + 1
+	return n%s.rate == 0
+}
+
+// logSampled logs a routine per-metric line at info level, subject to
+// LOG_SAMPLE_RATE. Lines that are sampled carry the rate so a reader can
+// scale what they see. Anomalies, warnings and errors are never sampled
+// and must be logged directly.
+func logSampled(logger *slog.Logger, msg string, args ...any) {
+	appState.cfgMu.RLock()
+	s := appState.logSampler
+	appState.cfgMu.RUnlock()
+	if !s.allow(msg) {
+		return
+	}
+	if s != nil && s.rate > 1 {
+		args = append(args, "log_sample_rate", s.rate)
+	}
+	logger.Info(msg, args...)
+}
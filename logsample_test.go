@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogSamplerAllow(t *testing.T) {
+	tests := []struct {
+		name    string
+		sampler *logSampler
+		want    string // one character per call, x for logged
+	}{
+		{name: "nil", sampler: nil, want: "xxxxxxx"},
+		{name: "rate 1", sampler: newLogSampler(1), want: "xxxxxxx"},
+		{name: "rate 3", sampler: newLogSampler(3), want: "x..x..x"},
+	}
+	for _, tt := range tests {
+		var got strings.Builder
+		for i := 0; i < len(tt.want); i++ {
+			if tt.sampler.allow("Processed metric") {
+				got.WriteByte('x')
+			} else {
+				got.WriteByte('.')
+			}
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got.String(), tt.want)
+		}
+	}
+}
+
+// Messages are counted separately, so a frequent line does not use up the
+// turns of a rarer one.
+func TestLogSampledPerMessage(t *testing.T) {
+	newTestAppState(t)
+	appState.logSampler = newLogSampler(4)
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	for i := 0; i < 8; i++ {
+		logSampled(logger, "Processed metric", "i", i)
+		logger.Warn("ANOMALY DETECTED!", "i", i)
+	}
+	logSampled(logger, "Redis counter incremented", "count", 1)
+
+	out := buf.String()
+	counts := map[string]int{
+		`msg="Processed metric"`:          2,
+		`msg="ANOMALY DETECTED!"`:         8,
+		`msg="Redis counter incremented"`: 1,
+		"log_sample_rate=4":               3,
+	}
+	for substr, want := range counts {
+		if got := strings.Count(out, substr); got != want {
+			t.Errorf("%s logged %d times, want %d", substr, got, want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestLRUEviction(t *testing.T) {
+	tests := []struct {
+		name    string
+		ops     []string // "+k" adds k, "?k" reads k
+		present []string
+		absent  []string
+	}{
+		{name: "under capacity", ops: []string{"+a", "+b"}, present: []string{"a", "b"}},
+		{name: "oldest evicted", ops: []string{"+a", "+b", "+c", "+d"}, present: []string{"b", "c", "d"}, absent: []string{"a"}},
+		{name: "read refreshes", ops: []string{"+a", "+b", "+c", "?a", "+d"}, present: []string{"a", "c", "d"}, absent: []string{"b"}},
+		{name: "re-add refreshes", ops: []string{"+a", "+b", "+c", "+a", "+d"}, present: []string{"a", "c", "d"}, absent: []string{"b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newLRU[string, int](3)
+			for i, op := range tt.ops {
+				if op[0] == '+' {
+					c.add(op[1:], i)
+				} else {
+					c.get(op[1:])
+				}
+			}
+			for _, k := range tt.present {
+				if _, ok := c.get(k); !ok {
+					t.Errorf("%q evicted, want kept", k)
+				}
+			}
+			for _, k := range tt.absent {
+				if _, ok := c.get(k); ok {
+					t.Errorf("%q kept, want evicted", k)
+				}
+			}
+			if c.len() > 3 {
+				t.Errorf("len = %d, want at most 3", c.len())
+			}
+		})
+	}
+}
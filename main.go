@@ -2,17 +2,29 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"math"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/procfs"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -20,32 +32,240 @@ type Metric struct {
 	Timestamp time.Time `json:"timestamp"`
 	CPU       float64   `json:"cpu"`
 	RPS       float64   `json:"rps"`
+	// RPSUnit and CPUUnit say which unit a client reported in; they are
+	// empty once the metric is normalized (see normalizeUnits).
+	RPSUnit string `json:"rps_unit,omitempty"`
+	CPUUnit string `json:"cpu_unit,omitempty"`
 }
 
 type AppState struct {
-	redisClient *redis.Client
-	mu          sync.Mutex
-	windowSize  int
+	// redisClient is a *redis.Client in production. Tests point it at
+	// miniredis (see newTestAppState), or wrap that client to fail or
+	// observe individual commands.
+	redisClient redis.UniversalClient
+	keyPrefix   string
+	// redisDown is set while the health monitor finds Redis unreachable;
+	// intake then buffers samples without trying Redis (see
+	// withRedisRetry).
+	redisDown atomic.Bool
+	// ready is set once bootstrapState has restored state from Redis.
+	ready atomic.Bool
+	// scriptingDisabled is set once the server rejects Lua scripts.
+	scriptingDisabled atomic.Bool
+	mu                sync.Mutex
+	clock             Clock
+	// cfgMu guards what PUT and PATCH /config and POST /config/reload can change
+	// at runtime: detector, quickDetector, windowSizes, minStdDev,
+	// severity, logSampler and settings, the configuration last loaded.
+	cfgMu      sync.RWMutex
+	settings   map[string]string
+	windowSize int
+	// windowSizes overrides windowSize per series (see WINDOW_SIZES).
+	windowSizes map[string]int
+	// rawRetention is how many raw samples are kept per stream; anything
+	// beyond the live window is eligible for /compact.
+	rawRetention       int
+	compactBucketSize  int
+	compactedRetention int
+	// deadLetters holds failed metrics while Redis cannot take them;
+	// guarded by mu.
+	deadLetters   []DeadLetter
+	deadLetterMax int
+	// anomalyRetention bounds the anomalies sorted set.
+	anomalyRetention int
+	// anomalyContexts keeps the window behind the most recent anomalies,
+	// keyed by anomaly ID (ANOMALY_CONTEXT_SIZE).
+	anomalyContexts *lru[string, AnomalyContext]
+	// anomalyHub fans new anomalies out to GET /anomalies/wait; at most
+	// cap(anomalyWaiters) requests wait at once (MAX_ANOMALY_WAITERS).
+	anomalyHub     *hub[AnomalyRecord]
+	anomalyWaiters chan struct{}
+	// redisRetry bounds the retries of sample writes (REDIS_RETRY_ATTEMPTS,
+	// REDIS_RETRY_BACKOFF, REDIS_RETRY_MAX_BACKOFF); each retry is counted
+	// in redisRetryCounter.
+	redisRetry        retryPolicy
+	redisRetryCounter prometheus.Counter
+	// logSampler thins routine per-metric log lines to 1 in
+	// LOG_SAMPLE_RATE; nil logs them all.
+	logSampler *logSampler
+	// workers process async /analyze metrics in per-stream order
+	// (ANALYZE_WORKERS queues of ANALYZE_QUEUE_SIZE).
+	workers *streamWorkers
+	// sampleRate is the fraction of samples written to Redis (SAMPLE_RATE);
+	// below 1 detection runs on the in-memory buffer.
+	sampleRate float64
+	// responsePrecision is the number of decimal places computed aggregates
+	// are rounded to in responses and gauges (RESPONSE_PRECISION).
+	responsePrecision int
+	// minSamples is how many RPS samples a window needs before detection
+	// runs (MIN_SAMPLES).
+	minSamples int
+	// gaugeHub fans gauge snapshots out to /metrics/stream clients, at most
+	// one per gaugeStreamInterval each; lastGauges is sent on connect.
+	gaugeHub            *hub[GaugeSnapshot]
+	lastGauges          atomic.Pointer[GaugeSnapshot]
+	gaugeStreamInterval time.Duration
+	// adminToken is the bearer token for admin endpoints (ADMIN_TOKEN);
+	// empty disables them.
+	adminToken string
+	// staticLimits are absolute per-series limits (CPU_MAX, RPS_MAX),
+	// checked independently of the statistical detectors.
+	staticLimits map[string]float64
+	// severity grades anomalies for logs, records and anomalyCounter
+	// (SEVERITY_WARNING_SCORE, SEVERITY_CRITICAL_SCORE).
+	severity SeverityCutoffs
+	// directions limits anomalies per series to spikes, dips or both
+	// (ANOMALY_DIRECTIONS). Fixed at startup.
+	directions map[string]Direction
+	// expectedIntervals is how often each stream should report
+	// (EXPECTED_INTERVALS); a longer gap than gapMultiplier times that is
+	// counted in sampleGapCounter by stream.
+	expectedIntervals map[string]time.Duration
+	gapMultiplier     float64
+	sampleGapCounter  *prometheus.CounterVec
+	// lastSeen is when each stream last received a sample, guarded by mu.
+	lastSeen map[string]time.Time
+	// paused holds the streams whose detection is suspended by
+	// POST /streams/{stream}/pause, guarded by mu.
+	paused map[string]bool
+	// maxBatchStreams bounds the streams in one /batch/analyze request
+	// (MAX_BATCH_STREAMS).
+	maxBatchStreams int
+	// maxBatchSamples bounds the metrics in one /analyze/batch request
+	// (MAX_BATCH_SAMPLES).
+	maxBatchSamples int
+	// fieldMapping renames the JSON keys /analyze reads Metric fields from
+	// (FIELD_MAPPING); nil keeps the default names.
+	fieldMapping map[string]string
+	// otlp maps OTLP metrics received on /v1/metrics to Metrics;
+	// otlpHold keeps samples waiting for their other field, which may come
+	// in a later export.
+	otlp     otlpMapping
+	otlpHold *pointHold
+	// remoteWrite maps Prometheus series received on /api/v1/write.
+	remoteWrite remoteWriteMapping
+	// remoteWriteHold keeps remote write samples waiting for their other
+	// field, as Prometheus sends CPU and RPS series in separate requests.
+	remoteWriteHold *pointHold
+	// codec serializes samples written to the raw lists (STORAGE_CODEC).
+	codec StorageCodec
+	// store holds the raw samples, in lists or Redis streams
+	// (STORAGE_BACKEND).
+	store MetricStore
+	// skewTolerance and skewPolicy bound how far a metric's timestamp may
+	// be from server time (CLOCK_SKEW_TOLERANCE, CLOCK_SKEW_POLICY).
+	skewTolerance time.Duration
+	skewPolicy    SkewPolicy
+	// stdDevMethod is the STDDEV_METHOD used by the z-score detectors.
+	stdDevMethod StdDevMethod
+	// minStdDev is the z-score standard deviation floor (MIN_STDDEV), kept
+	// for detectors built at runtime.
+	minStdDev float64
+	// weighting and weightingAlpha select the recency weighting of the
+	// weighted rolling average (ROLLING_AVG_WEIGHTING, ROLLING_AVG_ALPHA).
+	weighting      Weighting
+	weightingAlpha float64
+	// buffer mirrors recent samples per stream for the degraded path and
+	// the quick verdict; quickDetector scores against it.
+	quickDetector AnomalyDetector
+	buffer        *sampleBuffer
+	detector      AnomalyDetector
+	trend         AnomalyDetector
+	// divergence compares short- and long-window RPS averages; nil turns
+	// it off.
+	divergence *DivergenceDetector
+	// levelShift looks for sustained level shifts in each of
+	// levelShiftSeries (LEVEL_SHIFT_SERIES); no series turns it off.
+	levelShift       *CUSUMDetector
+	levelShiftSeries []string
+	// writeBehind batches sample writes through the buffer; nil writes
+	// each sample as it arrives (FLUSH_INTERVAL unset).
+	writeBehind *writeBehind
+	// recovery flushes the buffer when the Redis circuit breaker closes.
+	recovery *recoveryFlusher
+	// wsConns tracks open /ws/ingest connections for shutdown.
+	wsConns *wsConnSet
 	// Prometheus Metrics
-	requestCounter  prometheus.Counter
-	anomalyCounter  prometheus.Counter
-	cpuGauge        prometheus.Gauge
-	rpsGauge        prometheus.Gauge
-	rollingAvgGauge prometheus.Gauge
+	//
+	// Samples for different streams are processed concurrently, by parallel
+	// requests and by async /analyze, so every value that describes one
+	// stream is a vector labelled by stream: writers for different streams
+	// never touch the same series. Within a stream the last Set wins, which
+	// is the latest sample whenever a stream's samples arrive one at a time.
+	// Prometheus metrics are safe for concurrent use on their own; nothing
+	// here needs mu.
+	requestCounter   prometheus.Counter
+	anomalyCounter   *prometheus.CounterVec
+	cpuGauge         *prometheus.GaugeVec
+	rpsGauge         *prometheus.GaugeVec
+	rollingAvgGauge  *prometheus.GaugeVec
+	weightedAvgGauge *prometheus.GaugeVec
+	trendGauge       *prometheus.GaugeVec
+	// correlationGauge is the RPS/CPU correlation of each stream's window,
+	// 0 while either series is flat.
+	correlationGauge  *prometheus.GaugeVec
+	shortAvgGauge     *prometheus.GaugeVec
+	longAvgGauge      *prometheus.GaugeVec
+	divergenceCounter prometheus.Counter
+	// levelShiftCounter counts samples in a level shift by metric and
+	// direction.
+	levelShiftCounter *prometheus.CounterVec
+	trendCounter      prometheus.Counter
+	redisUpGauge      prometheus.Gauge
+	windowFillGauge   *prometheus.GaugeVec
+	// skewCounter counts metrics outside the skew tolerance by action,
+	// "rejected" or "clamped".
+	skewCounter *prometheus.CounterVec
+	// stalenessGauge is seconds since each stream's last sample.
+	stalenessGauge *prometheus.GaugeVec
+	// pausedGauge is 1 for each paused stream and 0 once resumed.
+	pausedGauge *prometheus.GaugeVec
+	// flushCounter counts the per-stream flushes of buffered samples by
+	// result, "ok" or "error"; flushedSamplesCounter the samples written.
+	flushCounter          *prometheus.CounterVec
+	flushedSamplesCounter prometheus.Counter
+	// recentAnomaliesGauge is the number of anomalies recorded within
+	// recentAnomalyWindow (ANOMALY_RECENT_WINDOW).
+	recentAnomaliesGauge prometheus.Gauge
+	recentAnomalyWindow  time.Duration
+	// thresholdBreachCounter counts static limit breaches by metric.
+	thresholdBreachCounter *prometheus.CounterVec
+	// breakerStateGauge follows gobreaker.State: 0 closed, 1 half-open, 2 open.
+	breakerStateGauge prometheus.Gauge
 }
 
 var appState *AppState
 
 func main() {
+	replayPath := flag.String("replay", "", "NDJSON `file` of metric records to feed through the pipeline at startup")
+	replayPaceFlag := flag.String("replay-pace", replayPaceFull, "pace of -replay: full, or original to wait as long as record timestamps are apart")
+	agentMode := flag.Bool("agent", false, "also sample and analyze the host's CPU, memory and the service's own request rate")
+	agentInterval := flag.Duration("agent-interval", 10*time.Second, "how often -agent samples the host")
+	flag.Parse()
+	replayPace, err := parseReplayPace(*replayPaceFlag)
+	if err != nil {
+		log.Fatalf("Invalid -replay-pace: %v", err)
+	}
+
+	strictStartup := false
+	if v := os.Getenv("STRICT_STARTUP"); v != "" {
+		var err error
+		if strictStartup, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid STRICT_STARTUP: %v", err)
+		}
+	}
+
 	redisAddr := getEnv("REDIS_ADDR", "redis-master.default.svc.cluster.local:6379")
 	redisPassword := getEnv("REDIS_PASSWORD", "")
+	redisDB := getEnvInt("REDIS_DB", 0)
+	keyPrefix := getEnv("REDIS_KEY_PREFIX", "")
 
-	log.Printf("Connecting to Redis at: %s", redisAddr)
+	log.Printf("Connecting to Redis at: %s (db %d, key prefix %q)", redisAddr, redisDB, keyPrefix)
 
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     redisAddr,
 		Password: redisPassword,
-		DB:       0,
+		DB:       redisDB,
 	})
 
 	ctx := context.Background()
@@ -65,72 +285,694 @@ func main() {
 		log.Printf("Warning: Could not establish Redis connection after retries")
 	}
 
-	requestCounter := promauto.NewCounter(prometheus.CounterOpts{
-		Name: "go_service_requests_total",
-		Help: "The total number of processed requests",
-	})
+	subsystem, ok := os.LookupEnv("METRICS_SUBSYSTEM")
+	if !ok {
+		subsystem = defaultMetricsSubsystem
+	}
+	metricsNamespace, metricsSubsystem, err = parseMetricsPrefix(os.Getenv("METRICS_NAMESPACE"), subsystem)
+	if err != nil {
+		log.Fatalf("Invalid metrics prefix: %v", err)
+	}
 
-	anomalyCounter := promauto.NewCounter(prometheus.CounterOpts{
-		Name: "go_service_anomalies_total",
-		Help: "The total number of detected anomalies",
-	})
+	requestCounter := promauto.NewCounter(counterOpts("requests_total", "The total number of processed requests"))
 
-	cpuGauge := promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "go_service_cpu_percent",
-		Help: "Current CPU usage percentage",
-	})
+	anomalyCounter := promauto.NewCounterVec(counterOpts("anomalies_total", "The total number of detected anomalies, by severity"), []string{"severity"})
+	for _, severity := range severities {
+		anomalyCounter.WithLabelValues(severity)
+	}
 
-	rpsGauge := promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "go_service_rps_current",
-		Help: "Current RPS value",
-	})
+	cpuGauge := promauto.NewGaugeVec(gaugeOpts("cpu_percent", "Current CPU usage percentage"), []string{"stream"})
+
+	rpsGauge := promauto.NewGaugeVec(gaugeOpts("rps_current", "Current RPS value"), []string{"stream"})
+
+	rollingAvgGauge := promauto.NewGaugeVec(gaugeOpts("rps_rolling_avg", "Rolling average of RPS values"), []string{"stream"})
+
+	weightedAvgGauge := promauto.NewGaugeVec(gaugeOpts("rps_weighted_avg", "Recency-weighted rolling average of RPS values"), []string{"stream"})
+
+	trendGauge := promauto.NewGaugeVec(gaugeOpts("rps_trend", "Least-squares slope of RPS over the window, per sample"), []string{"stream"})
+
+	correlationGauge := promauto.NewGaugeVec(gaugeOpts("rps_cpu_correlation", "Pearson correlation of RPS and CPU over the window, 0 while either is flat"), []string{"stream"})
+
+	shortAvgGauge := promauto.NewGaugeVec(gaugeOpts("rps_short_avg", "Average RPS over the divergence detector's short window"), []string{"stream"})
+
+	longAvgGauge := promauto.NewGaugeVec(gaugeOpts("rps_long_avg", "Average RPS over the divergence detector's long window"), []string{"stream"})
+
+	divergenceCounter := promauto.NewCounter(counterOpts("divergence_detections_total", "The total number of samples where the short and long RPS windows diverged"))
+
+	levelShiftCounter := promauto.NewCounterVec(counterOpts("level_shifts_total", "Samples during a sustained level shift found by CUSUM (LEVEL_SHIFT_SERIES), by metric and direction"), []string{"metric", "direction"})
+	for _, series := range knownSeries {
+		for _, direction := range []Direction{DirectionUp, DirectionDown} {
+			levelShiftCounter.WithLabelValues(series, string(direction))
+		}
+	}
+
+	redisUpGauge := promauto.NewGauge(gaugeOpts("redis_up", "Whether the last Redis health check succeeded (1) or failed (0)"))
+
+	trendCounter := promauto.NewCounter(counterOpts("trend_detections_total", "The total number of samples processed while RPS was drifting"))
+
+	windowFillGauge := promauto.NewGaugeVec(gaugeOpts("window_fill_ratio", "Samples in the RPS window divided by its configured size, capped at 1"), []string{"stream"})
+	// Export the default stream from the start so dashboards see 0 rather
+	// than a missing series before the first sample arrives.
+	windowFillGauge.WithLabelValues(defaultStream).Set(0)
+
+	skewCounter := promauto.NewCounterVec(counterOpts("clock_skew_total", "Metrics whose timestamp was outside CLOCK_SKEW_TOLERANCE, by action taken"), []string{"action"})
+
+	stalenessGauge := promauto.NewGaugeVec(gaugeOpts("seconds_since_last_sample", "Seconds since the stream last received a sample"), []string{"stream"})
+
+	pausedGauge := promauto.NewGaugeVec(gaugeOpts("stream_paused", "Whether anomaly detection is paused for the stream (1) or not (0)"), []string{"stream"})
+
+	flushCounter := promauto.NewCounterVec(counterOpts("buffer_flushes_total", "Per-stream flushes of buffered samples to Redis, by result"), []string{"result"})
+	for _, result := range []string{"ok", "error"} {
+		flushCounter.WithLabelValues(result)
+	}
+
+	flushedSamplesCounter := promauto.NewCounter(counterOpts("buffer_flushed_samples_total", "Buffered samples written to Redis by flushes"))
+
+	sampleGapCounter := promauto.NewCounterVec(counterOpts("sample_gaps_total", "Samples arriving more than GAP_MULTIPLIER expected intervals after the previous one, by stream"), []string{"stream"})
+
+	recentAnomaliesGauge := promauto.NewGauge(gaugeOpts("anomalies_recent", "Anomalies recorded within ANOMALY_RECENT_WINDOW; the anomalies_total counter is the source of truth"))
+
+	thresholdBreachCounter := promauto.NewCounterVec(counterOpts("threshold_breach_total", "Samples above a static limit (CPU_MAX, RPS_MAX), by metric"), []string{"metric"})
+
+	redisRetryCounter := promauto.NewCounter(counterOpts("redis_retries_total", "Sample writes retried after a transient Redis error"))
+
+	breakerStateGauge := promauto.NewGauge(gaugeOpts("redis_breaker_state", "State of the Redis circuit breaker: 0 closed, 1 half-open, 2 open"))
 
-	rollingAvgGauge := promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "go_service_rps_rolling_avg",
-		Help: "Rolling average of RPS values",
+	trend, err := newDetector(DetectorConfig{Type: "trend", Threshold: getEnvFloat("TREND_SLOPE_THRESHOLD", 1.0)})
+	if err != nil {
+		log.Fatalf("Invalid TREND_SLOPE_THRESHOLD: %v", err)
+	}
+
+	stdDevMethod, err := parseStdDevMethod(os.Getenv("STDDEV_METHOD"))
+	if err != nil {
+		log.Fatalf("Invalid STDDEV_METHOD: %v", err)
+	}
+
+	minStdDev := getEnvFloat("MIN_STDDEV", 0)
+	if minStdDev < 0 {
+		log.Fatalf("MIN_STDDEV must not be negative, got %v", minStdDev)
+	}
+	zscoreThreshold := getEnvFloat("ZSCORE_THRESHOLD", defaultZScoreThreshold)
+	if zscoreThreshold <= 0 {
+		log.Fatalf("ZSCORE_THRESHOLD must be positive, got %v", zscoreThreshold)
+	}
+	zscore := &ZScoreDetector{Threshold: zscoreThreshold, Method: stdDevMethod, MinStdDev: minStdDev}
+	detector := AnomalyDetector(zscore)
+	// The quick verdict stays a z-score whichever detector is live.
+	if mode := getEnv("DETECTOR", "zscore"); mode != "zscore" {
+		if detector, err = detectorFromEnv(mode); err != nil {
+			log.Fatalf("Invalid DETECTOR: %v", err)
+		}
+		log.Printf("Using %s detector", mode)
+	}
+	ensemble, err := ensembleFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid ENSEMBLE_DETECTORS: %v", err)
+	}
+	if ensemble != nil {
+		ensemble.Votes = promauto.NewCounterVec(counterOpts("detector_votes_total", "Samples each ensemble member flagged as anomalous"), []string{"detector"})
+		detector = ensemble
+		log.Printf("Using detector ensemble: %d members, %d votes needed", len(ensemble.Members), ensemble.MinVotes)
+	}
+
+	maxWindowSize := getEnvPositiveInt("MAX_WINDOW_SIZE", defaultMaxWindowSize)
+	windowSize := getEnvPositiveInt("WINDOW_SIZE", defaultWindowSize)
+	if windowSize > maxWindowSize {
+		log.Printf("Warning: WINDOW_SIZE %d capped at MAX_WINDOW_SIZE (%d)", windowSize, maxWindowSize)
+		windowSize = maxWindowSize
+	}
+	windowSizes, err := parseWindowSizes(os.Getenv("WINDOW_SIZES"), windowSize)
+	if err != nil {
+		log.Fatalf("Invalid WINDOW_SIZES: %v", err)
+	}
+	for _, series := range capWindowSizes(windowSizes, maxWindowSize) {
+		log.Printf("Warning: %s window size capped at MAX_WINDOW_SIZE (%d)", series, maxWindowSize)
+	}
+	if hw, ok := detector.(*HoltWintersDetector); ok && windowSizes["rps"] <= 2*hw.Season {
+		log.Printf("Warning: holtwinters needs an rps window over two seasons (%d samples), got %d; nothing will be flagged", 2*hw.Season, windowSizes["rps"])
+	}
+	longWindow := getEnvInt("DIVERGENCE_LONG_WINDOW", windowSizes["rps"])
+	if longWindow > maxWindowSize {
+		log.Printf("Warning: DIVERGENCE_LONG_WINDOW %d capped at MAX_WINDOW_SIZE (%d)", longWindow, maxWindowSize)
+		longWindow = maxWindowSize
+	}
+
+	divergence, err := newDetector(DetectorConfig{
+		Type:        "divergence",
+		Threshold:   getEnvFloat("DIVERGENCE_THRESHOLD", defaultDivergenceThreshold),
+		ShortWindow: getEnvInt("DIVERGENCE_SHORT_WINDOW", defaultShortWindow),
+		LongWindow:  longWindow,
 	})
+	if err != nil {
+		log.Fatalf("Invalid DIVERGENCE_* settings: %v", err)
+	}
+
+	levelShiftSeries, err := parseLevelShiftSeries(os.Getenv("LEVEL_SHIFT_SERIES"))
+	if err != nil {
+		log.Fatalf("Invalid LEVEL_SHIFT_SERIES: %v", err)
+	}
+	cusumConfig, err := detectorConfigFromEnv("cusum")
+	if err != nil {
+		log.Fatalf("Invalid CUSUM_* settings: %v", err)
+	}
+	levelShift, err := newDetector(cusumConfig)
+	if err != nil {
+		log.Fatalf("Invalid CUSUM_* settings: %v", err)
+	}
 
 	appState = &AppState{
-		redisClient:     rdb,
-		windowSize:      50,
-		requestCounter:  requestCounter,
-		anomalyCounter:  anomalyCounter,
-		cpuGauge:        cpuGauge,
-		rpsGauge:        rpsGauge,
-		rollingAvgGauge: rollingAvgGauge,
-	}
-
-	// HTTP Handlers
-	http.HandleFunc("/", rootHandler)
-	http.HandleFunc("/metrics", handleMetrics)
-	http.HandleFunc("/analyze", handleAnalyze)
-	http.HandleFunc("/count", countHandler)
-	http.HandleFunc("/health", healthHandler)
+		redisClient:        rdb,
+		keyPrefix:          keyPrefix,
+		clock:              realClock{},
+		stdDevMethod:       stdDevMethod,
+		windowSize:         windowSize,
+		windowSizes:        windowSizes,
+		compactBucketSize:  getEnvPositiveInt("COMPACT_BUCKET_SIZE", 10),
+		compactedRetention: getEnvPositiveInt("COMPACTED_RETENTION", 1000),
+		deadLetterMax:      getEnvPositiveInt("DEADLETTER_MAX", 10000),
+		anomalyRetention:   getEnvPositiveInt("ANOMALY_RETENTION", 10000),
+		anomalyContexts:    newLRU[string, AnomalyContext](getEnvPositiveInt("ANOMALY_CONTEXT_SIZE", 100)),
+		maxBatchStreams:    getEnvPositiveInt("MAX_BATCH_STREAMS", defaultMaxBatchStreams),
+		maxBatchSamples:    getEnvPositiveInt("MAX_BATCH_SAMPLES", defaultMaxBatchSamples),
+		redisRetry: retryPolicy{
+			Attempts: getEnvPositiveInt("REDIS_RETRY_ATTEMPTS", 3),
+			Base:     getEnvDuration("REDIS_RETRY_BACKOFF", 50*time.Millisecond),
+			Max:      getEnvDuration("REDIS_RETRY_MAX_BACKOFF", time.Second),
+		},
+		redisRetryCounter:      redisRetryCounter,
+		logSampler:             newLogSampler(getEnvPositiveInt("LOG_SAMPLE_RATE", 1)),
+		workers:                newStreamWorkers(getEnvPositiveInt("ANALYZE_WORKERS", runtime.GOMAXPROCS(0)), getEnvPositiveInt("ANALYZE_QUEUE_SIZE", 1000)),
+		anomalyHub:             newHub[AnomalyRecord](),
+		anomalyWaiters:         make(chan struct{}, getEnvPositiveInt("MAX_ANOMALY_WAITERS", 100)),
+		recovery:               newRecoveryFlusher(),
+		wsConns:                newWSConnSet(),
+		gaugeHub:               newHub[GaugeSnapshot](),
+		gaugeStreamInterval:    getEnvDuration("METRICS_STREAM_INTERVAL", time.Second),
+		detector:               detector,
+		quickDetector:          zscore,
+		settings:               environ(),
+		minStdDev:              minStdDev,
+		trend:                  trend,
+		divergence:             divergence.(*DivergenceDetector),
+		levelShift:             levelShift.(*CUSUMDetector),
+		levelShiftSeries:       levelShiftSeries,
+		requestCounter:         requestCounter,
+		anomalyCounter:         anomalyCounter,
+		cpuGauge:               cpuGauge,
+		rpsGauge:               rpsGauge,
+		rollingAvgGauge:        rollingAvgGauge,
+		weightedAvgGauge:       weightedAvgGauge,
+		trendGauge:             trendGauge,
+		correlationGauge:       correlationGauge,
+		shortAvgGauge:          shortAvgGauge,
+		longAvgGauge:           longAvgGauge,
+		divergenceCounter:      divergenceCounter,
+		levelShiftCounter:      levelShiftCounter,
+		trendCounter:           trendCounter,
+		redisUpGauge:           redisUpGauge,
+		windowFillGauge:        windowFillGauge,
+		skewCounter:            skewCounter,
+		stalenessGauge:         stalenessGauge,
+		pausedGauge:            pausedGauge,
+		flushCounter:           flushCounter,
+		flushedSamplesCounter:  flushedSamplesCounter,
+		recentAnomaliesGauge:   recentAnomaliesGauge,
+		sampleGapCounter:       sampleGapCounter,
+		recentAnomalyWindow:    getEnvDuration("ANOMALY_RECENT_WINDOW", 5*time.Minute),
+		thresholdBreachCounter: thresholdBreachCounter,
+		breakerStateGauge:      breakerStateGauge,
+	}
+	appState.weighting, err = parseWeighting(os.Getenv("ROLLING_AVG_WEIGHTING"))
+	if err != nil {
+		log.Fatalf("Invalid ROLLING_AVG_WEIGHTING: %v", err)
+	}
+	appState.weightingAlpha = getEnvFloat("ROLLING_AVG_ALPHA", defaultEWMAAlpha)
+	if appState.weightingAlpha <= 0 || appState.weightingAlpha > 1 {
+		log.Fatalf("ROLLING_AVG_ALPHA must be in (0, 1], got %v", appState.weightingAlpha)
+	}
+	appState.adminToken = os.Getenv("ADMIN_TOKEN")
+	appState.staticLimits, err = staticLimitsFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid static limit: %v", err)
+	}
+	appState.directions, err = parseDirections(os.Getenv("ANOMALY_DIRECTIONS"))
+	if err != nil {
+		log.Fatalf("Invalid ANOMALY_DIRECTIONS: %v", err)
+	}
+	appState.expectedIntervals, err = parseExpectedIntervals(os.Getenv("EXPECTED_INTERVALS"))
+	if err != nil {
+		log.Fatalf("Invalid EXPECTED_INTERVALS: %v", err)
+	}
+	appState.severity, err = newSeverityCutoffs(
+		getEnvFloat("SEVERITY_WARNING_SCORE", defaultSeverityWarning),
+		getEnvFloat("SEVERITY_CRITICAL_SCORE", defaultSeverityCritical))
+	if err != nil {
+		log.Fatalf("Invalid severity cutoffs: %v", err)
+	}
+	appState.gapMultiplier = getEnvFloat("GAP_MULTIPLIER", defaultGapMultiplier)
+	if appState.gapMultiplier < 1 {
+		log.Fatalf("GAP_MULTIPLIER must be at least 1, got %v", appState.gapMultiplier)
+	}
+	appState.fieldMapping, err = parseFieldMapping(os.Getenv("FIELD_MAPPING"))
+	if err != nil {
+		log.Fatalf("Invalid FIELD_MAPPING: %v", err)
+	}
+	appState.otlp = otlpMapping{
+		CPUMetric:       getEnv("OTLP_CPU_METRIC", defaultOTLPCPUMetric),
+		RPSMetric:       getEnv("OTLP_RPS_METRIC", defaultOTLPRPSMetric),
+		StreamAttribute: getEnv("OTLP_STREAM_ATTRIBUTE", defaultOTLPStreamAttribute),
+	}
+	appState.otlpHold = newPointHold(pointJoinWindow)
+	appState.remoteWrite = remoteWriteMapping{
+		CPUMetric:   os.Getenv("REMOTE_WRITE_CPU_METRIC"),
+		RPSMetric:   os.Getenv("REMOTE_WRITE_RPS_METRIC"),
+		StreamLabel: getEnv("REMOTE_WRITE_STREAM_LABEL", defaultRemoteWriteStreamLabel),
+	}
+	appState.remoteWriteHold = newPointHold(pointJoinWindow)
+	appState.codec, err = parseStorageCodec(os.Getenv("STORAGE_CODEC"))
+	if err != nil {
+		log.Fatalf("Invalid STORAGE_CODEC: %v", err)
+	}
+	backend, err := parseStorageBackend(os.Getenv("STORAGE_BACKEND"))
+	if err != nil {
+		log.Fatalf("Invalid STORAGE_BACKEND: %v", err)
+	}
+	appState.store = newMetricStore(backend)
+	appState.skewTolerance = getEnvDuration("CLOCK_SKEW_TOLERANCE", 5*time.Minute)
+	appState.skewPolicy, err = parseSkewPolicy(os.Getenv("CLOCK_SKEW_POLICY"))
+	if err != nil {
+		log.Fatalf("Invalid CLOCK_SKEW_POLICY: %v", err)
+	}
+	appState.rawRetention = getEnvPositiveInt("RAW_RETENTION", 10*appState.maxWindow())
+	if appState.rawRetention < appState.maxWindow() {
+		log.Fatalf("RAW_RETENTION (%d) must be at least the largest window size (%d)", appState.rawRetention, appState.maxWindow())
+	}
+	appState.sampleRate = getEnvFloat("SAMPLE_RATE", 1.0)
+	if appState.sampleRate < 0 || appState.sampleRate > 1 {
+		log.Fatalf("SAMPLE_RATE must be between 0.0 and 1.0, got %v", appState.sampleRate)
+	}
+	appState.responsePrecision = getEnvInt("RESPONSE_PRECISION", 4)
+	if appState.responsePrecision < 0 || appState.responsePrecision > maxResponsePrecision {
+		log.Fatalf("RESPONSE_PRECISION must be between 0 and %d, got %d", maxResponsePrecision, appState.responsePrecision)
+	}
+	nonFiniteAsZero, err = parseNonFinite(os.Getenv("JSON_NON_FINITE"))
+	if err != nil {
+		log.Fatalf("Invalid JSON_NON_FINITE: %v", err)
+	}
+	appState.minSamples = getEnvPositiveInt("MIN_SAMPLES", 10)
+	if appState.minSamples > appState.windowFor("rps") {
+		log.Fatalf("MIN_SAMPLES (%d) must not exceed the rps window size (%d)", appState.minSamples, appState.windowFor("rps"))
+	}
+	appState.buffer = newSampleBuffer(appState.maxWindow())
+	prometheus.MustRegister(newWindowCollector(appState.buffer))
+	promauto.NewGaugeFunc(gaugeOpts("buffer_pending_samples", "Samples in the in-memory buffer waiting to be written to Redis"),
+		func() float64 { return float64(appState.buffer.pendingCount()) })
+	if interval := getEnvDuration("FLUSH_INTERVAL", 0); interval > 0 {
+		batchSize := getEnvPositiveInt("FLUSH_BATCH_SIZE", appState.buffer.size)
+		// The buffer holds at most its size in pending samples per stream,
+		// dropping the oldest, so a batch must fill up before that.
+		if batchSize > appState.buffer.size {
+			log.Fatalf("FLUSH_BATCH_SIZE (%d) must not exceed the largest window size (%d)", batchSize, appState.buffer.size)
+		}
+		appState.writeBehind = newWriteBehind(interval, batchSize)
+		go runFlusher(context.Background(), appState.writeBehind)
+		log.Printf("Write-behind enabled: flushing every %s or %d samples", interval, batchSize)
+	}
+	// Installed after the startup retries so those are never short-circuited.
+	rdb.AddHook(redisBreakerHook{cb: newRedisBreaker(
+		uint32(getEnvPositiveInt("REDIS_BREAKER_FAILURES", 5)),
+		getEnvDuration("REDIS_BREAKER_TIMEOUT", 30*time.Second),
+		appState.recovery.trigger,
+	)})
+	appState.redisDown.Store(!redisConnected)
+	if redisConnected {
+		redisUpGauge.Set(1)
+	}
+	go monitorRedis(context.Background(), getEnvDuration("REDIS_HEALTH_INTERVAL", 5*time.Second))
+	go monitorStaleness(context.Background(), getEnvDuration("STALENESS_INTERVAL", 10*time.Second))
+	go monitorRecentAnomalies(context.Background(), getEnvDuration("ANOMALY_RECENT_INTERVAL", 15*time.Second))
+
+	tlsConf, err := newTLSSettings(os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"), os.Getenv("TLS_MIN_VERSION"))
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+
+	cors, err := newCORSPolicy(os.Getenv("CORS_ALLOWED_ORIGINS"), os.Getenv("CORS_ALLOW_CREDENTIALS") == "true")
+	if err != nil {
+		log.Fatalf("Invalid CORS_ALLOWED_ORIGINS: %v", err)
+	}
+
+	// With ADMIN_PORT set the admin routes get a server of their own;
+	// otherwise both sets share the one mux.
+	mux := http.NewServeMux()
+	registerDataRoutes(mux)
+	adminPort := os.Getenv("ADMIN_PORT")
+	adminMux := mux
+	if adminPort != "" {
+		adminMux = http.NewServeMux()
+	}
+	registerAdminRoutes(adminMux)
+	if os.Getenv("ENABLE_PPROF") == "true" {
+		registerPprof(adminMux)
+		log.Printf("pprof handlers enabled under /debug/pprof/")
+	}
+	if os.Getenv("ENABLE_BENCHMARK") == "true" {
+		registerBenchmark(adminMux)
+		log.Printf("Detector benchmark enabled at POST /benchmark-internal")
+	}
+	if os.Getenv("ENABLE_DASHBOARD") == "true" {
+		registerDashboard(mux)
+		log.Printf("Status dashboard enabled at GET /dashboard")
+	}
 
 	port := getEnv("PORT", "8080")
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
-}
+	if adminPort == port {
+		log.Fatalf("ADMIN_PORT must differ from PORT (%s)", port)
+	}
+	// With -agent, the service also analyzes the host it runs on, counting
+	// the requests it serves itself.
+	handler := withRequestID(withCORS(cors, mux))
+	var agent *hostAgent
+	if *agentMode {
+		if *agentInterval <= 0 {
+			log.Fatalf("-agent-interval must be positive, got %v", *agentInterval)
+		}
+		agent, err = newHostAgent(procfs.DefaultMountPoint, *agentInterval)
+		if err != nil {
+			log.Fatalf("Agent mode needs a readable %s: %v", procfs.DefaultMountPoint, err)
+		}
+		handler = agent.countRequests(handler)
+		log.Printf("Agent mode: sampling the host every %v into streams %s, %s and %s", *agentInterval, agentRequestsStream, agentCPUStream, agentMemoryStream)
+	}
+	servers := []*http.Server{{
+		Addr:    ":" + port,
+		Handler: handler,
+	}}
+	// /ws/ingest connections are hijacked, so Shutdown leaves them to us.
+	servers[0].RegisterOnShutdown(appState.wsConns.shutdown)
+	if adminPort != "" {
+		servers = append(servers, &http.Server{
+			Addr:    ":" + adminPort,
+			Handler: withRequestID(adminMux),
+		})
+	}
+	for _, srv := range servers {
+		if tlsConf.enabled() {
+			log.Printf("Server starting on %s (TLS)", srv.Addr)
+		} else {
+			log.Printf("Server starting on %s", srv.Addr)
+		}
+	}
 
-func rootHandler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownTimeout := getEnvDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
+
+	// Short-lived runs may never be scraped, so optionally push to a
+	// Pushgateway: periodically if PUSHGATEWAY_INTERVAL is set, and always
+	// once on the way out.
+	var pusher *push.Pusher
+	if url := os.Getenv("PUSHGATEWAY_URL"); url != "" {
+		pusher = newPusher(url, getEnv("PUSHGATEWAY_JOB", "go-service"), prometheus.DefaultGatherer)
+		if interval := getEnvDuration("PUSHGATEWAY_INTERVAL", 0); interval > 0 {
+			go pushPeriodically(ctx, pusher, interval)
+		}
+		log.Printf("Pushing metrics to Pushgateway at %s", url)
+	}
+
+	// Checked once every setting has been read, so one run reports them all.
+	if err := startupProblems(redisConnected, redisAddr); err != nil && strictStartup {
+		log.Fatalf("STRICT_STARTUP is set, refusing to start:\n%v", err)
+	}
+
+	bootstrapCtx, cancelBootstrap := context.WithTimeout(ctx, getEnvDuration("BOOTSTRAP_TIMEOUT", 10*time.Second))
+	go func() {
+		defer cancelBootstrap()
+		bootstrapState(bootstrapCtx)
+	}()
+
+	// The gRPC API (GRPC_PORT) shares the lifecycle of the HTTP servers.
+	var grpcDone chan error
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		if grpcPort == port || grpcPort == adminPort {
+			log.Fatalf("GRPC_PORT must differ from PORT and ADMIN_PORT (%s)", grpcPort)
+		}
+		lis, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatalf("Invalid GRPC_PORT: %v", err)
+		}
+		grpcSrv, err := newGRPCServer(tlsConf)
+		if err != nil {
+			log.Fatalf("Invalid TLS configuration for gRPC: %v", err)
+		}
+		log.Printf("gRPC server starting on %s", lis.Addr())
+		grpcDone = make(chan error, 1)
+		go func() { grpcDone <- runGRPCServer(ctx, grpcSrv, lis, shutdownTimeout) }()
+	}
+
+	// Message broker consumers run until ctx is done; consumers tracks
+	// them so in-flight samples are processed before shutdown completes.
+	var consumers sync.WaitGroup
+
+	// With KAFKA_BROKERS set, metrics are also consumed from KAFKA_TOPIC.
+	if spec := os.Getenv("KAFKA_BROKERS"); spec != "" {
+		brokers, err := parseKafkaBrokers(spec)
+		if err != nil {
+			log.Fatalf("Invalid KAFKA_BROKERS: %v", err)
+		}
+		topic := os.Getenv("KAFKA_TOPIC")
+		if topic == "" {
+			log.Fatalf("KAFKA_TOPIC is required when KAFKA_BROKERS is set")
+		}
+		groupID := getEnv("KAFKA_GROUP_ID", "go-service")
+		messages := promauto.NewCounterVec(counterOpts("kafka_messages_total", "Metric messages consumed from Kafka, by result"), []string{"result"})
+		for _, result := range []string{"processed", "invalid", "error"} {
+			messages.WithLabelValues(result)
+		}
+		consumer := newKafkaConsumer(brokers, topic, groupID, messages)
+		log.Printf("Consuming metrics from Kafka topic %s as group %s", topic, groupID)
+		consumers.Go(func() { consumer.run(ctx) })
 	}
 
+	// With NATS_URL set, metrics are also consumed from JetStream.
+	if url := os.Getenv("NATS_URL"); url != "" {
+		natsStream := os.Getenv("NATS_STREAM")
+		if natsStream == "" {
+			log.Fatalf("NATS_STREAM is required when NATS_URL is set")
+		}
+		subject := getEnv("NATS_SUBJECT", "metrics.>")
+		durable := getEnv("NATS_DURABLE", "go-service")
+		messages := promauto.NewCounterVec(counterOpts("nats_messages_total", "Metric messages consumed from NATS JetStream, by result"), []string{"result"})
+		for _, result := range []string{"processed", "invalid", "error"} {
+			messages.WithLabelValues(result)
+		}
+		sub, err := newNATSSubscriber(ctx, url, natsStream, subject, durable, messages)
+		if err != nil {
+			log.Fatalf("Invalid NATS configuration: %v", err)
+		}
+		log.Printf("Consuming metrics from NATS stream %s (%s) as %s", natsStream, subject, durable)
+		consumers.Go(func() {
+			if err := sub.run(ctx); err != nil {
+				log.Printf("NATS subscriber stopped: %v", err)
+			}
+		})
+	}
+
+	// With MQTT_BROKER set, metrics are also taken from MQTT publishers.
+	if broker := os.Getenv("MQTT_BROKER"); broker != "" {
+		qos, err := parseMQTTQoS(os.Getenv("MQTT_QOS"))
+		if err != nil {
+			log.Fatalf("Invalid MQTT_QOS: %v", err)
+		}
+		topic := getEnv("MQTT_TOPIC", "metrics/#")
+		messages := promauto.NewCounterVec(counterOpts("mqtt_messages_total", "Metric messages received over MQTT, by result"), []string{"result"})
+		for _, result := range []string{"processed", "invalid", "error"} {
+			messages.WithLabelValues(result)
+		}
+		sub := newMQTTSubscriber(broker, topic, getEnv("MQTT_CLIENT_ID", defaultMQTTClientID()), qos, messages)
+		log.Printf("Subscribing to MQTT topic %s on %s", topic, broker)
+		consumers.Go(func() { sub.run(ctx) })
+	}
+
+	// With STATSD_ADDR set, StatsD lines are aggregated into samples.
+	if addr := os.Getenv("STATSD_ADDR"); addr != "" {
+		interval := getEnvDuration("STATSD_FLUSH_INTERVAL", 10*time.Second)
+		lines := promauto.NewCounterVec(counterOpts("statsd_lines_total", "StatsD lines received, by result"), []string{"result"})
+		for _, result := range []string{"accepted", "invalid"} {
+			lines.WithLabelValues(result)
+		}
+		listener, err := newStatsdListener(addr, interval, lines)
+		if err != nil {
+			log.Fatalf("Invalid STATSD_ADDR: %v", err)
+		}
+		log.Printf("StatsD listener on %s (udp), flushing every %v", listener.conn.LocalAddr(), interval)
+		consumers.Go(func() { listener.run(ctx) })
+	}
+
+	// With GRAPHITE_ADDR set, Graphite plaintext lines are mapped to
+	// samples by GRAPHITE_MAPPING.
+	if addr := os.Getenv("GRAPHITE_ADDR"); addr != "" {
+		spec := os.Getenv("GRAPHITE_MAPPING")
+		if spec == "" {
+			log.Fatalf("GRAPHITE_MAPPING is required when GRAPHITE_ADDR is set")
+		}
+		patterns, err := parseGraphiteMapping(spec)
+		if err != nil {
+			log.Fatalf("Invalid GRAPHITE_MAPPING: %v", err)
+		}
+		interval := getEnvDuration("GRAPHITE_FLUSH_INTERVAL", 10*time.Second)
+		lines := promauto.NewCounterVec(counterOpts("graphite_lines_total", "Graphite plaintext lines received, by result"), []string{"result"})
+		for _, result := range []string{"accepted", "ignored", "invalid"} {
+			lines.WithLabelValues(result)
+		}
+		listener, err := newGraphiteListener(addr, patterns, interval, lines)
+		if err != nil {
+			log.Fatalf("Invalid GRAPHITE_ADDR: %v", err)
+		}
+		log.Printf("Graphite listener on %s (tcp), flushing every %v", listener.ln.Addr(), interval)
+		consumers.Go(func() { listener.run(ctx) })
+	}
+
+	// With REDIS_INGEST_STREAM set, metrics are also consumed from that
+	// Redis stream as a member of REDIS_INGEST_GROUP.
+	if key := os.Getenv("REDIS_INGEST_STREAM"); key != "" {
+		messages := promauto.NewCounterVec(counterOpts("redis_ingest_messages_total", "Metric entries consumed from REDIS_INGEST_STREAM, by result"), []string{"result"})
+		for _, result := range []string{"processed", "invalid", "error"} {
+			messages.WithLabelValues(result)
+		}
+		consumer := &redisIngestConsumer{
+			client:    rdb,
+			key:       key,
+			group:     getEnv("REDIS_INGEST_GROUP", "go-service"),
+			consumer:  getEnv("REDIS_INGEST_CONSUMER", defaultRedisIngestConsumer()),
+			claimIdle: getEnvDuration("REDIS_INGEST_CLAIM_IDLE", time.Minute),
+			messages:  messages,
+		}
+		if consumer.claimIdle <= 0 {
+			log.Fatalf("REDIS_INGEST_CLAIM_IDLE must be positive, got %v", consumer.claimIdle)
+		}
+		log.Printf("Consuming metrics from Redis stream %s as %s of group %s", key, consumer.consumer, consumer.group)
+		consumers.Go(func() { consumer.run(ctx) })
+	}
+
+	// With SCRAPE_TARGETS set, targets that cannot push are scraped.
+	if spec := os.Getenv("SCRAPE_TARGETS"); spec != "" {
+		targets, err := parseScrapeTargets(spec)
+		if err != nil {
+			log.Fatalf("Invalid SCRAPE_TARGETS: %v", err)
+		}
+		cpuMetric, rpsMetric := os.Getenv("SCRAPE_CPU_METRIC"), os.Getenv("SCRAPE_RPS_METRIC")
+		if cpuMetric == "" && rpsMetric == "" {
+			log.Fatalf("SCRAPE_CPU_METRIC or SCRAPE_RPS_METRIC is required when SCRAPE_TARGETS is set")
+		}
+		interval := getEnvDuration("SCRAPE_INTERVAL", 15*time.Second)
+		if interval <= 0 {
+			log.Fatalf("SCRAPE_INTERVAL must be positive, got %v", interval)
+		}
+		scrapes := promauto.NewCounterVec(counterOpts("scrapes_total", "Scrapes of SCRAPE_TARGETS, by result"), []string{"result"})
+		for _, result := range []string{"success", "failed"} {
+			scrapes.WithLabelValues(result)
+		}
+		s := newScraper(targets, cpuMetric, rpsMetric, interval, scrapes)
+		log.Printf("Scraping %d targets every %v", len(targets), interval)
+		consumers.Go(func() { s.run(ctx) })
+	}
+
+	if agent != nil {
+		consumers.Go(func() { agent.run(ctx) })
+	}
+
+	// With -replay, a file of recorded metrics is fed through the pipeline
+	// once bootstrapping is done. The service keeps serving afterwards, so
+	// the outcome can be inspected through the API.
+	if *replayPath != "" {
+		f, err := os.Open(*replayPath)
+		if err != nil {
+			log.Fatalf("Invalid -replay: %v", err)
+		}
+		log.Printf("Replaying %s at %s pace", *replayPath, replayPace)
+		consumers.Go(func() {
+			defer f.Close()
+			<-bootstrapCtx.Done()
+			start := time.Now()
+			res, err := replayFile(ctx, slog.Default().With("transport", "replay"), f, replayPace)
+			if err != nil {
+				log.Printf("Replay of %s stopped: %v", *replayPath, err)
+			}
+			log.Printf("Replay of %s done in %v: %d records, %d processed, %d invalid, %d failed, %d anomalies",
+				*replayPath, time.Since(start).Round(time.Millisecond), res.Records, res.Processed, res.Invalid, res.Failed, res.Anomalies)
+		})
+	}
+
+	err = runServers(ctx, tlsConf, shutdownTimeout, servers...)
+	// The servers may also stop on their own; take everything else down
+	// with them.
+	stop()
+	if grpcDone != nil {
+		if grpcErr := <-grpcDone; err == nil {
+			err = grpcErr
+		}
+	}
+	consumers.Wait()
+	if !appState.wsConns.wait(shutdownTimeout) {
+		log.Printf("WebSocket ingest connections still open after %v", shutdownTimeout)
+	}
+	appState.workers.stop()
+	flushOnShutdown(shutdownTimeout)
+	if pusher != nil {
+		pushMetrics(pusher, shutdownTimeout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func rootHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
 	w.Write([]byte("Go Streaming Analytics Service\n\n"))
 	w.Write([]byte("Available endpoints:\n"))
-	w.Write([]byte("POST /analyze - Submit metrics for analysis\n"))
-	w.Write([]byte("GET  /metrics - Prometheus metrics\n"))
-	w.Write([]byte("GET  /count   - Get request count\n"))
-	w.Write([]byte("GET  /health  - Health check\n"))
+	w.Write([]byte("POST /analyze/{stream}        - Submit metrics for analysis (?sync=true waits for the verdict)\n"))
+	w.Write([]byte("POST /analyze/batch           - Submit an array of metrics for one stream (?stream=), returns the verdict\n"))
+	w.Write([]byte("POST /batch/analyze           - Submit one metric per stream in one call, returns verdicts\n"))
+	w.Write([]byte("POST /analyze/stream          - Long-lived NDJSON body (?stream=), each line answered with its verdict as it arrives\n"))
+	w.Write([]byte("GET  /ws/ingest               - WebSocket, one metric per frame (?stream=), each answered with its verdict\n"))
+	w.Write([]byte("POST /ingest/csv/{stream}     - Backfill from a CSV of timestamp,cpu,rps rows, replayed in timestamp order\n"))
+	w.Write([]byte("POST /v1/metrics              - OTLP/HTTP metrics receiver (protobuf or JSON)\n"))
+	w.Write([]byte("POST /api/v1/write            - Prometheus remote write receiver\n"))
+	w.Write([]byte("POST /write                   - InfluxDB line protocol (cpu and rps fields, ?precision=)\n"))
+	w.Write([]byte("GET  /history/{stream}        - Recent raw samples (alias /metrics/raw/{stream})\n"))
+	w.Write([]byte("GET  /topk/{stream}           - Highest samples in the window (?metric=rps|cpu&n=5)\n"))
+	w.Write([]byte("GET  /calibrate/{stream}      - Suggest a z-score threshold (?target_rate=0.01)\n"))
+	w.Write([]byte("GET  /metrics                 - Prometheus metrics\n"))
+	w.Write([]byte("GET  /metrics/stream          - Live gauge snapshots (server-sent events)\n"))
+	w.Write([]byte("GET  /metrics/stream/{stream} - Window stats of one stream in Prometheus format\n"))
+	w.Write([]byte("GET  /count                   - Get request count\n"))
+	w.Write([]byte("GET  /health                  - Health check (?verbose=true adds runtime stats)\n"))
+	w.Write([]byte("GET  /ready                   - Readiness, 503 until state is restored from Redis\n"))
+	w.Write([]byte("POST /simulate                - Feed synthetic metrics through the pipeline (admin token)\n"))
+	w.Write([]byte("PATCH /config                 - Tune detector, threshold and window size live (admin token)\n"))
+	w.Write([]byte("PUT  /config                  - Replace detector, threshold and window size live (admin token)\n"))
+	w.Write([]byte("POST /config/reload           - Re-read env and CONFIG_FILE, apply what can change live (admin token)\n"))
+	w.Write([]byte("POST /replay                  - Dry-run detection over historical metrics\n"))
+	w.Write([]byte("POST /compact/{stream}        - Downsample raw samples older than the window\n"))
+	w.Write([]byte("GET  /deadletter              - List metrics that failed processing\n"))
+	w.Write([]byte("POST /deadletter/retry        - Reprocess dead-lettered metrics\n"))
+	w.Write([]byte("GET  /anomalies               - Recorded anomalies (?from=&to=&limit=&offset=)\n"))
+	w.Write([]byte("GET  /anomalies/{id}/context  - Window and stats behind a recent anomaly\n"))
+	w.Write([]byte("GET  /anomalies/wait          - Long-poll for the next anomaly (?timeout=30s), 204 on timeout\n"))
 }
 
+// healthHandler reports service and Redis health. ?verbose=true adds
+// goroutine, memory and GC stats; probes should leave it off to keep the
+// body small and avoid the ReadMemStats pause.
 func healthHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	verbose := false
+	if v := r.URL.Query().Get("verbose"); v != "" {
+		var err error
+		if verbose, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "verbose must be a boolean")
+			return
+		}
 	}
 
 	ctx := context.Background()
@@ -140,141 +982,196 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 		redisStatus = "unhealthy"
 	}
 
-	response := map[string]string{
+	response := map[string]interface{}{
 		"status":    "healthy",
 		"redis":     redisStatus,
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"timestamp": appState.now().UTC().Format(time.RFC3339),
+	}
+	if verbose {
+		response["runtime"] = readRuntimeStats()
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	encodeJSON(w, response)
 }
 
+// countHandler reports the request counter. A missing key just means no
+// request has been counted yet; it is not written here, since a SET racing
+// with an /analyze INCR could reset the count.
 func countHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	ctx := context.Background()
+	logger := loggerFrom(r.Context())
+	count, err := appState.redisClient.Get(ctx, appState.key("request_count")).Int()
+	if err != nil && err != redis.Nil {
+		logger.Error("Redis GET error", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Error retrieving count")
 		return
 	}
 
-	ctx := context.Background()
-	count, err := appState.redisClient.Get(ctx, "request_count").Int()
+	writeBody(w, r, http.StatusOK, map[string]int{"count": count})
+}
+
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
 	if err != nil {
-		if err == redis.Nil {
-			err := appState.redisClient.Set(ctx, "request_count", 0, 0).Err()
-			if err != nil {
-				log.Printf("Redis SET error: %v", err)
-				http.Error(w, "Error initializing count", http.StatusInternalServerError)
-				return
-			}
-			count = 0
-		} else {
-			log.Printf("Redis GET error: %v", err)
-			http.Error(w, "Error retrieving count", http.StatusInternalServerError)
-			return
-		}
+		invalidEnv(key, value, defaultValue)
+		return defaultValue
 	}
+	return n
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	response := map[string]int{"count": count}
-	json.NewEncoder(w).Encode(response)
+// getEnvPositiveInt is getEnvInt for settings where zero or a negative value
+// would break the service; such values stop startup instead of being used.
+func getEnvPositiveInt(key string, defaultValue int) int {
+	n := getEnvInt(key, defaultValue)
+	if n <= 0 {
+		log.Fatalf("%s must be a positive integer, got %d", key, n)
+	}
+	return n
 }
 
-func getEnv(key, defaultValue string) string {
+func getEnvFloat(key string, defaultValue float64) float64 {
 	value := os.Getenv(key)
 	if value == "" {
 		return defaultValue
 	}
-	return value
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		invalidEnv(key, value, defaultValue)
+		return defaultValue
+	}
+	return f
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		invalidEnv(key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+// key returns the Redis key for name with the configured prefix applied.
+// Every key the service reads or writes must be built through here.
+func (s *AppState) key(name string) string {
+	return s.keyPrefix + name
+}
+
+// metricsHandler serves OpenMetrics to clients whose Accept header asks for
+// it and the classic Prometheus text format otherwise. Exemplars and
+// _created samples only appear in the OpenMetrics output; the text encoder
+// drops them as the spec requires.
+var metricsHandler = newMetricsHandler(prometheus.DefaultRegisterer, prometheus.DefaultGatherer)
+
+func newMetricsHandler(reg prometheus.Registerer, gatherer prometheus.Gatherer) http.Handler {
+	return promhttp.InstrumentMetricHandler(reg, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics:                   true,
+		EnableOpenMetricsTextCreatedSamples: true,
+	}))
 }
 
 func handleMetrics(w http.ResponseWriter, r *http.Request) {
-	promhttp.Handler().ServeHTTP(w, r)
+	metricsHandler.ServeHTTP(w, r)
 }
 
+// handleAnalyze accepts one metric. By default it is processed in the
+// background and the response is 202; with ?sync=true the call waits and
+// returns the AnalysisResult, whose status is "warming", "normal" or
+// "anomaly".
 func handleAnalyze(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	stream, err := streamFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	syncMode := false
+	if v := r.URL.Query().Get("sync"); v != "" {
+		if syncMode, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "sync must be a boolean")
+			return
+		}
+	}
+	// Reject probes before anything is counted or stored.
+	if emptyBody(r) {
+		writeError(w, http.StatusBadRequest, "empty_body", "request body is empty, want a JSON metric")
 		return
 	}
 
 	ctx := context.Background()
-	newCount, err := appState.redisClient.Incr(ctx, "request_count").Result()
+	logger := loggerFrom(r.Context()).With("stream", stream)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxMetricBodyBytes)
+	metric, err := decodeIngestedBody(r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "too_large", "Request body too large")
+		return
+	}
+	if err != nil && isProtobuf(r) {
+		writeError(w, http.StatusBadRequest, "invalid_protobuf", "Invalid protobuf metric")
+		return
+	}
+	if err != nil && isMsgpack(r) {
+		writeError(w, http.StatusBadRequest, "invalid_msgpack", "Invalid MessagePack metric")
+		return
+	}
 	if err != nil {
-		log.Printf("Redis INCR error: %v", err)
-		http.Error(w, "Error incrementing counter", http.StatusInternalServerError)
+		writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
 		return
 	}
-	log.Printf("Redis counter incremented to: %d", newCount)
-
-	appState.requestCounter.Inc()
-
-	var metric Metric
-	err = json.NewDecoder(r.Body).Decode(&metric)
+	metric, err = stampMetric(logger, metric)
 	if err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	// With the circuit open, the metric is accepted on the in-memory path
+	// uncounted rather than failing the request.
+	if err := recordIntake(ctx, logger, stream, metric); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Error incrementing counter")
 		return
 	}
 
-	appState.cpuGauge.Set(metric.CPU)
-	appState.rpsGauge.Set(metric.RPS)
-
-	go func(m Metric) {
-		ctx := context.Background()
-
-		key := "metrics"
-		jsonData, _ := json.Marshal(m)
-		err := appState.redisClient.RPush(ctx, key, jsonData).Err()
-		if err != nil {
-			log.Printf("Redis RPush error: %v", err)
-			return
-		}
-
-		err = appState.redisClient.LTrim(ctx, key, -int64(appState.windowSize), -1).Err()
-		if err != nil {
-			log.Printf("Redis LTrim error: %v", err)
-		}
-
-		window, err := appState.redisClient.LRange(ctx, key, 0, -1).Result()
+	if syncMode {
+		result, err := processMetric(r.Context(), logger, stream, metric)
 		if err != nil {
-			log.Printf("Redis LRange error: %v", err)
+			writeError(w, http.StatusServiceUnavailable, "unavailable", "Error processing metric")
 			return
 		}
+		writeBody(w, r, http.StatusOK, result)
+		return
+	}
 
-		var rpsValues, cpuValues []float64
-		for _, item := range window {
-			var met Metric
-			json.Unmarshal([]byte(item), &met)
-			rpsValues = append(rpsValues, met.RPS)
-			cpuValues = append(cpuValues, met.CPU)
-		}
-
-		// Calculate Rolling Average (RPS)
-		rollingAvg := calculateAverage(rpsValues)
-		appState.rollingAvgGauge.Set(rollingAvg)
-
-		// Calculate Z-Score for current RPS value (anomaly detection)
-		if len(rpsValues) >= 2 { // Need at least 2 values for std deviation
-			currentValue := m.RPS
-			mean := calculateAverage(rpsValues)
-			stdDev := calculateStandardDeviation(rpsValues, mean)
-
-			if stdDev != 0 {
-				zScore := (currentValue - mean) / stdDev
-				if math.Abs(zScore) > 2.0 {
-					log.Printf("ANOMALY DETECTED! RPS: %.2f, Z-Score: %.2f, Mean: %.2f, StdDev: %.2f",
-						currentValue, zScore, mean, stdDev)
-					appState.anomalyCounter.Inc()
-				}
-			}
-		}
+	// Give a quick verdict against the in-memory buffer before handing the
+	// sample off; the Redis-backed result may differ.
+	if score, anomalous, ok := quickVerdict(stream, metric); ok {
+		w.Header().Set("X-Anomaly", strconv.FormatBool(anomalous))
+		w.Header().Set("X-Anomaly-Score", strconv.FormatFloat(appState.round(score), 'f', -1, 64))
+	}
 
-		log.Printf("Processed metric: Timestamp=%v, RPS=%.2f, CPU=%.2f, RollingAvgRPS=%.2f",
-			m.Timestamp.Format("15:04:05"), m.RPS, m.CPU, rollingAvg)
-	}(metric)
+	if !appState.workers.submit(logger, stream, metric) {
+		logger.Warn("Processing queue full, metric rejected")
+		writeError(w, http.StatusServiceUnavailable, "queue_full", "Processing queue full")
+		return
+	}
 
-	w.WriteHeader(http.StatusAccepted)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	writeBody(w, r, http.StatusAccepted, map[string]string{
 		"status":  "accepted",
 		"message": "Metric accepted for processing",
 	})
@@ -291,7 +1188,29 @@ func calculateAverage(values []float64) float64 {
 	return sum / float64(len(values))
 }
 
-func calculateStandardDeviation(values []float64, mean float64) float64 {
+// StdDevMethod selects the denominator used for the standard deviation.
+type StdDevMethod string
+
+const (
+	// StdDevSample divides by n-1 (Bessel-corrected). It is the default.
+	StdDevSample StdDevMethod = "sample"
+	// StdDevPopulation divides by n, treating the window as the full
+	// population.
+	StdDevPopulation StdDevMethod = "population"
+)
+
+func parseStdDevMethod(v string) (StdDevMethod, error) {
+	switch StdDevMethod(v) {
+	case "", StdDevSample:
+		return StdDevSample, nil
+	case StdDevPopulation:
+		return StdDevPopulation, nil
+	default:
+		return "", fmt.Errorf("unknown standard deviation method %q (want sample or population)", v)
+	}
+}
+
+func calculateStandardDeviation(values []float64, mean float64, method StdDevMethod) float64 {
 	if len(values) <= 1 {
 		return 0.0
 	}
@@ -299,6 +1218,64 @@ func calculateStandardDeviation(values []float64, mean float64) float64 {
 	for _, v := range values {
 		sum += math.Pow(v-mean, 2)
 	}
-	variance := sum / float64(len(values)-1)
-	return math.Sqrt(variance)
+	n := float64(len(values))
+	if method != StdDevPopulation {
+		n--
+	}
+	return math.Sqrt(sum / n)
+}
+
+// calculateMedian returns the median of values without reordering them.
+func calculateMedian(values []float64) float64 {
+	if len(values) == 0 {
+		return 0.0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// calculateSlope returns the least-squares slope of values against their
+// index, i.e. the average change per sample.
+func calculateSlope(values []float64) float64 {
+	n := float64(len(values))
+	if n < 2 {
+		return 0.0
+	}
+	meanX := (n - 1) / 2
+	meanY := calculateAverage(values)
+	var num, den float64
+	for i, v := range values {
+		dx := float64(i) - meanX
+		num += dx * (v - meanY)
+		den += dx * dx
+	}
+	return num / den
+}
+
+// calculateCorrelation returns the Pearson correlation of x and y over
+// their trailing min(len(x), len(y)) pairs. ok is false when there are
+// fewer than two pairs or either series is flat, where it is undefined.
+func calculateCorrelation(x, y []float64) (r float64, ok bool) {
+	n := min(len(x), len(y))
+	if n < 2 {
+		return 0, false
+	}
+	x, y = x[len(x)-n:], y[len(y)-n:]
+	meanX, meanY := calculateAverage(x), calculateAverage(y)
+	var cov, varX, varY float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0, false
+	}
+	return cov / math.Sqrt(varX*varY), true
 }
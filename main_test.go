@@ -0,0 +1,164 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		prefix, name, want string
+	}{
+		{"", "request_count", "request_count"},
+		{"tenant-a:", "request_count", "tenant-a:request_count"},
+		{"tenant-a:", "metrics", "tenant-a:metrics"},
+	}
+	for _, tt := range tests {
+		s := &AppState{keyPrefix: tt.prefix}
+		if got := s.key(tt.name); got != tt.want {
+			t.Errorf("key(%q) with prefix %q = %q, want %q", tt.name, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestGetEnvInt(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", 7},
+		{"3", 3},
+		{"-1", -1},
+		{"abc", 7},
+		{"1.5", 7},
+	}
+	for _, tt := range tests {
+		t.Setenv("TEST_ENV_INT", tt.value)
+		if got := getEnvInt("TEST_ENV_INT", 7); got != tt.want {
+			t.Errorf("getEnvInt(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGetEnvFloat(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{"", 2.5},
+		{"0.25", 0.25},
+		{"nope", 2.5},
+	}
+	for _, tt := range tests {
+		t.Setenv("TEST_ENV_FLOAT", tt.value)
+		if got := getEnvFloat("TEST_ENV_FLOAT", 2.5); got != tt.want {
+			t.Errorf("getEnvFloat(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGetEnvDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 5 * time.Second},
+		{"250ms", 250 * time.Millisecond},
+		{"0s", 5 * time.Second},
+		{"-1s", 5 * time.Second},
+		{"soon", 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Setenv("TEST_ENV_DURATION", tt.value)
+		if got := getEnvDuration("TEST_ENV_DURATION", 5*time.Second); got != tt.want {
+			t.Errorf("getEnvDuration(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+// newTestAppState installs an AppState backed by miniredis with unregistered
+// metrics, so handlers and the pipeline can run without main().
+func newTestAppState(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	gauge := func(name string) prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{Name: name})
+	}
+	counter := func(name string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{Name: name})
+	}
+	appState = &AppState{
+		redisClient:        redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1}),
+		clock:              newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+		windowSize:         5,
+		rawRetention:       50,
+		compactBucketSize:  10,
+		compactedRetention: 100,
+		deadLetterMax:      100,
+		anomalyRetention:   100,
+		anomalyContexts:    newLRU[string, AnomalyContext](10),
+		workers:            newStreamWorkers(2, 100),
+		// No retries: against a stopped miniredis each attempt waits out
+		// go-redis's own dial retries. retry_test.go turns them on.
+		redisRetry:             retryPolicy{Attempts: 1, Base: time.Millisecond, Max: time.Millisecond},
+		redisRetryCounter:      counter("redis_retries"),
+		anomalyHub:             newHub[AnomalyRecord](),
+		anomalyWaiters:         make(chan struct{}, 2),
+		recovery:               newRecoveryFlusher(),
+		wsConns:                newWSConnSet(),
+		maxBatchStreams:        10,
+		maxBatchSamples:        10,
+		otlp:                   defaultOTLPMapping,
+		otlpHold:               newPointHold(pointJoinWindow),
+		remoteWriteHold:        newPointHold(pointJoinWindow),
+		minSamples:             2,
+		responsePrecision:      4,
+		sampleRate:             1,
+		buffer:                 newSampleBuffer(5),
+		store:                  listStore{},
+		gaugeHub:               newHub[GaugeSnapshot](),
+		gaugeStreamInterval:    20 * time.Millisecond,
+		detector:               &ZScoreDetector{Threshold: defaultZScoreThreshold},
+		quickDetector:          &ZScoreDetector{Threshold: defaultZScoreThreshold},
+		settings:               map[string]string{},
+		trend:                  &TrendDetector{MaxSlope: 1},
+		requestCounter:         counter("requests"),
+		anomalyCounter:         prometheus.NewCounterVec(prometheus.CounterOpts{Name: "anomalies"}, []string{"severity"}),
+		severity:               SeverityCutoffs{Warning: defaultSeverityWarning, Critical: defaultSeverityCritical},
+		cpuGauge:               prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cpu"}, []string{"stream"}),
+		rpsGauge:               prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "rps"}, []string{"stream"}),
+		rollingAvgGauge:        prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "rolling_avg"}, []string{"stream"}),
+		weightedAvgGauge:       prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "weighted_avg"}, []string{"stream"}),
+		weighting:              WeightingLinear,
+		trendGauge:             prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "trend"}, []string{"stream"}),
+		correlationGauge:       prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "correlation"}, []string{"stream"}),
+		shortAvgGauge:          prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "short_avg"}, []string{"stream"}),
+		longAvgGauge:           prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "long_avg"}, []string{"stream"}),
+		divergenceCounter:      counter("divergence_detections"),
+		levelShift:             &CUSUMDetector{Threshold: defaultCUSUMThreshold, Slack: defaultCUSUMSlack},
+		levelShiftCounter:      prometheus.NewCounterVec(prometheus.CounterOpts{Name: "level_shifts"}, []string{"metric", "direction"}),
+		trendCounter:           counter("trend_detections"),
+		redisUpGauge:           gauge("redis_up"),
+		breakerStateGauge:      gauge("breaker_state"),
+		windowFillGauge:        prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "fill"}, []string{"stream"}),
+		skewCounter:            prometheus.NewCounterVec(prometheus.CounterOpts{Name: "skew"}, []string{"action"}),
+		stalenessGauge:         prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "staleness"}, []string{"stream"}),
+		pausedGauge:            prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "paused"}, []string{"stream"}),
+		flushCounter:           prometheus.NewCounterVec(prometheus.CounterOpts{Name: "flushes"}, []string{"result"}),
+		flushedSamplesCounter:  counter("flushed_samples"),
+		recentAnomaliesGauge:   gauge("anomalies_recent"),
+		sampleGapCounter:       prometheus.NewCounterVec(prometheus.CounterOpts{Name: "gaps"}, []string{"stream"}),
+		gapMultiplier:          defaultGapMultiplier,
+		recentAnomalyWindow:    5 * time.Minute,
+		thresholdBreachCounter: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "breaches"}, []string{"metric"}),
+	}
+	// Stop before miniredis does, so queued metrics are not processed
+	// against the next test's state.
+	t.Cleanup(appState.workers.stop)
+	t.Cleanup(appState.recovery.wait)
+	return mr
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultMetricsSubsystem = "go_service"
+
+// metricsNamespace and metricsSubsystem prefix the name of every metric the
+// service exports (METRICS_NAMESPACE, METRICS_SUBSYSTEM), so several
+// deployments of this code can share one Prometheus. The defaults keep the
+// original go_service_ names. They are set once in main, before any metric
+// is built.
+var (
+	metricsNamespace string
+	metricsSubsystem = defaultMetricsSubsystem
+)
+
+var metricNamePartPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parseMetricsPrefix validates a namespace and subsystem; either may be
+// empty to leave that part out.
+func parseMetricsPrefix(namespace, subsystem string) (string, string, error) {
+	for _, part := range []string{namespace, subsystem} {
+		if part != "" && !metricNamePartPattern.MatchString(part) {
+			return "", "", fmt.Errorf("%q is not a valid metric name part", part)
+		}
+	}
+	return namespace, subsystem, nil
+}
+
+// metricName returns the full exported name of the metric called name.
+func metricName(name string) string {
+	return prometheus.BuildFQName(metricsNamespace, metricsSubsystem, name)
+}
+
+func counterOpts(name, help string) prometheus.CounterOpts {
+	return prometheus.CounterOpts{Namespace: metricsNamespace, Subsystem: metricsSubsystem, Name: name, Help: help}
+}
+
+func gaugeOpts(name, help string) prometheus.GaugeOpts {
+	return prometheus.GaugeOpts{Namespace: metricsNamespace, Subsystem: metricsSubsystem, Name: name, Help: help}
+}
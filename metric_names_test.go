@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricsPrefix(t *testing.T) {
+	tests := []struct {
+		name                 string
+		namespace, subsystem string
+		want                 string
+		wantErr              bool
+	}{
+		{name: "default", subsystem: defaultMetricsSubsystem, want: "go_service_requests_total"},
+		{name: "namespace", namespace: "payments", subsystem: defaultMetricsSubsystem, want: "payments_go_service_requests_total"},
+		{name: "subsystem only", subsystem: "detector", want: "detector_requests_total"},
+		{name: "no prefix", want: "requests_total"},
+		{name: "invalid namespace", namespace: "pay-ments", wantErr: true},
+		{name: "invalid subsystem", subsystem: "1st", wantErr: true},
+	}
+	t.Cleanup(func() { metricsNamespace, metricsSubsystem = "", defaultMetricsSubsystem })
+	for _, tt := range tests {
+		namespace, subsystem, err := parseMetricsPrefix(tt.namespace, tt.subsystem)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		metricsNamespace, metricsSubsystem = namespace, subsystem
+		if got := metricName("requests_total"); got != tt.want {
+			t.Errorf("%s: metricName = %q, want %q", tt.name, got, tt.want)
+		}
+		desc := prometheus.NewCounter(counterOpts("requests_total", "help")).Desc().String()
+		if !strings.Contains(desc, `"`+tt.want+`"`) {
+			t.Errorf("%s: counter desc %s, want name %q", tt.name, desc, tt.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricsHandlerContentNegotiation(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "test_events_total",
+		Help: "Test counter",
+	})
+	reg.MustRegister(counter)
+	counter.(prometheus.ExemplarAdder).AddWithExemplar(1, prometheus.Labels{"stream": "web"})
+	handler := newMetricsHandler(reg, reg)
+
+	tests := []struct {
+		name            string
+		accept          string
+		wantContentType string
+		wantOpenMetrics bool
+	}{
+		{
+			name:            "openmetrics requested",
+			accept:          "application/openmetrics-text; version=1.0.0",
+			wantContentType: "application/openmetrics-text",
+			wantOpenMetrics: true,
+		},
+		{
+			name:            "default",
+			wantContentType: "text/plain",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.wantContentType) {
+				t.Errorf("Content-Type = %q, want prefix %q", ct, tt.wantContentType)
+			}
+			body := w.Body.String()
+			checks := []struct {
+				what string
+				text string
+			}{
+				{"_created sample", "test_events_created "},
+				{"exemplar", `# {stream="web"} 1`},
+				{"EOF marker", "# EOF"},
+			}
+			for _, c := range checks {
+				if got := strings.Contains(body, c.text); got != tt.wantOpenMetrics {
+					t.Errorf("%s present = %v, want %v\n%s", c.what, got, tt.wantOpenMetrics, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// mqttSubscriber feeds Metric JSON payloads published to MQTT_BROKER on
+// the topic filter MQTT_TOPIC through the /analyze pipeline, for devices
+// that speak nothing else. The last topic level names the stream, so
+// "metrics/web" goes to web; a single-level topic goes to the default
+// stream. Retained messages are ignored, as they replay an old sample on
+// every (re)connect.
+type mqttSubscriber struct {
+	opts  *mqtt.ClientOptions
+	topic string
+	qos   byte
+	// messages counts consumed messages by result, as for Kafka.
+	messages *prometheus.CounterVec
+}
+
+// parseMQTTQoS parses MQTT_QOS, one of 0, 1 or 2.
+func parseMQTTQoS(value string) (byte, error) {
+	switch value {
+	case "", "1":
+		return 1, nil
+	case "0":
+		return 0, nil
+	case "2":
+		return 2, nil
+	}
+	return 0, fmt.Errorf("unknown QoS %q, want 0, 1 or 2", value)
+}
+
+// defaultMQTTClientID keeps replicas from kicking each other off the
+// broker, which allows one session per client ID.
+func defaultMQTTClientID() string {
+	host, _ := os.Hostname()
+	return "go-service-" + host
+}
+
+func newMQTTSubscriber(broker, topic, clientID string, qos byte, messages *prometheus.CounterVec) *mqttSubscriber {
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true)
+	return &mqttSubscriber{opts: opts, topic: topic, qos: qos, messages: messages}
+}
+
+// run connects and processes messages until ctx is cancelled. The
+// subscription is made on every connect, so it survives reconnects.
+// Messages arriving between cancellation and the disconnect are still
+// processed, under a context that outlives ctx.
+func (s *mqttSubscriber) run(ctx context.Context) {
+	msgCtx := context.WithoutCancel(ctx)
+	s.opts.SetOnConnectHandler(func(c mqtt.Client) {
+		token := c.Subscribe(s.topic, s.qos, func(_ mqtt.Client, msg mqtt.Message) {
+			if result := s.handle(msgCtx, msg); result != "" {
+				s.messages.WithLabelValues(result).Inc()
+			}
+		})
+		if token.Wait() && token.Error() != nil {
+			slog.Error("MQTT subscribe error", "topic", s.topic, "error", token.Error())
+			return
+		}
+		slog.Info("Subscribed to MQTT topic", "topic", s.topic)
+	})
+	s.opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		slog.Warn("MQTT connection lost", "error", err)
+	})
+
+	client := mqtt.NewClient(s.opts)
+	// With ConnectRetry set, the token only completes once connected, so
+	// do not wait on it: shutdown must not block on an unreachable broker.
+	client.Connect()
+	<-ctx.Done()
+	client.Disconnect(250)
+}
+
+// handle runs one message through the pipeline and returns its result
+// label, or "" for an ignored retained message.
+func (s *mqttSubscriber) handle(ctx context.Context, msg mqtt.Message) string {
+	if msg.Retained() {
+		return ""
+	}
+	stream := defaultStream
+	if levels := strings.Split(msg.Topic(), "/"); len(levels) > 1 {
+		stream = levels[len(levels)-1]
+	}
+	logger := slog.Default().With("transport", "mqtt", "stream", stream, "topic", msg.Topic())
+	return ingestMessage(ctx, logger, stream, msg.Payload())
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type fakeMQTTMsg struct {
+	mqtt.Message
+	topic, payload string
+	retained       bool
+}
+
+func (m fakeMQTTMsg) Topic() string   { return m.topic }
+func (m fakeMQTTMsg) Payload() []byte { return []byte(m.payload) }
+func (m fakeMQTTMsg) Retained() bool  { return m.retained }
+
+func TestMQTTSubscriberHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		msg        fakeMQTTMsg
+		wantResult string
+		wantStream string
+	}{
+		{name: "processed", msg: fakeMQTTMsg{topic: "metrics/web", payload: `{"rps": 10}`}, wantResult: "processed", wantStream: "web"},
+		{name: "nested", msg: fakeMQTTMsg{topic: "site/a/metrics/api", payload: `{"rps": 10}`}, wantResult: "processed", wantStream: "api"},
+		{name: "single level", msg: fakeMQTTMsg{topic: "metrics", payload: `{"cpu": 50}`}, wantResult: "processed", wantStream: defaultStream},
+		{name: "retained", msg: fakeMQTTMsg{topic: "metrics/web", payload: `{"rps": 10}`, retained: true}},
+		{name: "invalid stream", msg: fakeMQTTMsg{topic: "metrics/bad name", payload: `{"rps": 1}`}, wantResult: "invalid"},
+		{name: "bad json", msg: fakeMQTTMsg{topic: "metrics/web", payload: `not json`}, wantResult: "invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			s := &mqttSubscriber{}
+			if got := s.handle(context.Background(), tt.msg); got != tt.wantResult {
+				t.Errorf("result = %q, want %q", got, tt.wantResult)
+			}
+			if tt.wantStream == "" {
+				if mr.Exists("request_count") {
+					t.Error("skipped message was counted")
+				}
+				return
+			}
+			if stored, _ := mr.List(appState.metricsKey(tt.wantStream)); len(stored) != 1 {
+				t.Errorf("stored %d samples on %q, want 1", len(stored), tt.wantStream)
+			}
+		})
+	}
+}
+
+func TestParseMQTTQoS(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    byte
+		wantErr bool
+	}{
+		{value: "", want: 1},
+		{value: "0", want: 0},
+		{value: "2", want: 2},
+		{value: "3", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMQTTQoS(tt.value)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseMQTTQoS(%q) = %d, %v; want %d, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
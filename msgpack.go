@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpackContentType is the media type MessagePack bodies are sent and
+// answered with.
+const msgpackContentType = "application/msgpack"
+
+// isMsgpackType reports whether mediaType names MessagePack, under its
+// registered name or the older unregistered ones clients still send.
+func isMsgpackType(mediaType string) bool {
+	switch mediaType {
+	case msgpackContentType, "application/x-msgpack", "application/vnd.msgpack":
+		return true
+	}
+	return false
+}
+
+// isMsgpack reports whether r has a MessagePack body.
+func isMsgpack(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return isMsgpackType(mediaType)
+}
+
+// prefersMsgpack reports whether r's Accept header ranks MessagePack above
+// JSON. JSON wins ties and is the answer to a missing Accept header, so
+// clients that never ask keep getting JSON.
+func prefersMsgpack(r *http.Request) bool {
+	msgpackQ, jsonQ := 0.0, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch {
+		case isMsgpackType(mediaType):
+			msgpackQ = max(msgpackQ, q)
+		case mediaType == "application/json", mediaType == "application/*", mediaType == "*/*":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return msgpackQ > jsonQ
+}
+
+// writeBody answers r with status and v, as MessagePack when the client
+// prefers it and as JSON otherwise. MessagePack maps are keyed by the JSON
+// field names, and carry non-finite floats as they are.
+func writeBody(w http.ResponseWriter, r *http.Request, status int, v interface{}) error {
+	w.Header().Add("Vary", "Accept")
+	if !prefersMsgpack(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		return encodeJSON(w, v)
+	}
+	w.Header().Set("Content-Type", msgpackContentType)
+	w.WriteHeader(status)
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	return enc.Encode(v)
+}
+
+// decodeMsgpack decodes one MessagePack value from body into v. Numbers
+// in generic maps come out as int64, uint64 or float64.
+func decodeMsgpack(body io.Reader, v interface{}) error {
+	dec := msgpack.NewDecoder(body)
+	dec.SetCustomStructTag("json")
+	dec.UseLooseInterfaceDecoding(true)
+	return dec.Decode(v)
+}
+
+// decodeIngestedMsgpack is decodeIngested for a metric decoded from
+// MessagePack into a map. Timestamps may be MessagePack timestamps as well
+// as the RFC3339 strings and epoch numbers JSON takes. Non-finite values,
+// which JSON cannot carry, are rejected.
+func decodeIngestedMsgpack(obj map[string]interface{}, mapping map[string]string) (Metric, error) {
+	var m Metric
+	for _, field := range metricFields {
+		value, ok := obj[mapping[field]]
+		if !ok {
+			value, ok = obj[field]
+		}
+		if !ok || value == nil {
+			continue
+		}
+		var err error
+		switch field {
+		case "timestamp":
+			m.Timestamp, err = msgpackTimestamp(value)
+		case "cpu":
+			m.CPU, err = msgpackFloat(value)
+		case "rps":
+			m.RPS, err = msgpackFloat(value)
+		case "cpu_unit":
+			m.CPUUnit, err = msgpackString(value)
+		case "rps_unit":
+			m.RPSUnit, err = msgpackString(value)
+		}
+		if err != nil {
+			return m, fmt.Errorf("field %s: %w", field, err)
+		}
+	}
+	return m, nil
+}
+
+func msgpackFloat(value interface{}) (float64, error) {
+	var f float64
+	switch v := value.(type) {
+	case int64:
+		f = float64(v)
+	case uint64:
+		f = float64(v)
+	case float64:
+		f = v
+	case float32:
+		f = float64(v)
+	default:
+		return 0, fmt.Errorf("want a number, got %T", value)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%v is not a finite number", f)
+	}
+	return f, nil
+}
+
+func msgpackString(value interface{}) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("want a string, got %T", value)
+	}
+	return s, nil
+}
+
+func msgpackTimestamp(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC(), nil
+	case string:
+		return parseTimestampJSON([]byte(strconv.Quote(v)))
+	}
+	f, err := msgpackFloat(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseTimestampJSON([]byte(strconv.FormatFloat(f, 'f', -1, 64)))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestPrefersMsgpack(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "application/msgpack", want: true},
+		{accept: "application/x-msgpack, application/json;q=0.5", want: true},
+		{accept: "application/json, application/msgpack", want: false},
+		{accept: "application/msgpack;q=0.8, */*;q=0.9", want: false},
+		{accept: "application/msgpack;q=0", want: false},
+		{accept: "text/html, application/vnd.msgpack", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", tt.accept)
+			if got := prefersMsgpack(r); got != tt.want {
+				t.Errorf("prefersMsgpack(%q) = %v, want %v", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeIngestedMsgpack(t *testing.T) {
+	ts := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		obj     map[string]interface{}
+		mapping map[string]string
+		want    Metric
+		wantErr bool
+	}{
+		{name: "msgpack timestamp", obj: map[string]interface{}{"timestamp": ts, "cpu": 1.5, "rps": int64(10)}, want: Metric{Timestamp: ts, CPU: 1.5, RPS: 10}},
+		{name: "epoch millis", obj: map[string]interface{}{"timestamp": uint64(1703462400000), "rps": 2.0}, want: Metric{Timestamp: ts, RPS: 2}},
+		{name: "rfc3339 and units", obj: map[string]interface{}{"timestamp": "2023-12-25T00:00:00Z", "rps": 1.0, "rps_unit": "per_minute"}, want: Metric{Timestamp: ts, RPS: 1, RPSUnit: "per_minute"}},
+		{name: "field mapping", obj: map[string]interface{}{"req_per_sec": 3.0}, mapping: map[string]string{"rps": "req_per_sec"}, want: Metric{RPS: 3}},
+		{name: "nil skipped", obj: map[string]interface{}{"cpu": nil, "rps": 1.0}, want: Metric{RPS: 1}},
+		{name: "string rps", obj: map[string]interface{}{"rps": "high"}, wantErr: true},
+		{name: "nan", obj: map[string]interface{}{"rps": math.NaN()}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeIngestedMsgpack(tt.obj, tt.mapping)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (!got.Timestamp.Equal(tt.want.Timestamp) || got.CPU != tt.want.CPU || got.RPS != tt.want.RPS || got.RPSUnit != tt.want.RPSUnit) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func mustMsgpack(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := msgpack.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMsgpackEndpoints(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		accept      string
+		body        func(t *testing.T) []byte
+		wantCode    int
+		// wantMsgpack is a key the MessagePack response must hold.
+		wantMsgpack string
+		wantBody    string
+		wantRPS     []float64
+	}{
+		{
+			name: "analyze, msgpack both ways", method: http.MethodPost, target: "/analyze/web?sync=true",
+			contentType: "application/msgpack", accept: "application/msgpack",
+			body:     func(t *testing.T) []byte { return mustMsgpack(t, map[string]interface{}{"rps": 10, "cpu": 50.5}) },
+			wantCode: http.StatusOK, wantMsgpack: "samples", wantRPS: []float64{10},
+		},
+		{
+			name: "analyze, msgpack in, json out", method: http.MethodPost, target: "/analyze/web?sync=true",
+			contentType: "application/x-msgpack",
+			body:        func(t *testing.T) []byte { return mustMsgpack(t, map[string]interface{}{"rps": 7}) },
+			wantCode:    http.StatusOK, wantBody: `"samples":1`, wantRPS: []float64{7},
+		},
+		{
+			name: "analyze, bad msgpack", method: http.MethodPost, target: "/analyze/web?sync=true",
+			contentType: "application/msgpack",
+			body:        func(*testing.T) []byte { return []byte{0xc1} },
+			wantCode:    http.StatusBadRequest, wantBody: `"invalid_msgpack"`,
+		},
+		{
+			name: "analyze batch", method: http.MethodPost, target: "/analyze/batch?stream=web",
+			contentType: "application/msgpack", accept: "application/msgpack",
+			body: func(t *testing.T) []byte {
+				return mustMsgpack(t, []map[string]interface{}{{"rps": 1}, {"rps": 2}})
+			},
+			wantCode: http.StatusOK, wantMsgpack: "accepted", wantRPS: []float64{1, 2},
+		},
+		{
+			name: "batch analyze", method: http.MethodPost, target: "/batch/analyze",
+			contentType: "application/msgpack",
+			body: func(t *testing.T) []byte {
+				return mustMsgpack(t, map[string]interface{}{"web": map[string]interface{}{"rps": 4}})
+			},
+			wantCode: http.StatusOK, wantBody: `"web"`, wantRPS: []float64{4},
+		},
+		{
+			name: "history", method: http.MethodGet, target: "/history/web", accept: "application/msgpack",
+			body:     func(*testing.T) []byte { return nil },
+			wantCode: http.StatusOK, wantMsgpack: "stream",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			r := httptest.NewRequest(tt.method, tt.target, bytes.NewReader(tt.body(t)))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantMsgpack != "" {
+				if ct := w.Header().Get("Content-Type"); ct != msgpackContentType {
+					t.Fatalf("Content-Type = %q, want %q", ct, msgpackContentType)
+				}
+				var got map[string]interface{}
+				if err := msgpack.Unmarshal(w.Body.Bytes(), &got); err != nil {
+					t.Fatalf("response is not MessagePack: %v", err)
+				}
+				if _, ok := got[tt.wantMsgpack]; !ok {
+					t.Errorf("response %v lacks %q", got, tt.wantMsgpack)
+				}
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body %s, want it to contain %s", w.Body.String(), tt.wantBody)
+			}
+			if got := rpsOf(appState.buffer.window("web")); !equalFloats(got, tt.wantRPS) {
+				t.Errorf("rps = %v, want %v", got, tt.wantRPS)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxNDJSONLineBytes bounds one metric line on /analyze/stream.
+	maxNDJSONLineBytes = 64 << 10
+	// ndjsonWriteTimeout bounds writing one result line, so a client that
+	// stops reading cannot hold a handler forever.
+	ndjsonWriteTimeout = 10 * time.Second
+)
+
+// handleNDJSONIngest takes a long-lived POST /analyze/stream whose body is
+// newline-delimited metric JSON, for producers that send many samples a
+// second but cannot use WebSockets. The stream is named by ?stream= as on
+// /analyze. Each line is processed as it arrives, and answered, in order,
+// with a line holding its AnalysisResult, or an error body for a line that
+// could not be processed, flushed right away. A bad line does not end the
+// request; a line over 64KiB does, after its error line.
+//
+// Over HTTP/1.1 this needs a client that reads results while it is still
+// sending, as HTTP/2 clients do.
+func handleNDJSONIngest(w http.ResponseWriter, r *http.Request) {
+	stream, err := streamFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/x-ndjson" && mediaType != "application/jsonl" {
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "want Content-Type application/x-ndjson")
+		return
+	}
+	rc := http.NewResponseController(w)
+	// HTTP/2 is full duplex already; only HTTP/1.1 needs asking.
+	if err := rc.EnableFullDuplex(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Error enabling streaming")
+		return
+	}
+
+	logger := loggerFrom(r.Context()).With("stream", stream, "transport", "ndjson")
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	write := func(reply interface{}) bool {
+		payload, err := marshalJSON(reply)
+		if err != nil {
+			logger.Error("NDJSON reply encoding error", "error", err)
+			return false
+		}
+		rc.SetWriteDeadline(time.Now().Add(ndjsonWriteTimeout))
+		if _, err := w.Write(append(payload, '\n')); err != nil {
+			logger.Warn("NDJSON write error", "error", err)
+			return false
+		}
+		if err := rc.Flush(); err != nil {
+			logger.Warn("NDJSON write error", "error", err)
+			return false
+		}
+		return true
+	}
+
+	sc := bufio.NewScanner(r.Body)
+	sc.Buffer(make([]byte, 0, 4096), maxNDJSONLineBytes)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		if !write(ingestRecord(r.Context(), logger, stream, sc.Bytes())) {
+			return
+		}
+	}
+	switch err := sc.Err(); {
+	case errors.Is(err, bufio.ErrTooLong):
+		write(errorBody{Error: errorDetail{Code: "too_large", Message: "line longer than 64KiB"}})
+	case err != nil && r.Context().Err() == nil:
+		logger.Warn("NDJSON read error", "error", err)
+	}
+}
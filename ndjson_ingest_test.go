@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNDJSONIngest(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantCode    int
+		wantLines   []string
+		wantRPS     []float64
+	}{
+		{
+			name:        "lines answered in order",
+			contentType: "application/x-ndjson",
+			body:        "{\"rps\": 10}\n\n{\"rps\": \"high\"}\n{\"rps\": 1, \"rps_unit\": \"furlongs\"}\n{\"rps\": 30}",
+			wantCode:    http.StatusOK,
+			wantLines:   []string{`"samples":1`, `"code":"invalid_json"`, `"code":"invalid_request"`, `"rolling_avg":20`},
+			wantRPS:     []float64{10, 30},
+		},
+		{
+			name:        "line too long ends the request",
+			contentType: "application/jsonl; charset=utf-8",
+			body:        "{\"rps\": 10}\n" + strings.Repeat(" ", maxNDJSONLineBytes) + "{\"rps\": 20}\n{\"rps\": 30}\n",
+			wantCode:    http.StatusOK,
+			wantLines:   []string{`"samples":1`, `"code":"too_large"`},
+			wantRPS:     []float64{10},
+		},
+		{
+			name:        "not ndjson",
+			contentType: "application/json",
+			body:        "{\"rps\": 10}\n",
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			r := httptest.NewRequest(http.MethodPost, "/analyze/stream?stream=web", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantLines != nil {
+				lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+				if len(lines) != len(tt.wantLines) {
+					t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(tt.wantLines), w.Body.String())
+				}
+				for i, want := range tt.wantLines {
+					if !strings.Contains(lines[i], want) {
+						t.Errorf("line %d = %s, want it to contain %s", i, lines[i], want)
+					}
+				}
+			}
+			if got := rpsOf(appState.buffer.window("web")); !equalFloats(got, tt.wantRPS) {
+				t.Errorf("rps = %v, want %v", got, tt.wantRPS)
+			}
+		})
+	}
+}
+
+// TestNDJSONIngestStreaming checks that each line is answered before the
+// next is sent, over a real HTTP/1.1 connection.
+func TestNDJSONIngestStreaming(t *testing.T) {
+	newTestAppState(t)
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+
+	body, send := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/analyze/stream?stream=web", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	go send.Write([]byte("{\"rps\": 10}\n"))
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	replies := bufio.NewReader(resp.Body)
+
+	for i, want := range []string{`"samples":1`, `"samples":2`} {
+		if i > 0 {
+			if _, err := send.Write([]byte("{\"rps\": 20}\n")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		line, err := replies.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reply %d: %v", i, err)
+		}
+		if !strings.Contains(line, want) {
+			t.Errorf("reply %d = %s, want it to contain %s", i, line, want)
+		}
+	}
+	send.Close()
+	if rest, _ := io.ReadAll(replies); len(rest) != 0 {
+		t.Errorf("unexpected trailing replies: %s", rest)
+	}
+}
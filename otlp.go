@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Defaults of OTLP_CPU_METRIC, OTLP_RPS_METRIC and OTLP_STREAM_ATTRIBUTE.
+const (
+	defaultOTLPCPUMetric       = "system.cpu.utilization"
+	defaultOTLPRPSMetric       = "http.server.request.rate"
+	defaultOTLPStreamAttribute = "service.name"
+)
+
+// otlpMapping says which OTLP metrics become a Metric's CPU and RPS, and
+// which attribute, looked up on the data point and then on its resource,
+// names the stream. Points without it go to the default stream.
+type otlpMapping struct {
+	CPUMetric       string
+	RPSMetric       string
+	StreamAttribute string
+}
+
+var defaultOTLPMapping = otlpMapping{
+	CPUMetric:       defaultOTLPCPUMetric,
+	RPSMetric:       defaultOTLPRPSMetric,
+	StreamAttribute: defaultOTLPStreamAttribute,
+}
+
+// otlpUnits maps the UCUM units OTLP metrics declare to cpu_unit and
+// rps_unit values. Other units are taken to be canonical already.
+var otlpUnits = map[string]string{
+	"1":    "fraction",
+	"%":    "percent",
+	"/s":   "per_second",
+	"/min": "per_minute",
+	"/h":   "per_hour",
+}
+
+// otlpUnit returns the cpu_unit or rps_unit for a UCUM unit such as
+// "{request}/s", ignoring its annotation.
+func otlpUnit(unit string) string {
+	if i := strings.Index(unit, "}"); i >= 0 && strings.HasPrefix(unit, "{") {
+		unit = unit[i+1:]
+	}
+	return otlpUnits[unit]
+}
+
+// ingestOTLP joins the data points of req into Metrics with a pointJoiner
+// and processes them. Gauge and sum points of the two mapped metrics are
+// used; everything else is ignored. Sums are taken as they are, so
+// counters should be turned into rates before they are exported here, e.g.
+// with the collector's cumulativetodelta and deltatorate processors. A
+// sample with only one of the two waits in otlpHold for the other, for up
+// to pointJoinWindow. It returns the number of points rejected and why.
+func ingestOTLP(ctx context.Context, logger *slog.Logger, req *colmetricspb.ExportMetricsServiceRequest) (int64, string) {
+	mapping := appState.otlp
+	j := newHeldPointJoiner(appState.otlpHold, mapping.CPUMetric != "", mapping.RPSMetric != "")
+
+	for _, rm := range req.GetResourceMetrics() {
+		resourceStream := otlpAttribute(rm.GetResource().GetAttributes(), mapping.StreamAttribute)
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, metric := range sm.GetMetrics() {
+				isCPU := metric.GetName() == mapping.CPUMetric
+				if !isCPU && metric.GetName() != mapping.RPSMetric {
+					continue
+				}
+				var points []*metricspb.NumberDataPoint
+				switch {
+				case metric.GetGauge() != nil:
+					points = metric.GetGauge().GetDataPoints()
+				case metric.GetSum() != nil:
+					points = metric.GetSum().GetDataPoints()
+				default:
+					j.reject(1, fmt.Sprintf("%s is not a gauge or sum", metric.GetName()))
+					continue
+				}
+				unit := otlpUnit(metric.GetUnit())
+				for _, dp := range points {
+					stream := otlpAttribute(dp.GetAttributes(), mapping.StreamAttribute)
+					if stream == "" {
+						stream = resourceStream
+					}
+					if stream == "" {
+						stream = defaultStream
+					}
+					if !streamNamePattern.MatchString(stream) {
+						j.reject(1, fmt.Sprintf("invalid stream name %q", stream))
+						continue
+					}
+					value := dp.GetAsDouble()
+					if _, ok := dp.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+						value = float64(dp.GetAsInt())
+					}
+					var m Metric
+					if isCPU {
+						m.CPU, m.CPUUnit = value, unit
+					} else {
+						m.RPS, m.RPSUnit = value, unit
+					}
+					// Units are converted per point, since points of
+					// one sample may come in different units.
+					m, err := normalizeUnits(m)
+					if err != nil {
+						j.reject(1, err.Error())
+						continue
+					}
+
+					var ts time.Time
+					if ns := dp.GetTimeUnixNano(); ns > 0 {
+						ts = time.Unix(0, int64(ns)).UTC()
+					}
+					if isCPU {
+						j.add(stream, ts, true, m.CPU)
+					} else {
+						j.add(stream, ts, false, m.RPS)
+					}
+				}
+			}
+		}
+	}
+	return j.process(ctx, logger)
+}
+
+// otlpAttribute returns the string value of the attribute named key.
+func otlpAttribute(attrs []*commonpb.KeyValue, key string) string {
+	for _, kv := range attrs {
+		if kv.GetKey() == key {
+			return kv.GetValue().GetStringValue()
+		}
+	}
+	return ""
+}
+
+func otlpResponse(rejected int64, reason string) *colmetricspb.ExportMetricsServiceResponse {
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	if rejected > 0 {
+		resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{RejectedDataPoints: rejected, ErrorMessage: reason}
+	}
+	return resp
+}
+
+// handleOTLPMetrics is the OTLP/HTTP metrics receiver, so an OpenTelemetry
+// exporter can point at the service directly. It takes binary protobuf or,
+// with Content-Type application/json, the OTLP JSON encoding, and answers
+// in the same one. Points that could not be used are reported as a partial
+// success, per the OTLP spec, rather than failing the export.
+func handleOTLPMetrics(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Error reading body")
+		return
+	}
+	var req colmetricspb.ExportMetricsServiceRequest
+	marshal := proto.Marshal
+	contentType := "application/x-protobuf"
+	if isProtobuf(r) {
+		err = proto.Unmarshal(data, &req)
+	} else {
+		marshal = protojson.Marshal
+		contentType = "application/json"
+		err = protojson.Unmarshal(data, &req)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid OTLP metrics export request")
+		return
+	}
+
+	rejected, reason := ingestOTLP(r.Context(), loggerFrom(r.Context()).With("transport", "otlp"), &req)
+	out, err := marshal(otlpResponse(rejected, reason))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Error encoding response")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(out)
+}
+
+// otlpMetricsServer is the OTLP/gRPC metrics receiver, served alongside
+// the Ingest service on GRPC_PORT.
+type otlpMetricsServer struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+}
+
+func (otlpMetricsServer) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	rejected, reason := ingestOTLP(ctx, slog.Default().With("transport", "otlp"), req)
+	return otlpResponse(rejected, reason), nil
+}
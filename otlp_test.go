@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func otlpString(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func otlpGauge(name, unit string, points ...*metricspb.NumberDataPoint) *metricspb.Metric {
+	return &metricspb.Metric{Name: name, Unit: unit, Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: points}}}
+}
+
+func otlpPoint(ts time.Time, value float64, attrs ...*commonpb.KeyValue) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		TimeUnixNano: uint64(ts.UnixNano()),
+		Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+		Attributes:   attrs,
+	}
+}
+
+func otlpRequest(service string, metrics ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource:     &resourcepb.Resource{Attributes: []*commonpb.KeyValue{otlpString("service.name", service)}},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+	}}}
+}
+
+func TestIngestOTLP(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Second)
+	tests := []struct {
+		name         string
+		req          *colmetricspb.ExportMetricsServiceRequest
+		wantRejected int64
+		wantRPS      []float64
+		wantCPU      []float64
+		wantHeld     int
+	}{
+		{
+			name: "cpu and rps joined by timestamp, in order",
+			req: otlpRequest("web",
+				otlpGauge(defaultOTLPRPSMetric, "{request}/s", otlpPoint(t1, 20), otlpPoint(t0, 10)),
+				otlpGauge(defaultOTLPCPUMetric, "1", otlpPoint(t0, 0.5), otlpPoint(t1, 0.25)),
+			),
+			wantRPS: []float64{10, 20},
+			wantCPU: []float64{50, 25},
+		},
+		{
+			name: "per-core cpu averaged, per-route rps added",
+			req: otlpRequest("web",
+				otlpGauge(defaultOTLPCPUMetric, "1", otlpPoint(t0, 0.2, otlpString("cpu", "0")), otlpPoint(t0, 0.4, otlpString("cpu", "1"))),
+				otlpGauge(defaultOTLPRPSMetric, "{request}/min", otlpPoint(t0, 60, otlpString("route", "/a")), otlpPoint(t0, 120, otlpString("route", "/b"))),
+			),
+			wantRPS: []float64{3},
+			wantCPU: []float64{30},
+		},
+		{
+			name: "point attribute names the stream",
+			req: otlpRequest("api",
+				otlpGauge(defaultOTLPRPSMetric, "", otlpPoint(t0, 5, otlpString("service.name", "web"))),
+				otlpGauge(defaultOTLPCPUMetric, "%", otlpPoint(t0, 40, otlpString("service.name", "web"))),
+			),
+			wantRPS: []float64{5},
+			wantCPU: []float64{40},
+		},
+		{
+			name:     "cpu-only export held, not stored with rps 0",
+			req:      otlpRequest("web", otlpGauge(defaultOTLPCPUMetric, "1", otlpPoint(t0, 0.5))),
+			wantRPS:  []float64{},
+			wantHeld: 1,
+		},
+		{
+			name:    "other metrics ignored",
+			req:     otlpRequest("web", otlpGauge("process.memory.usage", "By", otlpPoint(t0, 1e9))),
+			wantRPS: []float64{},
+		},
+		{
+			name: "histogram rejected",
+			req: otlpRequest("web", &metricspb.Metric{
+				Name: defaultOTLPRPSMetric,
+				Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{}},
+			}),
+			wantRejected: 1,
+			wantRPS:      []float64{},
+		},
+		{
+			name:         "invalid stream rejected",
+			req:          otlpRequest("bad name", otlpGauge(defaultOTLPRPSMetric, "", otlpPoint(t0, 5))),
+			wantRejected: 1,
+			wantRPS:      []float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			rejected, reason := ingestOTLP(t.Context(), slog.Default(), tt.req)
+			if rejected != tt.wantRejected {
+				t.Errorf("rejected %d (%s), want %d", rejected, reason, tt.wantRejected)
+			}
+			window := appState.buffer.window("web")
+			if got := rpsOf(window); !equalFloats(got, tt.wantRPS) {
+				t.Errorf("rps = %v, want %v", got, tt.wantRPS)
+			}
+			for i, want := range tt.wantCPU {
+				if i < len(window) && window[i].CPU != want {
+					t.Errorf("sample %d cpu = %v, want %v", i, window[i].CPU, want)
+				}
+			}
+			if n := len(appState.otlpHold.samples); n != tt.wantHeld {
+				t.Errorf("%d samples held, want %d", n, tt.wantHeld)
+			}
+		})
+	}
+}
+
+func TestOTLPHTTPReceiver(t *testing.T) {
+	req := otlpRequest("web",
+		otlpGauge(defaultOTLPRPSMetric, "", otlpPoint(time.Time{}, 5)),
+		otlpGauge(defaultOTLPCPUMetric, "%", otlpPoint(time.Time{}, 40)),
+		otlpGauge(defaultOTLPRPSMetric, "", otlpPoint(time.Time{}, 1, otlpString("service.name", "bad name"))),
+	)
+	protoBody, _ := proto.Marshal(req)
+	jsonBody, _ := protojson.Marshal(req)
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		wantCode    int
+	}{
+		{name: "protobuf", contentType: "application/x-protobuf", body: protoBody, wantCode: http.StatusOK},
+		{name: "json", contentType: "application/json", body: jsonBody, wantCode: http.StatusOK},
+		{name: "garbage", contentType: "application/x-protobuf", body: []byte{0xff, 0xff}, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			r := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			var resp colmetricspb.ExportMetricsServiceResponse
+			var err error
+			if strings.Contains(tt.contentType, "json") {
+				err = protojson.Unmarshal(w.Body.Bytes(), &resp)
+			} else {
+				err = proto.Unmarshal(w.Body.Bytes(), &resp)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resp.GetPartialSuccess().GetRejectedDataPoints(); got != 1 {
+				t.Errorf("rejected %d points, want 1", got)
+			}
+			if stored, _ := mr.List("metrics:web"); len(stored) != 1 {
+				t.Errorf("stored %d samples, want 1", len(stored))
+			}
+		})
+	}
+}
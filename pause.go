@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// setPaused suspends or resumes anomaly detection for stream. Paused
+// streams keep being stored and their window gauges kept current; only
+// detection, and with it anomaly records, counters and the quick verdict,
+// is skipped. The flag lives in this process only: every replica has to be
+// paused, and a restart resumes the stream.
+func (s *AppState) setPaused(stream string, paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused == nil {
+		s.paused = make(map[string]bool)
+	}
+	if paused {
+		s.paused[stream] = true
+		s.pausedGauge.WithLabelValues(stream).Set(1)
+	} else {
+		delete(s.paused, stream)
+		s.pausedGauge.WithLabelValues(stream).Set(0)
+	}
+}
+
+func (s *AppState) isPaused(stream string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused[stream]
+}
+
+// handleStreamPause serves POST /streams/{stream}/pause and /resume.
+func handleStreamPause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stream, err := streamFromRequest(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		appState.setPaused(stream, paused)
+		log.Printf("Stream %s paused: %t", stream, paused)
+		w.Header().Set("Content-Type", "application/json")
+		encodeJSON(w, map[string]interface{}{"stream": stream, "paused": paused})
+	}
+}
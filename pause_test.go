@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamPause(t *testing.T) {
+	newTestAppState(t)
+	appState.adminToken = "s3cret"
+	appState.detector = &ZScoreDetector{Threshold: 1.5}
+	mux := newMux()
+
+	post := func(target, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	for _, rps := range []float64{10, 11, 10, 11} {
+		post("/analyze/web?sync=true", fmt.Sprintf(`{"rps": %v}`, rps), "")
+	}
+
+	tests := []struct {
+		name       string
+		action     string
+		token      string
+		wantCode   int
+		wantPaused float64
+		wantStatus string
+	}{
+		{name: "no token", action: "pause", wantCode: http.StatusUnauthorized, wantStatus: statusAnomaly},
+		{name: "paused", action: "pause", token: "s3cret", wantCode: http.StatusOK, wantPaused: 1, wantStatus: statusPaused},
+		{name: "paused twice", action: "pause", token: "s3cret", wantCode: http.StatusOK, wantPaused: 1, wantStatus: statusPaused},
+		{name: "resumed", action: "resume", token: "s3cret", wantCode: http.StatusOK, wantStatus: statusAnomaly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := post("/streams/web/"+tt.action, "", tt.token); w.Code != tt.wantCode {
+				t.Fatalf("%s: status = %d, want %d (%s)", tt.action, w.Code, tt.wantCode, w.Body.String())
+			}
+			if got := gaugeValue(appState.pausedGauge.WithLabelValues("web")); got != tt.wantPaused {
+				t.Errorf("paused gauge = %v, want %v", got, tt.wantPaused)
+			}
+
+			before := anomalyCount()
+			w := post("/analyze/web?sync=true", `{"rps": 500}`, "")
+			var got AnalysisResult
+			json.Unmarshal(w.Body.Bytes(), &got)
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q (%s)", got.Status, tt.wantStatus, w.Body.String())
+			}
+			if counted := anomalyCount() - before; (counted > 0) != (tt.wantStatus == statusAnomaly) {
+				t.Errorf("anomalies counted = %v for status %s", counted, tt.wantStatus)
+			}
+			// The spike is stored either way.
+			if window := appState.buffer.window("web"); window[len(window)-1].RPS != 500 {
+				t.Errorf("newest sample = %+v, want the spike", window[len(window)-1])
+			}
+			// Pull the window back to normal for the next case.
+			for _, rps := range []float64{10, 11, 10, 11, 10} {
+				post("/analyze/web?sync=true", fmt.Sprintf(`{"rps": %v}`, rps), "")
+			}
+		})
+	}
+
+	if w := post("/streams/bad%20name/pause", "", "s3cret"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid stream: status = %d, want 400", w.Code)
+	}
+}
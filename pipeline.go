@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+)
+
+// Analysis status values reported by processMetric and the sync /analyze
+// response.
+const (
+	// statusWarming means the window holds fewer than MIN_SAMPLES samples, so
+	// detection was skipped and no verdict is given.
+	statusWarming = "warming"
+	// statusNormal means detection ran and did not flag the sample.
+	statusNormal = "normal"
+	// statusAnomaly means detection ran and flagged the sample.
+	statusAnomaly = "anomaly"
+	// statusPaused means detection is paused for the stream, so the sample
+	// was stored but not scored.
+	statusPaused = "paused"
+)
+
+// AnalysisResult is the outcome of processing one sample, returned as the
+// body of a sync /analyze call. Score, TrendSlope, Drifting, Divergence and
+// Diverging are only set once the window is warm; Breaches lists the
+// series above their static limit and GapSeconds the time since the
+// previous sample when it was overdue, regardless.
+type AnalysisResult struct {
+	Status      string   `json:"status"`
+	Samples     int      `json:"samples"`
+	MinSamples  int      `json:"min_samples"`
+	RollingAvg  float64  `json:"rolling_avg"`
+	WeightedAvg float64  `json:"weighted_avg"`
+	Score       *float64 `json:"score,omitempty"`
+	Severity    string   `json:"severity,omitempty"`
+	// Direction is "up" or "down" for an anomaly.
+	Direction  Direction `json:"direction,omitempty"`
+	TrendSlope *float64  `json:"trend_slope,omitempty"`
+	Drifting   bool      `json:"drifting,omitempty"`
+	Divergence *float64  `json:"divergence,omitempty"`
+	Diverging  bool      `json:"diverging,omitempty"`
+	Breaches   []string  `json:"breaches,omitempty"`
+	// LevelShifts is the direction of each LEVEL_SHIFT_SERIES series that
+	// has shifted to a new level, such as {"rps": "down"}.
+	LevelShifts map[string]Direction `json:"level_shifts,omitempty"`
+	GapSeconds  *float64             `json:"gap_seconds,omitempty"`
+	// Correlation is the Pearson correlation of RPS and CPU over the
+	// window, omitted while either is flat.
+	Correlation *float64 `json:"rps_cpu_correlation,omitempty"`
+}
+
+// processMetric stores m on its stream, recomputes the window aggregates and
+// runs detection. Samples that cannot be stored are dead-lettered before the
+// error is returned, so the caller never has to. While the Redis circuit
+// breaker is open, or with write-behind on, the sample is buffered in
+// memory instead and detection runs against the in-memory window.
+func processMetric(ctx context.Context, logger *slog.Logger, stream string, m Metric) (AnalysisResult, error) {
+	if appState.sampleRate < 1 {
+		return processSampled(ctx, logger, stream, m)
+	}
+	if appState.writeBehind != nil {
+		appState.writeBehind.add(stream, m)
+		return analyzeWindow(ctx, logger, stream, m, appState.buffer.window(stream)), nil
+	}
+	data, err := encodeMetric(m, appState.codec)
+	if err != nil {
+		return AnalysisResult{}, err
+	}
+	var items []string
+	err = withRedisRetry(ctx, logger, func() (err error) {
+		items, err = appState.store.AppendAndRead(ctx, stream, data)
+		return err
+	})
+	if isBreakerRejection(err) {
+		logger.Warn("Redis circuit open, processing metric in memory")
+		appState.buffer.addPending(stream, m)
+		return analyzeWindow(ctx, logger, stream, m, appState.buffer.window(stream)), nil
+	}
+	if err != nil {
+		logger.Error("Redis push/read window error", "error", err)
+		deadLetter(ctx, logger, stream, m, err)
+		return AnalysisResult{}, err
+	}
+	window := decodeWindow(items)
+	appState.buffer.sync(stream, window)
+	return analyzeWindow(ctx, logger, stream, m, window), nil
+}
+
+// processSampled is processMetric when SAMPLE_RATE is below 1. Every sample
+// goes into the in-memory buffer and detection runs against it, so gauges
+// and verdicts still see all traffic; only a random SAMPLE_RATE fraction is
+// written to Redis.
+//
+// Anything computed from Redis instead (history, compaction, replays of
+// stored data) therefore works on a subsample: averages stay unbiased but
+// their standard error grows by roughly 1/sqrt(SAMPLE_RATE), and a window
+// of N stored samples spans about 1/SAMPLE_RATE times as much traffic.
+func processSampled(ctx context.Context, logger *slog.Logger, stream string, m Metric) (AnalysisResult, error) {
+	if rand.Float64() >= appState.sampleRate {
+		appState.buffer.addRecent(stream, m)
+		return analyzeWindow(ctx, logger, stream, m, appState.buffer.window(stream)), nil
+	}
+
+	if appState.writeBehind != nil {
+		appState.writeBehind.add(stream, m)
+		return analyzeWindow(ctx, logger, stream, m, appState.buffer.window(stream)), nil
+	}
+	err := withRedisRetry(ctx, logger, func() error { return storeSample(ctx, stream, m) })
+	switch {
+	case isBreakerRejection(err):
+		logger.Warn("Redis circuit open, processing metric in memory")
+		appState.buffer.addPending(stream, m)
+	case err != nil:
+		logger.Error("Redis push error", "error", err)
+		deadLetter(ctx, logger, stream, m, err)
+		return AnalysisResult{}, err
+	default:
+		appState.buffer.addRecent(stream, m)
+	}
+	return analyzeWindow(ctx, logger, stream, m, appState.buffer.window(stream)), nil
+}
+
+// storeSample appends m to the raw samples of stream and trims them to
+// RAW_RETENTION, without reading the window back.
+func storeSample(ctx context.Context, stream string, m Metric) error {
+	data, err := encodeMetric(m, appState.codec)
+	if err != nil {
+		return err
+	}
+	pipe := appState.redisClient.TxPipeline()
+	appState.store.Append(ctx, pipe, stream, data)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// analyzeWindow updates the window gauges and runs detection for m, the
+// newest sample in window. Detection is skipped until the RPS window holds
+// at least MIN_SAMPLES samples, and while the stream is paused.
+func analyzeWindow(ctx context.Context, logger *slog.Logger, stream string, m Metric, window []Metric) AnalysisResult {
+	var allRPS, cpuValues []float64
+	for _, met := range window {
+		allRPS = append(allRPS, met.RPS)
+		cpuValues = append(cpuValues, met.CPU)
+	}
+	rpsValues := lastN(allRPS, appState.windowFor("rps"))
+	cpuValues = lastN(cpuValues, appState.windowFor("cpu"))
+
+	appState.windowFillGauge.WithLabelValues(stream).Set(windowFillRatio(len(rpsValues), appState.windowFor("rps")))
+
+	// The buffer keeps the RPS stats of the window it holds up to date;
+	// they are only computed here for a window it does not hold.
+	stats, ok := appState.buffer.windowStats(stream, window, appState.windowFor("rps"))
+	if !ok {
+		stats = runningStats{}
+		for _, v := range rpsValues {
+			stats.add(v)
+		}
+	}
+	rollingAvg := stats.mean
+	appState.rollingAvgGauge.WithLabelValues(stream).Set(appState.round(rollingAvg))
+	weightedAvg := calculateWeightedAverage(rpsValues, appState.weighting, appState.weightingAlpha)
+	appState.weightedAvgGauge.WithLabelValues(stream).Set(appState.round(weightedAvg))
+	correlation, correlated := calculateCorrelation(rpsValues, cpuValues)
+	appState.correlationGauge.WithLabelValues(stream).Set(appState.round(correlation))
+	if appState.divergence != nil {
+		shortAvg, longAvg := appState.divergence.Averages(allRPS)
+		appState.shortAvgGauge.WithLabelValues(stream).Set(appState.round(shortAvg))
+		appState.longAvgGauge.WithLabelValues(stream).Set(appState.round(longAvg))
+	}
+
+	result := AnalysisResult{
+		Status:      statusNormal,
+		Samples:     len(rpsValues),
+		MinSamples:  appState.minSamples,
+		RollingAvg:  appState.round(rollingAvg),
+		WeightedAvg: appState.round(weightedAvg),
+		Breaches:    checkStaticLimits(logger, m),
+	}
+	if correlated {
+		rounded := appState.round(correlation)
+		result.Correlation = &rounded
+	}
+	if gap, ok := checkGap(logger, stream, m, window); ok {
+		secs := gap.Seconds()
+		result.GapSeconds = &secs
+	}
+	snap := GaugeSnapshot{
+		Stream:     stream,
+		Timestamp:  m.Timestamp,
+		RPS:        m.RPS,
+		CPU:        m.CPU,
+		RollingAvg: appState.round(rollingAvg),
+	}
+	defer func() {
+		snap.Status = result.Status
+		publishGauges(snap)
+	}()
+
+	if appState.isPaused(stream) {
+		result.Status = statusPaused
+		return result
+	}
+	if len(rpsValues) < appState.minSamples {
+		result.Status = statusWarming
+		logSampled(logger, "Window warming up, detection skipped", "samples", len(rpsValues), "min_samples", appState.minSamples)
+		return result
+	}
+
+	// Run anomaly detection for the current RPS value
+	detector := appState.currentDetector()
+	score, anomalous := detector.Detect(rpsValues, m.RPS)
+	rounded := appState.round(score)
+	result.Score = &rounded
+	direction := directionOf(rpsValues, m.RPS)
+	if anomalous && !appState.directionFor("rps").allows(direction) {
+		logSampled(logger, "Anomaly outside ANOMALY_DIRECTIONS ignored", "rps", m.RPS, "score", score, "direction", direction)
+		anomalous = false
+	}
+	if anomalous {
+		severity := appState.currentSeverity().classify(severityScore(detector, score))
+		result.Status, result.Severity, result.Direction = statusAnomaly, severity, direction
+		logger.Warn("ANOMALY DETECTED!", "rps", m.RPS, "score", score, "detector", detector.Name(), "severity", severity, "direction", direction)
+		appState.anomalyCounter.WithLabelValues(severity).Inc()
+		rec := AnomalyRecord{
+			ID:        newRequestID(),
+			Stream:    stream,
+			Timestamp: m.Timestamp,
+			RPS:       m.RPS,
+			Score:     score,
+			Detector:  detector.Name(),
+			Severity:  severity,
+			Direction: direction,
+		}
+		recordAnomaly(ctx, logger, rec)
+		appState.anomalyHub.publish(rec)
+		appState.anomalyContexts.add(rec.ID, AnomalyContext{
+			Anomaly:    rec,
+			Window:     append([]Metric(nil), window[len(window)-len(rpsValues):]...),
+			Samples:    len(rpsValues),
+			RollingAvg: rollingAvg,
+			StdDev:     stats.stdDev(appState.stdDevMethod),
+		})
+	}
+
+	// Detect sustained drift that stays within the z-score band. Drift is
+	// counted separately so it does not change what the anomaly counter
+	// means to existing alerts.
+	slope, drifting := appState.trend.Detect(rpsValues, m.RPS)
+	roundedSlope := appState.round(slope)
+	appState.trendGauge.WithLabelValues(stream).Set(roundedSlope)
+	result.TrendSlope, result.Drifting = &roundedSlope, drifting
+	snap.TrendSlope = roundedSlope
+	if drifting {
+		logger.Warn("TREND DETECTED!", "rps_slope", slope)
+		appState.trendCounter.Inc()
+	}
+
+	// Compare the short and long windows, which catches both fast spikes
+	// and shifts the single window has already absorbed.
+	if appState.divergence != nil {
+		divergence, diverging := appState.divergence.Detect(allRPS, m.RPS)
+		roundedDivergence := appState.round(divergence)
+		result.Divergence, result.Diverging = &roundedDivergence, diverging
+		if diverging {
+			logger.Warn("DIVERGENCE DETECTED!", "score", divergence,
+				"short_window", appState.divergence.Short, "long_window", appState.divergence.Long)
+			appState.divergenceCounter.Inc()
+		}
+	}
+
+	// Look for sustained shifts in level, such as traffic that dropped and
+	// stayed down, which single-sample scores see at most once.
+	result.LevelShifts = checkLevelShifts(logger, map[string][]float64{"rps": rpsValues, "cpu": cpuValues})
+
+	logSampled(logger, "Processed metric", "timestamp", m.Timestamp.Format("15:04:05"),
+		"rps", m.RPS, "cpu", m.CPU, "rolling_avg_rps", rollingAvg)
+	return result
+}
+
+// quickVerdict scores m against the in-memory buffer for its stream with
+// the quick z-score detector, without touching Redis. ok is false while the
+// buffer has fewer than MIN_SAMPLES samples or the stream is paused, where
+// no verdict is given.
+func quickVerdict(stream string, m Metric) (score float64, anomalous, ok bool) {
+	buffered := appState.buffer.window(stream)
+	window := make([]float64, 0, len(buffered)+1)
+	for _, met := range buffered {
+		window = append(window, met.RPS)
+	}
+	window = lastN(append(window, m.RPS), appState.windowFor("rps"))
+	if len(window) < appState.minSamples || appState.isPaused(stream) {
+		return 0, false, false
+	}
+	appState.cfgMu.RLock()
+	detector := appState.quickDetector
+	appState.cfgMu.RUnlock()
+	score, anomalous = detector.Detect(window, m.RPS)
+	anomalous = anomalous && appState.directionFor("rps").allows(directionOf(window, m.RPS))
+	return score, anomalous, true
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSyncAnalyzeStatus(t *testing.T) {
+	newTestAppState(t)
+	appState.minSamples = 3
+	mux := newMux()
+
+	tests := []struct {
+		rps         float64
+		wantStatus  string
+		wantSamples int
+		wantScore   bool
+	}{
+		{rps: 10, wantStatus: statusWarming, wantSamples: 1},
+		{rps: 11, wantStatus: statusWarming, wantSamples: 2},
+		{rps: 10, wantStatus: statusNormal, wantSamples: 3, wantScore: true},
+		{rps: 11, wantStatus: statusNormal, wantSamples: 4, wantScore: true},
+		{rps: 500, wantStatus: statusNormal, wantSamples: 5, wantScore: true},
+	}
+	// A z-score over 5 samples cannot exceed (n-1)/sqrt(n) ~ 1.79, so use a
+	// lower threshold to see the spike flagged.
+	appState.detector = &ZScoreDetector{Threshold: 1.5}
+	tests[4].wantStatus = statusAnomaly
+
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		body := strings.NewReader(fmt.Sprintf(`{"rps": %v}`, tt.rps))
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze/web?sync=true", body))
+		if w.Code != http.StatusOK {
+			t.Fatalf("sample %d: status = %d, want 200", i, w.Code)
+		}
+		var raw map[string]interface{}
+		var got AnalysisResult
+		data := w.Body.Bytes()
+		json.Unmarshal(data, &raw)
+		json.Unmarshal(data, &got)
+		if got.Status != tt.wantStatus || got.Samples != tt.wantSamples || got.MinSamples != 3 {
+			t.Errorf("sample %d: got %s, want status %s with %d samples", i, data, tt.wantStatus, tt.wantSamples)
+		}
+		if _, hasScore := raw["score"]; hasScore != tt.wantScore {
+			t.Errorf("sample %d: score present = %v, want %v (%s)", i, hasScore, tt.wantScore, data)
+		}
+	}
+}
+
+func TestAnalyzeRejectsBadSyncFlag(t *testing.T) {
+	newTestAppState(t)
+	w := httptest.NewRecorder()
+	newMux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze?sync=maybe", strings.NewReader(`{"rps": 1}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestWarmupSuppressesDetection(t *testing.T) {
+	newTestAppState(t)
+	appState.minSamples = 5
+	appState.detector = &ZScoreDetector{Threshold: 0.1}
+	window := []Metric{{RPS: 1}, {RPS: 1}, {RPS: 100}}
+	got := analyzeWindow(t.Context(), slog.Default(), defaultStream, window[2], window)
+	if got.Status != statusWarming || got.Score != nil || anomalyCount() != 0 {
+		t.Errorf("result = %+v, anomalies = %v; want warming with no detection", got, anomalyCount())
+	}
+}
+
+func TestAnalyzeQuickVerdictHeaders(t *testing.T) {
+	newTestAppState(t)
+	appState.minSamples = 4
+	appState.quickDetector = &ZScoreDetector{Threshold: 1.4}
+	// Separate streams, so the background processing of one request cannot
+	// change the buffer the next one is scored against.
+	for _, stream := range []string{"a", "b"} {
+		for _, rps := range []float64{10, 11, 10} {
+			appState.buffer.addPending(stream, Metric{RPS: rps})
+		}
+	}
+	mux := newMux()
+
+	tests := []struct {
+		name        string
+		target      string
+		rps         float64
+		wantAnomaly string
+		wantScore   string
+	}{
+		{name: "warming stream has no verdict", target: "/analyze/empty", rps: 500},
+		{name: "normal", target: "/analyze/a", rps: 11, wantAnomaly: "false", wantScore: "0.866"},
+		{name: "spike", target: "/analyze/b", rps: 500, wantAnomaly: "true", wantScore: "1.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(fmt.Sprintf(`{"rps": %v}`, tt.rps))))
+			if w.Code != http.StatusAccepted {
+				t.Fatalf("status = %d, want 202", w.Code)
+			}
+			if got := w.Header().Get("X-Anomaly"); got != tt.wantAnomaly {
+				t.Errorf("X-Anomaly = %q, want %q", got, tt.wantAnomaly)
+			}
+			if got := w.Header().Get("X-Anomaly-Score"); got != tt.wantScore {
+				t.Errorf("X-Anomaly-Score = %q, want %q", got, tt.wantScore)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+		})
+	}
+}
+
+func TestSamplingSkipsRedisButNotGauges(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.sampleRate = 0
+	mux := newMux()
+	for _, rps := range []string{"10", "20", "30"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze?sync=true", strings.NewReader(`{"rps": `+rps+`}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+	}
+	if stored, _ := mr.List("metrics"); len(stored) != 0 {
+		t.Errorf("stored %d samples with SAMPLE_RATE=0, want 0", len(stored))
+	}
+	if got := counterValue(appState.requestCounter); got != 3 {
+		t.Errorf("request counter = %v, want 3", got)
+	}
+	if got := gaugeValue(appState.rollingAvgGauge.WithLabelValues(defaultStream)); got != 20 {
+		t.Errorf("rolling average = %v, want 20 over every sample", got)
+	}
+}
+
+func TestSamplingStoresFraction(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.sampleRate = 0.5
+	appState.rawRetention = 10000
+	const n = 2000
+	for i := 0; i < n; i++ {
+		if _, err := processMetric(t.Context(), slog.Default(), defaultStream, Metric{RPS: float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored, _ := mr.List("metrics")
+	// Binomial(2000, 0.5) has a standard deviation of ~22; allow 6 of them.
+	if len(stored) < 870 || len(stored) > 1130 {
+		t.Errorf("stored %d of %d samples at SAMPLE_RATE=0.5", len(stored), n)
+	}
+	if got := rpsOf(appState.buffer.window(defaultStream)); len(got) != 5 || got[4] != n-1 {
+		t.Errorf("buffer window = %v, want the last 5 samples", got)
+	}
+}
+
+func TestSyncAnalyzeDivergence(t *testing.T) {
+	newTestAppState(t)
+	appState.divergence = &DivergenceDetector{Short: 2, Long: 6, Threshold: 1}
+	appState.minSamples = 1
+	mux := newMux()
+
+	tests := []struct {
+		rps           float64
+		wantDiverging bool
+	}{
+		{rps: 10}, {rps: 11}, {rps: 10}, {rps: 11},
+		{rps: 30},
+		{rps: 30, wantDiverging: true},
+	}
+	var result AnalysisResult
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		body := strings.NewReader(fmt.Sprintf(`{"rps": %v}`, tt.rps))
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze/div?sync=true", body))
+		if w.Code != http.StatusOK {
+			t.Fatalf("sample %d: status = %d, want 200", i, w.Code)
+		}
+		result = AnalysisResult{}
+		json.NewDecoder(w.Body).Decode(&result)
+		if result.Divergence == nil || result.Diverging != tt.wantDiverging {
+			t.Errorf("sample %d: divergence = %v, diverging %v; want diverging %v", i, result.Divergence, result.Diverging, tt.wantDiverging)
+		}
+	}
+	// The long window reaches past the 5-sample RPS window.
+	if result.Samples != 5 || *result.Divergence != 1.2897 {
+		t.Errorf("result = %+v, want 5 samples and divergence 1.2897", result)
+	}
+	if got := gaugeValue(appState.shortAvgGauge.WithLabelValues("div")); got != 30 {
+		t.Errorf("short avg gauge = %v, want 30", got)
+	}
+	if got := gaugeValue(appState.longAvgGauge.WithLabelValues("div")); got != 17 {
+		t.Errorf("long avg gauge = %v, want 17", got)
+	}
+	if got := counterValue(appState.divergenceCounter); got != 1 {
+		t.Errorf("divergence counter = %v, want 1", got)
+	}
+}
+
+// TestGaugesPerStreamUnderConcurrency feeds two streams at once; run it
+// with -race. Each stream's gauges must end on that stream's own values,
+// not whichever stream happened to write last.
+func TestGaugesPerStreamUnderConcurrency(t *testing.T) {
+	newTestAppState(t)
+	mux := newMux()
+	streams := map[string][]float64{
+		"web": {10, 20, 30, 40, 50, 60, 70},
+		"api": {1, 2, 3, 4, 5, 6, 7},
+	}
+
+	var wg sync.WaitGroup
+	for stream, values := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, rps := range values {
+				w := httptest.NewRecorder()
+				body := strings.NewReader(fmt.Sprintf(`{"rps": %v, "cpu": %v}`, rps, rps/10))
+				mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze/"+stream+"?sync=true", body))
+				if w.Code != http.StatusOK {
+					t.Errorf("%s: status = %d", stream, w.Code)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	tests := []struct {
+		stream                string
+		wantRPS, wantCPU, avg float64
+	}{
+		{stream: "web", wantRPS: 70, wantCPU: 7, avg: 50},
+		{stream: "api", wantRPS: 7, wantCPU: 0.7, avg: 5},
+	}
+	for _, tt := range tests {
+		if got := gaugeValue(appState.rpsGauge.WithLabelValues(tt.stream)); got != tt.wantRPS {
+			t.Errorf("%s: rps gauge = %v, want %v", tt.stream, got, tt.wantRPS)
+		}
+		if got := gaugeValue(appState.cpuGauge.WithLabelValues(tt.stream)); got != tt.wantCPU {
+			t.Errorf("%s: cpu gauge = %v, want %v", tt.stream, got, tt.wantCPU)
+		}
+		if got := gaugeValue(appState.rollingAvgGauge.WithLabelValues(tt.stream)); got != tt.avg {
+			t.Errorf("%s: rolling avg gauge = %v, want %v", tt.stream, got, tt.avg)
+		}
+	}
+}
+
+func TestAnalyzeWindowCorrelation(t *testing.T) {
+	newTestAppState(t)
+	tests := []struct {
+		name      string
+		window    []Metric
+		want      *float64
+		wantGauge float64
+	}{
+		{name: "cpu tracks rps", window: []Metric{{RPS: 10, CPU: 20}, {RPS: 20, CPU: 40}, {RPS: 30, CPU: 60}}, want: ptr(1), wantGauge: 1},
+		{name: "flat cpu", window: []Metric{{RPS: 10, CPU: 50}, {RPS: 20, CPU: 50}, {RPS: 30, CPU: 50}}, want: nil, wantGauge: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := analyzeWindow(t.Context(), slog.Default(), "web", tt.window[len(tt.window)-1], tt.window)
+			if (got.Correlation == nil) != (tt.want == nil) || (got.Correlation != nil && *got.Correlation != *tt.want) {
+				t.Errorf("correlation = %v, want %v", got.Correlation, tt.want)
+			}
+			if g := gaugeValue(appState.correlationGauge.WithLabelValues("web")); g != tt.wantGauge {
+				t.Errorf("gauge = %v, want %v", g, tt.wantGauge)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pointJoiner turns the single-valued data points of metrics systems that
+// send CPU and RPS as separate series (OTLP, Prometheus remote write) into
+// Metrics, one per stream and timestamp. Several CPU points for one sample,
+// as from per-core utilization, are averaged; several RPS points, as from
+// per-route request rates, are added up.
+//
+// A joiner with a pointHold only processes complete samples, ones with a
+// point for each field the transport maps. The rest wait in the hold for
+// the missing field, as it may come in a later request or flush, and are
+// dropped if it does not come in time: a missing field is never stored as
+// 0, which would read as an outage.
+type pointJoiner struct {
+	samples  map[pointKey]*pointSample
+	rejected int64
+	reasons  []string
+
+	hold             *pointHold
+	needCPU, needRPS bool
+}
+
+type pointKey struct {
+	stream string
+	ts     time.Time
+}
+
+type pointSample struct {
+	cpuSum float64
+	cpuN   int
+	rps    float64
+	rpsN   int
+	points int64
+}
+
+// merge adds the points of o to s.
+func (s *pointSample) merge(o *pointSample) {
+	s.cpuSum += o.cpuSum
+	s.cpuN += o.cpuN
+	s.rps += o.rps
+	s.rpsN += o.rpsN
+	s.points += o.points
+}
+
+func newPointJoiner() *pointJoiner {
+	return &pointJoiner{samples: make(map[pointKey]*pointSample)}
+}
+
+// newHeldPointJoiner returns a pointJoiner that holds samples in hold until
+// they have a CPU point, if needCPU, and an RPS point, if needRPS.
+func newHeldPointJoiner(hold *pointHold, needCPU, needRPS bool) *pointJoiner {
+	j := newPointJoiner()
+	j.hold, j.needCPU, j.needRPS = hold, needCPU, needRPS
+	return j
+}
+
+// pointJoinWindow is how long a sample of a request-based transport waits
+// in its pointHold for its other field.
+const pointJoinWindow = time.Minute
+
+// pointHold keeps incomplete samples across the requests or flushes of one
+// transport. A sample is dropped once it has waited window since its first
+// point arrived.
+type pointHold struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	samples map[pointKey]*heldSample
+}
+
+type heldSample struct {
+	pointSample
+	since time.Time
+}
+
+func newPointHold(window time.Duration) *pointHold {
+	return &pointHold{window: window, samples: make(map[pointKey]*heldSample)}
+}
+
+// join merges samples into the hold and takes out the ones that are now
+// complete, dropping those that waited too long.
+func (h *pointHold) join(logger *slog.Logger, samples map[pointKey]*pointSample, needCPU, needRPS bool) map[pointKey]*pointSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := appState.now()
+	for k, s := range samples {
+		held := h.samples[k]
+		if held == nil {
+			held = &heldSample{since: now}
+			h.samples[k] = held
+		}
+		held.merge(s)
+	}
+	ready := make(map[pointKey]*pointSample)
+	for k, held := range h.samples {
+		switch missing := held.missing(needCPU, needRPS); {
+		case missing == "":
+			ready[k] = &held.pointSample
+			delete(h.samples, k)
+		case now.Sub(held.since) >= h.window:
+			logger.Warn("Dropping sample never joined with its other field", "stream", k.stream, "timestamp", k.ts, "missing", missing)
+			delete(h.samples, k)
+		}
+	}
+	return ready
+}
+
+// missing names the field s lacks a point for, or returns "".
+func (s *pointSample) missing(needCPU, needRPS bool) string {
+	switch {
+	case needCPU && s.cpuN == 0:
+		return "cpu"
+	case needRPS && s.rpsN == 0:
+		return "rps"
+	}
+	return ""
+}
+
+// reject counts n points as rejected, keeping the first few reasons.
+func (j *pointJoiner) reject(n int64, reason string) {
+	j.rejected += n
+	if len(j.reasons) < 5 {
+		j.reasons = append(j.reasons, reason)
+	}
+}
+
+// add records a point of a canonical-unit CPU or RPS value. A zero ts is
+// the arrival time.
+func (j *pointJoiner) add(stream string, ts time.Time, cpu bool, value float64) {
+	key := pointKey{stream: stream, ts: ts}
+	s := j.samples[key]
+	if s == nil {
+		s = &pointSample{}
+		j.samples[key] = s
+	}
+	if cpu {
+		s.cpuSum += value
+		s.cpuN++
+	} else {
+		s.rps += value
+		s.rpsN++
+	}
+	s.points++
+}
+
+// process runs the joined samples through processMetric in timestamp
+// order, rejecting the points of samples that fail, and returns the number
+// of points rejected in all and why. With a hold, only the samples it
+// completes are processed.
+func (j *pointJoiner) process(ctx context.Context, logger *slog.Logger) (int64, string) {
+	samples := j.samples
+	if j.hold != nil {
+		samples = j.hold.join(logger, j.samples, j.needCPU, j.needRPS)
+	}
+	keys := make([]pointKey, 0, len(samples))
+	for k := range samples {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b pointKey) int {
+		return cmp.Or(a.ts.Compare(b.ts), strings.Compare(a.stream, b.stream))
+	})
+	for _, k := range keys {
+		s := samples[k]
+		m := Metric{Timestamp: k.ts, RPS: s.rps}
+		if s.cpuN > 0 {
+			m.CPU = s.cpuSum / float64(s.cpuN)
+		}
+		logger := logger.With("stream", k.stream)
+		var err error
+		if m, err = stampMetric(logger, m); err != nil {
+			j.reject(s.points, err.Error())
+			continue
+		}
+		if err := recordIntake(ctx, logger, k.stream, m); err != nil {
+			j.reject(s.points, "error incrementing counter")
+			continue
+		}
+		if _, err := processMetric(ctx, logger, k.stream, m); err != nil {
+			j.reject(s.points, "error processing metric")
+		}
+	}
+	return j.rejected, strings.Join(j.reasons, "; ")
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// registerPprof adds the net/http/pprof handlers under /debug/pprof/. It is
+// only called when ENABLE_PPROF=true: profiles expose internals and the
+// CPU profile and trace endpoints are expensive to serve.
+//
+// pprof.Index serves the named profiles (heap, goroutine, allocs, ...) from
+// the path, so one wildcard route covers them.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegisterPprof(t *testing.T) {
+	newTestAppState(t)
+	tests := []struct {
+		name     string
+		enabled  bool
+		target   string
+		wantCode int
+	}{
+		{name: "disabled index", target: "/debug/pprof/", wantCode: http.StatusNotFound},
+		{name: "disabled heap", target: "/debug/pprof/heap", wantCode: http.StatusNotFound},
+		{name: "index", enabled: true, target: "/debug/pprof/", wantCode: http.StatusOK},
+		{name: "goroutine", enabled: true, target: "/debug/pprof/goroutine?debug=1", wantCode: http.StatusOK},
+		{name: "cmdline", enabled: true, target: "/debug/pprof/cmdline", wantCode: http.StatusOK},
+		{name: "other routes kept", enabled: true, target: "/health", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := newMux()
+			if tt.enabled {
+				registerPprof(mux)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
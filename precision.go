@@ -0,0 +1,28 @@
+package main
+
+import "math"
+
+// maxResponsePrecision keeps RESPONSE_PRECISION within what a float64 can
+// meaningfully carry.
+const maxResponsePrecision = 15
+
+// roundTo rounds v to places decimal places. NaN and infinities are
+// returned unchanged.
+func roundTo(v float64, places int) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+	scale := math.Pow10(places)
+	rounded := math.Round(v*scale) / scale
+	if math.IsInf(rounded, 0) || math.IsNaN(rounded) {
+		// v*scale overflowed; v is too large to have a fractional part.
+		return v
+	}
+	return rounded
+}
+
+// round applies RESPONSE_PRECISION to a computed aggregate. It is for
+// presentation only: detection always works on the unrounded values.
+func (s *AppState) round(v float64) float64 {
+	return roundTo(v, s.responsePrecision)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoundTo(t *testing.T) {
+	tests := []struct {
+		v      float64
+		places int
+		want   float64
+	}{
+		{v: 33.33333333333336, places: 4, want: 33.3333},
+		{v: 2.846049894151541, places: 2, want: 2.85},
+		{v: -2.846049894151541, places: 2, want: -2.85},
+		{v: 0.00004, places: 4, want: 0},
+		{v: 12.5, places: 0, want: 13},
+		{v: 1e300, places: 15, want: 1e300},
+		{v: math.Inf(1), places: 4, want: math.Inf(1)},
+	}
+	for _, tt := range tests {
+		if got := roundTo(tt.v, tt.places); got != tt.want {
+			t.Errorf("roundTo(%v, %d) = %v, want %v", tt.v, tt.places, got, tt.want)
+		}
+	}
+	if got := roundTo(math.NaN(), 4); !math.IsNaN(got) {
+		t.Errorf("roundTo(NaN) = %v, want NaN", got)
+	}
+}
+
+func TestSyncAnalyzeRoundsAggregates(t *testing.T) {
+	newTestAppState(t)
+	appState.minSamples = 3
+	mux := newMux()
+	var body string
+	for _, rps := range []string{"10", "20", "70"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze?sync=true", strings.NewReader(`{"rps": `+rps+`}`)))
+		body = w.Body.String()
+	}
+	// mean 33.333..., z-score 1.1406..., slope 30
+	for _, want := range []string{`"rolling_avg":33.3333,`, `"score":1.1406,`, `"trend_slope":30`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("response %s does not contain %s", body, want)
+		}
+	}
+	if got := gaugeValue(appState.rollingAvgGauge.WithLabelValues(defaultStream)); got != 33.3333 {
+		t.Errorf("rolling average gauge = %v, want 33.3333", got)
+	}
+}
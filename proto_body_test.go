@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-stream-processing/ingestpb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProtobufBodies(t *testing.T) {
+	marshal := func(m proto.Message) []byte {
+		data, err := proto.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        []byte
+		wantCode    int
+		wantStored  int
+	}{
+		{
+			name: "metric", target: "/analyze/web?sync=true", contentType: "application/x-protobuf",
+			body:     marshal(&ingestpb.Metric{Rps: 10}),
+			wantCode: http.StatusOK, wantStored: 1,
+		},
+		{
+			name: "stream field ignored", target: "/analyze/web?sync=true", contentType: "application/protobuf",
+			body:     marshal(&ingestpb.Metric{Stream: "api", Rps: 10}),
+			wantCode: http.StatusOK, wantStored: 1,
+		},
+		{
+			name: "per minute", target: "/analyze/web?sync=true", contentType: "application/x-protobuf",
+			body:     marshal(&ingestpb.Metric{Rps: 600, RpsUnit: "per_minute"}),
+			wantCode: http.StatusOK, wantStored: 1,
+		},
+		{
+			name: "batch", target: "/analyze/batch?stream=web", contentType: "application/x-protobuf",
+			body:     marshal(&ingestpb.MetricBatch{Metrics: []*ingestpb.Metric{{Rps: 1}, {Rps: 2}, {Rps: 3}}}),
+			wantCode: http.StatusOK, wantStored: 3,
+		},
+		{
+			name: "empty batch", target: "/analyze/batch?stream=web", contentType: "application/x-protobuf",
+			body:     marshal(&ingestpb.MetricBatch{}),
+			wantCode: http.StatusBadRequest,
+		},
+		{name: "garbage", target: "/analyze/web?sync=true", contentType: "application/x-protobuf", body: []byte{0xff, 0xff, 0xff}, wantCode: http.StatusBadRequest},
+		{
+			name: "too large", target: "/analyze/web?sync=true", contentType: "application/x-protobuf",
+			body:     marshal(&ingestpb.Metric{Rps: 10, Stream: strings.Repeat("x", maxMetricBodyBytes)}),
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+		{name: "garbage batch", target: "/analyze/batch?stream=web", contentType: "application/x-protobuf", body: []byte{0xff, 0xff, 0xff}, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			req := httptest.NewRequest(http.MethodPost, tt.target, bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode == http.StatusBadRequest && !strings.Contains(w.Body.String(), "invalid_") {
+				t.Errorf("body %s, want an invalid_* error code", w.Body.String())
+			}
+			if stored, _ := mr.List("metrics:web"); len(stored) != tt.wantStored {
+				t.Errorf("stored %d samples, want %d", len(stored), tt.wantStored)
+			}
+		})
+	}
+}
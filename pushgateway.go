@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// newPusher pushes everything in g to the Pushgateway at url under job,
+// grouped by instance so replicas do not overwrite each other.
+func newPusher(url, job string, g prometheus.Gatherer) *push.Pusher {
+	instance, err := os.Hostname()
+	if err != nil || instance == "" {
+		instance = "unknown"
+	}
+	return push.New(url, job).Gatherer(g).Grouping("instance", instance)
+}
+
+// pushMetrics does one push, bounded by timeout. Failures are logged only;
+// a missing Pushgateway must not stop the service or its shutdown.
+func pushMetrics(p *push.Pusher, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := p.PushContext(ctx); err != nil {
+		log.Printf("Pushgateway push failed: %v", err)
+	}
+}
+
+// pushPeriodically pushes every interval until ctx is cancelled.
+func pushPeriodically(ctx context.Context, p *push.Pusher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pushMetrics(p, interval)
+		}
+	}
+}
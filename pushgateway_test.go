@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fakePushgateway records the method, path and body of every push.
+type fakePushgateway struct {
+	mu     sync.Mutex
+	pushes []string
+}
+
+func (f *fakePushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	f.pushes = append(f.pushes, r.Method+" "+r.URL.Path+"\n"+string(body))
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *fakePushgateway) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.pushes)
+}
+
+func TestPushMetrics(t *testing.T) {
+	gw := &fakePushgateway{}
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "go_service_requests_total", Help: "test"})
+	reg.MustRegister(c)
+	c.Add(3)
+
+	pushMetrics(newPusher(srv.URL, "batch", reg), time.Second)
+	if gw.count() != 1 {
+		t.Fatalf("pushes = %d, want 1", gw.count())
+	}
+	push := gw.pushes[0]
+	if !strings.HasPrefix(push, "PUT /metrics/job/batch/instance/") {
+		t.Errorf("push request = %q, want PUT to job batch grouped by instance", strings.SplitN(push, "\n", 2)[0])
+	}
+	if !strings.Contains(push, "go_service_requests_total") {
+		t.Error("push body does not contain go_service_requests_total")
+	}
+}
+
+func TestPushMetricsToleratesFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	// Must log and return rather than panic or block.
+	pushMetrics(newPusher(srv.URL, "batch", prometheus.NewRegistry()), time.Second)
+}
+
+func TestPushPeriodically(t *testing.T) {
+	gw := &fakePushgateway{}
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pushPeriodically(ctx, newPusher(srv.URL, "batch", prometheus.NewRegistry()), 10*time.Millisecond)
+		close(done)
+	}()
+	for i := 0; i < 100 && gw.count() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if gw.count() < 2 {
+		t.Errorf("pushes = %d, want at least 2", gw.count())
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisIngestBatch is how many entries one XREADGROUP or XAUTOCLAIM
+	// asks for.
+	redisIngestBatch = 100
+	// redisIngestBlock is how long XREADGROUP waits for new entries. A
+	// blocked read is not cut short by shutdown, so it also bounds how
+	// long the consumer holds shutdown up.
+	redisIngestBlock = 2 * time.Second
+)
+
+// redisIngestConsumer feeds metrics XADDed to a Redis stream
+// (REDIS_INGEST_STREAM) through the /analyze pipeline as consumer
+// REDIS_INGEST_CONSUMER of the group REDIS_INGEST_GROUP, which it creates
+// at the stream's end if it does not exist. An entry holds its metric as
+// JSON in a "metric" field, or as fields of its own ("cpu", "rps",
+// "timestamp", ... as FIELD_MAPPING names them), and may name its stream
+// in a "stream" field.
+//
+// Entries are acknowledged once handled; samples that fail go to the
+// dead-letter list as on every ingest path. Entries read but never
+// acknowledged, because a consumer died mid-batch, stay pending in the
+// group: on start the consumer first re-reads its own, and it claims those
+// of other consumers once they have been idle for REDIS_INGEST_CLAIM_IDLE,
+// so a restart or a lost pod does not lose data.
+type redisIngestConsumer struct {
+	client    redis.UniversalClient
+	key       string
+	group     string
+	consumer  string
+	claimIdle time.Duration
+	// messages counts consumed entries by result: "processed", "invalid"
+	// or "error".
+	messages *prometheus.CounterVec
+}
+
+// defaultRedisIngestConsumer names the consumer after the host, which is
+// the pod name in Kubernetes, so a restarted pod takes back its own
+// pending entries.
+func defaultRedisIngestConsumer() string {
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "go-service"
+}
+
+// run consumes entries until ctx is cancelled.
+func (c *redisIngestConsumer) run(ctx context.Context) {
+	for {
+		err := c.client.XGroupCreateMkStream(ctx, c.key, c.group, "$").Err()
+		if err == nil || strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			break
+		}
+		if !c.pause(ctx, "Redis ingest group create error", err) {
+			return
+		}
+	}
+
+	// Entries delivered to this consumer before a restart come first;
+	// reading from "0" returns them instead of new ones.
+	start := "0"
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= c.claimIdle {
+			c.claim(ctx)
+			lastClaim = time.Now()
+		}
+		id := ">"
+		if start != "" {
+			id = start
+		}
+		streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.consumer,
+			Streams:  []string{c.key, id},
+			Count:    redisIngestBatch,
+			Block:    redisIngestBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil && !c.pause(ctx, "Redis ingest read error", err) {
+				return
+			}
+			continue
+		}
+		var msgs []redis.XMessage
+		if len(streams) > 0 {
+			msgs = streams[0].Messages
+		}
+		if start != "" {
+			if len(msgs) == 0 {
+				start = ""
+				continue
+			}
+			start = msgs[len(msgs)-1].ID
+		}
+		c.handleAll(ctx, msgs)
+	}
+}
+
+// claim takes over the entries other consumers left pending for longer
+// than claimIdle, and handles them.
+func (c *redisIngestConsumer) claim(ctx context.Context) {
+	cursor := "0-0"
+	for {
+		msgs, next, err := c.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   c.key,
+			Group:    c.group,
+			Consumer: c.consumer,
+			MinIdle:  c.claimIdle,
+			Start:    cursor,
+			Count:    redisIngestBatch,
+		}).Result()
+
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Redis ingest claim error", "error", err, "key", c.key)
+			}
+			return
+		}
+		if len(msgs) > 0 {
+			slog.Info("Claimed pending Redis ingest entries", "count", len(msgs), "key", c.key)
+		}
+		c.handleAll(ctx, msgs)
+		if next == "0-0" || ctx.Err() != nil {
+			return
+		}
+		cursor = next
+	}
+}
+
+// handleAll handles msgs in order and acknowledges them.
+func (c *redisIngestConsumer) handleAll(ctx context.Context, msgs []redis.XMessage) {
+	if len(msgs) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		c.messages.WithLabelValues(c.handle(ctx, msg)).Inc()
+		ids = append(ids, msg.ID)
+	}
+	// An entry left unacknowledged is only handled again, which
+	// at-least-once delivery already allows for.
+	if err := c.client.XAck(ctx, c.key, c.group, ids...).Err(); err != nil && ctx.Err() == nil {
+		slog.Error("Redis ingest ack error", "error", err, "key", c.key)
+	}
+}
+
+// handle runs one entry through the pipeline and returns its result label.
+func (c *redisIngestConsumer) handle(ctx context.Context, msg redis.XMessage) string {
+	stream := defaultStream
+	if s, ok := msg.Values["stream"].(string); ok && s != "" {
+		stream = s
+	}
+	logger := slog.Default().With("transport", "redis_stream", "stream", stream, "id", msg.ID)
+	return ingestMessage(ctx, logger, stream, redisEntryPayload(msg.Values))
+}
+
+// redisEntryPayload returns the metric JSON of an entry: its "metric"
+// field, or an object of its other fields, numbers as numbers.
+func redisEntryPayload(values map[string]interface{}) []byte {
+	if metric, ok := values["metric"].(string); ok {
+		return []byte(metric)
+	}
+	obj := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		s, ok := v.(string)
+		if !ok || k == "stream" {
+			continue
+		}
+		// Values that are JSON numbers, epoch timestamps included, go in
+		// as numbers.
+		var f float64
+		if json.Unmarshal([]byte(s), &f) == nil {
+			obj[k] = f
+		} else {
+			obj[k] = s
+		}
+	}
+	data, _ := json.Marshal(obj)
+	return data
+}
+
+// pause logs err and waits a second before the next attempt, reporting
+// false if ctx was cancelled meanwhile.
+func (c *redisIngestConsumer) pause(ctx context.Context, msg string, err error) bool {
+	slog.Error(msg, "error", err, "key", c.key)
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(time.Second):
+		return true
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisEntryPayload(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		want   map[string]interface{}
+	}{
+		{name: "metric field", values: map[string]interface{}{"stream": "web", "metric": `{"rps":1}`}, want: map[string]interface{}{"rps": 1.0}},
+		{
+			name:   "flat fields",
+			values: map[string]interface{}{"stream": "web", "rps": "12.5", "cpu": "40", "timestamp": "2024-01-01T00:00:00Z", "rps_unit": "per_minute"},
+			want:   map[string]interface{}{"rps": 12.5, "cpu": 40.0, "timestamp": "2024-01-01T00:00:00Z", "rps_unit": "per_minute"},
+		},
+		{name: "not a number", values: map[string]interface{}{"rps": "NaN"}, want: map[string]interface{}{"rps": "NaN"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]interface{}
+			if err := json.Unmarshal(redisEntryPayload(tt.values), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %v, want %v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisIngestConsumer(t *testing.T) {
+	mr := newTestAppState(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+	const key = "ingest"
+	add := func(values ...interface{}) {
+		t.Helper()
+		if err := client.XAdd(ctx, &redis.XAddArgs{Stream: key, Values: values}).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A consumer that died after reading an entry left it pending.
+	if err := client.XGroupCreateMkStream(ctx, key, "go-service", "$").Err(); err != nil {
+		t.Fatal(err)
+	}
+	add("stream", "web", "rps", "10")
+	if err := client.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "go-service", Consumer: "dead", Streams: []string{key, ">"}}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	add("stream", "web", "metric", `{"rps": 20}`)
+	add("stream", "web", "metric", `{"rps": "high"}`)
+
+	messages := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "redis_ingest"}, []string{"result"})
+	c := &redisIngestConsumer{client: client, key: key, group: "go-service", consumer: "web-0", claimIdle: 10 * time.Millisecond, messages: messages}
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.run(runCtx)
+	}()
+	pending := func() int64 {
+		p, err := appState.redisClient.XPending(ctx, key, "go-service").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Count
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for (counterValue(messages.WithLabelValues("processed"))+counterValue(messages.WithLabelValues("invalid")) < 3 || pending() > 0) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Closing the client ends the blocked read that shutdown waits out.
+	cancel()
+	client.Close()
+	<-done
+
+	if got := counterValue(messages.WithLabelValues("processed")); got != 2 {
+		t.Errorf("processed = %v, want 2", got)
+	}
+	if got := counterValue(messages.WithLabelValues("invalid")); got != 1 {
+		t.Errorf("invalid = %v, want 1", got)
+	}
+	if got := rpsOf(appState.buffer.window("web")); !equalFloats(got, []float64{10, 20}) {
+		t.Errorf("rps = %v, want [10 20]", got)
+	}
+	if n := pending(); n != 0 {
+		t.Errorf("%d entries still pending, want 0", n)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// monitorRedis pings Redis every interval, keeps the redisDown flag and the
+// go_service_redis_up gauge current and logs every connectivity transition.
+// Intake consults redisDown instead of discovering an outage one failed
+// request at a time.
+func monitorRedis(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, interval)
+			err := appState.redisClient.Ping(pingCtx).Err()
+			cancel()
+			setRedisUp(err == nil, err)
+		}
+	}
+}
+
+func setRedisUp(up bool, err error) {
+	was := !appState.redisDown.Swap(!up)
+	if up {
+		appState.redisUpGauge.Set(1)
+	} else {
+		appState.redisUpGauge.Set(0)
+	}
+
+	switch {
+	case was && !up:
+		log.Printf("Redis connection lost: %v", err)
+	case !was && up:
+		log.Printf("Redis connection restored")
+		// The breaker may never have opened, so it will not flush what
+		// intake buffered in the meantime.
+		appState.recovery.trigger()
+	}
+}
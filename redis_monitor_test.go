@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestSetRedisUp(t *testing.T) {
+	newTestAppState(t)
+
+	steps := []struct {
+		up   bool
+		want float64
+	}{
+		{up: true, want: 1},
+		{up: false, want: 0},
+		{up: false, want: 0},
+		{up: true, want: 1},
+	}
+	for i, step := range steps {
+		setRedisUp(step.up, errors.New("connection refused"))
+		if got := appState.redisDown.Load(); got == step.up {
+			t.Errorf("step %d: redisDown = %v, want %v", i, got, !step.up)
+		}
+		if got := gaugeValue(appState.redisUpGauge); got != step.want {
+			t.Errorf("step %d: gauge = %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+// While the monitor finds Redis down, samples are buffered without a
+// round-trip, and written back once it is found up again.
+func TestIntakeWhileRedisDown(t *testing.T) {
+	mr := newTestAppState(t)
+	setRedisUp(false, errors.New("connection refused"))
+	before := mr.CommandCount()
+
+	if _, err := processMetric(t.Context(), slog.Default(), "web", Metric{RPS: 10}); err != nil {
+		t.Fatalf("processMetric: %v", err)
+	}
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Errorf("%d Redis commands while down, want none", n)
+	}
+	if n := appState.buffer.pendingCount(); n != 1 {
+		t.Fatalf("%d samples pending, want 1", n)
+	}
+
+	setRedisUp(true, nil)
+	appState.recovery.wait()
+	if n := appState.buffer.pendingCount(); n != 0 {
+		t.Errorf("%d samples still pending after Redis came back", n)
+	}
+	if items, _ := mr.List(appState.metricsKey("web")); len(items) != 1 {
+		t.Errorf("stored %d samples, want 1", len(items))
+	}
+}
+
+func gaugeValue(g prometheus.Gauge) float64 {
+	var m dto.Metric
+	g.Write(&m)
+	return m.GetGauge().GetValue()
+}
+
+func counterValue(c prometheus.Counter) float64 {
+	var m dto.Metric
+	c.Write(&m)
+	return m.GetCounter().GetValue()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// streamField is the field holding the encoded sample in each entry of a
+// Redis stream.
+const streamField = "sample"
+
+// redisStreamStore is the Redis stream backend. Entries get server-assigned
+// IDs and carry one field, "sample", encoded like list items. Trimming is
+// approximate (MAXLEN ~), so a stream may briefly hold a few more than
+// RAW_RETENTION samples; reads never return more than they ask for.
+//
+// A key holds either a list or a stream, so switching STORAGE_BACKEND on
+// existing data needs the old keys removed or a new REDIS_KEY_PREFIX.
+type redisStreamStore struct{}
+
+func (redisStreamStore) Append(ctx context.Context, c redis.Cmdable, stream string, samples ...interface{}) {
+	for _, data := range samples {
+		c.XAdd(ctx, &redis.XAddArgs{
+			Stream: appState.metricsKey(stream),
+			MaxLen: int64(appState.rawRetention),
+			Approx: true,
+			Values: []interface{}{streamField, data},
+		})
+	}
+}
+
+func (redisStreamStore) Recent(ctx context.Context, c redis.Cmdable, stream string, n int) func() ([]string, error) {
+	cmd := c.XRevRangeN(ctx, appState.metricsKey(stream), "+", "-", int64(n))
+	return func() ([]string, error) {
+		msgs, err := cmd.Result()
+		if err != nil {
+			return nil, err
+		}
+		items := streamSamples(msgs)
+		slices.Reverse(items)
+		return items, nil
+	}
+}
+
+// AppendAndRead runs XADD and XREVRANGE in one MULTI, so the window read
+// always includes the sample just added.
+func (s redisStreamStore) AppendAndRead(ctx context.Context, stream string, data []byte) ([]string, error) {
+	var read func() ([]string, error)
+	_, err := appState.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		s.Append(ctx, pipe, stream, data)
+		read = s.Recent(ctx, pipe, stream, appState.maxWindow())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return read()
+}
+
+// Compact summarises the entries older than the live window and deletes
+// them by ID. IDs do not shift the way list indexes do, so unlike the list
+// backend there is no conflict to retry: entries trimmed concurrently by
+// XADD are simply already gone when XDEL runs.
+func (redisStreamStore) Compact(ctx context.Context, stream string, bucketSize int) (int, int, int, error) {
+	key := appState.metricsKey(stream)
+	n, err := appState.redisClient.XLen(ctx, key).Result()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	old := n - int64(appState.maxWindow())
+	if old <= 0 {
+		return 0, 0, 0, nil
+	}
+	msgs, err := appState.redisClient.XRangeN(ctx, key, "-", "+", old).Result()
+	if err != nil || len(msgs) == 0 {
+		return 0, 0, 0, err
+	}
+	window := decodeWindow(streamSamples(msgs))
+	points := bucketSamples(window, bucketSize)
+
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+	compactedKey := appState.compactedKey(stream)
+	_, err = appState.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(points) > 0 {
+			summaries := make([]interface{}, len(points))
+			for i, p := range points {
+				summaries[i], _ = json.Marshal(p)
+			}
+			pipe.RPush(ctx, compactedKey, summaries...)
+			pipe.LTrim(ctx, compactedKey, -int64(appState.compactedRetention), -1)
+		}
+		pipe.XDel(ctx, key, ids...)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return len(window), len(points), len(msgs) - len(window), nil
+}
+
+// streamSamples extracts the encoded samples from stream entries. Entries
+// without a sample field are skipped like malformed list items.
+func streamSamples(msgs []redis.XMessage) []string {
+	items := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		if v, ok := msg.Values[streamField].(string); ok {
+			items = append(items, v)
+		}
+	}
+	return items
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseStorageBackend(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    StorageBackend
+		wantErr bool
+	}{
+		{value: "", want: BackendList},
+		{value: "list", want: BackendList},
+		{value: "stream", want: BackendStream},
+		{value: "zset", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseStorageBackend(tt.value)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseStorageBackend(%q) = %q, %v; want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestStreamBackendPipeline(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.store = redisStreamStore{}
+	appState.rawRetention = 8
+	ctx := context.Background()
+
+	var result AnalysisResult
+	for i := 1; i <= 12; i++ {
+		var err error
+		result, err = processMetric(ctx, slog.Default(), "web", Metric{RPS: float64(i), Timestamp: appState.now().Add(time.Duration(i) * time.Second)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if result.Samples != 5 || result.RollingAvg != 10 {
+		t.Errorf("result = %+v, want the window 8..12", result)
+	}
+	if rps := rpsOf(appState.buffer.window("web")); !equalFloats(rps, []float64{8, 9, 10, 11, 12}) {
+		t.Errorf("window = %v, want 8..12 oldest first", rps)
+	}
+	if n, _ := appState.redisClient.XLen(ctx, "metrics:web").Result(); n < 8 {
+		t.Errorf("XLEN = %d, want at least RAW_RETENTION samples kept", n)
+	}
+	if mr.Exists("metrics:web") && mr.Type("metrics:web") != "stream" {
+		t.Errorf("metrics:web is a %s, want a stream", mr.Type("metrics:web"))
+	}
+
+	// Readers and batch ingestion go through the same backend.
+	tests := []struct {
+		name, method, target, body string
+		wantBody                   string
+	}{
+		{name: "history", method: http.MethodGet, target: "/history/web?limit=2", wantBody: `"rps":11`},
+		{name: "topk", method: http.MethodGet, target: "/topk/web?n=1", wantBody: `"value":12`},
+		{name: "batch", method: http.MethodPost, target: "/batch/analyze", body: `{"web": {"rps": 13}}`, wantBody: `"samples":5`},
+	}
+	mux := newMux()
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tt.wantBody) {
+			t.Errorf("%s: status %d body %s, want 200 containing %s", tt.name, w.Code, w.Body.String(), tt.wantBody)
+		}
+	}
+}
+
+func TestStreamBackendCompact(t *testing.T) {
+	newTestAppState(t)
+	appState.store = redisStreamStore{}
+	appState.compactBucketSize = 2
+	ctx := context.Background()
+	// An entry written by something else, without a sample field.
+	appState.redisClient.XAdd(ctx, &redis.XAddArgs{Stream: "metrics:web", Values: []interface{}{"other", "x"}})
+	for i := 1; i <= 8; i++ {
+		if err := storeSample(ctx, "web", Metric{RPS: float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	compacted, buckets, skipped, err := compactStream(ctx, "web", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 9 entries, 5 of them the live window: the foreign entry and 3
+	// samples in 2 buckets.
+	if compacted != 3 || buckets != 2 || skipped != 1 {
+		t.Errorf("compacted %d in %d buckets, skipped %d; want 3, 2, 1", compacted, buckets, skipped)
+	}
+	items, _ := recentSamples(ctx, "web", 100)
+	if rps := rpsOf(decodeWindow(items)); !equalFloats(rps, []float64{4, 5, 6, 7, 8}) {
+		t.Errorf("raw samples after compaction = %v, want 4..8", rps)
+	}
+	if n, _ := appState.redisClient.LLen(ctx, "metrics_compacted:web").Result(); n != 2 {
+		t.Errorf("compacted list holds %d points, want 2", n)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"go-stream-processing/ingestpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// defaultRemoteWriteStreamLabel is the default of REMOTE_WRITE_STREAM_LABEL.
+const defaultRemoteWriteStreamLabel = "job"
+
+// maxRemoteWriteBytes bounds a decompressed remote write request.
+const maxRemoteWriteBytes = 32 << 20
+
+// remoteWriteMapping says which Prometheus series become a Metric's CPU
+// and RPS, by metric name, and which label names the stream. With neither
+// metric set, remote write is off.
+type remoteWriteMapping struct {
+	CPUMetric   string
+	RPSMetric   string
+	StreamLabel string
+}
+
+func (m remoteWriteMapping) enabled() bool {
+	return m.CPUMetric != "" || m.RPSMetric != ""
+}
+
+// handleRemoteWrite is a Prometheus remote write 1.0 receiver, so an
+// existing Prometheus can feed the pipeline with a remote_write block.
+// Samples of the series named by REMOTE_WRITE_CPU_METRIC and
+// REMOTE_WRITE_RPS_METRIC are joined per stream (REMOTE_WRITE_STREAM_LABEL,
+// default job) and timestamp by a pointJoiner; other series are ignored.
+// Raw counters would need a rate first, so point it at recording rules
+// such as job:http_requests:rate1m. CPU is taken as a percentage.
+//
+// Prometheus shards series across requests, so with both metrics mapped a
+// sample waits in remoteWriteHold until both its CPU and RPS have come,
+// for up to pointJoinWindow. Both series must therefore be evaluated at
+// the same timestamps, e.g. by recording rules in one group.
+//
+// Prometheus retries 5xx answers and drops 4xx ones, so samples that
+// cannot be used are answered with 400 once the rest are processed, and
+// nothing here answers 5xx after processing began.
+func handleRemoteWrite(w http.ResponseWriter, r *http.Request) {
+	mapping := appState.remoteWrite
+	if !mapping.enabled() {
+		writeError(w, http.StatusForbidden, "remote_write_disabled", "remote write is disabled (REMOTE_WRITE_CPU_METRIC and REMOTE_WRITE_RPS_METRIC are not set)")
+		return
+	}
+	if _, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); params["proto"] != "" && params["proto"] != "prometheus.WriteRequest" {
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", fmt.Sprintf("remote write message %q is not supported, want prometheus.WriteRequest", params["proto"]))
+		return
+	}
+	compressed, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRemoteWriteBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "too_large", "Request body too large")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Error reading body")
+		return
+	}
+	if n, err := snappy.DecodedLen(compressed); err != nil || n > maxRemoteWriteBytes {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Body is not snappy-compressed or too large")
+		return
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Body is not snappy-compressed")
+		return
+	}
+	var req ingestpb.WriteRequest
+	if err := proto.Unmarshal(data, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_protobuf", "Invalid remote write request")
+		return
+	}
+
+	j := newHeldPointJoiner(appState.remoteWriteHold, mapping.CPUMetric != "", mapping.RPSMetric != "")
+	for _, ts := range req.GetTimeseries() {
+		var name, stream string
+		for _, l := range ts.GetLabels() {
+			switch l.GetName() {
+			case "__name__":
+				name = l.GetValue()
+			case mapping.StreamLabel:
+				stream = l.GetValue()
+			}
+		}
+		isCPU := name == mapping.CPUMetric
+		if name == "" || (!isCPU && name != mapping.RPSMetric) {
+			continue
+		}
+		if stream == "" {
+			stream = defaultStream
+		}
+		if !streamNamePattern.MatchString(stream) {
+			j.reject(int64(len(ts.GetSamples())), fmt.Sprintf("invalid stream name %q", stream))
+			continue
+		}
+		for _, s := range ts.GetSamples() {
+			// Staleness markers and other non-finite values carry no sample.
+			if math.IsNaN(s.GetValue()) || math.IsInf(s.GetValue(), 0) {
+				continue
+			}
+			j.add(stream, time.UnixMilli(s.GetTimestamp()).UTC(), isCPU, s.GetValue())
+		}
+	}
+
+	rejected, reason := j.process(r.Context(), loggerFrom(r.Context()).With("transport", "remote_write"))
+	if rejected > 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("%d samples rejected: %s", rejected, reason))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"go-stream-processing/ingestpb"
+	"google.golang.org/protobuf/proto"
+)
+
+func remoteWriteBody(t *testing.T, series ...*ingestpb.TimeSeries) []byte {
+	t.Helper()
+	data, err := proto.Marshal(&ingestpb.WriteRequest{Timeseries: series})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snappy.Encode(nil, data)
+}
+
+func promSeries(name, job string, samples ...*ingestpb.Sample) *ingestpb.TimeSeries {
+	return &ingestpb.TimeSeries{
+		Labels:  []*ingestpb.Label{{Name: "__name__", Value: name}, {Name: "job", Value: job}, {Name: "instance", Value: "a:9090"}},
+		Samples: samples,
+	}
+}
+
+func TestRemoteWrite(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration, v float64) *ingestpb.Sample {
+		return &ingestpb.Sample{Value: v, Timestamp: t0.Add(d).UnixMilli()}
+	}
+	tests := []struct {
+		name        string
+		disabled    bool
+		contentType string
+		body        func(t *testing.T) []byte
+		wantCode    int
+		wantRPS     []float64
+		wantCPU     []float64
+	}{
+		{
+			name: "series joined in timestamp order",
+			body: func(t *testing.T) []byte {
+				return remoteWriteBody(t,
+					promSeries("job:rps", "web", at(time.Second, 20), at(0, 10)),
+					promSeries("job:cpu", "web", at(0, 50), at(time.Second, 60)),
+					promSeries("up", "web", at(0, 1)),
+				)
+			},
+			wantCode: http.StatusNoContent,
+			wantRPS:  []float64{10, 20},
+			wantCPU:  []float64{50, 60},
+		},
+		{
+			name: "staleness marker skipped",
+			body: func(t *testing.T) []byte {
+				return remoteWriteBody(t,
+					promSeries("job:rps", "web", at(0, 10), at(time.Second, math.Float64frombits(0x7ff0000000000002))),
+					promSeries("job:cpu", "web", at(0, 50), at(time.Second, 60)),
+				)
+			},
+			wantCode: http.StatusNoContent,
+			wantRPS:  []float64{10},
+			wantCPU:  []float64{50},
+		},
+		{
+			name: "invalid stream",
+			body: func(t *testing.T) []byte {
+				return remoteWriteBody(t, promSeries("job:rps", "bad name", at(0, 10)), promSeries("job:rps", "web", at(0, 5)), promSeries("job:cpu", "web", at(0, 50)))
+			},
+			wantCode: http.StatusBadRequest,
+			wantRPS:  []float64{5},
+		},
+		{name: "not snappy", body: func(*testing.T) []byte { return []byte("plain") }, wantCode: http.StatusBadRequest},
+		{
+			name:        "remote write 2.0",
+			contentType: "application/x-protobuf;proto=io.prometheus.write.v2.Request",
+			body:        func(t *testing.T) []byte { return remoteWriteBody(t) },
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+		{name: "disabled", disabled: true, body: func(t *testing.T) []byte { return remoteWriteBody(t) }, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			if !tt.disabled {
+				appState.remoteWrite = remoteWriteMapping{CPUMetric: "job:cpu", RPSMetric: "job:rps", StreamLabel: "job"}
+			}
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(tt.body(t)))
+			r.Header.Set("Content-Encoding", "snappy")
+			r.Header.Set("Content-Type", cmp.Or(tt.contentType, "application/x-protobuf"))
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			window := appState.buffer.window("web")
+			if got := rpsOf(window); !equalFloats(got, tt.wantRPS) {
+				t.Errorf("rps = %v, want %v", got, tt.wantRPS)
+			}
+			for i, want := range tt.wantCPU {
+				if i < len(window) && window[i].CPU != want {
+					t.Errorf("sample %d cpu = %v, want %v", i, window[i].CPU, want)
+				}
+			}
+			if tt.wantCode == http.StatusBadRequest && tt.wantRPS != nil && !strings.Contains(w.Body.String(), "1 samples rejected") {
+				t.Errorf("body %s, want the rejected count", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRemoteWriteJoinsAcrossRequests(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration, v float64) *ingestpb.Sample {
+		return &ingestpb.Sample{Value: v, Timestamp: t0.Add(d).UnixMilli()}
+	}
+	newTestAppState(t)
+	appState.remoteWrite = remoteWriteMapping{CPUMetric: "job:cpu", RPSMetric: "job:rps", StreamLabel: "job"}
+	write := func(series ...*ingestpb.TimeSeries) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(remoteWriteBody(t, series...)))
+		w := httptest.NewRecorder()
+		newMux().ServeHTTP(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("status = %d (%s)", w.Code, w.Body.String())
+		}
+	}
+
+	// Shards carry the two series of a sample in separate requests.
+	write(promSeries("job:rps", "web", at(0, 10), at(time.Second, 20)))
+	if window := appState.buffer.window("web"); len(window) != 0 {
+		t.Fatalf("half-filled samples stored: %+v", window)
+	}
+	write(promSeries("job:cpu", "web", at(0, 50)))
+	window := appState.buffer.window("web")
+	if len(window) != 1 || window[0].RPS != 10 || window[0].CPU != 50 {
+		t.Fatalf("window = %+v, want the joined sample at t0", window)
+	}
+
+	// The sample at 1s never gets its CPU and is dropped, not stored with
+	// CPU 0.
+	appState.clock.(*fakeClock).Advance(pointJoinWindow)
+	write(promSeries("job:cpu", "web", at(2*time.Second, 70)))
+	if n := len(appState.remoteWriteHold.samples); n != 1 {
+		t.Errorf("%d samples held, want only the one at 2s", n)
+	}
+	if got := rpsOf(appState.buffer.window("web")); !equalFloats(got, []float64{10}) {
+		t.Errorf("rps = %v, want [10]", got)
+	}
+}
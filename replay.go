@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxReplayBodyBytes bounds the size of a /replay request body.
+	maxReplayBodyBytes = 10 << 20
+	// maxReplayWindow bounds the caller-supplied window_size.
+	maxReplayWindow = 10000
+)
+
+type replayRequest struct {
+	Metrics    []Metric       `json:"metrics"`
+	Detector   DetectorConfig `json:"detector"`
+	WindowSize int            `json:"window_size"`
+}
+
+type replayPoint struct {
+	Index     int       `json:"index"`
+	Timestamp time.Time `json:"timestamp"`
+	RPS       float64   `json:"rps"`
+	Score     float64   `json:"score"`
+}
+
+type replayResponse struct {
+	Detector string        `json:"detector"`
+	Total    int           `json:"total"`
+	Flagged  []replayPoint `json:"flagged"`
+}
+
+// handleReplay runs detection over caller-supplied historical metrics as a
+// dry run. The window is rebuilt in memory, so live Redis state is never
+// read or written.
+func handleReplay(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxReplayBodyBytes)
+	var req replayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "too_large", "Request body too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+
+	for i, m := range req.Metrics {
+		normalized, err := normalizeUnits(m)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("metric %d: %v", i, err))
+			return
+		}
+		req.Metrics[i] = normalized
+	}
+
+	detector, err := newDetector(req.Detector)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	windowSize := req.WindowSize
+	if windowSize <= 0 {
+		windowSize = appState.windowSize
+	}
+	if windowSize > maxReplayWindow {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("window_size must not exceed %d", maxReplayWindow))
+		return
+	}
+
+	response := replayResponse{
+		Detector: detector.Name(),
+		Total:    len(req.Metrics),
+		Flagged:  replayDetection(detector, req.Metrics, windowSize),
+	}
+	for i := range response.Flagged {
+		response.Flagged[i].Score = appState.round(response.Flagged[i].Score)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, response)
+}
+
+// replayDetection feeds metrics through detector one at a time, keeping a
+// sliding window of at most windowSize RPS values, and returns the points
+// that would have been flagged.
+func replayDetection(detector AnomalyDetector, metrics []Metric, windowSize int) []replayPoint {
+	flagged := []replayPoint{}
+	var window []float64
+	for i, m := range metrics {
+		window = append(window, m.RPS)
+		if len(window) > windowSize {
+			window = window[len(window)-windowSize:]
+		}
+		score, anomalous := detector.Detect(window, m.RPS)
+		if anomalous {
+			flagged = append(flagged, replayPoint{
+				Index:     i,
+				Timestamp: m.Timestamp,
+				RPS:       m.RPS,
+				Score:     score,
+			})
+		}
+	}
+	return flagged
+}
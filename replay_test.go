@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func replayBody(t *testing.T, windowSize int, detector DetectorConfig, rps ...float64) *bytes.Buffer {
+	t.Helper()
+	req := replayRequest{Detector: detector, WindowSize: windowSize}
+	for _, v := range rps {
+		req.Metrics = append(req.Metrics, Metric{RPS: v})
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(req); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestReplayDetectionWindowing(t *testing.T) {
+	d := &ZScoreDetector{Threshold: 2}
+	metrics := func(rps ...float64) []Metric {
+		out := make([]Metric, len(rps))
+		for i, v := range rps {
+			out[i] = Metric{RPS: v}
+		}
+		return out
+	}
+	tests := []struct {
+		name        string
+		metrics     []Metric
+		windowSize  int
+		wantIndexes []int
+	}{
+		{name: "no data", windowSize: 5},
+		{name: "steady", metrics: metrics(10, 10, 10, 10, 10), windowSize: 5},
+		{
+			name:        "spike after long baseline",
+			metrics:     metrics(10, 11, 10, 11, 10, 11, 10, 11, 10, 50),
+			windowSize:  10,
+			wantIndexes: []int{9},
+		},
+		{
+			// With a window of 2 the spike is compared only with its
+			// immediate predecessor and can never exceed 2 sigma.
+			name:       "small window hides spike",
+			metrics:    metrics(10, 11, 10, 11, 10, 11, 10, 11, 10, 50),
+			windowSize: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flagged := replayDetection(d, tt.metrics, tt.windowSize)
+			var got []int
+			for _, p := range flagged {
+				got = append(got, p.Index)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantIndexes) {
+				t.Errorf("flagged indexes = %v, want %v", got, tt.wantIndexes)
+			}
+		})
+	}
+}
+
+func TestHandleReplay(t *testing.T) {
+	appState = &AppState{windowSize: 50, responsePrecision: 4}
+
+	tests := []struct {
+		name     string
+		body     *bytes.Buffer
+		wantCode int
+	}{
+		{name: "ok", body: replayBody(t, 0, DetectorConfig{}, 10, 11, 10, 11, 10, 11, 10, 11, 10, 50), wantCode: http.StatusOK},
+		{name: "bad detector", body: replayBody(t, 0, DetectorConfig{Type: "magic"}, 1), wantCode: http.StatusBadRequest},
+		{name: "huge window", body: replayBody(t, 10000000000, DetectorConfig{}, 1), wantCode: http.StatusBadRequest},
+		{name: "invalid json", body: bytes.NewBufferString("{"), wantCode: http.StatusBadRequest},
+		{
+			name:     "body too large",
+			body:     bytes.NewBufferString(`{"metrics":[` + strings.Repeat(`{"rps":1},`, maxReplayBodyBytes/10) + `{"rps":1}]}`),
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleReplay(w, httptest.NewRequest(http.MethodPost, "/replay", tt.body))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var resp replayResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Total != 10 || len(resp.Flagged) != 1 || resp.Flagged[0].Index != 9 {
+				t.Errorf("response = %+v, want one flagged point at index 9 of 10", resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		reuse    bool
+	}{
+		{name: "generated when missing"},
+		{name: "client id reused", incoming: "abc-123", reuse: true},
+		{name: "unsafe id replaced", incoming: "bad id\twith spaces"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen bool
+			handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = loggerFrom(r.Context()) != nil
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(requestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			got := w.Header().Get(requestIDHeader)
+			if tt.reuse && got != tt.incoming {
+				t.Errorf("%s = %q, want client value %q", requestIDHeader, got, tt.incoming)
+			}
+			if !tt.reuse && !uuidPattern.MatchString(got) {
+				t.Errorf("%s = %q, want a generated UUID", requestIDHeader, got)
+			}
+			if !seen {
+				t.Error("handler did not get a request logger")
+			}
+		})
+	}
+}
+
+func TestNewRequestIDUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := newRequestID()
+		if seen[id] {
+			t.Fatalf("duplicate id %s", id)
+		}
+		seen[id] = true
+	}
+}
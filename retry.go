@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// retryPolicy bounds the retries of a Redis write: at most Attempts tries
+// in all, sleeping a random time up to Base, 2*Base, 4*Base, ... (capped at
+// Max) between them.
+type retryPolicy struct {
+	Attempts int
+	Base     time.Duration
+	Max      time.Duration
+}
+
+// backoff returns the sleep before retry n (0-based), with full jitter so
+// writers that failed together do not retry together.
+func (p retryPolicy) backoff(n int) time.Duration {
+	d := p.Max
+	if n < 30 {
+		d = min(p.Base<<n, p.Max)
+	}
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d + 1)
+}
+
+// transientRedisReplies are server replies that mean "not now" rather than
+// "never": a replica loading its dataset, a failover in progress.
+var transientRedisReplies = []string{"LOADING", "READONLY", "TRYAGAIN", "MASTERDOWN", "CLUSTERDOWN"}
+
+// isRetryableRedisError reports whether err is worth retrying: a network
+// failure or timeout, or one of transientRedisReplies. Other server
+// replies, encoding errors and cancelled contexts fail the same way again,
+// and an open breaker has already decided for us.
+func isRetryableRedisError(err error) bool {
+	if err == nil || isBreakerRejection(err) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		for _, prefix := range transientRedisReplies {
+			if strings.HasPrefix(redisErr.Error(), prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// withRedisRetry runs write, retrying transient failures under
+// appState.redisRetry, and returns the last error. Each retry is counted in
+// go_service_redis_retries_total. While the health monitor finds Redis
+// down, write is not run at all and errRedisDown, a breaker rejection, is
+// returned, so callers buffer the sample as they do with the circuit open.
+//
+// A write whose reply was lost may have been applied, so a retried sample
+// can be stored twice; losing it is the worse outcome.
+func withRedisRetry(ctx context.Context, logger *slog.Logger, write func() error) error {
+	if appState.redisDown.Load() {
+		return errRedisDown
+	}
+	policy := appState.redisRetry
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = write(); !isRetryableRedisError(err) || attempt+1 >= policy.Attempts {
+			return err
+		}
+		wait := policy.backoff(attempt)
+		logger.Warn("Transient Redis error, retrying", "error", err, "attempt", attempt+1, "backoff", wait)
+		appState.redisRetryCounter.Inc()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+func TestIsRetryableRedisError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "connection closed", err: io.EOF, want: true},
+		{name: "dial refused", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{name: "wrapped network error", err: fmt.Errorf("push: %w", &net.OpError{Op: "read", Err: io.ErrUnexpectedEOF}), want: true},
+		{name: "loading", err: redisReply("LOADING Redis is loading the dataset in memory"), want: true},
+		{name: "readonly replica", err: redisReply("READONLY You can't write against a read only replica."), want: true},
+		{name: "wrong type", err: redisReply("WRONGTYPE Operation against a key holding the wrong kind of value"), want: false},
+		{name: "breaker open", err: gobreaker.ErrOpenState, want: false},
+		{name: "caller cancelled", err: context.Canceled, want: false},
+		{name: "encode error", err: errors.New("msgpack: bad value"), want: false},
+	}
+	for _, tt := range tests {
+		if got := isRetryableRedisError(tt.err); got != tt.want {
+			t.Errorf("%s: isRetryableRedisError(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+// redisReply is a server error reply, as go-redis returns it.
+type redisReply string
+
+func (e redisReply) Error() string { return string(e) }
+func (redisReply) RedisError()     {}
+
+var _ redis.Error = redisReply("")
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := retryPolicy{Attempts: 10, Base: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	for n, ceiling := range []time.Duration{10, 20, 40, 50, 50, 50} {
+		ceiling *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(n); d < 0 || d > ceiling {
+				t.Fatalf("backoff(%d) = %v, want within [0, %v]", n, d, ceiling)
+			}
+		}
+	}
+	if d := p.backoff(100); d > p.Max {
+		t.Errorf("backoff(100) = %v, want capped at %v", d, p.Max)
+	}
+}
+
+func TestWithRedisRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  []error // returned by successive calls, then nil
+		attempts  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "first try", attempts: 3, wantCalls: 1},
+		{name: "recovers", failures: []error{io.EOF, io.EOF}, attempts: 3, wantCalls: 3},
+		{name: "exhausted", failures: []error{io.EOF, io.EOF, io.EOF}, attempts: 3, wantCalls: 3, wantErr: true},
+		{name: "permanent", failures: []error{redisReply("WRONGTYPE")}, attempts: 3, wantCalls: 1, wantErr: true},
+		{name: "single attempt", failures: []error{io.EOF}, attempts: 1, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			appState.redisRetry.Attempts = tt.attempts
+			calls := 0
+			err := withRedisRetry(context.Background(), slog.Default(), func() error {
+				calls++
+				if calls <= len(tt.failures) {
+					return tt.failures[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls || (err != nil) != tt.wantErr {
+				t.Errorf("calls = %d, err = %v; want %d calls, err %v", calls, err, tt.wantCalls, tt.wantErr)
+			}
+			if got := counterValue(appState.redisRetryCounter); got != float64(tt.wantCalls-1) {
+				t.Errorf("retries counted = %v, want %d", got, tt.wantCalls-1)
+			}
+		})
+	}
+}
+
+// With Redis gone for good the metric ends up dead-lettered once the
+// retries are used up.
+func TestProcessMetricRetriesThenDeadLetters(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.redisRetry.Attempts = 3
+	mr.Close()
+	if _, err := processMetric(context.Background(), slog.Default(), defaultStream, Metric{RPS: 1}); err == nil {
+		t.Fatal("processMetric succeeded with Redis down")
+	}
+	if got := counterValue(appState.redisRetryCounter); got != 2 {
+		t.Errorf("retries = %v, want 2", got)
+	}
+	if got := len(appState.deadLetters); got != 1 {
+		t.Errorf("dead letters = %d, want 1", got)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import "net/http"
+
+// newMux registers every endpoint using method+path patterns, so the mux
+// answers 405 for wrong methods and path parameters come from r.PathValue.
+// GET patterns also match HEAD, and the server drops the body of a HEAD
+// response, so read endpoints answer HEAD with GET's headers for free;
+// only handlers that never finish on their own need to check for it.
+// Routes that take a stream accept it both in the path and, for one more
+// release, as the legacy ?stream= query parameter.
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	registerDataRoutes(mux)
+	registerAdminRoutes(mux)
+	return mux
+}
+
+// registerDataRoutes adds the endpoints clients ingest and query through.
+func registerDataRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /{$}", rootHandler)
+	mux.HandleFunc("GET /metrics/stream", handleGaugeStream)
+	mux.HandleFunc("POST /analyze", decompressBody(handleAnalyze))
+	mux.HandleFunc("POST /analyze/{stream}", decompressBody(handleAnalyze))
+	// More specific than /analyze/{stream}, so a stream named "batch" can
+	// only be reached through ?stream=.
+	mux.HandleFunc("POST /analyze/batch", decompressBody(handleAnalyzeBatch))
+	mux.HandleFunc("POST /analyze/stream", decompressBody(handleNDJSONIngest))
+	mux.HandleFunc("POST /batch/analyze", decompressBody(handleBatchAnalyze))
+	mux.HandleFunc("GET /ws/ingest", handleWSIngest)
+	mux.HandleFunc("POST /ingest/csv", decompressBody(handleCSVIngest))
+	mux.HandleFunc("POST /ingest/csv/{stream}", decompressBody(handleCSVIngest))
+	mux.HandleFunc("POST /v1/metrics", decompressBody(handleOTLPMetrics))
+	mux.HandleFunc("POST /api/v1/write", handleRemoteWrite)
+	mux.HandleFunc("POST /write", decompressBody(handleInfluxWrite))
+	mux.HandleFunc("GET /history/{stream}", handleHistory)
+	mux.HandleFunc("GET /metrics/raw/{stream}", handleHistory)
+	mux.HandleFunc("GET /topk", handleTopK)
+	mux.HandleFunc("GET /topk/{stream}", handleTopK)
+	mux.HandleFunc("GET /calibrate", handleCalibrate)
+	mux.HandleFunc("GET /calibrate/{stream}", handleCalibrate)
+	mux.HandleFunc("GET /count", countHandler)
+	mux.HandleFunc("GET /health", healthHandler)
+	mux.HandleFunc("GET /ready", handleReady)
+	mux.HandleFunc("POST /replay", decompressBody(handleReplay))
+	mux.HandleFunc("POST /compact", handleCompact)
+	mux.HandleFunc("POST /compact/{stream}", handleCompact)
+	mux.HandleFunc("GET /deadletter", handleDeadLetter)
+	mux.HandleFunc("POST /deadletter/retry", handleDeadLetterRetry)
+	mux.HandleFunc("GET /anomalies", handleAnomalies)
+	mux.HandleFunc("GET /anomalies/{id}/context", handleAnomalyContext)
+	mux.HandleFunc("GET /anomalies/wait", handleAnomalyWait)
+}
+
+// registerAdminRoutes adds the Prometheus scrape endpoints and the
+// endpoints that change configuration or generate load. With ADMIN_PORT set
+// they are served on that port only, so network policy can keep them off
+// the data plane; pprof joins them there when enabled.
+func registerAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /metrics", handleMetrics)
+	mux.HandleFunc("GET /metrics/stream/{stream}", handleStreamMetrics)
+	mux.HandleFunc("POST /simulate", requireAdmin(handleSimulate))
+	mux.HandleFunc("PATCH /config", requireAdmin(handleConfigPatch))
+	mux.HandleFunc("PUT /config", requireAdmin(handleConfigPut))
+	mux.HandleFunc("POST /config/reload", requireAdmin(handleConfigReload))
+	mux.HandleFunc("POST /streams/{stream}/pause", requireAdmin(handleStreamPause(true)))
+	mux.HandleFunc("POST /streams/{stream}/resume", requireAdmin(handleStreamPause(false)))
+}
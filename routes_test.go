@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMuxRouting(t *testing.T) {
+	newTestAppState(t)
+	mux := newMux()
+	tests := []struct {
+		method, target string
+		wantCode       int
+	}{
+		{http.MethodGet, "/", http.StatusOK},
+		{http.MethodGet, "/nope", http.StatusNotFound},
+		{http.MethodGet, "/analyze", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/analyze/web", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/count", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/history/web", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/history/web", http.StatusOK},
+		{http.MethodGet, "/metrics/raw/web", http.StatusOK},
+		{http.MethodGet, "/history/bad%20name", http.StatusBadRequest},
+		{http.MethodGet, "/history/web?limit=0", http.StatusBadRequest},
+		{http.MethodPost, "/compact/web", http.StatusOK},
+		{http.MethodDelete, "/deadletter", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/health", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAnalyzeStreamFromPathAndQuery(t *testing.T) {
+	mr := newTestAppState(t)
+	mux := newMux()
+	for _, target := range []string{"/analyze/web", "/analyze?stream=api", "/analyze"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"rps": 1}`)))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("%s: status = %d, want 202", target, w.Code)
+		}
+	}
+	for _, key := range []string{"metrics:web", "metrics:api", "metrics"} {
+		var items []string
+		for i := 0; i < 100 && len(items) == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+			items, _ = mr.List(key)
+		}
+		if len(items) != 1 {
+			t.Errorf("%s has %d samples, want 1", key, len(items))
+		}
+	}
+}
+
+func TestHandleHistory(t *testing.T) {
+	mr := newTestAppState(t)
+	pushSamples(t, mr, "metrics:web", 1, 2, 3, 4, 5, 6, 7)
+	tests := []struct {
+		target  string
+		wantRPS []float64
+	}{
+		{target: "/history/web", wantRPS: []float64{3, 4, 5, 6, 7}},
+		{target: "/history/web?limit=2", wantRPS: []float64{6, 7}},
+		{target: "/metrics/raw/web?limit=50", wantRPS: []float64{1, 2, 3, 4, 5, 6, 7}},
+		{target: "/history/other", wantRPS: []float64{}},
+	}
+	mux := newMux()
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		var resp struct {
+			Samples []Metric `json:"samples"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		var got []float64
+		for _, m := range resp.Samples {
+			got = append(got, m.RPS)
+		}
+		if len(got) != len(tt.wantRPS) {
+			t.Errorf("%s: rps = %v, want %v", tt.target, got, tt.wantRPS)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.wantRPS[i] {
+				t.Errorf("%s: rps = %v, want %v", tt.target, got, tt.wantRPS)
+				break
+			}
+		}
+	}
+}
+
+func TestHeadOnReadEndpoints(t *testing.T) {
+	newTestAppState(t)
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+
+	for _, path := range []string{"/", "/health", "/count", "/metrics", "/metrics/stream", "/anomalies", "/history/web"} {
+		t.Run(path, func(t *testing.T) {
+			// Only GET's headers are compared, so the never-ending event
+			// stream is closed unread.
+			get, err := http.Get(srv.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer get.Body.Close()
+
+			head, err := http.Head(srv.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer head.Body.Close()
+			body, _ := io.ReadAll(head.Body)
+			if head.StatusCode != http.StatusOK || len(body) != 0 {
+				t.Errorf("HEAD = %d with %d body bytes, want 200 with none", head.StatusCode, len(body))
+			}
+			if got, want := head.Header.Get("Content-Type"), get.Header.Get("Content-Type"); got != want {
+				t.Errorf("HEAD Content-Type = %q, GET has %q", got, want)
+			}
+		})
+	}
+}
+
+func TestAdminRoutesSeparate(t *testing.T) {
+	newTestAppState(t)
+	dataMux, adminMux := http.NewServeMux(), http.NewServeMux()
+	registerDataRoutes(dataMux)
+	registerAdminRoutes(adminMux)
+	tests := []struct {
+		mux            *http.ServeMux
+		method, target string
+		wantCode       int
+	}{
+		{dataMux, http.MethodGet, "/health", http.StatusOK},
+		{dataMux, http.MethodGet, "/metrics", http.StatusNotFound},
+		{dataMux, http.MethodPatch, "/config", http.StatusNotFound},
+		{dataMux, http.MethodPost, "/streams/web/pause", http.StatusNotFound},
+		{adminMux, http.MethodGet, "/metrics", http.StatusOK},
+		{adminMux, http.MethodPatch, "/config", http.StatusForbidden},
+		{adminMux, http.MethodGet, "/health", http.StatusNotFound},
+		{adminMux, http.MethodGet, "/analyze", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		name := "data "
+		if tt.mux == adminMux {
+			name = "admin "
+		}
+		t.Run(name+tt.method+" "+tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// runtimeDetectors are the detector modes DETECTOR can select and PATCH
+// /config can switch to.
+var runtimeDetectors = []string{"zscore", "ewma", "mad", "cusum"}
+
+// ConfigPatch is the body of PATCH /config. Omitted fields are left as
+// they are.
+type ConfigPatch struct {
+	// Detector switches the detector mode, keeping Threshold if it is
+	// given and otherwise using the new mode's default.
+	Detector *string `json:"detector"`
+	// Threshold is the score above which the detector flags a sample.
+	Threshold *float64 `json:"threshold"`
+	// WindowSize is the rps window detection runs over.
+	WindowSize *int `json:"window_size"`
+	// Alpha is the EWMA smoothing factor in (0, 1], kept across threshold
+	// changes like Threshold; ewma only.
+	Alpha *float64 `json:"alpha"`
+}
+
+// RuntimeConfig is the effective runtime-tunable configuration.
+type RuntimeConfig struct {
+	Detector   string   `json:"detector"`
+	Threshold  *float64 `json:"threshold,omitempty"`
+	Alpha      *float64 `json:"alpha,omitempty"`
+	WindowSize int      `json:"window_size"`
+	MinSamples int      `json:"min_samples"`
+}
+
+// currentDetector returns the detector in use, which PATCH /config may
+// replace at any time.
+func (s *AppState) currentDetector() AnomalyDetector {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.detector
+}
+
+// currentSeverity returns the anomaly severity cutoffs in use.
+func (s *AppState) currentSeverity() SeverityCutoffs {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.severity
+}
+
+// detectorThreshold returns the threshold of d, if it has a single one.
+func detectorThreshold(d AnomalyDetector) (float64, bool) {
+	switch d := d.(type) {
+	case *ZScoreDetector:
+		return d.Threshold, true
+	case *EWMADetector:
+		return d.Threshold, true
+	case *MADDetector:
+		return d.Threshold, true
+	case *HoltWintersDetector:
+		return d.Threshold, true
+	case *CUSUMDetector:
+		return d.Threshold, true
+	}
+	return 0, false
+}
+
+// runtimeConfig reports the effective configuration; callers hold cfgMu.
+func (s *AppState) runtimeConfig() RuntimeConfig {
+	cfg := RuntimeConfig{
+		Detector:   s.detector.Name(),
+		WindowSize: s.windowSize,
+		MinSamples: s.minSamples,
+	}
+	if size, ok := s.windowSizes["rps"]; ok {
+		cfg.WindowSize = size
+	}
+	if t, ok := detectorThreshold(s.detector); ok {
+		cfg.Threshold = &t
+	}
+	if ewma, ok := s.detector.(*EWMADetector); ok {
+		cfg.Alpha = &ewma.Alpha
+	}
+	return cfg
+}
+
+// applyConfigPatch validates p in full and then applies it, so a request
+// either takes effect entirely or not at all. Nothing is persisted: the
+// environment is the configuration again after a restart.
+func (s *AppState) applyConfigPatch(p ConfigPatch) (RuntimeConfig, error) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+
+	detector := s.detector
+	if p.Detector != nil || p.Threshold != nil || p.Alpha != nil {
+		mode := detector.Name()
+		if p.Detector != nil {
+			mode = *p.Detector
+		}
+		// A Holt-Winters detector can be retuned but not switched to, as
+		// its season is only configured at startup.
+		hw, isHW := detector.(*HoltWintersDetector)
+		keepHW := isHW && mode == "holtwinters"
+		if !isRuntimeDetector(mode) && !keepHW {
+			return RuntimeConfig{}, fmt.Errorf("detector must be one of %v, got %q", runtimeDetectors, mode)
+		}
+		cfg := DetectorConfig{Type: mode, StdDevMethod: string(s.stdDevMethod), MinStdDev: s.minStdDev}
+		if keepHW {
+			cfg.Season, cfg.Alpha, cfg.Beta, cfg.Gamma = hw.Season, hw.Alpha, hw.Beta, hw.Gamma
+		}
+		if p.Threshold != nil {
+			if *p.Threshold <= 0 {
+				return RuntimeConfig{}, fmt.Errorf("threshold must be positive, got %v", *p.Threshold)
+			}
+			cfg.Threshold = *p.Threshold
+		} else if t, ok := detectorThreshold(detector); ok && p.Detector == nil {
+			cfg.Threshold = t
+		}
+		if p.Alpha != nil {
+			if mode != "ewma" {
+				return RuntimeConfig{}, fmt.Errorf("alpha only applies to the ewma detector, not %s", mode)
+			}
+			if *p.Alpha <= 0 || *p.Alpha > 1 {
+				return RuntimeConfig{}, fmt.Errorf("alpha must be in (0, 1], got %v", *p.Alpha)
+			}
+			cfg.Alpha = *p.Alpha
+		} else if ewma, ok := detector.(*EWMADetector); ok && mode == "ewma" {
+			cfg.Alpha = ewma.Alpha
+		}
+		if cusum, ok := detector.(*CUSUMDetector); ok && mode == "cusum" {
+			cfg.Slack = cusum.Slack
+		} else if mode == "cusum" {
+			// The level shift detector was built with CUSUM_SLACK.
+			cfg.Slack = s.levelShift.Slack
+		}
+		var err error
+		if detector, err = newDetector(cfg); err != nil {
+			return RuntimeConfig{}, err
+		}
+	}
+
+	windowSizes := s.windowSizes
+	if p.WindowSize != nil {
+		size := *p.WindowSize
+		// Redis retention and the in-memory buffer are sized at startup.
+		if size < max(s.minSamples, 2) || size > s.buffer.size {
+			return RuntimeConfig{}, fmt.Errorf("window_size must be between %d and %d, got %d", max(s.minSamples, 2), s.buffer.size, size)
+		}
+		windowSizes = make(map[string]int, len(s.windowSizes))
+		for series, n := range s.windowSizes {
+			windowSizes[series] = n
+		}
+		windowSizes["rps"] = size
+	}
+
+	s.detector = detector
+	s.windowSizes = windowSizes
+	return s.runtimeConfig(), nil
+}
+
+func isRuntimeDetector(mode string) bool {
+	for _, d := range runtimeDetectors {
+		if d == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// handleConfigPatch applies a ConfigPatch and returns the effective
+// configuration.
+func handleConfigPatch(w http.ResponseWriter, r *http.Request) {
+	var p ConfigPatch
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON: "+err.Error())
+		return
+	}
+	cfg, err := appState.applyConfigPatch(p)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	loggerFrom(r.Context()).Info("Runtime configuration updated", "detector", cfg.Detector, "window_size", cfg.WindowSize)
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, cfg)
+}
+
+// handleConfigPut replaces the runtime configuration with a ConfigPatch
+// that sets detector, threshold and window_size, and alpha for ewma or
+// else its default, for clients that keep the whole configuration,
+// such as a deploy pipeline holding per-environment sensitivity, and want
+// no leftovers from earlier changes. It returns the effective
+// configuration.
+func handleConfigPut(w http.ResponseWriter, r *http.Request) {
+	var p ConfigPatch
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON: "+err.Error())
+		return
+	}
+	if p.Detector == nil || p.Threshold == nil || p.WindowSize == nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "detector, threshold and window_size are all required; use PATCH to change some of them")
+		return
+	}
+	if *p.Detector == "ewma" && p.Alpha == nil {
+		alpha := defaultEWMAAlpha
+		p.Alpha = &alpha
+	}
+	cfg, err := appState.applyConfigPatch(p)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	loggerFrom(r.Context()).Info("Runtime configuration replaced", "detector", cfg.Detector, "threshold", *p.Threshold, "window_size", cfg.WindowSize)
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, cfg)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleConfigPatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantCode   int
+		wantConfig RuntimeConfig
+	}{
+		{name: "threshold", body: `{"threshold": 3.5}`, wantCode: http.StatusOK,
+			wantConfig: RuntimeConfig{Detector: "zscore", Threshold: ptr(3.5), WindowSize: 5, MinSamples: 2}},
+		{name: "mode keeps given threshold", body: `{"detector": "mad", "threshold": 4}`, wantCode: http.StatusOK,
+			wantConfig: RuntimeConfig{Detector: "mad", Threshold: ptr(4.0), WindowSize: 5, MinSamples: 2}},
+		{name: "mode alone uses its default", body: `{"detector": "ewma"}`, wantCode: http.StatusOK,
+			wantConfig: RuntimeConfig{Detector: "ewma", Threshold: ptr(defaultEWMAThreshold), Alpha: ptr(defaultEWMAAlpha), WindowSize: 5, MinSamples: 2}},
+		{name: "ewma alpha", body: `{"detector": "ewma", "threshold": 2.5, "alpha": 0.1}`, wantCode: http.StatusOK,
+			wantConfig: RuntimeConfig{Detector: "ewma", Threshold: ptr(2.5), Alpha: ptr(0.1), WindowSize: 5, MinSamples: 2}},
+		{name: "cusum", body: `{"detector": "cusum"}`, wantCode: http.StatusOK,
+			wantConfig: RuntimeConfig{Detector: "cusum", Threshold: ptr(defaultCUSUMThreshold), WindowSize: 5, MinSamples: 2}},
+		{name: "alpha for zscore", body: `{"alpha": 0.1}`, wantCode: http.StatusBadRequest},
+		{name: "alpha out of range", body: `{"detector": "ewma", "alpha": 1.5}`, wantCode: http.StatusBadRequest},
+		{name: "window size", body: `{"window_size": 3}`, wantCode: http.StatusOK,
+			wantConfig: RuntimeConfig{Detector: "zscore", Threshold: ptr(defaultZScoreThreshold), WindowSize: 3, MinSamples: 2}},
+		{name: "empty patch", body: `{}`, wantCode: http.StatusOK,
+			wantConfig: RuntimeConfig{Detector: "zscore", Threshold: ptr(defaultZScoreThreshold), WindowSize: 5, MinSamples: 2}},
+		{name: "unknown mode", body: `{"detector": "trend"}`, wantCode: http.StatusBadRequest},
+		{name: "negative threshold", body: `{"threshold": -1}`, wantCode: http.StatusBadRequest},
+		{name: "window above buffer", body: `{"window_size": 6}`, wantCode: http.StatusBadRequest},
+		{name: "window below min samples", body: `{"window_size": 1}`, wantCode: http.StatusBadRequest},
+		{name: "unknown field", body: `{"cooldown": "5m"}`, wantCode: http.StatusBadRequest},
+		{name: "invalid patch changes nothing", body: `{"threshold": 9, "window_size": 100}`, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			appState.adminToken = "secret"
+			req := httptest.NewRequest(http.MethodPatch, "/config", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+
+			appState.cfgMu.RLock()
+			effective := appState.runtimeConfig()
+			appState.cfgMu.RUnlock()
+			if tt.wantCode != http.StatusOK {
+				if effective.Detector != "zscore" || *effective.Threshold != defaultZScoreThreshold || effective.WindowSize != 5 {
+					t.Errorf("rejected patch changed the config to %+v", effective)
+				}
+				return
+			}
+			var got RuntimeConfig
+			json.NewDecoder(w.Body).Decode(&got)
+			if !equalRuntimeConfig(got, tt.wantConfig) || !equalRuntimeConfig(effective, tt.wantConfig) {
+				t.Errorf("response %+v, effective %+v, want %+v", got, effective, tt.wantConfig)
+			}
+		})
+	}
+}
+
+func TestHandleConfigPut(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantCode   int
+		wantConfig RuntimeConfig
+	}{
+		{name: "full config", body: `{"detector": "zscore", "threshold": 3, "window_size": 4}`, wantCode: http.StatusOK,
+			wantConfig: RuntimeConfig{Detector: "zscore", Threshold: ptr(3.0), WindowSize: 4, MinSamples: 2}},
+		{name: "switches mode", body: `{"detector": "mad", "threshold": 5, "window_size": 5}`, wantCode: http.StatusOK,
+			wantConfig: RuntimeConfig{Detector: "mad", Threshold: ptr(5.0), WindowSize: 5, MinSamples: 2}},
+		{name: "ewma default alpha", body: `{"detector": "ewma", "threshold": 2, "window_size": 5}`, wantCode: http.StatusOK,
+			wantConfig: RuntimeConfig{Detector: "ewma", Threshold: ptr(2.0), Alpha: ptr(defaultEWMAAlpha), WindowSize: 5, MinSamples: 2}},
+		{name: "missing threshold", body: `{"detector": "zscore", "window_size": 4}`, wantCode: http.StatusBadRequest},
+		{name: "missing window size", body: `{"detector": "zscore", "threshold": 3}`, wantCode: http.StatusBadRequest},
+		{name: "zero threshold", body: `{"detector": "zscore", "threshold": 0, "window_size": 4}`, wantCode: http.StatusBadRequest},
+		{name: "window above buffer", body: `{"detector": "zscore", "threshold": 3, "window_size": 6}`, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAppState(t)
+			appState.adminToken = "secret"
+			req := httptest.NewRequest(http.MethodPut, "/config", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+
+			appState.cfgMu.RLock()
+			effective := appState.runtimeConfig()
+			appState.cfgMu.RUnlock()
+			if tt.wantCode != http.StatusOK {
+				if effective.Detector != "zscore" || *effective.Threshold != defaultZScoreThreshold || effective.WindowSize != 5 {
+					t.Errorf("rejected config changed the config to %+v", effective)
+				}
+				return
+			}
+			var got RuntimeConfig
+			json.NewDecoder(w.Body).Decode(&got)
+			if !equalRuntimeConfig(got, tt.wantConfig) || !equalRuntimeConfig(effective, tt.wantConfig) {
+				t.Errorf("response %+v, effective %+v, want %+v", got, effective, tt.wantConfig)
+			}
+		})
+	}
+}
+
+func TestConfigPatchRequiresToken(t *testing.T) {
+	newTestAppState(t)
+	appState.adminToken = "secret"
+	w := httptest.NewRecorder()
+	newMux().ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/config", strings.NewReader(`{"threshold": 1}`)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", w.Code)
+	}
+	if d, ok := appState.currentDetector().(*ZScoreDetector); !ok || d.Threshold != defaultZScoreThreshold {
+		t.Errorf("unauthorised patch changed the detector to %+v", appState.currentDetector())
+	}
+}
+
+func ptr(v float64) *float64 { return &v }
+
+func equalRuntimeConfig(a, b RuntimeConfig) bool {
+	equal := func(x, y *float64) bool { return (x == nil) == (y == nil) && (x == nil || *x == *y) }
+	if !equal(a.Threshold, b.Threshold) || !equal(a.Alpha, b.Alpha) {
+		return false
+	}
+	a.Threshold, b.Threshold = nil, nil
+	a.Alpha, b.Alpha = nil, nil
+	return a == b
+}
+
+func TestConfigPatchCUSUMSlack(t *testing.T) {
+	newTestAppState(t)
+	// As built from CUSUM_SLACK at startup.
+	appState.levelShift = &CUSUMDetector{Threshold: defaultCUSUMThreshold, Slack: 0.8}
+	detector := "cusum"
+	if _, err := appState.applyConfigPatch(ConfigPatch{Detector: &detector}); err != nil {
+		t.Fatal(err)
+	}
+	if got := appState.currentDetector().(*CUSUMDetector).Slack; got != 0.8 {
+		t.Errorf("slack = %v, want CUSUM_SLACK's 0.8", got)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"runtime"
+	"time"
+)
+
+// RuntimeStats is the process snapshot added to /health?verbose=true for
+// quick capacity checks.
+type RuntimeStats struct {
+	Goroutines     int       `json:"goroutines"`
+	HeapAllocBytes uint64    `json:"heap_alloc_bytes"`
+	HeapSysBytes   uint64    `json:"heap_sys_bytes"`
+	HeapObjects    uint64    `json:"heap_objects"`
+	NumGC          uint32    `json:"num_gc"`
+	LastGC         time.Time `json:"last_gc,omitzero"`
+	GCPauseTotalNs uint64    `json:"gc_pause_total_ns"`
+	// PendingSamples are samples buffered in memory while Redis is
+	// unavailable, waiting to be flushed.
+	PendingSamples int `json:"pending_samples"`
+}
+
+// readRuntimeStats collects RuntimeStats. runtime.ReadMemStats stops the
+// world briefly, which is why it is opt-in rather than part of every probe.
+func readRuntimeStats() RuntimeStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	stats := RuntimeStats{
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: ms.HeapAlloc,
+		HeapSysBytes:   ms.HeapSys,
+		HeapObjects:    ms.HeapObjects,
+		NumGC:          ms.NumGC,
+		GCPauseTotalNs: ms.PauseTotalNs,
+		PendingSamples: appState.buffer.pendingCount(),
+	}
+	if ms.LastGC > 0 {
+		stats.LastGC = time.Unix(0, int64(ms.LastGC)).UTC()
+	}
+	return stats
+}
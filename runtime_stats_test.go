@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthVerbose(t *testing.T) {
+	newTestAppState(t)
+	appState.buffer.addPending("web", Metric{RPS: 1})
+	tests := []struct {
+		target      string
+		wantCode    int
+		wantRuntime bool
+	}{
+		{target: "/health", wantCode: http.StatusOK},
+		{target: "/health?verbose=false", wantCode: http.StatusOK},
+		{target: "/health?verbose=true", wantCode: http.StatusOK, wantRuntime: true},
+		{target: "/health?verbose=maybe", wantCode: http.StatusBadRequest},
+	}
+	mux := newMux()
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var body struct {
+				Status  string        `json:"status"`
+				Runtime *RuntimeStats `json:"runtime"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Status != "healthy" || (body.Runtime != nil) != tt.wantRuntime {
+				t.Fatalf("body = %+v, want runtime %v", body, tt.wantRuntime)
+			}
+			if tt.wantRuntime {
+				if body.Runtime.Goroutines <= 0 || body.Runtime.HeapAllocBytes == 0 || body.Runtime.PendingSamples != 1 {
+					t.Errorf("runtime = %+v", body.Runtime)
+				}
+			}
+		})
+	}
+}
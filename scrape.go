@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// maxScrapeBytes bounds one scraped /metrics page.
+const maxScrapeBytes = 16 << 20
+
+// scrapeTarget is one endpoint the scraper pulls, with what it needs to
+// turn a counter into a rate between scrapes.
+type scrapeTarget struct {
+	stream string
+	url    string
+
+	// prevTotal is the RPS counter at prevAt, the last scrape that had it.
+	prevTotal float64
+	prevAt    time.Time
+}
+
+// parseScrapeTargets parses a SCRAPE_TARGETS list such as
+// "web=http://web:8080/metrics,api=http://api:9100/metrics", naming the
+// stream each target feeds. A target without a name feeds the default
+// stream.
+func parseScrapeTargets(spec string) ([]*scrapeTarget, error) {
+	var targets []*scrapeTarget
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		stream, target := defaultStream, entry
+		if name, rest, ok := strings.Cut(entry, "="); ok && !strings.Contains(name, "/") {
+			stream, target = strings.TrimSpace(name), strings.TrimSpace(rest)
+		}
+		if !streamNamePattern.MatchString(stream) {
+			return nil, fmt.Errorf("invalid stream name %q", stream)
+		}
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("target %q is not an http or https URL", target)
+		}
+		if seen[stream] {
+			return nil, fmt.Errorf("stream %q is scraped more than once", stream)
+		}
+		seen[stream] = true
+		targets = append(targets, &scrapeTarget{stream: stream, url: target})
+	}
+	return targets, nil
+}
+
+// scraper pulls the Prometheus /metrics pages of targets that cannot push
+// (SCRAPE_TARGETS) every interval (SCRAPE_INTERVAL), and feeds one Metric
+// per target into the pipeline. SCRAPE_CPU_METRIC and SCRAPE_RPS_METRIC
+// name the series read. Gauges are taken as they are; CPU series with
+// several label sets, as per core, are averaged, and RPS series, as per
+// route, are added up. An RPS counter, such as http_requests_total, is
+// turned into a per-second rate between two scrapes, so a target's first
+// scrape, and the one after a counter reset, have no RPS. Such scrapes
+// feed no sample, even when they read a CPU: an RPS of 0 would look like
+// an outage.
+type scraper struct {
+	client    *http.Client
+	targets   []*scrapeTarget
+	cpuMetric string
+	rpsMetric string
+	interval  time.Duration
+	// scrapes counts scrapes by result, "success" or "failed".
+	scrapes *prometheus.CounterVec
+}
+
+func newScraper(targets []*scrapeTarget, cpuMetric, rpsMetric string, interval time.Duration, scrapes *prometheus.CounterVec) *scraper {
+	return &scraper{
+		client:    &http.Client{Timeout: interval},
+		targets:   targets,
+		cpuMetric: cpuMetric,
+		rpsMetric: rpsMetric,
+		interval:  interval,
+		scrapes:   scrapes,
+	}
+}
+
+// run scrapes every target each interval until ctx is cancelled.
+func (s *scraper) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.scrapeAll(ctx, now)
+		}
+	}
+}
+
+// scrapeAll scrapes the targets concurrently and processes their samples.
+func (s *scraper) scrapeAll(ctx context.Context, now time.Time) {
+	var wg sync.WaitGroup
+	for _, t := range s.targets {
+		wg.Go(func() {
+			logger := slog.Default().With("transport", "scrape", "stream", t.stream, "target", t.url)
+			m, ok, err := s.scrape(ctx, t, now)
+			if err != nil {
+				s.scrapes.WithLabelValues("failed").Inc()
+				logSampled(logger, "Scrape failed", "error", err)
+				return
+			}
+			s.scrapes.WithLabelValues("success").Inc()
+			if !ok {
+				return
+			}
+			if err := recordIntake(ctx, logger, t.stream, m); err != nil {
+				return
+			}
+			processMetric(ctx, logger, t.stream, m)
+		})
+	}
+	wg.Wait()
+}
+
+// scrape fetches t and returns its sample at now. ok is false when the
+// page held nothing usable yet, or no RPS while one is scraped, as on the
+// first scrape of a counter.
+func (s *scraper) scrape(ctx context.Context, t *scrapeTarget, now time.Time) (Metric, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url, nil)
+	if err != nil {
+		return Metric{}, false, err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Metric{}, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Metric{}, false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(io.LimitReader(resp.Body, maxScrapeBytes))
+	if err != nil {
+		return Metric{}, false, err
+	}
+
+	m := Metric{Timestamp: now.UTC()}
+	var haveCPU, haveRPS bool
+	if mf := families[s.cpuMetric]; mf != nil {
+		values, err := scrapedValues(mf)
+		if err != nil {
+			return Metric{}, false, err
+		}
+		for _, v := range values {
+			m.CPU += v / float64(len(values))
+		}
+		haveCPU = len(values) > 0
+	}
+	if mf := families[s.rpsMetric]; mf != nil {
+		values, err := scrapedValues(mf)
+		if err != nil {
+			return Metric{}, false, err
+		}
+		var total float64
+		for _, v := range values {
+			total += v
+		}
+		switch {
+		case len(values) == 0:
+		case mf.GetType() != dto.MetricType_COUNTER:
+			m.RPS, haveRPS = total, true
+		default:
+			// A counter needs two scrapes for a rate, and one that went
+			// down was reset.
+			if !t.prevAt.IsZero() && total >= t.prevTotal && now.After(t.prevAt) {
+				m.RPS, haveRPS = (total-t.prevTotal)/now.Sub(t.prevAt).Seconds(), true
+			}
+			t.prevTotal, t.prevAt = total, now
+		}
+	}
+	if s.rpsMetric != "" {
+		return m, haveRPS, nil
+	}
+	return m, haveCPU, nil
+}
+
+// scrapedValues returns the finite value of every series of a gauge,
+// counter or untyped family.
+func scrapedValues(mf *dto.MetricFamily) ([]float64, error) {
+	var values []float64
+	for _, metric := range mf.GetMetric() {
+		var v float64
+		switch mf.GetType() {
+		case dto.MetricType_GAUGE:
+			v = metric.GetGauge().GetValue()
+		case dto.MetricType_COUNTER:
+			v = metric.GetCounter().GetValue()
+		case dto.MetricType_UNTYPED:
+			v = metric.GetUntyped().GetValue()
+		default:
+			return nil, fmt.Errorf("%s is not a gauge or counter", mf.GetName())
+		}
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseScrapeTargets(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{spec: "web=http://web:8080/metrics, api=https://api/metrics", want: []string{"web", "api"}},
+		{spec: "http://web:8080/metrics?a=b", want: []string{defaultStream}},
+		{spec: "web=ftp://web/metrics", wantErr: true},
+		{spec: "web=http://a/metrics,web=http://b/metrics", wantErr: true},
+		{spec: "bad name=http://a/metrics", wantErr: true},
+		{spec: "web=", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseScrapeTargets(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d targets, want %d", len(got), len(tt.want))
+			}
+			for i, stream := range tt.want {
+				if got[i].stream != stream {
+					t.Errorf("target %d stream = %q, want %q", i, got[i].stream, stream)
+				}
+			}
+		})
+	}
+}
+
+func TestScrape(t *testing.T) {
+	var requests atomic.Int64
+	pages := []string{
+		"# TYPE http_requests_total counter\nhttp_requests_total{route=\"/a\"} 100\nhttp_requests_total{route=\"/b\"} 100\n# TYPE cpu_percent gauge\ncpu_percent{core=\"0\"} 40\ncpu_percent{core=\"1\"} 60\n",
+		"# TYPE http_requests_total counter\nhttp_requests_total{route=\"/a\"} 200\nhttp_requests_total{route=\"/b\"} 200\n# TYPE cpu_percent gauge\ncpu_percent{core=\"0\"} 40\ncpu_percent{core=\"1\"} 60\n",
+		"# TYPE http_requests_total counter\nhttp_requests_total{route=\"/a\"} 5\n# TYPE cpu_percent gauge\ncpu_percent 70\n",
+		"# TYPE http_requests_total summary\nhttp_requests_total_count 5\n",
+		"not a metrics page {",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(pages[requests.Add(1)-1]))
+	}))
+	defer srv.Close()
+
+	newTestAppState(t)
+	s := newScraper(nil, "cpu_percent", "http_requests_total", time.Second, nil)
+	target := &scrapeTarget{stream: "web", url: srv.URL}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	steps := []struct {
+		name    string
+		wantOK  bool
+		wantErr bool
+		want    Metric
+	}{
+		// Without a rate there is no sample, even with a CPU.
+		{name: "first scrape has no rate"},
+		{name: "rate between scrapes", wantOK: true, want: Metric{CPU: 50, RPS: 20}},
+		{name: "counter reset"},
+		{name: "summary", wantErr: true},
+		{name: "bad page", wantErr: true},
+	}
+	for i, step := range steps {
+		now := start.Add(time.Duration(i) * 10 * time.Second)
+		m, ok, err := s.scrape(context.Background(), target, now)
+		if (err != nil) != step.wantErr || ok != step.wantOK {
+			t.Fatalf("%s: ok = %v, err = %v", step.name, ok, err)
+		}
+		if ok && (m.CPU != step.want.CPU || m.RPS != step.want.RPS || !m.Timestamp.Equal(now)) {
+			t.Errorf("%s: got %+v, want %+v at %v", step.name, m, step.want, now)
+		}
+	}
+}
+
+func TestScrapeAll(t *testing.T) {
+	newTestAppState(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("# TYPE rps gauge\nrps 12\n"))
+	}))
+	defer srv.Close()
+
+	targets, err := parseScrapeTargets("web=" + srv.URL + "/metrics,api=" + srv.URL + "/down")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scrapes := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "scrapes"}, []string{"result"})
+	s := newScraper(targets, "", "rps", time.Second, scrapes)
+	s.scrapeAll(context.Background(), time.Now())
+
+	if got := counterValue(scrapes.WithLabelValues("success")); got != 1 {
+		t.Errorf("successful scrapes = %v, want 1", got)
+	}
+	if got := counterValue(scrapes.WithLabelValues("failed")); got != 1 {
+		t.Errorf("failed scrapes = %v, want 1", got)
+	}
+	if got := rpsOf(appState.buffer.window("web")); !equalFloats(got, []float64{12}) {
+		t.Errorf("web rps = %v, want [12]", got)
+	}
+	if got := appState.buffer.window("api"); len(got) != 0 {
+		t.Errorf("api window = %v, want empty", got)
+	}
+}
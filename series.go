@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// knownSeries are the metric names carried by every sample. Each one can
+// have its own window size.
+var knownSeries = []string{"rps", "cpu"}
+
+// defaultMaxWindowSize is the default MAX_WINDOW_SIZE. Every sample is
+// read back from Redis and decoded on each request, so the cost of a
+// window grows with its size.
+const defaultMaxWindowSize = 10000
+
+// defaultWindowSize is the default WINDOW_SIZE, the window of every series
+// WINDOW_SIZES leaves out.
+const defaultWindowSize = 50
+
+// capWindowSizes lowers every size above limit to limit, so a mistyped
+// WINDOW_SIZES cannot make each request load millions of samples. It
+// returns the names of the series it capped, sorted.
+func capWindowSizes(sizes map[string]int, limit int) []string {
+	var capped []string
+	for _, name := range knownSeries {
+		if sizes[name] > limit {
+			sizes[name] = limit
+			capped = append(capped, name)
+		}
+	}
+	return capped
+}
+
+// parseWindowSizes parses a WINDOW_SIZES spec such as "rps=50,cpu=100".
+// Series missing from the spec use defaultSize.
+func parseWindowSizes(spec string, defaultSize int) (map[string]int, error) {
+	sizes := make(map[string]int, len(knownSeries))
+	for _, name := range knownSeries {
+		sizes[name] = defaultSize
+	}
+	if strings.TrimSpace(spec) == "" {
+		return sizes, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("malformed entry %q, want name=size", entry)
+		}
+		if _, known := sizes[name]; !known {
+			return nil, fmt.Errorf("unknown metric %q, want one of %s", name, strings.Join(knownSeries, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("metric %q listed more than once", name)
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("window size for %q must be a positive integer, got %q", name, value)
+		}
+		seen[name] = true
+		sizes[name] = size
+	}
+	return sizes, nil
+}
+
+// windowFor returns the window size configured for a series.
+func (s *AppState) windowFor(series string) int {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	if size, ok := s.windowSizes[series]; ok {
+		return size
+	}
+	return s.windowSize
+}
+
+// maxWindow is the largest window of any series, or of the divergence
+// detector's long window. All series share one Redis list per stream, so
+// that list has to hold at least this many samples and each series then
+// aggregates over its own trailing slice of it.
+func (s *AppState) maxWindow() int {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	size := s.windowSize
+	for _, n := range s.windowSizes {
+		size = max(size, n)
+	}
+	if s.divergence != nil {
+		size = max(size, s.divergence.Long)
+	}
+	return size
+}
+
+// lastN returns the trailing n values, or all of them if there are fewer.
+func lastN(values []float64, n int) []float64 {
+	if len(values) > n {
+		return values[len(values)-n:]
+	}
+	return values
+}
+
+// windowFillRatio reports how full a window is, from 0 for a stream with no
+// samples yet to 1 once the window is complete.
+func windowFillRatio(samples, size int) float64 {
+	if samples <= 0 || size <= 0 {
+		return 0
+	}
+	return math.Min(float64(samples)/float64(size), 1)
+}
+
+// seriesValue returns the value of the named series in m. name must be one
+// of knownSeries.
+func seriesValue(m Metric, name string) float64 {
+	if name == "cpu" {
+		return m.CPU
+	}
+	return m.RPS
+}
+
+func isKnownSeries(name string) bool {
+	for _, s := range knownSeries {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestParseWindowSizes(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]int
+		wantErr bool
+	}{
+		{spec: "", want: map[string]int{"rps": 50, "cpu": 50}},
+		{spec: "rps=20", want: map[string]int{"rps": 20, "cpu": 50}},
+		{spec: "rps=20, cpu = 100", want: map[string]int{"rps": 20, "cpu": 100}},
+		{spec: "rps", wantErr: true},
+		{spec: "=10", wantErr: true},
+		{spec: "rps=abc", wantErr: true},
+		{spec: "rps=0", wantErr: true},
+		{spec: "cpu=-5", wantErr: true},
+		{spec: "latency_ms=200", wantErr: true},
+		{spec: "rps=10,rps=20", wantErr: true},
+		{spec: "rps=10,", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseWindowSizes(tt.spec, 50)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("sizes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapWindowSizes(t *testing.T) {
+	tests := []struct {
+		name       string
+		sizes      map[string]int
+		limit      int
+		want       map[string]int
+		wantCapped []string
+	}{
+		{name: "within limit", sizes: map[string]int{"rps": 50, "cpu": 100}, limit: 100,
+			want: map[string]int{"rps": 50, "cpu": 100}},
+		{name: "one capped", sizes: map[string]int{"rps": 10000000, "cpu": 100}, limit: 10000,
+			want: map[string]int{"rps": 10000, "cpu": 100}, wantCapped: []string{"rps"}},
+		{name: "both capped", sizes: map[string]int{"rps": 600, "cpu": 700}, limit: 500,
+			want: map[string]int{"rps": 500, "cpu": 500}, wantCapped: []string{"rps", "cpu"}},
+	}
+	for _, tt := range tests {
+		capped := capWindowSizes(tt.sizes, tt.limit)
+		if !maps.Equal(tt.sizes, tt.want) || !slices.Equal(capped, tt.wantCapped) {
+			t.Errorf("%s: sizes %v, capped %v; want %v, %v", tt.name, tt.sizes, capped, tt.want, tt.wantCapped)
+		}
+	}
+}
+
+func TestWindowForAndMaxWindow(t *testing.T) {
+	s := &AppState{windowSize: 50, windowSizes: map[string]int{"rps": 20, "cpu": 120}}
+	if got := s.windowFor("rps"); got != 20 {
+		t.Errorf("windowFor(rps) = %d, want 20", got)
+	}
+	if got := s.windowFor("latency_ms"); got != 50 {
+		t.Errorf("windowFor(latency_ms) = %d, want default 50", got)
+	}
+	if got := s.maxWindow(); got != 120 {
+		t.Errorf("maxWindow() = %d, want 120", got)
+	}
+	if got := (&AppState{windowSize: 50}).maxWindow(); got != 50 {
+		t.Errorf("maxWindow() without overrides = %d, want 50", got)
+	}
+}
+
+func TestLastN(t *testing.T) {
+	values := []float64{1, 2, 3, 4}
+	if got := lastN(values, 2); fmt.Sprint(got) != "[3 4]" {
+		t.Errorf("lastN(2) = %v", got)
+	}
+	if got := lastN(values, 10); len(got) != 4 {
+		t.Errorf("lastN(10) = %v", got)
+	}
+}
+
+func TestWindowFillRatio(t *testing.T) {
+	tests := []struct {
+		samples, size int
+		want          float64
+	}{
+		{0, 50, 0},
+		{1, 50, 0.02},
+		{25, 50, 0.5},
+		{50, 50, 1},
+		{80, 50, 1},
+		{10, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := windowFillRatio(tt.samples, tt.size); got != tt.want {
+			t.Errorf("windowFillRatio(%d, %d) = %v, want %v", tt.samples, tt.size, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// tlsVersions maps the TLS_MIN_VERSION values we accept to their constants.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion parses a TLS_MIN_VERSION value. An empty value means TLS
+// 1.2; anything older is rejected outright.
+func parseTLSVersion(v string) (uint16, error) {
+	if v == "" {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := tlsVersions[v]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q (want 1.2 or 1.3)", v)
+	}
+	return version, nil
+}
+
+// tlsSettings holds the certificate pair and TLS config for HTTPS, or is
+// disabled when neither file is configured.
+type tlsSettings struct {
+	certFile string
+	keyFile  string
+	config   *tls.Config
+}
+
+func (t tlsSettings) enabled() bool {
+	return t.certFile != ""
+}
+
+// newTLSSettings validates the TLS env settings. Setting only one of the
+// certificate and key files is an error rather than a silent fall back to
+// plain HTTP.
+func newTLSSettings(certFile, keyFile, minVersion string) (tlsSettings, error) {
+	if (certFile == "") != (keyFile == "") {
+		return tlsSettings{}, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if certFile == "" {
+		return tlsSettings{}, nil
+	}
+	version, err := parseTLSVersion(minVersion)
+	if err != nil {
+		return tlsSettings{}, err
+	}
+	return tlsSettings{
+		certFile: certFile,
+		keyFile:  keyFile,
+		config:   &tls.Config{MinVersion: version},
+	}, nil
+}
+
+// runServer serves srv, over HTTPS when t is enabled, until ctx is cancelled
+// and then shuts it down, giving in-flight requests up to shutdownTimeout to
+// finish.
+func runServer(ctx context.Context, srv *http.Server, t tlsSettings, shutdownTimeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		if t.enabled() {
+			srv.TLSConfig = t.config
+			errCh <- srv.ListenAndServeTLS(t.certFile, t.keyFile)
+		} else {
+			errCh <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down server on %s", srv.Addr)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// runServers runs every server with runServer until ctx is cancelled, so
+// they shut down together. If one of them fails, the others are shut down
+// too and its error is returned.
+func runServers(ctx context.Context, t tlsSettings, shutdownTimeout time.Duration, srvs ...*http.Server) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errCh := make(chan error, len(srvs))
+	for _, srv := range srvs {
+		go func() { errCh <- runServer(ctx, srv, t, shutdownTimeout) }()
+	}
+	var first error
+	for range srvs {
+		if err := <-errCh; err != nil && first == nil {
+			first = err
+			cancel()
+		}
+	}
+	return first
+}
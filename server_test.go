@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewTLSSettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		cert, key   string
+		minVersion  string
+		wantEnabled bool
+		wantVersion uint16
+		wantErr     bool
+	}{
+		{name: "plain HTTP"},
+		{name: "default min version", cert: "c.pem", key: "k.pem", wantEnabled: true, wantVersion: tls.VersionTLS12},
+		{name: "TLS 1.3", cert: "c.pem", key: "k.pem", minVersion: "1.3", wantEnabled: true, wantVersion: tls.VersionTLS13},
+		{name: "unsupported version", cert: "c.pem", key: "k.pem", minVersion: "1.0", wantErr: true},
+		{name: "cert without key", cert: "c.pem", wantErr: true},
+		{name: "key without cert", key: "k.pem", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTLSSettings(tt.cert, tt.key, tt.minVersion)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.enabled() != tt.wantEnabled {
+				t.Errorf("enabled = %v, want %v", got.enabled(), tt.wantEnabled)
+			}
+			if tt.wantEnabled && got.config.MinVersion != tt.wantVersion {
+				t.Errorf("MinVersion = %x, want %x", got.config.MinVersion, tt.wantVersion)
+			}
+		})
+	}
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 to dir.
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "go-service test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestRunServerTLSAndShutdown(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+	settings, err := newTLSSettings(certFile, keyFile, "1.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := freeAddr(t)
+	srv := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- runServer(ctx, srv, settings, time.Second) }()
+
+	client := func(maxVersion uint16) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			MaxVersion:         maxVersion,
+		}}}
+	}
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = client(0).Get("https://" + addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.TLS == nil || resp.TLS.Version != tls.VersionTLS13 {
+		t.Errorf("negotiated TLS state = %+v, want TLS 1.3", resp.TLS)
+	}
+
+	if _, err := client(tls.VersionTLS12).Get("https://" + addr); err == nil {
+		t.Error("TLS 1.2 client connected despite TLS_MIN_VERSION=1.3")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("runServer = %v, want nil after shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runServer did not return after cancellation")
+	}
+}
+
+func TestRunServers(t *testing.T) {
+	tests := []struct {
+		name    string
+		taken   bool // the second address is already in use
+		wantErr bool
+	}{
+		{name: "shut down together"},
+		{name: "one fails to listen", taken: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+			first := &http.Server{Addr: freeAddr(t), Handler: handler}
+			second := &http.Server{Addr: freeAddr(t), Handler: handler}
+			if tt.taken {
+				ln, err := net.Listen("tcp", second.Addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer ln.Close()
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- runServers(ctx, tlsSettings{}, time.Second, first, second) }()
+
+			if !tt.taken {
+				for _, srv := range []*http.Server{first, second} {
+					var err error
+					for i := 0; i < 50; i++ {
+						var resp *http.Response
+						if resp, err = http.Get("http://" + srv.Addr); err == nil {
+							resp.Body.Close()
+							break
+						}
+						time.Sleep(20 * time.Millisecond)
+					}
+					if err != nil {
+						t.Fatalf("GET %s: %v", srv.Addr, err)
+					}
+				}
+				cancel()
+			}
+
+			select {
+			case err := <-done:
+				if (err != nil) != tt.wantErr {
+					t.Errorf("runServers = %v, wantErr %v", err, tt.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("runServers did not return")
+			}
+			// Whatever happened, nothing is left listening.
+			if _, err := http.Get("http://" + first.Addr); err == nil {
+				t.Error("first server still serving after runServers returned")
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	severityInfo     = "info"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+
+	defaultSeverityWarning  = 2.0
+	defaultSeverityCritical = 4.0
+)
+
+// severities lists every severity label, so the anomaly counter can export
+// all of them at zero from startup.
+var severities = []string{severityInfo, severityWarning, severityCritical}
+
+// SeverityCutoffs grade an anomaly by the absolute detector score, in the
+// detector's own units (standard deviations for zscore), after
+// severityScore has scaled the ones that are not deviations. Scores above
+// Critical are critical and scores above Warning are warnings. An anomaly
+// at or below Warning, which only happens when the detector threshold is
+// lower than the cutoff, is info.
+type SeverityCutoffs struct {
+	Warning  float64
+	Critical float64
+}
+
+func newSeverityCutoffs(warning, critical float64) (SeverityCutoffs, error) {
+	if warning < 0 {
+		return SeverityCutoffs{}, fmt.Errorf("warning cutoff must not be negative, got %v", warning)
+	}
+	if critical <= warning {
+		return SeverityCutoffs{}, fmt.Errorf("critical cutoff %v must be above the warning cutoff %v", critical, warning)
+	}
+	return SeverityCutoffs{Warning: warning, Critical: critical}, nil
+}
+
+// severityScaler is implemented by detectors whose scores are not on a
+// deviation scale, so the cutoffs can grade them as well.
+type severityScaler interface {
+	severityScore(score float64) float64
+}
+
+// severityScore returns score of d on the scale the cutoffs are set in.
+func severityScore(d AnomalyDetector, score float64) float64 {
+	if s, ok := d.(severityScaler); ok {
+		return s.severityScore(score)
+	}
+	return score
+}
+
+// classify returns the severity of an anomaly with the given score.
+func (c SeverityCutoffs) classify(score float64) string {
+	switch s := math.Abs(score); {
+	case s > c.Critical:
+		return severityCritical
+	case s > c.Warning:
+		return severityWarning
+	default:
+		return severityInfo
+	}
+}
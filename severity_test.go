@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+// anomalyCount sums go_service_anomalies_total over all severities.
+func anomalyCount() float64 {
+	total := 0.0
+	for _, severity := range severities {
+		total += counterValue(appState.anomalyCounter.WithLabelValues(severity))
+	}
+	return total
+}
+
+func TestNewSeverityCutoffs(t *testing.T) {
+	tests := []struct {
+		name              string
+		warning, critical float64
+		wantErr           bool
+	}{
+		{name: "defaults", warning: defaultSeverityWarning, critical: defaultSeverityCritical},
+		{name: "zero warning", warning: 0, critical: 1},
+		{name: "negative warning", warning: -1, critical: 4, wantErr: true},
+		{name: "critical equal", warning: 3, critical: 3, wantErr: true},
+		{name: "critical below", warning: 4, critical: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := newSeverityCutoffs(tt.warning, tt.critical)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSeverityClassify(t *testing.T) {
+	c := SeverityCutoffs{Warning: 2, Critical: 4}
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{score: 1.5, want: severityInfo},
+		{score: 2, want: severityInfo},
+		{score: 2.5, want: severityWarning},
+		{score: -3, want: severityWarning},
+		{score: 4, want: severityWarning},
+		{score: 4.1, want: severityCritical},
+		{score: -10, want: severityCritical},
+	}
+	for _, tt := range tests {
+		if got := c.classify(tt.score); got != tt.want {
+			t.Errorf("classify(%v) = %q, want %q", tt.score, got, tt.want)
+		}
+	}
+}
+
+func TestCUSUMSeverityScaled(t *testing.T) {
+	c := SeverityCutoffs{Warning: defaultSeverityWarning, Critical: defaultSeverityCritical}
+	d := &CUSUMDetector{Threshold: 5, Slack: 0.5}
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{score: 6, want: severityWarning},
+		{score: -8, want: severityWarning},
+		{score: 10.5, want: severityCritical},
+	}
+	for _, tt := range tests {
+		if got := c.classify(severityScore(d, tt.score)); got != tt.want {
+			t.Errorf("cusum score %v graded %q, want %q", tt.score, got, tt.want)
+		}
+	}
+}
+
+func TestAnomalySeverityRecorded(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.windowSize = 21
+	appState.detector = &ZScoreDetector{Threshold: 1}
+	// The current sample is part of the window, which caps its z-score at
+	// (n-1)/sqrt(n), about 4.4 for 21 samples: enough room for both levels.
+	tests := []struct {
+		current float64
+		want    string
+	}{
+		{current: 15, want: severityWarning},
+		{current: 1000, want: severityCritical},
+	}
+	for _, tt := range tests {
+		var window []Metric
+		for i := 0; i < 10; i++ {
+			window = append(window, Metric{RPS: 10}, Metric{RPS: 12})
+		}
+		window = append(window, Metric{RPS: tt.current})
+		got := analyzeWindow(context.Background(), slog.Default(), defaultStream, window[len(window)-1], window)
+		if got.Status != statusAnomaly || got.Severity != tt.want {
+			t.Errorf("rps %v: result %+v, want %s anomaly", tt.current, got, tt.want)
+		}
+	}
+
+	for _, severity := range []string{severityWarning, severityCritical} {
+		if v := counterValue(appState.anomalyCounter.WithLabelValues(severity)); v != 1 {
+			t.Errorf("anomalies_total{severity=%q} = %v, want 1", severity, v)
+		}
+	}
+	members, err := mr.ZMembers(appState.key("anomalies"))
+	if err != nil || len(members) != 2 {
+		t.Fatalf("stored anomalies = %v, %v; want 2", members, err)
+	}
+	for _, item := range members {
+		var rec AnomalyRecord
+		if err := json.Unmarshal([]byte(item), &rec); err != nil || rec.Severity == "" {
+			t.Errorf("record %s has no severity", item)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultSimulationStream = "simulated"
+	defaultSimulationRate   = 10
+	maxSimulationRate       = 1000
+	maxSimulationDuration   = 10 * time.Minute
+)
+
+// SimulationRequest is the body of POST /simulate. Durations are Go
+// duration strings such as "30s".
+type SimulationRequest struct {
+	Stream   string `json:"stream"`
+	Duration string `json:"duration"`
+	// Rate is samples per second.
+	Rate    float64 `json:"rate"`
+	BaseRPS float64 `json:"base_rps"`
+	BaseCPU float64 `json:"base_cpu"`
+	// Noise is the standard deviation of the Gaussian noise, as a fraction
+	// of the base values.
+	Noise  float64          `json:"noise"`
+	Spikes []SimulatedSpike `json:"spikes,omitempty"`
+	// Seed makes a run reproducible; 0 picks a random one.
+	Seed uint64 `json:"seed,omitempty"`
+}
+
+// SimulatedSpike replaces the RPS of the first sample at or after At.
+type SimulatedSpike struct {
+	At  string  `json:"at"`
+	RPS float64 `json:"rps"`
+}
+
+// SimulationSummary is the response of POST /simulate.
+type SimulationSummary struct {
+	Stream    string `json:"stream"`
+	Generated int    `json:"generated"`
+	Anomalies int    `json:"anomalies"`
+	Warming   int    `json:"warming"`
+	Failed    int    `json:"failed"`
+	Cancelled bool   `json:"cancelled,omitempty"`
+}
+
+// simulation is a validated SimulationRequest.
+type simulation struct {
+	stream   string
+	duration time.Duration
+	interval time.Duration
+	baseRPS  float64
+	baseCPU  float64
+	noise    float64
+	spikes   map[int]float64 // sample index -> RPS
+	rng      *rand.Rand
+}
+
+func newSimulation(req SimulationRequest) (*simulation, error) {
+	sim := &simulation{stream: req.Stream, baseRPS: req.BaseRPS, baseCPU: req.BaseCPU, noise: req.Noise}
+	if sim.stream == "" {
+		sim.stream = defaultSimulationStream
+	}
+	if !streamNamePattern.MatchString(sim.stream) {
+		return nil, fmt.Errorf("invalid stream name %q", sim.stream)
+	}
+	var err error
+	if sim.duration, err = time.ParseDuration(req.Duration); err != nil || sim.duration <= 0 || sim.duration > maxSimulationDuration {
+		return nil, fmt.Errorf("duration must be a positive duration up to %v", maxSimulationDuration)
+	}
+	rate := req.Rate
+	if rate == 0 {
+		rate = defaultSimulationRate
+	}
+	if rate < 0 || rate > maxSimulationRate {
+		return nil, fmt.Errorf("rate must be between 0 and %d samples per second", maxSimulationRate)
+	}
+	sim.interval = time.Duration(float64(time.Second) / rate)
+	if sim.baseRPS < 0 || sim.baseCPU < 0 || sim.noise < 0 {
+		return nil, errors.New("base_rps, base_cpu and noise must not be negative")
+	}
+
+	sim.spikes = make(map[int]float64, len(req.Spikes))
+	for _, spike := range req.Spikes {
+		at, err := time.ParseDuration(spike.At)
+		if err != nil || at < 0 || at >= sim.duration {
+			return nil, fmt.Errorf("spike at %q must be a duration within the run", spike.At)
+		}
+		sim.spikes[int((at+sim.interval-1)/sim.interval)] = spike.RPS
+	}
+
+	seed := req.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	sim.rng = rand.New(rand.NewPCG(seed, seed))
+	return sim, nil
+}
+
+// sample generates the i-th metric of the run.
+func (sim *simulation) sample(i int, ts time.Time) Metric {
+	m := Metric{
+		Timestamp: ts,
+		RPS:       max(0, sim.baseRPS*(1+sim.noise*sim.rng.NormFloat64())),
+		CPU:       max(0, sim.baseCPU*(1+sim.noise*sim.rng.NormFloat64())),
+	}
+	if rps, ok := sim.spikes[i]; ok {
+		m.RPS = rps
+	}
+	return m
+}
+
+// run feeds generated samples through processMetric at the configured
+// rate until the duration is up or ctx is cancelled.
+func (sim *simulation) run(ctx context.Context, logger *slog.Logger) SimulationSummary {
+	summary := SimulationSummary{Stream: sim.stream}
+	ticker := time.NewTicker(sim.interval)
+	defer ticker.Stop()
+	n := max(1, int(sim.duration/sim.interval))
+	for i := 0; i < n; i++ {
+		result, err := processMetric(ctx, logger, sim.stream, sim.sample(i, appState.now().UTC()))
+		summary.Generated++
+		switch {
+		case err != nil:
+			summary.Failed++
+		case result.Status == statusAnomaly:
+			summary.Anomalies++
+		case result.Status == statusWarming:
+			summary.Warming++
+		}
+		if i == n-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			summary.Cancelled = true
+			return summary
+		case <-ticker.C:
+		}
+	}
+	return summary
+}
+
+// handleSimulate generates synthetic metrics and runs them through the
+// normal pipeline, for demos and for checking detector tuning without an
+// external load generator. It responds once the run finishes; the run
+// stops early if the client goes away.
+func handleSimulate(w http.ResponseWriter, r *http.Request) {
+	var req SimulationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+	sim, err := newSimulation(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	logger := loggerFrom(r.Context()).With("stream", sim.stream, "simulation", true)
+	logger.Info("Starting simulation", "duration", sim.duration, "interval", sim.interval)
+	summary := sim.run(r.Context(), logger)
+	logger.Info("Simulation finished", "generated", summary.Generated, "anomalies", summary.Anomalies, "cancelled", summary.Cancelled)
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, summary)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSimulationValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     SimulationRequest
+		wantErr bool
+	}{
+		{name: "defaults", req: SimulationRequest{Duration: "1s", BaseRPS: 100}},
+		{name: "missing duration", req: SimulationRequest{BaseRPS: 100}, wantErr: true},
+		{name: "too long", req: SimulationRequest{Duration: "1h"}, wantErr: true},
+		{name: "bad stream", req: SimulationRequest{Duration: "1s", Stream: "a b"}, wantErr: true},
+		{name: "rate too high", req: SimulationRequest{Duration: "1s", Rate: 5000}, wantErr: true},
+		{name: "negative noise", req: SimulationRequest{Duration: "1s", Noise: -1}, wantErr: true},
+		{name: "spike after end", req: SimulationRequest{Duration: "1s", Spikes: []SimulatedSpike{{At: "2s", RPS: 1}}}, wantErr: true},
+		{name: "spike unparsable", req: SimulationRequest{Duration: "1s", Spikes: []SimulatedSpike{{At: "soon", RPS: 1}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		sim, err := newSimulation(tt.req)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && (sim.stream != defaultSimulationStream || sim.interval != 100*time.Millisecond) {
+			t.Errorf("%s: stream %q interval %v, want defaults", tt.name, sim.stream, sim.interval)
+		}
+	}
+}
+
+func TestHandleSimulate(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.adminToken = "s3cret"
+	appState.detector = &ZScoreDetector{Threshold: 1.5}
+	body := `{"stream": "sim", "duration": "50ms", "rate": 100, "base_rps": 100, "base_cpu": 20,
+		"noise": 0.01, "seed": 7, "spikes": [{"at": "40ms", "rps": 1000}]}`
+	req := httptest.NewRequest(http.MethodPost, "/simulate", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w := httptest.NewRecorder()
+	newMux().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var summary SimulationSummary
+	json.NewDecoder(w.Body).Decode(&summary)
+	want := SimulationSummary{Stream: "sim", Generated: 5, Anomalies: 1, Warming: 1}
+	if summary != want {
+		t.Errorf("summary = %+v, want %+v", summary, want)
+	}
+	if stored, _ := mr.List("metrics:sim"); len(stored) != 5 {
+		t.Errorf("stored %d samples, want 5", len(stored))
+	}
+}
+
+func TestSimulationCancel(t *testing.T) {
+	newTestAppState(t)
+	sim, err := newSimulation(SimulationRequest{Duration: "10m", Rate: 100, BaseRPS: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	summary := sim.run(ctx, slog.Default())
+	if !summary.Cancelled || time.Since(start) > time.Second {
+		t.Errorf("summary = %+v after %v, want a prompt cancellation", summary, time.Since(start))
+	}
+}
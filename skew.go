@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// SkewPolicy says what happens to a metric whose timestamp is further from
+// server time than CLOCK_SKEW_TOLERANCE.
+type SkewPolicy string
+
+const (
+	// SkewReject refuses the metric with 400. It is the default.
+	SkewReject SkewPolicy = "reject"
+	// SkewClamp moves the timestamp to the nearest edge of the tolerance
+	// and logs a warning.
+	SkewClamp SkewPolicy = "clamp"
+)
+
+func parseSkewPolicy(v string) (SkewPolicy, error) {
+	switch SkewPolicy(v) {
+	case "", SkewReject:
+		return SkewReject, nil
+	case SkewClamp:
+		return SkewClamp, nil
+	default:
+		return "", fmt.Errorf("unknown clock skew policy %q (want reject or clamp)", v)
+	}
+}
+
+// checkSkew compares ts with server time; skew is ts minus server time.
+// Within tolerance ts is returned unchanged with ok true. Outside it, the
+// clamp policy returns ts moved into range with ok true and the reject
+// policy returns ok false. A zero tolerance turns the check off.
+func (s *AppState) checkSkew(ts time.Time) (adjusted time.Time, skew time.Duration, ok bool) {
+	now := s.now()
+	skew = ts.Sub(now)
+	if s.skewTolerance <= 0 || (skew <= s.skewTolerance && skew >= -s.skewTolerance) {
+		return ts, skew, true
+	}
+	if s.skewPolicy != SkewClamp {
+		return ts, skew, false
+	}
+	if skew > 0 {
+		return now.Add(s.skewTolerance).UTC(), skew, true
+	}
+	return now.Add(-s.skewTolerance).UTC(), skew, true
+}
+
+// stampMetric converts a metric to canonical units, gives it the arrival
+// time if it was sent without a timestamp and applies the skew policy to
+// the rest, counting and logging what it does. The error is meant for the
+// client.
+func stampMetric(logger *slog.Logger, m Metric) (Metric, error) {
+	m, err := normalizeUnits(m)
+	if err != nil {
+		logger.Warn("Rejected metric with unknown unit", "error", err)
+		return m, err
+	}
+	if m.Timestamp.IsZero() {
+		m.Timestamp = appState.now().UTC()
+	}
+	adjusted, skew, ok := appState.checkSkew(m.Timestamp)
+	if !ok {
+		appState.skewCounter.WithLabelValues("rejected").Inc()
+		logger.Warn("Rejected metric with skewed timestamp", "timestamp", m.Timestamp, "skew", skew)
+		return m, fmt.Errorf("timestamp is %v from server time, more than the allowed %v", skew, appState.skewTolerance)
+	}
+	if !adjusted.Equal(m.Timestamp) {
+		appState.skewCounter.WithLabelValues("clamped").Inc()
+		logger.Warn("Clamped metric with skewed timestamp", "timestamp", m.Timestamp, "skew", skew, "clamped_to", adjusted)
+		m.Timestamp = adjusted
+	}
+	return m, nil
+}
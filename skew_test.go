@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckSkew(t *testing.T) {
+	newTestAppState(t)
+	now := appState.now()
+	tests := []struct {
+		name      string
+		tolerance time.Duration
+		policy    SkewPolicy
+		ts        time.Time
+		want      time.Time
+		wantOK    bool
+	}{
+		{name: "off", ts: now.Add(time.Hour), want: now.Add(time.Hour), wantOK: true},
+		{name: "within", tolerance: time.Minute, policy: SkewReject, ts: now.Add(-time.Minute), want: now.Add(-time.Minute), wantOK: true},
+		{name: "future rejected", tolerance: time.Minute, policy: SkewReject, ts: now.Add(2 * time.Minute), want: now.Add(2 * time.Minute)},
+		{name: "past rejected", tolerance: time.Minute, policy: SkewReject, ts: now.Add(-time.Hour), want: now.Add(-time.Hour)},
+		{name: "future clamped", tolerance: time.Minute, policy: SkewClamp, ts: now.Add(time.Hour), want: now.Add(time.Minute), wantOK: true},
+		{name: "past clamped", tolerance: time.Minute, policy: SkewClamp, ts: now.Add(-time.Hour), want: now.Add(-time.Minute), wantOK: true},
+	}
+	for _, tt := range tests {
+		appState.skewTolerance, appState.skewPolicy = tt.tolerance, tt.policy
+		got, _, ok := appState.checkSkew(tt.ts)
+		if ok != tt.wantOK || !got.Equal(tt.want) {
+			t.Errorf("%s: checkSkew = %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestParseSkewPolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    SkewPolicy
+		wantErr bool
+	}{
+		{in: "", want: SkewReject},
+		{in: "reject", want: SkewReject},
+		{in: "clamp", want: SkewClamp},
+		{in: "drop", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSkewPolicy(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSkewPolicy(%q) = %q, %v; want %q, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAnalyzeClockSkew(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      SkewPolicy
+		timestamp   string
+		wantCode    int
+		wantRejects float64
+		wantClamps  float64
+	}{
+		{name: "in range", policy: SkewReject, timestamp: "2024-01-01T00:04:00Z", wantCode: http.StatusOK},
+		{name: "missing timestamp", policy: SkewReject, wantCode: http.StatusOK},
+		{name: "rejected", policy: SkewReject, timestamp: "2030-01-01T00:00:00Z", wantCode: http.StatusBadRequest, wantRejects: 1},
+		{name: "clamped", policy: SkewClamp, timestamp: "2000-01-01T00:00:00Z", wantCode: http.StatusOK, wantClamps: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestAppState(t)
+			appState.skewTolerance, appState.skewPolicy = 5*time.Minute, tt.policy
+			body := `{"rps": 1}`
+			if tt.timestamp != "" {
+				body = `{"rps": 1, "timestamp": "` + tt.timestamp + `"}`
+			}
+			w := httptest.NewRecorder()
+			newMux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze?sync=true", strings.NewReader(body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if got := counterValue(appState.skewCounter.WithLabelValues("rejected")); got != tt.wantRejects {
+				t.Errorf("rejected = %v, want %v", got, tt.wantRejects)
+			}
+			if got := counterValue(appState.skewCounter.WithLabelValues("clamped")); got != tt.wantClamps {
+				t.Errorf("clamped = %v, want %v", got, tt.wantClamps)
+			}
+			if tt.wantClamps > 0 {
+				stored, _ := mr.List("metrics")
+				if len(stored) != 1 || !strings.Contains(stored[0], "2023-12-31T23:55:00Z") {
+					t.Errorf("stored %v, want timestamp clamped to 2023-12-31T23:55:00Z", stored)
+				}
+			}
+		})
+	}
+}
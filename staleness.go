@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// markSeen records that stream just received a sample and resets its
+// staleness gauge.
+func (s *AppState) markSeen(stream string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastSeen == nil {
+		s.lastSeen = make(map[string]time.Time)
+	}
+	s.lastSeen[stream] = s.now()
+	s.stalenessGauge.WithLabelValues(stream).Set(0)
+}
+
+// updateStaleness sets go_service_seconds_since_last_sample for every stream
+// seen so far.
+func (s *AppState) updateStaleness() {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for stream, seen := range s.lastSeen {
+		s.stalenessGauge.WithLabelValues(stream).Set(now.Sub(seen).Seconds())
+	}
+}
+
+// monitorStaleness refreshes the staleness gauges every interval, so a
+// stream that stops reporting shows a growing value rather than the one
+// from its last sample.
+func monitorStaleness(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			appState.updateStaleness()
+		}
+	}
+}
+
+// restoreSeen records seen as the last sample of stream unless a newer one
+// has arrived since, and sets its staleness gauge from it.
+func (s *AppState) restoreSeen(stream string, seen time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastSeen == nil {
+		s.lastSeen = make(map[string]time.Time)
+	}
+	if last, ok := s.lastSeen[stream]; ok && !last.Before(seen) {
+		return
+	}
+	s.lastSeen[stream] = seen
+	s.stalenessGauge.WithLabelValues(stream).Set(s.now().Sub(seen).Seconds())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStalenessGauge(t *testing.T) {
+	newTestAppState(t)
+	clock := appState.clock.(*fakeClock)
+	mux := newMux()
+	post := func(target, body string) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+		if w.Code >= 300 {
+			t.Fatalf("%s: status %d", target, w.Code)
+		}
+	}
+	staleness := func(stream string) float64 {
+		return gaugeValue(appState.stalenessGauge.WithLabelValues(stream))
+	}
+
+	post("/analyze/web?sync=true", `{"rps": 1}`)
+	post("/batch/analyze", `{"api": {"rps": 1}}`)
+	clock.Advance(30 * time.Second)
+	post("/analyze/web?sync=true", `{"rps": 1}`)
+	clock.Advance(15 * time.Second)
+	appState.updateStaleness()
+
+	tests := []struct {
+		stream string
+		want   float64
+	}{
+		{stream: "web", want: 15},
+		{stream: "api", want: 45},
+	}
+	for _, tt := range tests {
+		if got := staleness(tt.stream); got != tt.want {
+			t.Errorf("%s staleness = %v, want %v", tt.stream, got, tt.want)
+		}
+	}
+}
+
+func TestMonitorStalenessTicks(t *testing.T) {
+	newTestAppState(t)
+	clock := appState.clock.(*fakeClock)
+	appState.markSeen("web")
+	clock.Advance(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go monitorStaleness(ctx, 5*time.Millisecond)
+	for i := 0; i < 100 && gaugeValue(appState.stalenessGauge.WithLabelValues("web")) != 60; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := gaugeValue(appState.stalenessGauge.WithLabelValues("web")); got != 60 {
+		t.Errorf("staleness = %v without new samples, want 60", got)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// envErrors collects invalid settings the lenient getEnv* helpers replaced
+// with their defaults, so STRICT_STARTUP can refuse to start on them.
+var envErrors []error
+
+// invalidEnv records that key held an unusable value and defaultValue is
+// used instead.
+func invalidEnv(key, value string, defaultValue interface{}) {
+	log.Printf("Invalid value for %s (%q), using default %v", key, value, defaultValue)
+	envErrors = append(envErrors, fmt.Errorf("invalid value for %s: %q", key, value))
+}
+
+// startupProblems lists everything STRICT_STARTUP treats as fatal: Redis
+// unreachable after the connection retries, and any invalid setting that
+// was replaced by its default. Without STRICT_STARTUP these only warn.
+func startupProblems(redisConnected bool, redisAddr string) error {
+	problems := append([]error(nil), envErrors...)
+	if !redisConnected {
+		problems = append(problems, fmt.Errorf("could not reach Redis at %s", redisAddr))
+	}
+	return errors.Join(problems...)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartupProblems(t *testing.T) {
+	tests := []struct {
+		name           string
+		env            map[string]string
+		redisConnected bool
+		want           []string
+	}{
+		{name: "clean", redisConnected: true},
+		{name: "redis down", want: []string{"could not reach Redis at redis:6379"}},
+		{
+			name:           "invalid numbers",
+			env:            map[string]string{"TEST_INT": "ten", "TEST_FLOAT": "x", "TEST_DURATION": "-1s"},
+			redisConnected: true,
+			want:           []string{`TEST_INT: "ten"`, `TEST_FLOAT: "x"`, `TEST_DURATION: "-1s"`},
+		},
+		{
+			name: "everything reported",
+			env:  map[string]string{"TEST_INT": "ten"},
+			want: []string{`TEST_INT: "ten"`, "could not reach Redis"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envErrors = nil
+			t.Cleanup(func() { envErrors = nil })
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			// Invalid values still fall back to the defaults.
+			if getEnvInt("TEST_INT", 1) != 1 || getEnvFloat("TEST_FLOAT", 1) != 1 || getEnvDuration("TEST_DURATION", time.Second) != time.Second {
+				t.Fatal("invalid setting did not fall back to its default")
+			}
+
+			err := startupProblems(tt.redisConnected, "redis:6379")
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("startupProblems = %v, want none", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("startupProblems = nil, want %q", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("startupProblems = %q, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
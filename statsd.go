@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// statsdListener takes StatsD packets on UDP (STATSD_ADDR) from apps that
+// cannot POST JSON, and feeds one Metric per stream into the pipeline
+// every flush interval (STATSD_FLUSH_INTERVAL).
+//
+// Metric names are "<stream>.rps" and "<stream>.cpu". Counters ("|c") are
+// summed over the interval, scaled by their sample rate, and turn into a
+// per-second rate; gauges ("|g") keep their last value across intervals
+// and may be adjusted with a leading + or -. A field counted within the
+// interval uses the rate, else its gauge, else 0. Only streams that got a
+// line within the interval are flushed. Timers, sets and other types are
+// ignored.
+type statsdListener struct {
+	conn     net.PacketConn
+	interval time.Duration
+	// lines counts received lines by result, "accepted" or "invalid".
+	lines *prometheus.CounterVec
+
+	mu      sync.Mutex
+	streams map[string]*statsdStream
+	// last is when the current interval started.
+	last time.Time
+}
+
+// statsdStream is the aggregate of one stream.
+type statsdStream struct {
+	counts  map[string]float64
+	gauges  map[string]float64
+	updated bool
+}
+
+// statsdLine is one parsed StatsD line.
+type statsdLine struct {
+	stream, field string
+	value         float64
+	counter       bool
+	// delta marks a gauge given as +n or -n.
+	delta bool
+}
+
+func newStatsdListener(addr string, interval time.Duration, lines *prometheus.CounterVec) (*statsdListener, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdListener{conn: conn, interval: interval, lines: lines, streams: make(map[string]*statsdStream), last: time.Now()}, nil
+}
+
+// parseStatsdLine parses "name:value|type[|@rate][|#tags]".
+func parseStatsdLine(line string) (statsdLine, error) {
+	var l statsdLine
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return l, fmt.Errorf("missing value in %q", line)
+	}
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return l, fmt.Errorf("missing type in %q", line)
+	}
+	// Stream names may hold dots; the field is the last segment.
+	i := strings.LastIndex(name, ".")
+	stream, field := name[:max(i, 0)], name[i+1:]
+	if i < 0 || (field != "rps" && field != "cpu") {
+		return l, fmt.Errorf("metric %q is not <stream>.rps or <stream>.cpu", name)
+	}
+	if !streamNamePattern.MatchString(stream) {
+		return l, fmt.Errorf("invalid stream name %q", stream)
+	}
+	l.stream, l.field = stream, field
+
+	raw := parts[0]
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return l, fmt.Errorf("value %q is not a finite number", raw)
+	}
+	switch parts[1] {
+	case "c":
+		l.counter = true
+		for _, p := range parts[2:] {
+			if rate, ok := strings.CutPrefix(p, "@"); ok {
+				r, err := strconv.ParseFloat(rate, 64)
+				if err != nil || r <= 0 || r > 1 {
+					return l, fmt.Errorf("sample rate %q is not in (0, 1]", rate)
+				}
+				value /= r
+			}
+		}
+	case "g":
+		l.delta = strings.HasPrefix(raw, "+") || strings.HasPrefix(raw, "-")
+	default:
+		return l, fmt.Errorf("unsupported type %q", parts[1])
+	}
+	l.value = value
+	return l, nil
+}
+
+// add folds l into its stream's aggregate.
+func (s *statsdListener) add(l statsdLine) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.streams[l.stream]
+	if st == nil {
+		st = &statsdStream{counts: make(map[string]float64), gauges: make(map[string]float64)}
+		s.streams[l.stream] = st
+	}
+	switch {
+	case l.counter:
+		st.counts[l.field] += l.value
+	case l.delta:
+		st.gauges[l.field] += l.value
+	default:
+		st.gauges[l.field] = l.value
+	}
+	st.updated = true
+}
+
+// drain returns the Metric of every stream updated in the interval ending
+// at now, and starts a new one.
+func (s *statsdListener) drain(now time.Time) map[string]Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elapsed := now.Sub(s.last)
+	s.last = now
+	out := make(map[string]Metric)
+	for name, st := range s.streams {
+		if !st.updated {
+			continue
+		}
+		value := func(field string) float64 {
+			if n, ok := st.counts[field]; ok {
+				return n / elapsed.Seconds()
+			}
+			return st.gauges[field]
+		}
+		out[name] = Metric{CPU: value("cpu"), RPS: value("rps")}
+		clear(st.counts)
+		st.updated = false
+	}
+	return out
+}
+
+// flush runs the aggregated samples through the pipeline.
+func (s *statsdListener) flush(ctx context.Context, now time.Time) {
+	for stream, m := range s.drain(now) {
+		logger := slog.Default().With("transport", "statsd", "stream", stream)
+		m, err := stampMetric(logger, m)
+		if err != nil {
+			continue
+		}
+		if err := recordIntake(ctx, logger, stream, m); err != nil {
+			continue
+		}
+		processMetric(ctx, logger, stream, m)
+	}
+}
+
+// run reads packets and flushes every interval until ctx is cancelled,
+// then flushes what it has left.
+func (s *statsdListener) run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		s.conn.Close()
+	}()
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.flush(ctx, now)
+			}
+		}
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := s.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			break
+		}
+		if err != nil {
+			slog.Warn("StatsD read error", "error", err)
+			continue
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			l, err := parseStatsdLine(line)
+			if err != nil {
+				logSampled(slog.Default(), "Invalid StatsD line", "error", err)
+				s.lines.WithLabelValues("invalid").Inc()
+				continue
+			}
+			s.add(l)
+			s.lines.WithLabelValues("accepted").Inc()
+		}
+	}
+	// The pipeline's own context is gone by now.
+	s.flush(context.Background(), time.Now())
+}
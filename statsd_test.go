@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseStatsdLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    statsdLine
+		wantErr bool
+	}{
+		{line: "web.rps:1|c", want: statsdLine{stream: "web", field: "rps", value: 1, counter: true}},
+		{line: "web.rps:1|c|@0.25", want: statsdLine{stream: "web", field: "rps", value: 4, counter: true}},
+		{line: "eu.web.cpu:42.5|g|#env:prod", want: statsdLine{stream: "eu.web", field: "cpu", value: 42.5}},
+		{line: "web.cpu:-5|g", want: statsdLine{stream: "web", field: "cpu", value: -5, delta: true}},
+		{line: "web.rps:1|c|@0", wantErr: true},
+		{line: "web.rps:12|ms", wantErr: true},
+		{line: "web.latency:1|c", wantErr: true},
+		{line: "rps:1|c", wantErr: true},
+		{line: "web.rps:abc|c", wantErr: true},
+		{line: "web.rps:NaN|g", wantErr: true},
+		{line: "web.rps", wantErr: true},
+		{line: "web.rps:1", wantErr: true},
+		{line: "bad name.rps:1|c", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseStatsdLine(tt.line)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("parseStatsdLine(%q) = %+v, %v; want %+v, error %v", tt.line, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestStatsdAggregation(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &statsdListener{streams: make(map[string]*statsdStream), last: start}
+	for _, line := range []string{"web.rps:10|c", "web.rps:5|c|@0.5", "web.cpu:40|g", "web.cpu:+10|g", "api.cpu:5|g"} {
+		l, err := parseStatsdLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.add(l)
+	}
+
+	got := s.drain(start.Add(10 * time.Second))
+	if want := (Metric{RPS: 2, CPU: 50}); got["web"] != want {
+		t.Errorf("web = %+v, want %+v", got["web"], want)
+	}
+	if want := (Metric{CPU: 5}); got["api"] != want {
+		t.Errorf("api = %+v, want %+v", got["api"], want)
+	}
+
+	// Gauges carry over; counters start from zero; idle streams are skipped.
+	l, _ := parseStatsdLine("web.rps:7|g")
+	s.add(l)
+	got = s.drain(start.Add(20 * time.Second))
+	if want := (Metric{RPS: 7, CPU: 50}); len(got) != 1 || got["web"] != want {
+		t.Errorf("second interval = %+v, want only web %+v", got, want)
+	}
+}
+
+func TestStatsdListener(t *testing.T) {
+	mr := newTestAppState(t)
+	lines := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "statsd_lines"}, []string{"result"})
+	s, err := newStatsdListener("127.0.0.1:0", time.Hour, lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx)
+	}()
+
+	conn, err := net.Dial("udp", s.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("web.rps:1|c\nweb.cpu:30|g\nweb.bogus:1|c\n")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for counterValue(lines.WithLabelValues("invalid")) < 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Shutting down flushes the open interval.
+	cancel()
+	<-done
+
+	if got := counterValue(lines.WithLabelValues("accepted")); got != 2 {
+		t.Errorf("accepted lines = %v, want 2", got)
+	}
+	if stored, _ := mr.List("metrics:web"); len(stored) != 1 {
+		t.Errorf("stored %d samples, want 1", len(stored))
+	}
+}
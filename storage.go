@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// StorageBackend selects the Redis data type raw samples are kept in
+// (STORAGE_BACKEND).
+type StorageBackend string
+
+const (
+	// BackendList keeps each stream in a list (RPUSH/LTRIM/LRANGE). It is
+	// the default.
+	BackendList StorageBackend = "list"
+	// BackendStream keeps each stream in a Redis stream (XADD/XREVRANGE),
+	// which other services can read through consumer groups.
+	BackendStream StorageBackend = "stream"
+)
+
+func parseStorageBackend(v string) (StorageBackend, error) {
+	switch StorageBackend(v) {
+	case "", BackendList:
+		return BackendList, nil
+	case BackendStream:
+		return BackendStream, nil
+	default:
+		return "", fmt.Errorf("unknown storage backend %q (want list or stream)", v)
+	}
+}
+
+func newMetricStore(backend StorageBackend) MetricStore {
+	if backend == BackendStream {
+		return redisStreamStore{}
+	}
+	return listStore{}
+}
+
+// MetricStore keeps the raw samples of each stream under metricsKey.
+// Samples go in and come out encoded (see encodeMetric), oldest first, and
+// each stream holds at most RAW_RETENTION of them.
+//
+// Append and Recent queue their commands on c, so several streams can share
+// one pipeline; on a plain client they run immediately.
+type MetricStore interface {
+	// Append adds samples to stream and trims it to RAW_RETENTION.
+	Append(ctx context.Context, c redis.Cmdable, stream string, samples ...interface{})
+	// Recent reads the newest n samples of stream. The returned function
+	// yields them once c has run.
+	Recent(ctx context.Context, c redis.Cmdable, stream string, n int) func() ([]string, error)
+	// AppendAndRead appends one sample and returns the live window, as
+	// atomically as the backend allows.
+	AppendAndRead(ctx context.Context, stream string, data []byte) ([]string, error)
+	// Compact replaces samples older than the live window with bucket
+	// summaries in the compacted list; see compactStream.
+	Compact(ctx context.Context, stream string, bucketSize int) (compacted, buckets, skipped int, err error)
+}
+
+// recentSamples reads the newest n samples of stream right away.
+func recentSamples(ctx context.Context, stream string, n int) ([]string, error) {
+	return appState.store.Recent(ctx, appState.redisClient, stream, n)()
+}
+
+// listStore is the list backend.
+type listStore struct{}
+
+func (listStore) Append(ctx context.Context, c redis.Cmdable, stream string, samples ...interface{}) {
+	key := appState.metricsKey(stream)
+	c.RPush(ctx, key, samples...)
+	c.LTrim(ctx, key, -int64(appState.rawRetention), -1)
+}
+
+func (listStore) Recent(ctx context.Context, c redis.Cmdable, stream string, n int) func() ([]string, error) {
+	cmd := c.LRange(ctx, appState.metricsKey(stream), -int64(n), -1)
+	return cmd.Result
+}
+
+func (listStore) AppendAndRead(ctx context.Context, stream string, data []byte) ([]string, error) {
+	return pushAndReadWindow(ctx, appState.metricsKey(stream), data)
+}
+
+func (listStore) Compact(ctx context.Context, stream string, bucketSize int) (int, int, int, error) {
+	return compactList(ctx, stream, bucketSize)
+}
+
+// pushTrimRangeScript appends a sample, trims the list to the retention and
+// returns the live window in one atomic server-side call, so concurrent
+// writers to the same stream can never observe a half-updated window.
+//
+// KEYS[1] raw list. ARGV[1] sample, ARGV[2] retention, ARGV[3] window size.
+var pushTrimRangeScript = redis.NewScript(`
+redis.call('RPUSH', KEYS[1], ARGV[1])
+redis.call('LTRIM', KEYS[1], -tonumber(ARGV[2]), -1)
+return redis.call('LRANGE', KEYS[1], -tonumber(ARGV[3]), -1)
+`)
+
+// pushAndReadWindow stores data on the stream list at key and returns the
+// current window. It uses pushTrimRangeScript (EVALSHA, loading the script
+// on first use) and falls back to a MULTI/EXEC transaction when the server
+// has scripting disabled.
+func pushAndReadWindow(ctx context.Context, key string, data []byte) ([]string, error) {
+	retention, window := appState.rawRetention, appState.maxWindow()
+
+	if !appState.scriptingDisabled.Load() {
+		items, err := pushTrimRangeScript.Run(ctx, appState.redisClient, []string{key}, data, retention, window).StringSlice()
+		if err == nil || !isScriptingUnavailable(err) {
+			return items, err
+		}
+		log.Printf("Redis scripting unavailable, falling back to MULTI/EXEC: %v", err)
+		appState.scriptingDisabled.Store(true)
+	}
+	return pushAndReadWindowPipelined(ctx, key, data, retention, window)
+}
+
+// pushAndReadWindowPipelined is the fallback for servers without scripting.
+// The commands go out as one MULTI/EXEC transaction, so it takes a single
+// round-trip and stays atomic: no concurrent writer can interleave.
+func pushAndReadWindowPipelined(ctx context.Context, key string, data []byte, retention, window int) ([]string, error) {
+	var lrange *redis.StringSliceCmd
+	_, err := appState.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, data)
+		pipe.LTrim(ctx, key, -int64(retention), -1)
+		lrange = pipe.LRange(ctx, key, -int64(window), -1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lrange.Val(), nil
+}
+
+// isScriptingUnavailable reports whether err means the server refuses to run
+// Lua scripts at all, as opposed to the script itself failing.
+func isScriptingUnavailable(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "unknown command") ||
+		strings.Contains(msg, "noperm") ||
+		strings.Contains(msg, "scripting is disabled")
+}
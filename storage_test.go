@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newStorageTestState(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	appState = &AppState{
+		redisClient:  redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		windowSize:   3,
+		rawRetention: 5,
+	}
+	return mr
+}
+
+func TestPushAndReadWindow(t *testing.T) {
+	for _, pipelined := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pipelined=%v", pipelined), func(t *testing.T) {
+			mr := newStorageTestState(t)
+			appState.scriptingDisabled.Store(pipelined)
+
+			var window []string
+			for i := 1; i <= 7; i++ {
+				var err error
+				window, err = pushAndReadWindow(context.Background(), "metrics", []byte(fmt.Sprint(i)))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if fmt.Sprint(window) != "[5 6 7]" {
+				t.Errorf("window = %v, want [5 6 7]", window)
+			}
+			if raw, _ := mr.List("metrics"); fmt.Sprint(raw) != "[3 4 5 6 7]" {
+				t.Errorf("stored list = %v, want [3 4 5 6 7]", raw)
+			}
+		})
+	}
+}
+
+func TestIsScriptingUnavailable(t *testing.T) {
+	tests := []struct {
+		err  string
+		want bool
+	}{
+		{"ERR unknown command 'evalsha', with args beginning with:", true},
+		{"NOPERM this user has no permissions to run the 'evalsha' command", true},
+		{"ERR scripting is disabled in this instance", true},
+		{"ERR Error running script: attempt to compare nil with number", false},
+		{"dial tcp: connection refused", false},
+	}
+	for _, tt := range tests {
+		if got := isScriptingUnavailable(errors.New(tt.err)); got != tt.want {
+			t.Errorf("isScriptingUnavailable(%q) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// streamRegistry builds a throwaway registry holding the current window
+// stats of one stream, each labelled with stream. It is populated on every
+// scrape so per-stream series never leak into the global registry.
+func streamRegistry(stream string, window []Metric) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	labels := prometheus.Labels{"stream": stream}
+	gauge := func(name, help string, v float64) {
+		opts := gaugeOpts(name, help)
+		opts.ConstLabels = labels
+		g := prometheus.NewGauge(opts)
+		g.Set(v)
+		reg.MustRegister(g)
+	}
+
+	var rpsValues []float64
+	for _, m := range window {
+		rpsValues = append(rpsValues, m.RPS)
+	}
+	rpsValues = lastN(rpsValues, appState.windowFor("rps"))
+	mean := calculateAverage(rpsValues)
+
+	gauge("stream_samples", "Samples in the RPS window of the stream", float64(len(rpsValues)))
+	gauge("stream_rps_rolling_avg", "Rolling average of RPS values in the stream",
+		appState.round(mean))
+	gauge("stream_rps_stddev", "Standard deviation of RPS values in the stream",
+		appState.round(calculateStandardDeviation(rpsValues, mean, appState.stdDevMethod)))
+
+	// The anomaly state is that of the newest sample, and is only known once
+	// the window is warm.
+	if len(rpsValues) >= appState.minSamples && len(rpsValues) > 0 {
+		score, anomalous := appState.currentDetector().Detect(rpsValues, rpsValues[len(rpsValues)-1])
+		state := 0.0
+		if anomalous {
+			state = 1
+		}
+		gauge("stream_anomaly", "Whether the newest sample of the stream is anomalous (1) or not (0)", state)
+		gauge("stream_anomaly_score", "Detector score of the newest sample of the stream", appState.round(score))
+	}
+	return reg
+}
+
+// handleStreamMetrics serves the window stats of one stream in the
+// Prometheus text format, for federating streams independently. Streams
+// with no stored samples are 404.
+func handleStreamMetrics(w http.ResponseWriter, r *http.Request) {
+	stream, err := streamFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	items, err := recentSamples(ctx, stream, appState.maxWindow())
+	if err != nil {
+		loggerFrom(ctx).Error("Redis LRANGE error", "stream", stream, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Error reading window")
+		return
+	}
+	if len(items) == 0 {
+		writeError(w, http.StatusNotFound, "not_found", "unknown stream "+stream)
+		return
+	}
+	window := decodeWindow(items)
+
+	promhttp.HandlerFor(streamRegistry(stream, window), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleStreamMetrics(t *testing.T) {
+	mr := newTestAppState(t)
+	pushSamples(t, mr, "metrics:web", 10, 10, 10, 10, 50)
+	pushSamples(t, mr, "metrics:cold", 10)
+	tests := []struct {
+		target   string
+		wantCode int
+		want     []string
+		notWant  []string
+	}{
+		{target: "/metrics/stream/web", wantCode: http.StatusOK, want: []string{
+			`go_service_stream_samples{stream="web"} 5`,
+			`go_service_stream_rps_rolling_avg{stream="web"} 18`,
+			`go_service_stream_rps_stddev{stream="web"} 17.8885`,
+			`go_service_stream_anomaly{stream="web"} 0`,
+			`go_service_stream_anomaly_score{stream="web"} 1.7889`,
+		}},
+		// Below MIN_SAMPLES there is no anomaly state yet.
+		{target: "/metrics/stream/cold", wantCode: http.StatusOK,
+			want:    []string{`go_service_stream_samples{stream="cold"} 1`},
+			notWant: []string{"go_service_stream_anomaly"}},
+		{target: "/metrics/stream/missing", wantCode: http.StatusNotFound},
+		{target: "/metrics/stream/bad%20name", wantCode: http.StatusBadRequest},
+	}
+	mux := newMux()
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			body := w.Body.String()
+			for _, line := range tt.want {
+				if !strings.Contains(body, line) {
+					t.Errorf("missing %q in:\n%s", line, body)
+				}
+			}
+			for _, name := range tt.notWant {
+				if strings.Contains(body, name) {
+					t.Errorf("unexpected %q in:\n%s", name, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+const defaultStream = "default"
+
+var streamNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// streamFromRequest returns the stream named by the {stream} path segment.
+// Routes without one still honour the legacy "stream" query parameter, and
+// fall back to the default stream.
+func streamFromRequest(r *http.Request) (string, error) {
+	stream := r.PathValue("stream")
+	if stream == "" {
+		stream = r.URL.Query().Get("stream")
+	}
+	if stream == "" {
+		return defaultStream, nil
+	}
+	if !streamNamePattern.MatchString(stream) {
+		return "", fmt.Errorf("invalid stream name %q", stream)
+	}
+	return stream, nil
+}
+
+// metricsKey holds the raw samples of a stream. The default stream keeps
+// the original "metrics" key so existing data stays readable.
+func (s *AppState) metricsKey(stream string) string {
+	if stream == defaultStream {
+		return s.key("metrics")
+	}
+	return s.key("metrics:" + stream)
+}
+
+// compactedKey is the list of downsampled summary points for a stream.
+func (s *AppState) compactedKey(stream string) string {
+	if stream == defaultStream {
+		return s.key("metrics_compacted")
+	}
+	return s.key("metrics_compacted:" + stream)
+}
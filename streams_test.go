@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamFromRequest(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{query: "", want: defaultStream},
+		{query: "stream=web-1", want: "web-1"},
+		{query: "stream=api.v2_eu", want: "api.v2_eu"},
+		{query: "stream=bad%20name", wantErr: true},
+		{query: "stream=a:b", wantErr: true},
+		{query: "stream=" + strings.Repeat("a", 65), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := streamFromRequest(httptest.NewRequest("GET", "/?"+tt.query, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("stream = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamFromPath(t *testing.T) {
+	mux := http.NewServeMux()
+	var got string
+	var gotErr error
+	mux.HandleFunc("/s/{stream}", func(w http.ResponseWriter, r *http.Request) {
+		got, gotErr = streamFromRequest(r)
+	})
+	tests := []struct {
+		target  string
+		want    string
+		wantErr bool
+	}{
+		{target: "/s/web", want: "web"},
+		{target: "/s/web?stream=api", want: "web"},
+		{target: "/s/bad%20name", wantErr: true},
+	}
+	for _, tt := range tests {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.target, nil))
+		if (gotErr != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: stream = (%q, %v), want (%q, wantErr %v)", tt.target, got, gotErr, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestStreamKeys(t *testing.T) {
+	tests := []struct {
+		prefix, stream        string
+		wantMetrics, wantComp string
+	}{
+		{"", defaultStream, "metrics", "metrics_compacted"},
+		{"", "web", "metrics:web", "metrics_compacted:web"},
+		{"app1:", defaultStream, "app1:metrics", "app1:metrics_compacted"},
+		{"app1:", "web", "app1:metrics:web", "app1:metrics_compacted:web"},
+	}
+	for _, tt := range tests {
+		s := &AppState{keyPrefix: tt.prefix}
+		if got := s.metricsKey(tt.stream); got != tt.wantMetrics {
+			t.Errorf("metricsKey(%q) with prefix %q = %q, want %q", tt.stream, tt.prefix, got, tt.wantMetrics)
+		}
+		if got := s.compactedKey(tt.stream); got != tt.wantComp {
+			t.Errorf("compactedKey(%q) with prefix %q = %q, want %q", tt.stream, tt.prefix, got, tt.wantComp)
+		}
+	}
+}
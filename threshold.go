@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// staticLimitsFromEnv reads the absolute limits <SERIES>_MAX (CPU_MAX,
+// RPS_MAX) for every known series. Series without one have no limit.
+func staticLimitsFromEnv() (map[string]float64, error) {
+	limits := make(map[string]float64)
+	for _, series := range knownSeries {
+		key := strings.ToUpper(series) + "_MAX"
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		limit, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number, got %q", key, v)
+		}
+		limits[series] = limit
+	}
+	return limits, nil
+}
+
+// checkStaticLimits returns the series of m above their static limit,
+// counting and logging each breach. It runs on every sample, warm window
+// or not, since a hard limit needs no baseline.
+func checkStaticLimits(logger *slog.Logger, m Metric) []string {
+	var breached []string
+	for _, series := range knownSeries {
+		limit, ok := appState.staticLimits[series]
+		if !ok {
+			continue
+		}
+		if v := seriesValue(m, series); v > limit {
+			breached = append(breached, series)
+			appState.thresholdBreachCounter.WithLabelValues(series).Inc()
+			logger.Warn("THRESHOLD BREACHED!", "metric", series, "value", v, "limit", limit)
+		}
+	}
+	return breached
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStaticLimitsFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{name: "unset", want: map[string]float64{}},
+		{name: "cpu only", env: map[string]string{"CPU_MAX": "90"}, want: map[string]float64{"cpu": 90}},
+		{name: "both", env: map[string]string{"CPU_MAX": "90", "RPS_MAX": "1e4"}, want: map[string]float64{"cpu": 90, "rps": 10000}},
+		{name: "invalid", env: map[string]string{"RPS_MAX": "lots"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CPU_MAX", "")
+			t.Setenv("RPS_MAX", "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			got, err := staticLimitsFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(got) != len(tt.want) {
+				t.Errorf("limits = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("limits[%s] = %v, want %v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckStaticLimits(t *testing.T) {
+	newTestAppState(t)
+	appState.staticLimits = map[string]float64{"cpu": 90, "rps": 1000}
+	tests := []struct {
+		m    Metric
+		want []string
+	}{
+		{m: Metric{CPU: 50, RPS: 100}},
+		{m: Metric{CPU: 90, RPS: 1000}},
+		{m: Metric{CPU: 95, RPS: 100}, want: []string{"cpu"}},
+		{m: Metric{CPU: 95, RPS: 5000}, want: []string{"rps", "cpu"}},
+	}
+	for _, tt := range tests {
+		if got := checkStaticLimits(slog.Default(), tt.m); !slices.Equal(got, tt.want) {
+			t.Errorf("checkStaticLimits(%+v) = %v, want %v", tt.m, got, tt.want)
+		}
+	}
+	if got := counterValue(appState.thresholdBreachCounter.WithLabelValues("cpu")); got != 2 {
+		t.Errorf("cpu breaches = %v, want 2", got)
+	}
+	if got := counterValue(appState.thresholdBreachCounter.WithLabelValues("rps")); got != 1 {
+		t.Errorf("rps breaches = %v, want 1", got)
+	}
+}
+
+// Static limits fire even while the window is too small for statistics.
+func TestStaticLimitDuringWarmup(t *testing.T) {
+	newTestAppState(t)
+	appState.minSamples = 5
+	appState.staticLimits = map[string]float64{"cpu": 90}
+	w := httptest.NewRecorder()
+	newMux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze?sync=true", strings.NewReader(`{"cpu": 99, "rps": 1}`)))
+	var result AnalysisResult
+	json.NewDecoder(w.Body).Decode(&result)
+	if result.Status != statusWarming || !slices.Equal(result.Breaches, []string{"cpu"}) {
+		t.Errorf("result = %+v, want warming with a cpu breach", result)
+	}
+}
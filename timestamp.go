@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Epoch numbers below maxEpochSeconds are read as seconds and those below
+// maxEpochMillis as milliseconds. 1e11 seconds is in the year 5138, while
+// 1e11 milliseconds is only March 1973, so the ranges do not overlap for
+// any timestamp a client will plausibly send.
+const (
+	maxEpochSeconds = 1e11
+	maxEpochMillis  = 1e14
+)
+
+// parseTimestampJSON decodes a JSON timestamp: an RFC3339 string, or a
+// number of Unix epoch seconds or milliseconds, possibly fractional. null
+// is the zero time. Numbers are returned in UTC.
+func parseTimestampJSON(data []byte) (time.Time, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || string(data) == "null" {
+		return time.Time{}, nil
+	}
+	if data[0] == '"' {
+		var ts time.Time
+		if err := json.Unmarshal(data, &ts); err != nil {
+			return time.Time{}, fmt.Errorf("timestamp must be RFC3339: %w", err)
+		}
+		return ts, nil
+	}
+
+	var epoch float64
+	if err := json.Unmarshal(data, &epoch); err != nil {
+		return time.Time{}, fmt.Errorf("timestamp must be an RFC3339 string or epoch seconds or milliseconds, got %s", data)
+	}
+	// Split off the fraction first; scaling the whole value to nanoseconds
+	// would lose precision.
+	whole, frac := math.Modf(epoch)
+	switch abs := math.Abs(epoch); {
+	case abs < maxEpochSeconds:
+		return time.Unix(int64(whole), int64(math.Round(frac*1e9))).UTC(), nil
+	case abs < maxEpochMillis:
+		return time.UnixMilli(int64(whole)).Add(time.Duration(math.Round(frac * 1e6))).UTC(), nil
+	default:
+		return time.Time{}, fmt.Errorf("timestamp %s is out of range for epoch seconds or milliseconds", data)
+	}
+}
+
+// UnmarshalJSON accepts the timestamp in any form parseTimestampJSON does.
+// Metrics are still marshalled with RFC3339 timestamps.
+func (m *Metric) UnmarshalJSON(data []byte) error {
+	type plain Metric
+	var aux struct {
+		plain
+		Timestamp json.RawMessage `json:"timestamp"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	ts, err := parseTimestampJSON(aux.Timestamp)
+	if err != nil {
+		return err
+	}
+	*m = Metric(aux.plain)
+	m.Timestamp = ts
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseTimestampJSON(t *testing.T) {
+	want := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "rfc3339", in: `"2024-01-01T12:00:00Z"`, want: want},
+		{name: "rfc3339 offset", in: `"2024-01-01T13:00:00+01:00"`, want: want},
+		{name: "epoch seconds", in: `1704110400`, want: want},
+		{name: "fractional seconds", in: `1704110400.25`, want: want.Add(250 * time.Millisecond)},
+		{name: "epoch millis", in: `1704110400000`, want: want},
+		{name: "fractional millis", in: `1704110400000.5`, want: want.Add(500 * time.Microsecond)},
+		{name: "null", in: `null`},
+		{name: "epoch micros", in: `1704110400000000`, wantErr: true},
+		{name: "not a date", in: `"yesterday"`, wantErr: true},
+		{name: "numeric string", in: `"1704110400"`, wantErr: true},
+		{name: "bool", in: `true`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimestampJSON([]byte(tt.in))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: parseTimestampJSON(%s) = %v, want %v", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMetricUnmarshalJSON(t *testing.T) {
+	want := Metric{Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), CPU: 1.5, RPS: 15}
+	for _, body := range []string{
+		`{"timestamp": "2024-01-01T12:00:00Z", "cpu": 1.5, "rps": 15}`,
+		`{"timestamp": 1704110400, "cpu": 1.5, "rps": 15}`,
+		`{"timestamp": 1704110400000, "cpu": 1.5, "rps": 15}`,
+	} {
+		var got Metric
+		if err := json.Unmarshal([]byte(body), &got); err != nil {
+			t.Errorf("%s: %v", body, err)
+			continue
+		}
+		if !got.Timestamp.Equal(want.Timestamp) || got.CPU != want.CPU || got.RPS != want.RPS {
+			t.Errorf("%s: got %+v, want %+v", body, got, want)
+		}
+	}
+
+	var m Metric
+	if err := json.Unmarshal([]byte(`{"timestamp": 1e20, "rps": 1}`), &m); err == nil {
+		t.Error("out of range timestamp accepted")
+	}
+
+	// Marshalling still writes RFC3339, and round trips.
+	data, _ := json.Marshal(want)
+	var back Metric
+	if err := json.Unmarshal(data, &back); err != nil || back != want {
+		t.Errorf("round trip of %s = %+v, %v", data, back, err)
+	}
+}
+
+func TestDecodeIngestedEpochTimestamp(t *testing.T) {
+	m, err := decodeIngested(json.RawMessage(`{"ts": 1704110400000, "rps": 1}`), map[string]string{"timestamp": "ts"})
+	if err != nil || !m.Timestamp.Equal(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("decodeIngested = %+v, %v", m, err)
+	}
+}
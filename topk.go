@@ -0,0 +1,75 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTopK = 5
+
+type topKSample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// topK returns the n highest values of series in window, highest first.
+// Ties keep window order, so the earlier sample wins.
+func topK(window []Metric, series string, n int) []topKSample {
+	samples := make([]topKSample, len(window))
+	for i, m := range window {
+		samples[i] = topKSample{Timestamp: m.Timestamp, Value: seriesValue(m, series)}
+	}
+	slices.SortStableFunc(samples, func(a, b topKSample) int {
+		return cmp.Compare(b.Value, a.Value)
+	})
+	return samples[:min(n, len(samples))]
+}
+
+// handleTopK returns the highest samples of one series in the current
+// window of a stream. n is clamped to that series' window size.
+func handleTopK(w http.ResponseWriter, r *http.Request) {
+	stream, err := streamFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	series := r.URL.Query().Get("metric")
+	if series == "" {
+		series = "rps"
+	}
+	if !isKnownSeries(series) {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("unknown metric %q, want one of %s", series, strings.Join(knownSeries, ", ")))
+		return
+	}
+	n := defaultTopK
+	if v := r.URL.Query().Get("n"); v != "" {
+		n, err = strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request", "n must be a positive integer")
+			return
+		}
+	}
+	size := appState.windowFor(series)
+	n = min(n, size)
+
+	ctx := r.Context()
+	items, err := recentSamples(ctx, stream, size)
+	if err != nil {
+		loggerFrom(ctx).Error("Redis LRANGE error", "stream", stream, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Error reading window")
+		return
+	}
+	window := decodeWindow(items)
+
+	writeBody(w, r, http.StatusOK, map[string]interface{}{
+		"stream":  stream,
+		"metric":  series,
+		"n":       n,
+		"samples": topK(window, series, n),
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTopK(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := []Metric{
+		{Timestamp: base, RPS: 5, CPU: 50},
+		{Timestamp: base.Add(time.Second), RPS: 9, CPU: 10},
+		{Timestamp: base.Add(2 * time.Second), RPS: 5, CPU: 90},
+		{Timestamp: base.Add(3 * time.Second), RPS: 1, CPU: 20},
+	}
+	tests := []struct {
+		name       string
+		series     string
+		n          int
+		wantValues []float64
+		wantFirst  time.Time
+	}{
+		{name: "rps top 2", series: "rps", n: 2, wantValues: []float64{9, 5}, wantFirst: base.Add(time.Second)},
+		{name: "ties keep order", series: "rps", n: 3, wantValues: []float64{9, 5, 5}},
+		{name: "cpu", series: "cpu", n: 1, wantValues: []float64{90}, wantFirst: base.Add(2 * time.Second)},
+		{name: "n beyond window", series: "cpu", n: 10, wantValues: []float64{90, 50, 20, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := topK(window, tt.series, tt.n)
+			var values []float64
+			for _, s := range got {
+				values = append(values, s.Value)
+			}
+			if !equalFloats(values, tt.wantValues) {
+				t.Errorf("values = %v, want %v", values, tt.wantValues)
+			}
+			if !tt.wantFirst.IsZero() && !got[0].Timestamp.Equal(tt.wantFirst) {
+				t.Errorf("first timestamp = %v, want %v", got[0].Timestamp, tt.wantFirst)
+			}
+		})
+	}
+	if got := topK(window, "rps", 3); !got[1].Timestamp.Equal(base) {
+		t.Errorf("tie order: second sample = %v, want the earlier one", got[1].Timestamp)
+	}
+}
+
+func TestHandleTopK(t *testing.T) {
+	mr := newTestAppState(t)
+	pushSamples(t, mr, "metrics:web", 100, 1, 2, 3, 4, 5, 6)
+	mux := newMux()
+	tests := []struct {
+		target     string
+		wantCode   int
+		wantN      int
+		wantValues []float64
+	}{
+		// The window is the last 5 samples, so 100 has already left it.
+		{target: "/topk/web", wantCode: http.StatusOK, wantN: 5, wantValues: []float64{6, 5, 4, 3, 2}},
+		{target: "/topk?stream=web&n=2", wantCode: http.StatusOK, wantN: 2, wantValues: []float64{6, 5}},
+		{target: "/topk/web?metric=cpu&n=1", wantCode: http.StatusOK, wantN: 1, wantValues: []float64{0.6}},
+		{target: "/topk/web?n=50", wantCode: http.StatusOK, wantN: 5, wantValues: []float64{6, 5, 4, 3, 2}},
+		{target: "/topk/web?metric=memory", wantCode: http.StatusBadRequest},
+		{target: "/topk/web?n=0", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var resp struct {
+				N       int          `json:"n"`
+				Samples []topKSample `json:"samples"`
+			}
+			json.NewDecoder(w.Body).Decode(&resp)
+			var values []float64
+			for _, s := range resp.Samples {
+				values = append(values, s.Value)
+			}
+			if resp.N != tt.wantN || !equalFloats(values, tt.wantValues) {
+				t.Errorf("got n=%d values %v, want n=%d values %v", resp.N, values, tt.wantN, tt.wantValues)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Canonical units: RPS is requests per second and CPU is a percentage of
+// one core, 0-100. A metric may say it was reported in another unit with
+// rps_unit or cpu_unit; normalizeUnits converts it before storage and
+// detection, so every stored sample and every window is canonical.
+
+// rpsUnits are the rps_unit values, as the factor to requests per second.
+var rpsUnits = map[string]float64{
+	"per_second": 1,
+	"per_minute": 1.0 / 60,
+	"per_hour":   1.0 / 3600,
+}
+
+// cpuUnits are the cpu_unit values, as the factor to a percentage.
+var cpuUnits = map[string]float64{
+	"percent":  1,
+	"fraction": 100,
+}
+
+// normalizeUnits converts m to the canonical units and clears its unit
+// hints. A missing hint means the value is already canonical; an unknown
+// one is an error for the client, since guessing would silently skew the
+// stream.
+func normalizeUnits(m Metric) (Metric, error) {
+	if m.RPSUnit != "" {
+		factor, ok := rpsUnits[m.RPSUnit]
+		if !ok {
+			return m, fmt.Errorf("unknown rps_unit %q, want one of %s", m.RPSUnit, unitNames(rpsUnits))
+		}
+		m.RPS *= factor
+	}
+	if m.CPUUnit != "" {
+		factor, ok := cpuUnits[m.CPUUnit]
+		if !ok {
+			return m, fmt.Errorf("unknown cpu_unit %q, want one of %s", m.CPUUnit, unitNames(cpuUnits))
+		}
+		m.CPU *= factor
+	}
+	m.RPSUnit, m.CPUUnit = "", ""
+	return m, nil
+}
+
+func unitNames(units map[string]float64) string {
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeUnits(t *testing.T) {
+	tests := []struct {
+		name             string
+		in               Metric
+		wantRPS, wantCPU float64
+		wantErr          bool
+	}{
+		{name: "no hints", in: Metric{RPS: 10, CPU: 50}, wantRPS: 10, wantCPU: 50},
+		{name: "per second", in: Metric{RPS: 10, RPSUnit: "per_second"}, wantRPS: 10},
+		{name: "per minute", in: Metric{RPS: 600, RPSUnit: "per_minute"}, wantRPS: 10},
+		{name: "per hour", in: Metric{RPS: 36000, RPSUnit: "per_hour"}, wantRPS: 10},
+		{name: "cpu fraction", in: Metric{CPU: 0.25, CPUUnit: "fraction"}, wantCPU: 25},
+		{name: "cpu percent", in: Metric{CPU: 25, CPUUnit: "percent"}, wantCPU: 25},
+		{name: "unknown rps unit", in: Metric{RPS: 1, RPSUnit: "per_fortnight"}, wantErr: true},
+		{name: "unknown cpu unit", in: Metric{CPU: 1, CPUUnit: "millicores"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeUnits(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (got.RPS != tt.wantRPS || got.CPU != tt.wantCPU || got.RPSUnit != "" || got.CPUUnit != "") {
+			t.Errorf("%s: got %+v, want rps %v cpu %v with hints cleared", tt.name, got, tt.wantRPS, tt.wantCPU)
+		}
+	}
+}
+
+// A per-minute reporter in a per-second stream must not look like a 60x
+// spike, and stored samples carry no unit hints.
+func TestAnalyzeNormalizesUnits(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.detector = &ZScoreDetector{Threshold: 1.5}
+	mux := newMux()
+	var result AnalysisResult
+	for _, body := range []string{
+		`{"rps": 10}`, `{"rps": 11}`, `{"rps": 10}`, `{"rps": 11}`,
+		`{"rps": 630, "rps_unit": "per_minute"}`,
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze?sync=true", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d (%s)", body, w.Code, w.Body.String())
+		}
+		json.NewDecoder(w.Body).Decode(&result)
+	}
+	if result.Status != statusNormal {
+		t.Errorf("per-minute sample flagged: %+v", result)
+	}
+	stored, _ := mr.List("metrics")
+	if last := stored[len(stored)-1]; strings.Contains(last, "unit") || !strings.Contains(last, `"rps":10.5`) {
+		t.Errorf("stored %s, want canonical rps 10.5 without a unit", last)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze?sync=true", strings.NewReader(`{"rps": 1, "rps_unit": "per_day"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown unit: status %d, want 400", w.Code)
+	}
+}
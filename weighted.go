@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Weighting selects how the weighted rolling average favours recent
+// samples. The plain rolling average is always computed alongside it.
+type Weighting string
+
+const (
+	// WeightingLinear gives the i-th oldest of n samples weight i, so the
+	// newest counts n times as much as the oldest:
+	//
+	//	avg = Σ i·x_i / Σ i,  i = 1..n
+	//
+	// It is the default.
+	WeightingLinear Weighting = "linear"
+	// WeightingExponential gives a sample k steps older than the newest
+	// weight (1-α)^k, normalised over the window:
+	//
+	//	avg = Σ (1-α)^k·x_k / Σ (1-α)^k,  k = 0..n-1
+	//
+	// Larger α reacts faster; α = 1 is just the newest sample.
+	WeightingExponential Weighting = "exponential"
+)
+
+func parseWeighting(v string) (Weighting, error) {
+	switch Weighting(v) {
+	case "", WeightingLinear:
+		return WeightingLinear, nil
+	case WeightingExponential:
+		return WeightingExponential, nil
+	default:
+		return "", fmt.Errorf("unknown weighting %q (want linear or exponential)", v)
+	}
+}
+
+// calculateWeightedAverage returns the recency-weighted average of values,
+// oldest first. alpha is only used by WeightingExponential and must be in
+// (0, 1].
+func calculateWeightedAverage(values []float64, weighting Weighting, alpha float64) float64 {
+	if len(values) == 0 {
+		return 0.0
+	}
+	var sum, weights float64
+	for i, v := range values {
+		var w float64
+		if weighting == WeightingExponential {
+			w = math.Pow(1-alpha, float64(len(values)-1-i))
+		} else {
+			w = float64(i + 1)
+		}
+		sum += w * v
+		weights += w
+	}
+	return sum / weights
+}
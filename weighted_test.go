@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseWeighting(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Weighting
+		wantErr bool
+	}{
+		{in: "", want: WeightingLinear},
+		{in: "linear", want: WeightingLinear},
+		{in: "exponential", want: WeightingExponential},
+		{in: "quadratic", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseWeighting(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseWeighting(%q) = %q, %v; want %q, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCalculateWeightedAverage(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    []float64
+		weighting Weighting
+		alpha     float64
+		want      float64
+	}{
+		{name: "empty", weighting: WeightingLinear, want: 0},
+		{name: "constant", values: []float64{4, 4, 4}, weighting: WeightingExponential, alpha: 0.5, want: 4},
+		// (1·1 + 2·2 + 3·3) / 6
+		{name: "linear", values: []float64{1, 2, 3}, weighting: WeightingLinear, want: 14.0 / 6},
+		// (0.25·1 + 0.5·2 + 1·3) / 1.75
+		{name: "exponential", values: []float64{1, 2, 3}, weighting: WeightingExponential, alpha: 0.5, want: 4.25 / 1.75},
+		{name: "alpha one is newest", values: []float64{1, 2, 3}, weighting: WeightingExponential, alpha: 1, want: 3},
+	}
+	for _, tt := range tests {
+		if got := calculateWeightedAverage(tt.values, tt.weighting, tt.alpha); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: calculateWeightedAverage = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// After RPS steps from 10 to 20 both weightings should sit closer to the
+// new level than the plain average at every point of the transition.
+func TestWeightedAverageConvergesFasterOnStep(t *testing.T) {
+	const window = 20
+	var values []float64
+	for i := 0; i < window; i++ {
+		values = append(values, 10)
+	}
+	for _, weighting := range []Weighting{WeightingLinear, WeightingExponential} {
+		series := append([]float64(nil), values...)
+		for step := 1; step < window; step++ {
+			series = lastN(append(series, 20), window)
+			plain := calculateAverage(series)
+			weighted := calculateWeightedAverage(series, weighting, defaultEWMAAlpha)
+			if weighted <= plain {
+				t.Errorf("%s, %d samples after step: weighted %v not above plain %v", weighting, step, weighted, plain)
+			}
+		}
+	}
+}
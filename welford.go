@@ -0,0 +1,111 @@
+package main
+
+import "math"
+
+// runningStats keeps the mean and variance of a set of values with
+// Welford's online algorithm, so values can be added and removed in O(1)
+// without the cancellation a running sum of squares suffers once the values
+// are large relative to their spread.
+type runningStats struct {
+	n    int
+	mean float64
+	m2   float64 // sum of squared deviations from mean
+}
+
+func (s *runningStats) add(x float64) {
+	s.n++
+	delta := x - s.mean
+	s.mean += delta / float64(s.n)
+	s.m2 += delta * (x - s.mean)
+}
+
+// remove undoes add(x) for a value previously added.
+func (s *runningStats) remove(x float64) {
+	if s.n <= 1 {
+		*s = runningStats{}
+		return
+	}
+	delta := x - s.mean
+	s.n--
+	s.mean -= delta / float64(s.n)
+	s.m2 -= delta * (x - s.mean)
+}
+
+// stdDev is calculateStandardDeviation of the values held.
+func (s runningStats) stdDev(method StdDevMethod) float64 {
+	if s.n <= 1 {
+		return 0.0
+	}
+	n := float64(s.n)
+	if method != StdDevPopulation {
+		n--
+	}
+	// Removals can leave m2 a rounding error below zero.
+	return math.Sqrt(max(s.m2, 0) / n)
+}
+
+// slidingStats is runningStats over the last window RPS values of a
+// stream's buffer. Removals accumulate rounding error, so it is rebuilt from
+// the values every window removals, which keeps the cost O(1) amortized.
+type slidingStats struct {
+	window  int
+	stats   runningStats
+	removed int
+	// lows and highs are monotonic queues of the values that can still
+	// become the window's minimum and maximum, the current one first. Each
+	// value carries its position, counted from the first value added.
+	added       int
+	lows, highs []positionedValue
+}
+
+type positionedValue struct {
+	pos int
+	v   float64
+}
+
+func newSlidingStats(samples []Metric, window int) *slidingStats {
+	s := &slidingStats{window: window}
+	for _, m := range samples[max(len(samples)-window, 0):] {
+		s.add(m.RPS)
+	}
+	return s
+}
+
+func (s *slidingStats) add(v float64) {
+	s.stats.add(v)
+	s.added++
+	for len(s.lows) > 0 && s.lows[len(s.lows)-1].v >= v {
+		s.lows = s.lows[:len(s.lows)-1]
+	}
+	s.lows = append(s.lows, positionedValue{s.added, v})
+	for len(s.highs) > 0 && s.highs[len(s.highs)-1].v <= v {
+		s.highs = s.highs[:len(s.highs)-1]
+	}
+	s.highs = append(s.highs, positionedValue{s.added, v})
+}
+
+// push takes in the last of samples, which held the values so far followed
+// by the new one, and drops the values that fall out of the window.
+func (s *slidingStats) push(samples []Metric) {
+	s.add(samples[len(samples)-1].RPS)
+	for s.stats.n > s.window {
+		s.stats.remove(samples[len(samples)-s.stats.n].RPS)
+		s.removed++
+	}
+	first := s.added - s.stats.n // position of the last value dropped
+	for len(s.lows) > 0 && s.lows[0].pos <= first {
+		s.lows = s.lows[1:]
+	}
+	for len(s.highs) > 0 && s.highs[0].pos <= first {
+		s.highs = s.highs[1:]
+	}
+	if s.removed >= s.window {
+		*s = *newSlidingStats(samples, s.window)
+	}
+}
+
+// bounds returns the lowest and highest value in the window, which must not
+// be empty.
+func (s *slidingStats) bounds() (lo, hi float64) {
+	return s.lows[0].v, s.highs[0].v
+}
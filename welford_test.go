@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func closeTo(got, want float64) bool {
+	return math.Abs(got-want) <= 1e-9*max(1, math.Abs(want))
+}
+
+func TestSlidingStatsMatchesNaive(t *testing.T) {
+	tests := []struct {
+		name   string
+		window int
+		value  func(r *rand.Rand) float64
+	}{
+		{name: "small values", window: 50, value: func(r *rand.Rand) float64 { return r.Float64() * 10 }},
+		// A sum of squares loses the spread entirely at this offset.
+		{name: "large offset", window: 1000, value: func(r *rand.Rand) float64 { return 1e9 + r.NormFloat64() }},
+		{name: "wide range", window: 200, value: func(r *rand.Rand) float64 { return r.ExpFloat64() * 1e6 }},
+		{name: "constant", window: 100, value: func(*rand.Rand) float64 { return 42 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			b := newSampleBuffer(tt.window)
+			var all []float64
+			for i := 0; i < 100000; i++ {
+				v := tt.value(r)
+				all = append(all, v)
+				b.addRecent("s", Metric{RPS: v})
+				if i%9973 != 0 && i != 99999 {
+					continue
+				}
+				got := b.rpsWindows(tt.window)["s"]
+				want := lastN(all, tt.window)
+				mean := calculateAverage(want)
+				if got.stats.n != len(want) {
+					t.Fatalf("sample %d: stats over %d, want %d", i, got.stats.n, len(want))
+				}
+				if lo, hi := minMax(want); got.lo != lo || got.hi != hi {
+					t.Errorf("sample %d: bounds = [%v, %v], want [%v, %v]", i, got.lo, got.hi, lo, hi)
+				}
+				if !closeTo(got.stats.mean, mean) {
+					t.Errorf("sample %d: mean = %v, want %v", i, got.stats.mean, mean)
+				}
+				for _, method := range []StdDevMethod{StdDevSample, StdDevPopulation} {
+					if sd, want := got.stats.stdDev(method), calculateStandardDeviation(want, mean, method); math.Abs(sd-want) > 1e-6*max(1, want) {
+						t.Errorf("sample %d: %s stddev = %v, want %v", i, method, sd, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRPSWindowsRebuild(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(b *sampleBuffer)
+		window int
+		want   []float64
+	}{
+		{name: "unchanged", change: func(*sampleBuffer) {}, window: 3, want: []float64{3, 4, 5}},
+		{name: "window shrinks", change: func(*sampleBuffer) {}, window: 2, want: []float64{4, 5}},
+		{name: "window above buffer size", change: func(*sampleBuffer) {}, window: 10, want: []float64{1, 2, 3, 4, 5}},
+		{
+			name:   "synced from redis",
+			change: func(b *sampleBuffer) { b.sync("s", []Metric{{RPS: 10}, {RPS: 20}}) },
+			window: 3,
+			want:   []float64{10, 20},
+		},
+		{
+			name:   "synced with new samples",
+			change: func(b *sampleBuffer) { b.sync("s", []Metric{{RPS: 3}, {RPS: 4}, {RPS: 5}, {RPS: 6}, {RPS: 7}}) },
+			window: 3,
+			want:   []float64{5, 6, 7},
+		},
+		{
+			name: "synced with pending samples",
+			change: func(b *sampleBuffer) {
+				b.pending["s"] = []Metric{{RPS: 9}}
+				b.sync("s", []Metric{{RPS: 4}, {RPS: 5}, {RPS: 6}})
+			},
+			window: 3,
+			want:   []float64{5, 6, 9},
+		},
+		{
+			name:   "synced with a repeated sample",
+			change: func(b *sampleBuffer) { b.sync("s", []Metric{{RPS: 2}, {RPS: 3}, {RPS: 4}, {RPS: 5}, {RPS: 5}}) },
+			window: 3,
+			want:   []float64{4, 5, 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newSampleBuffer(5)
+			for i := 0; i < 5; i++ {
+				b.addRecent("s", Metric{RPS: float64(i + 1)})
+			}
+			b.rpsWindows(3) // build the stats for the initial window
+			tt.change(b)
+			got := b.rpsWindows(tt.window)["s"]
+			if lo, hi := minMax(tt.want); got.lo != lo || got.hi != hi {
+				t.Errorf("bounds = [%v, %v], want [%v, %v]", got.lo, got.hi, lo, hi)
+			}
+			if mean := calculateAverage(tt.want); got.stats.n != len(tt.want) || !closeTo(got.stats.mean, mean) {
+				t.Errorf("stats over %d with mean %v, want %d with mean %v", got.stats.n, got.stats.mean, len(tt.want), mean)
+			}
+		})
+	}
+}
+
+func minMax(values []float64) (lo, hi float64) {
+	lo, hi = values[0], values[0]
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	return lo, hi
+}
+
+func TestWindowStats(t *testing.T) {
+	b := newSampleBuffer(5)
+	for i := 0; i < 5; i++ {
+		b.addRecent("s", Metric{RPS: float64(i + 1)})
+	}
+	held := b.window("s")
+	tests := []struct {
+		name    string
+		samples []Metric
+		wantOK  bool
+	}{
+		{name: "held window", samples: held, wantOK: true},
+		{name: "shorter window", samples: held[1:], wantOK: false},
+		{name: "other newest sample", samples: append(held[:4:4], Metric{RPS: 9}), wantOK: false},
+		{name: "empty", samples: nil, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := b.windowStats("s", tt.samples, 3)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && (got.n != 3 || !closeTo(got.mean, 4)) {
+				t.Errorf("stats over %d with mean %v, want 3 with mean 4", got.n, got.mean)
+			}
+		})
+	}
+}
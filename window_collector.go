@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// windowCollector reports the RPS window of every stream held in the
+// in-memory buffer, computed at scrape time. Unlike the gauges set on the
+// analyze path, its values cannot go stale between requests.
+type windowCollector struct {
+	buffer  *sampleBuffer
+	samples *prometheus.Desc
+	avg     *prometheus.Desc
+	stddev  *prometheus.Desc
+	min     *prometheus.Desc
+	max     *prometheus.Desc
+}
+
+func newWindowCollector(buffer *sampleBuffer) *windowCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(metricName(name), help, []string{"stream"}, nil)
+	}
+	return &windowCollector{
+		buffer:  buffer,
+		samples: desc("window_samples", "Samples currently in the stream's RPS window"),
+		avg:     desc("window_rps_avg", "Mean RPS of the stream's current window"),
+		stddev:  desc("window_rps_stddev", "Standard deviation of RPS in the stream's current window"),
+		min:     desc("window_rps_min", "Lowest RPS in the stream's current window"),
+		max:     desc("window_rps_max", "Highest RPS in the stream's current window"),
+	}
+}
+
+func (c *windowCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.samples, c.avg, c.stddev, c.min, c.max} {
+		ch <- d
+	}
+}
+
+// Collect emits one set of series per buffered stream. A stream with an
+// empty window only reports its sample count, since the other stats are
+// undefined.
+func (c *windowCollector) Collect(ch chan<- prometheus.Metric) {
+	for stream, window := range c.buffer.rpsWindows(appState.windowFor("rps")) {
+		gauge := func(d *prometheus.Desc, v float64) {
+			ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, stream)
+		}
+		gauge(c.samples, float64(window.stats.n))
+		if window.stats.n == 0 {
+			continue
+		}
+		gauge(c.avg, appState.round(window.stats.mean))
+		gauge(c.stddev, appState.round(window.stats.stdDev(appState.stdDevMethod)))
+		gauge(c.min, window.lo)
+		gauge(c.max, window.hi)
+	}
+}
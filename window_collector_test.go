@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWindowCollector(t *testing.T) {
+	newTestAppState(t)
+	buf := newSampleBuffer(appState.maxWindow())
+	for _, rps := range []float64{1, 2, 3, 4, 5, 6} {
+		buf.addRecent("web", Metric{RPS: rps})
+	}
+	buf.addRecent("api", Metric{RPS: 7})
+	buf.sync("idle", nil)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newWindowCollector(buf))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			got[mf.GetName()+"/"+m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+
+	tests := []struct {
+		series string
+		want   float64
+		absent bool
+	}{
+		// The buffer holds 6 samples of web but its window is 5.
+		{series: "go_service_window_samples/web", want: 5},
+		{series: "go_service_window_rps_avg/web", want: 4},
+		{series: "go_service_window_rps_stddev/web", want: 1.5811},
+		{series: "go_service_window_rps_min/web", want: 2},
+		{series: "go_service_window_rps_max/web", want: 6},
+		{series: "go_service_window_samples/api", want: 1},
+		{series: "go_service_window_rps_stddev/api", want: 0},
+		{series: "go_service_window_samples/idle", want: 0},
+		{series: "go_service_window_rps_avg/idle", absent: true},
+	}
+	for _, tt := range tests {
+		v, ok := got[tt.series]
+		if ok == tt.absent || v != tt.want {
+			t.Errorf("%s = %v (present %v), want %v (present %v)", tt.series, v, ok, tt.want, !tt.absent)
+		}
+	}
+
+	// Values follow the buffer at scrape time, not the last analyze call.
+	buf.addRecent("api", Metric{RPS: 9})
+	families, _ = reg.Gather()
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			if mf.GetName() == "go_service_window_rps_max" && m.GetLabel()[0].GetValue() == "api" && m.GetGauge().GetValue() != 9 {
+				t.Errorf("api max after new sample = %v, want 9", m.GetGauge().GetValue())
+			}
+		}
+	}
+}
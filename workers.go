@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+)
+
+// streamWorkers processes accepted metrics off the request path. Each
+// stream is hashed to one of a fixed set of queues, each drained by a
+// single goroutine, so:
+//
+//   - metrics of one stream are processed one at a time, in the order
+//     they were accepted, and therefore stored in that order: every
+//     window a detector sees is in arrival order;
+//   - different streams usually land on different queues and run in
+//     parallel, but streams sharing a queue also share its order, so a
+//     slow stream delays the others on its queue.
+//
+// Ordering holds between metrics accepted by this process; two replicas
+// receiving the same stream still interleave in Redis.
+type streamWorkers struct {
+	mu     sync.RWMutex
+	closed bool
+	queues []chan workItem
+	wg     sync.WaitGroup
+}
+
+type workItem struct {
+	logger *slog.Logger
+	stream string
+	metric Metric
+}
+
+// newStreamWorkers starts n workers with a queue of depth metrics each.
+func newStreamWorkers(n, depth int) *streamWorkers {
+	w := &streamWorkers{queues: make([]chan workItem, n)}
+	for i := range w.queues {
+		q := make(chan workItem, depth)
+		w.queues[i] = q
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for item := range q {
+				processMetric(context.Background(), item.logger, item.stream, item.metric)
+			}
+		}()
+	}
+	return w
+}
+
+func (w *streamWorkers) queueFor(stream string) chan workItem {
+	h := fnv.New32a()
+	h.Write([]byte(stream))
+	return w.queues[h.Sum32()%uint32(len(w.queues))]
+}
+
+// submit queues m for processing. It reports false, without blocking, if
+// the stream's queue is full or the workers have been stopped.
+func (w *streamWorkers) submit(logger *slog.Logger, stream string, m Metric) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return false
+	}
+	select {
+	case w.queueFor(stream) <- workItem{logger: logger, stream: stream, metric: m}:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop refuses new metrics and waits for the queued ones to be processed.
+func (w *streamWorkers) stop() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		for _, q := range w.queues {
+			close(q)
+		}
+	}
+	w.mu.Unlock()
+	w.wg.Wait()
+}
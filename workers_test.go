@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestStreamWorkersPreserveOrder(t *testing.T) {
+	mr := newTestAppState(t)
+	appState.buffer = newSampleBuffer(50)
+	appState.rawRetention = 200
+	workers := newStreamWorkers(4, 100)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	streams := []string{"a", "b", "c", "d", "e"}
+	const perStream = 40
+	for i := 0; i < perStream; i++ {
+		for _, stream := range streams {
+			m := Metric{Timestamp: base.Add(time.Duration(i) * time.Second), RPS: float64(i)}
+			if !workers.submit(slog.Default(), stream, m) {
+				t.Fatalf("submit %s #%d rejected", stream, i)
+			}
+		}
+	}
+	workers.stop()
+
+	for _, stream := range streams {
+		items, err := mr.List(appState.metricsKey(stream))
+		if err != nil || len(items) != perStream {
+			t.Fatalf("%s: stored %d samples, %v; want %d", stream, len(items), err, perStream)
+		}
+		for i, m := range decodeWindow(items) {
+			if m.RPS != float64(i) {
+				t.Fatalf("%s: sample %d has rps %v, out of order", stream, i, m.RPS)
+			}
+		}
+	}
+}
+
+func TestStreamWorkersSubmit(t *testing.T) {
+	newTestAppState(t)
+	tests := []struct {
+		name    string
+		depth   int
+		fill    int
+		stopped bool
+		want    bool
+	}{
+		{name: "room", depth: 2, want: true},
+		{name: "queue full", depth: 2, fill: 2, want: false},
+		{name: "stopped", depth: 2, stopped: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Build the queue by hand so nothing drains it.
+			w := &streamWorkers{queues: []chan workItem{make(chan workItem, tt.depth)}}
+			for i := 0; i < tt.fill; i++ {
+				w.queues[0] <- workItem{}
+			}
+			if tt.stopped {
+				w.stop()
+			}
+			if got := w.submit(slog.Default(), "web", Metric{RPS: 1}); got != tt.want {
+				t.Errorf("submit = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
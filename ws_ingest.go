@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// maxWSFrameBytes bounds one metric frame on /ws/ingest.
+	maxWSFrameBytes = 64 << 10
+	// wsWriteTimeout bounds writing one reply, so a client that stops
+	// reading cannot hold a handler forever.
+	wsWriteTimeout = 10 * time.Second
+)
+
+// wsConnSet tracks open /ws/ingest connections. They are hijacked, so
+// http.Server.Shutdown neither waits for nor closes them: shutdown, which
+// the server runs through RegisterOnShutdown, interrupts their reads
+// instead, and each handler answers the frame it is processing, if any,
+// and then sends a close frame.
+type wsConnSet struct {
+	mu      sync.Mutex
+	conns   map[*websocket.Conn]bool
+	closing bool
+	wg      sync.WaitGroup
+}
+
+func newWSConnSet() *wsConnSet {
+	return &wsConnSet{conns: make(map[*websocket.Conn]bool)}
+}
+
+// add tracks conn, unless the set is shutting down.
+func (s *wsConnSet) add(conn *websocket.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.conns[conn] = true
+	s.wg.Add(1)
+	return true
+}
+
+func (s *wsConnSet) remove(conn *websocket.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+func (s *wsConnSet) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// shutdown stops every connection from reading further frames.
+func (s *wsConnSet) shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closing = true
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+// wait waits up to timeout for the handlers of every connection to return.
+func (s *wsConnSet) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// closeGoingAway sends conn the close frame of a server going down.
+func closeGoingAway(conn *websocket.Conn) {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+}
+
+// wsUpgrader keeps gorilla's default origin check: browsers may only
+// connect from the service's own origin, while clients that send no Origin
+// header, which is every non-browser producer, are let through.
+var wsUpgrader = websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096}
+
+// handleWSIngest upgrades GET /ws/ingest to a WebSocket for producers that
+// send many samples a second, sparing them a request per sample. The stream
+// is named by ?stream= as on /analyze. Every text frame holds one metric and
+// is processed synchronously, then answered, in order, with a frame holding
+// its AnalysisResult, or an error body like the HTTP endpoints' for a frame
+// that could not be processed. A bad frame does not close the connection;
+// shutting down does, once the frame in flight is answered.
+func handleWSIngest(w http.ResponseWriter, r *http.Request) {
+	stream, err := streamFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	// The upgrader answers failed handshakes itself.
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conns := appState.wsConns
+	if !conns.add(conn) {
+		closeGoingAway(conn)
+		return
+	}
+	defer conns.remove(conn)
+	conn.SetReadLimit(maxWSFrameBytes)
+
+	logger := loggerFrom(r.Context()).With("stream", stream, "transport", "websocket")
+	logger.Info("WebSocket ingest connected")
+	for {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil && conns.shuttingDown() {
+			logger.Info("WebSocket ingest closed for shutdown")
+			closeGoingAway(conn)
+			return
+		}
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Warn("WebSocket ingest closed", "error", err)
+			}
+			return
+		}
+		var reply interface{}
+		if msgType != websocket.TextMessage {
+			reply = errorBody{Error: errorDetail{Code: "invalid_request", Message: "want text frames holding JSON metrics"}}
+		} else {
+			reply = ingestRecord(r.Context(), logger, stream, data)
+		}
+		payload, err := marshalJSON(reply)
+		if err != nil {
+			logger.Error("WebSocket reply encoding error", "error", err)
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+			logger.Warn("WebSocket write error", "error", err)
+			return
+		}
+	}
+}
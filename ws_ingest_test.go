@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWSIngest(t *testing.T) {
+	mr := newTestAppState(t)
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/ingest?stream=web", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	frames := []struct {
+		name      string
+		msgType   int
+		data      string
+		wantReply string
+	}{
+		{name: "first", msgType: websocket.TextMessage, data: `{"rps": 10}`, wantReply: `"samples":1`},
+		{name: "bad json", msgType: websocket.TextMessage, data: `{"rps": "high"}`, wantReply: `"code":"invalid_json"`},
+		{name: "bad unit", msgType: websocket.TextMessage, data: `{"rps": 1, "rps_unit": "furlongs"}`, wantReply: `"code":"invalid_request"`},
+		{name: "binary", msgType: websocket.BinaryMessage, data: `{"rps": 1}`, wantReply: `"code":"invalid_request"`},
+		// The connection survives bad frames.
+		{name: "second", msgType: websocket.TextMessage, data: `{"rps": 30}`, wantReply: `"rolling_avg":20`},
+	}
+	for _, f := range frames {
+		if err := conn.WriteMessage(f.msgType, []byte(f.data)); err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+		_, reply, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+		if !strings.Contains(string(reply), f.wantReply) {
+			t.Errorf("%s: reply %s, want it to contain %s", f.name, reply, f.wantReply)
+		}
+	}
+	if stored, _ := mr.List("metrics:web"); len(stored) != 2 {
+		t.Errorf("stored %d samples, want 2", len(stored))
+	}
+	// httptest.Server.Close does not wait for hijacked connections.
+	conn.Close()
+	if !appState.wsConns.wait(time.Second) {
+		t.Error("handler still running after the client went away")
+	}
+}
+
+func TestWSIngestRejectsBadStream(t *testing.T) {
+	newTestAppState(t)
+	w := httptest.NewRecorder()
+	newMux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ws/ingest?stream=bad%20name", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestWSIngestShutdown(t *testing.T) {
+	newTestAppState(t)
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+	srv.Config.RegisterOnShutdown(appState.wsConns.shutdown)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/ingest?stream=web", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"rps": 10}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("read after shutdown = %v, want a going-away close frame", err)
+	}
+	if !appState.wsConns.wait(time.Second) {
+		t.Error("handler still running after shutdown")
+	}
+}